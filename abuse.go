@@ -0,0 +1,183 @@
+//go:build daemon
+
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "math/bits"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Optional abuse protection for the public /print endpoint, for printers
+// exposed to the internet:
+//
+//   pow:       hashcash-style proof of work. GET /challenge hands out a signed,
+//              expiring challenge; the client finds a nonce such that
+//              sha256(challenge + ":" + nonce) starts with `difficulty` zero
+//              bits and sends both back in X-PoW-Challenge / X-PoW-Nonce.
+//   turnstile: the client sends a Cloudflare Turnstile token in
+//              X-Turnstile-Token, which we verify with the siteverify API.
+//
+// Requests carrying the admin token or a user token are never challenged.
+
+const (
+    POW_CHALLENGE_TTL    = 5 * time.Minute
+    TURNSTILE_VERIFY_URL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+)
+
+type AbuseGuard struct {
+    cfg    AbuseConfig
+    secret []byte
+    client *http.Client
+
+    mu   sync.Mutex
+    used map[string]time.Time
+}
+
+func newAbuseGuard(cfg AbuseConfig) *AbuseGuard {
+    secret := make([]byte, 32)
+    rand.Read(secret)
+    return &AbuseGuard{
+        cfg:    cfg,
+        secret: secret,
+        client: &http.Client{Timeout: 10 * time.Second},
+        used:   make(map[string]time.Time),
+    }
+}
+
+func (g *AbuseGuard) Enabled() bool {
+    return g.cfg.Mode == ABUSE_POW || g.cfg.Mode == ABUSE_TURNSTILE
+}
+
+// ChallengeInfo is what GET /challenge returns to clients.
+type ChallengeInfo struct {
+    Mode       string `json:"mode"`
+    Challenge  string `json:"challenge,omitempty"`
+    Difficulty int    `json:"difficulty,omitempty"`
+    SiteKey    string `json:"site_key,omitempty"`
+}
+
+func (g *AbuseGuard) Challenge() ChallengeInfo {
+    switch g.cfg.Mode {
+    case ABUSE_POW:
+        nonce := make([]byte, 12)
+        rand.Read(nonce)
+        body := hex.EncodeToString(nonce) + "." + strconv.FormatInt(time.Now().Add(POW_CHALLENGE_TTL).Unix(), 10)
+        return ChallengeInfo{Mode: ABUSE_POW, Challenge: body + "." + g.sign(body), Difficulty: g.cfg.Difficulty}
+    case ABUSE_TURNSTILE:
+        return ChallengeInfo{Mode: ABUSE_TURNSTILE, SiteKey: g.cfg.TurnstileSiteKey}
+    }
+    return ChallengeInfo{Mode: "none"}
+}
+
+// Verify checks the proof attached to a request.
+func (g *AbuseGuard) Verify(r *http.Request) error {
+    switch g.cfg.Mode {
+    case ABUSE_POW:
+        return g.verifyPoW(r.Header.Get("X-PoW-Challenge"), r.Header.Get("X-PoW-Nonce"))
+    case ABUSE_TURNSTILE:
+        return g.verifyTurnstile(r.Header.Get("X-Turnstile-Token"), clientIP(r))
+    }
+    return nil
+}
+
+func (g *AbuseGuard) sign(body string) string {
+    mac := hmac.New(sha256.New, g.secret)
+    mac.Write([]byte(body))
+    return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (g *AbuseGuard) verifyPoW(challenge, nonce string) error {
+    if challenge == "" || nonce == "" {
+        return fmt.Errorf("proof of work required (GET /challenge)")
+    }
+    parts := strings.Split(challenge, ".")
+    if len(parts) != 3 || !hmac.Equal([]byte(g.sign(parts[0]+"."+parts[1])), []byte(parts[2])) {
+        return fmt.Errorf("invalid challenge")
+    }
+    expires, err := strconv.ParseInt(parts[1], 10, 64)
+    if err != nil || time.Now().Unix() > expires {
+        return fmt.Errorf("challenge expired")
+    }
+
+    sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+    if leadingZeroBits(sum[:]) < g.cfg.Difficulty {
+        return fmt.Errorf("proof of work too weak")
+    }
+
+    // Each challenge is single use
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    now := time.Now()
+    for c, exp := range g.used {
+        if now.After(exp) {
+            delete(g.used, c)
+        }
+    }
+    if _, seen := g.used[challenge]; seen {
+        return fmt.Errorf("challenge already used")
+    }
+    g.used[challenge] = time.Unix(expires, 0)
+    return nil
+}
+
+func leadingZeroBits(b []byte) int {
+    n := 0
+    for _, c := range b {
+        if c != 0 {
+            return n + bits.LeadingZeros8(c)
+        }
+        n += 8
+    }
+    return n
+}
+
+func (g *AbuseGuard) verifyTurnstile(token, remoteIP string) error {
+    if token == "" {
+        return fmt.Errorf("turnstile token required")
+    }
+    form := url.Values{"secret": {g.cfg.TurnstileSecret}, "response": {token}}
+    if remoteIP != "" {
+        form.Set("remoteip", remoteIP)
+    }
+    resp, err := g.client.PostForm(TURNSTILE_VERIFY_URL, form)
+    if err != nil {
+        return fmt.Errorf("turnstile verification failed: %v", err)
+    }
+    defer resp.Body.Close()
+
+    var result struct {
+        Success    bool     `json:"success"`
+        ErrorCodes []string `json:"error-codes"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return fmt.Errorf("turnstile verification failed: %v", err)
+    }
+    if !result.Success {
+        return fmt.Errorf("turnstile rejected the request: %s", strings.Join(result.ErrorCodes, ", "))
+    }
+    return nil
+}
+
+// clientIP prefers X-Forwarded-For since the web server proxies to us.
+func clientIP(r *http.Request) string {
+    if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+        return strings.TrimSpace(strings.Split(fwd, ",")[0])
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
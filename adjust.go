@@ -0,0 +1,245 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "math"
+)
+
+// Tone adjustments run on the grayscale image before dithering. Thermal heads
+// print photos much darker than a screen shows them, so these are mostly
+// used to lift the midtones (gamma) and brighten the whole image. An unsharp
+// mask then restores edges lost when a photo is scaled down to 384 dots,
+// which makes text in images far more legible. Histogram equalization, done
+// first, spreads a low-contrast scan's few gray levels over the whole range
+// so a faint pencil drawing doesn't dither to blank paper. The zero value
+// leaves the image alone.
+
+type Adjustments struct {
+    // Equalize spreads the image's levels evenly before the other settings
+    Equalize bool `json:"equalize"`
+    // Brightness (-100 to 100) shifts every level up or down
+    Brightness int `json:"brightness"`
+    // Contrast (-100 to 100) stretches or flattens levels around mid-gray
+    Contrast int `json:"contrast"`
+    // Gamma (0.1 to 10, 0 for none) above 1 lightens the midtones
+    Gamma float64 `json:"gamma"`
+    // Sharpen (0 to 5) is the unsharp mask amount: how much of the
+    // difference to a blurred copy is added back
+    Sharpen float64 `json:"sharpen"`
+    // SharpenRadius (0.5 to 5 pixels, default 1) is the blur's sigma
+    SharpenRadius float64 `json:"sharpen_radius"`
+}
+
+const (
+    MIN_GAMMA = 0.1
+    MAX_GAMMA = 10
+
+    MAX_SHARPEN            = 5
+    MIN_SHARPEN_RADIUS     = 0.5
+    MAX_SHARPEN_RADIUS     = 5
+    DEFAULT_SHARPEN_RADIUS = 1
+
+    // EQUALIZE_CLIP caps each histogram bin at this many times the average
+    // bin, so a large blank background isn't stretched into visible noise
+    EQUALIZE_CLIP = 3
+)
+
+func (a Adjustments) validate() error {
+    if a.Brightness < -100 || a.Brightness > 100 {
+        return fmt.Errorf("brightness must be between -100 and 100")
+    }
+    if a.Contrast < -100 || a.Contrast > 100 {
+        return fmt.Errorf("contrast must be between -100 and 100")
+    }
+    if a.Gamma != 0 && (a.Gamma < MIN_GAMMA || a.Gamma > MAX_GAMMA) {
+        return fmt.Errorf("gamma must be between %g and %g", MIN_GAMMA, float64(MAX_GAMMA))
+    }
+    if a.Sharpen < 0 || a.Sharpen > MAX_SHARPEN {
+        return fmt.Errorf("sharpen must be between 0 and %d", MAX_SHARPEN)
+    }
+    if a.SharpenRadius != 0 && (a.SharpenRadius < MIN_SHARPEN_RADIUS || a.SharpenRadius > MAX_SHARPEN_RADIUS) {
+        return fmt.Errorf("sharpen_radius must be between %g and %d", MIN_SHARPEN_RADIUS, MAX_SHARPEN_RADIUS)
+    }
+    return nil
+}
+
+func (a Adjustments) isZero() bool {
+    return !a.Equalize && a.Brightness == 0 && a.Contrast == 0 && (a.Gamma == 0 || a.Gamma == 1) && a.Sharpen == 0
+}
+
+// or fills the settings a job left at zero from defaults.
+func (a Adjustments) or(defaults Adjustments) Adjustments {
+    a.Equalize = a.Equalize || defaults.Equalize
+    if a.Brightness == 0 {
+        a.Brightness = defaults.Brightness
+    }
+    if a.Contrast == 0 {
+        a.Contrast = defaults.Contrast
+    }
+    if a.Gamma == 0 {
+        a.Gamma = defaults.Gamma
+    }
+    if a.Sharpen == 0 {
+        a.Sharpen = defaults.Sharpen
+    }
+    if a.SharpenRadius == 0 {
+        a.SharpenRadius = defaults.SharpenRadius
+    }
+    return a
+}
+
+// lut maps each input level to its adjusted level: brightness, then
+// contrast, then gamma.
+func (a Adjustments) lut() [256]uint8 {
+    var table [256]uint8
+    contrast := float64(100+a.Contrast) / 100
+    for i := range table {
+        v := float64(i) + float64(a.Brightness)*255/100
+        v = (v-128)*contrast + 128
+        v = math.Max(0, math.Min(255, v))
+        if a.Gamma != 0 {
+            v = 255 * math.Pow(v/255, 1/a.Gamma)
+        }
+        table[i] = uint8(math.Round(v))
+    }
+    return table
+}
+
+// adjustImage returns a grayscale copy of img with the adjustments applied.
+func adjustImage(img image.Image, a Adjustments) image.Image {
+    if a.isZero() {
+        return img
+    }
+    b := img.Bounds()
+    out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < b.Dx(); x++ {
+            out.Pix[y*out.Stride+x] = luminance(img.At(b.Min.X+x, b.Min.Y+y))
+        }
+    }
+    table := a.lut()
+    if a.Equalize {
+        equalized := equalizeTable(out)
+        for i, level := range equalized {
+            equalized[i] = table[level]
+        }
+        table = equalized
+    }
+    for i, level := range out.Pix {
+        out.Pix[i] = table[level]
+    }
+    if a.Sharpen > 0 {
+        radius := a.SharpenRadius
+        if radius == 0 {
+            radius = DEFAULT_SHARPEN_RADIUS
+        }
+        unsharpMask(out, a.Sharpen, radius)
+    }
+    return out
+}
+
+// equalizeTable maps each level of img to its place in the image's
+// cumulative histogram, scaled to 0-255. Bins are first clipped at
+// EQUALIZE_CLIP times the average bin of the levels the image uses, with the
+// excess spread evenly over those levels.
+func equalizeTable(img *image.Gray) [256]uint8 {
+    var hist [256]float64
+    low, high := 255, 0
+    for _, v := range img.Pix {
+        hist[v]++
+        low, high = min(low, int(v)), max(high, int(v))
+    }
+    var table [256]uint8
+    if low >= high {
+        for i := range table {
+            table[i] = uint8(i)
+        }
+        return table
+    }
+    levels := float64(high - low + 1)
+    limit := EQUALIZE_CLIP * float64(len(img.Pix)) / levels
+    excess := 0.0
+    for i := low; i <= high; i++ {
+        if hist[i] > limit {
+            excess += hist[i] - limit
+            hist[i] = limit
+        }
+    }
+    cdf := make([]float64, 256)
+    sum := 0.0
+    for i := low; i <= high; i++ {
+        sum += hist[i] + excess/levels
+        cdf[i] = sum
+    }
+    first := cdf[low]
+    for i := range table {
+        switch {
+        case i < low:
+            table[i] = 0
+        case i > high:
+            table[i] = 255
+        default:
+            table[i] = uint8(math.Round((cdf[i] - first) / (sum - first) * 255))
+        }
+    }
+    return table
+}
+
+// unsharpMask sharpens img in place: each pixel moves away from a Gaussian
+// blurred copy of itself by amount times the difference.
+func unsharpMask(img *image.Gray, amount, sigma float64) {
+    blurred := gaussianBlur(img, sigma)
+    for i, v := range img.Pix {
+        sharp := float64(v) + amount*(float64(v)-blurred[i])
+        img.Pix[i] = uint8(math.Max(0, math.Min(255, math.Round(sharp))))
+    }
+}
+
+// gaussianBlur returns the blurred levels of img (same layout as Pix), done
+// as two 1D passes with edge pixels repeated.
+func gaussianBlur(img *image.Gray, sigma float64) []float64 {
+    radius := int(math.Ceil(3 * sigma))
+    kernel := make([]float64, 2*radius+1)
+    sum := 0.0
+    for i := range kernel {
+        d := float64(i - radius)
+        kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+        sum += kernel[i]
+    }
+    for i := range kernel {
+        kernel[i] /= sum
+    }
+
+    w, h := img.Rect.Dx(), img.Rect.Dy()
+    clamp := func(v, max int) int {
+        if v < 0 {
+            return 0
+        }
+        if v >= max {
+            return max - 1
+        }
+        return v
+    }
+    horizontal := make([]float64, w*h)
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            v := 0.0
+            for k, weight := range kernel {
+                v += weight * float64(img.Pix[y*img.Stride+clamp(x+k-radius, w)])
+            }
+            horizontal[y*w+x] = v
+        }
+    }
+    out := make([]float64, len(img.Pix))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            v := 0.0
+            for k, weight := range kernel {
+                v += weight * horizontal[clamp(y+k-radius, h)*w+x]
+            }
+            out[y*img.Stride+x] = v
+        }
+    }
+    return out
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "os"
+
+    "golang.org/x/image/font"
+    "golang.org/x/image/font/opentype"
+    "golang.org/x/image/math/fixed"
+)
+
+// Banners render each character as tall as the paper is wide, turned 90° so
+// the text runs along the roll. A few words make a banner of a meter or more.
+
+const (
+    DEFAULT_BANNER_FONT = "fonts/dotmatrixbold.ttf"
+    // Blank paper left along both edges of the roll
+    BANNER_MARGIN = 8
+)
+
+type BannerOptions struct {
+    // FontPath is a TrueType/OpenType font file
+    FontPath string
+    // Spacing is the gap between characters, in dots along the roll
+    Spacing int
+}
+
+// loadFontFace opens a TTF/OTF at a pixel size.
+func loadFontFace(path string, size float64) (font.Face, error) {
+    f, err := parsedFont(path, func() (*opentype.Font, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read font: %v", err)
+        }
+        f, err := opentype.Parse(data)
+        if err != nil {
+            return nil, fmt.Errorf("failed to parse font %s: %v", path, err)
+        }
+        return f, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// renderBanner lays the text out in one long line with glyphs filling the
+// paper width, then turns it so the first character prints first.
+func renderBanner(text string, opts BannerOptions) (image.Image, error) {
+    if opts.FontPath == "" {
+        opts.FontPath = DEFAULT_BANNER_FONT
+    }
+    height := PRINTER_WIDTH - 2*BANNER_MARGIN
+
+    // Scale the font so the ink of this particular text fills the height,
+    // rather than ascent + descent which leaves room for accents
+    probe, err := loadFontFace(opts.FontPath, 100)
+    if err != nil {
+        return nil, err
+    }
+    bounds, _ := font.BoundString(probe, text)
+    probe.Close()
+    ink := (bounds.Max.Y - bounds.Min.Y).Ceil()
+    if ink <= 0 {
+        return nil, fmt.Errorf("banner text has nothing to print")
+    }
+    face, err := loadFontFace(opts.FontPath, 100*float64(height)/float64(ink))
+    if err != nil {
+        return nil, err
+    }
+    defer face.Close()
+    bounds, _ = font.BoundString(face, text)
+    baseline := (-bounds.Min.Y).Ceil()
+
+    runes := []rune(text)
+    length := 0
+    for i, r := range runes {
+        adv, ok := face.GlyphAdvance(r)
+        if !ok {
+            adv, _ = face.GlyphAdvance('?')
+        }
+        length += adv.Ceil()
+        if i < len(runes)-1 {
+            length += opts.Spacing
+        }
+    }
+
+    line := image.NewGray(image.Rect(0, 0, length, height))
+    draw.Draw(line, line.Bounds(), image.White, image.Point{}, draw.Src)
+    d := &font.Drawer{Dst: line, Src: image.Black, Face: face}
+    x := 0
+    for _, r := range runes {
+        d.Dot = fixed.P(x, baseline)
+        d.DrawString(string(r))
+        adv, ok := face.GlyphAdvance(r)
+        if !ok {
+            adv, _ = face.GlyphAdvance('?')
+        }
+        x += adv.Ceil() + opts.Spacing
+    }
+
+    // Rotate 90° clockwise: the line's left end becomes the first row
+    out := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, length))
+    draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+    for y := 0; y < height; y++ {
+        for x := 0; x < length; x++ {
+            out.SetGray(PRINTER_WIDTH-1-BANNER_MARGIN-y, x, color.Gray{line.GrayAt(x, y).Y})
+        }
+    }
+    return out, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "time"
+)
+
+// Batches print several images back-to-back on one connection while holding
+// the job lock, so nothing from another client can land in between. Every
+// image is loaded and encoded up front: if any of them fails, nothing prints.
+// Once printing starts a failure stops the batch and the remaining jobs are
+// reported as skipped. The configured separator goes between the jobs.
+// Every job gets its own header, footer and job QR as on /print; one receipt
+// code, the feeds and the tear line go around the batch as a whole.
+
+const (
+    BATCH_PRINTED = "printed"
+    BATCH_FAILED  = "failed"
+    BATCH_SKIPPED = "skipped"
+    BATCH_INVALID = "invalid"
+)
+
+const MAX_BATCH_JOBS = 50
+
+type BatchJob struct {
+    Image string `json:"image"`
+    // Text the image was rendered from, if any (used by content filters)
+    Text string `json:"text,omitempty"`
+}
+
+type BatchJobResult struct {
+    Index  int    `json:"index"`
+    JobID  string `json:"job_id"`
+    Image  string `json:"image"`
+    Status string `json:"status"`
+    Error  string `json:"error,omitempty"`
+}
+
+type BatchResult struct {
+    // Status is "printed" only if every job printed
+    Status string `json:"status"`
+    Error  string `json:"error,omitempty"`
+    // Receipt is the code stamped at the start of the batch, if any
+    Receipt string           `json:"receipt,omitempty"`
+    Jobs    []BatchJobResult `json:"jobs"`
+}
+
+// PrintBatch prints jobs in order. check, if not nil, is called on every
+// prepared job before anything prints and can reject the batch.
+func (pd *PrinterDaemon) PrintBatch(jobs []BatchJob, opts PrintOptions, check func(BatchJob, *preparedImage) error) *BatchResult {
+    result := &BatchResult{Status: BATCH_PRINTED, Receipt: opts.Receipt, Jobs: make([]BatchJobResult, len(jobs))}
+    for i, job := range jobs {
+        result.Jobs[i] = BatchJobResult{Index: i, Image: job.Image, Status: BATCH_SKIPPED}
+    }
+    // Jobs that never get to print end up canceled in the tracker
+    defer func() {
+        for _, r := range result.Jobs {
+            if r.JobID != "" && r.Status != BATCH_PRINTED && r.Status != BATCH_FAILED {
+                pd.jobs.Finish(r.JobID, errJobCanceled)
+            }
+        }
+    }()
+    if len(jobs) == 0 {
+        result.Status = BATCH_INVALID
+        result.Error = "batch has no jobs"
+        return result
+    }
+    if len(jobs) > MAX_BATCH_JOBS {
+        result.Status = BATCH_INVALID
+        result.Error = fmt.Sprintf("batch has %d jobs, the limit is %d", len(jobs), MAX_BATCH_JOBS)
+        return result
+    }
+    separator := pd.separatorStyle(opts)
+    if !validSeparator(separator) {
+        result.Status = BATCH_INVALID
+        result.Error = fmt.Sprintf("unknown separator %q", separator)
+        return result
+    }
+
+    // Prepare everything before printing anything
+    prepared := make([]*preparedImage, len(jobs))
+    for i := range jobs {
+        result.Jobs[i].JobID = pd.jobs.New(opts.Source)
+    }
+    for i, job := range jobs {
+        pd.jobs.Set(result.Jobs[i].JobID, JobRendering, nil)
+        p, err := pd.prepareImage(job.Image, opts.Render)
+        if err == nil && check != nil {
+            err = check(job, p)
+        }
+        if err != nil {
+            result.Status = BATCH_INVALID
+            result.Error = fmt.Sprintf("job %d: %v", i, err)
+            result.Jobs[i].Status = BATCH_INVALID
+            result.Jobs[i].Error = err.Error()
+            pd.jobs.Finish(result.Jobs[i].JobID, err)
+            return result
+        }
+        prepared[i] = p
+        pd.jobs.SetRows(result.Jobs[i].JobID, p.numRows)
+        if opts.ReplyTo != "" {
+            pd.jobs.SetReply(result.Jobs[i].JobID, opts.ReplyTo, p)
+        }
+        pd.jobs.Set(result.Jobs[i].JobID, JobQueued, nil)
+        if ttl := pd.jobTTL(opts); ttl > 0 {
+            pd.jobs.SetExpiry(result.Jobs[i].JobID, time.Now().Add(ttl))
+        }
+    }
+
+    pd.lockForJob()
+    defer pd.jobMu.Unlock()
+
+    pd.jobs.Set(result.Jobs[0].JobID, JobConnecting, nil)
+    spool := false
+    if err := pd.connectForJob(result.Jobs[0].JobID); err != nil {
+        if pd.config.Offline != OFFLINE_FILE || err == errJobCanceled || err == errJobExpired {
+            result.Status = BATCH_FAILED
+            result.Error = err.Error()
+            pd.jobs.Finish(result.Jobs[0].JobID, err)
+            return result
+        }
+        log.Printf("%v", err)
+        spool = true
+    } else {
+        defer pd.releaseConnection()
+    }
+
+    // One receipt code for the whole batch, on the first job that prints
+    receipt := opts.Receipt
+    for i, p := range prepared {
+        jobID := result.Jobs[i].JobID
+        if job, _ := pd.jobs.Get(jobID); job.State == JobCanceled {
+            // Canceled while waiting, the rest of the batch still prints
+            continue
+        }
+        if pd.jobs.Expired(jobID) {
+            pd.jobs.Finish(jobID, errJobExpired)
+            result.Jobs[i].Error = errJobExpired.Error()
+            continue
+        }
+        log.Printf("Batch job %d/%d: %s", i+1, len(prepared), p.source)
+        jobOpts := opts
+        jobOpts.Receipt = receipt
+        p, err := pd.decorateBatchJob(jobID, p, jobOpts, separator, i == 0, i == len(prepared)-1)
+        if err == nil {
+            receipt = ""
+            if spool {
+                err = pd.spoolJob(jobID, p)
+            } else {
+                pd.jobs.Set(jobID, JobConnecting, nil)
+                stop := pd.watchJob(jobID)
+                err = pd.printPrepared(jobID, p)
+                stop()
+            }
+        }
+        pd.jobs.Finish(jobID, err)
+        if err != nil {
+            result.Status = BATCH_FAILED
+            result.Error = fmt.Sprintf("job %d: %v", i, err)
+            result.Jobs[i].Status = BATCH_FAILED
+            result.Jobs[i].Error = err.Error()
+            return result
+        }
+        result.Jobs[i].Status = BATCH_PRINTED
+        pd.lastSource = opts.Source
+    }
+    return result
+}
+
+// decorateBatchJob is decorate for one job of a batch. The header, footer
+// and job QR are the job's own, the separator goes between jobs, and the
+// feeds and tear line go around the batch as a whole.
+func (pd *PrinterDaemon) decorateBatchJob(jobID string, p *preparedImage, opts PrintOptions, separator string, first, last bool) (*preparedImage, error) {
+    p, err := pd.annotate(jobID, p, opts)
+    if err != nil {
+        return nil, err
+    }
+    if first {
+        p = withFeedBefore(p, pd.feedBeforeRows(opts))
+    }
+    if !first || (opts.Source != "" && opts.Source == pd.lastSource) {
+        if p, err = withSeparator(p, separator); err != nil {
+            return nil, err
+        }
+    }
+    if last {
+        if opts.TearLine || pd.config.TearLine {
+            p = withTearLine(p)
+        }
+        p = withFeed(p, pd.feedRows(opts))
+    }
+    return p, nil
+}
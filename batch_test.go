@@ -0,0 +1,36 @@
+package main
+
+import (
+    "image"
+    "testing"
+    "time"
+)
+
+// Batch jobs get the header, footer, receipt and job QR a single job gets.
+func TestBatchJobsAreDecorated(t *testing.T) {
+    pd := NewPrinterDaemon("", &Config{Header: "{{.Job}}", Footer: "{{.Time}}", JobQR: true, location: time.UTC})
+    plain := newPreparedImage("test", image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, 8)))
+    opts := PrintOptions{Receipt: "AC34"}
+
+    jobID := pd.jobs.New("test")
+    single, err := pd.decorate(jobID, plain, opts)
+    if err != nil {
+        t.Fatal(err)
+    }
+    first, err := pd.decorateBatchJob(jobID, plain, opts, SEPARATOR_NONE, true, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if first.numRows != single.numRows {
+        t.Errorf("batch job has %d rows, a single job %d", first.numRows, single.numRows)
+    }
+
+    opts.Receipt = ""
+    next, err := pd.decorateBatchJob(jobID, plain, opts, SEPARATOR_NONE, true, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if receipt := withReceipt(plain, "AC34").numRows - plain.numRows; next.numRows != first.numRows-receipt {
+        t.Errorf("job without the receipt has %d rows, want %d", next.numRows, first.numRows-receipt)
+    }
+}
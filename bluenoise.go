@@ -0,0 +1,144 @@
+package main
+
+import (
+    "image"
+    "math"
+    "math/rand"
+    "sync"
+)
+
+// Blue-noise dithering thresholds every pixel against a tiled mask whose
+// values are spread so that any threshold level gives evenly scattered dots
+// with no low-frequency clumps. Unlike Bayer it has no visible grid or
+// diagonal pattern, and unlike error diffusion no worms, and each pixel is
+// independent of the others. The 64x64 mask is made once, on first use, with
+// Ulichney's void-and-cluster method from a fixed seed, so every run and
+// every build prints identical dots.
+
+const (
+    BLUE_NOISE_SIZE = 64
+    // Spread of the Gaussian used to find clusters and voids
+    BLUE_NOISE_SIGMA = 1.5
+    // Share of dots in the initial random pattern
+    BLUE_NOISE_SEED_DENSITY = 0.1
+    BLUE_NOISE_SEED         = 1
+)
+
+var (
+    blueNoiseOnce sync.Once
+    blueNoise     []uint8
+)
+
+// blueNoiseMask returns the mask's thresholds, 0-255, row by row.
+func blueNoiseMask() []uint8 {
+    blueNoiseOnce.Do(func() {
+        blueNoise = makeBlueNoise(BLUE_NOISE_SIZE, BLUE_NOISE_SIGMA)
+    })
+    return blueNoise
+}
+
+// makeBlueNoise runs void-and-cluster on a size x size torus and returns the
+// rank of every pixel scaled to 0-255.
+func makeBlueNoise(size int, sigma float64) []uint8 {
+    n := size * size
+    // Gaussian weight by wrapped offset, so energy updates are table lookups
+    weights := make([]float64, n)
+    for dy := 0; dy < size; dy++ {
+        for dx := 0; dx < size; dx++ {
+            wx, wy := float64(min(dx, size-dx)), float64(min(dy, size-dy))
+            weights[dy*size+dx] = math.Exp(-(wx*wx + wy*wy) / (2 * sigma * sigma))
+        }
+    }
+    dots := make([]bool, n)
+    energy := make([]float64, n)
+    toggle := func(p int, on bool) {
+        dots[p] = on
+        sign := 1.0
+        if !on {
+            sign = -1
+        }
+        px, py := p%size, p/size
+        for q := range energy {
+            dx := (q%size - px + size) % size
+            dy := (q/size - py + size) % size
+            energy[q] += sign * weights[dy*size+dx]
+        }
+    }
+    // tightest is the dot with the most energy around it, largest the empty
+    // pixel with the least
+    tightest := func() int {
+        best := -1
+        for p := range dots {
+            if dots[p] && (best < 0 || energy[p] > energy[best]) {
+                best = p
+            }
+        }
+        return best
+    }
+    largest := func() int {
+        best := -1
+        for p := range dots {
+            if !dots[p] && (best < 0 || energy[p] < energy[best]) {
+                best = p
+            }
+        }
+        return best
+    }
+
+    // Random start, then move dots from clusters into voids until stable
+    rng := rand.New(rand.NewSource(BLUE_NOISE_SEED))
+    ones := int(float64(n) * BLUE_NOISE_SEED_DENSITY)
+    for _, p := range rng.Perm(n)[:ones] {
+        toggle(p, true)
+    }
+    for {
+        cluster := tightest()
+        toggle(cluster, false)
+        void := largest()
+        if void == cluster {
+            toggle(cluster, true)
+            break
+        }
+        toggle(void, true)
+    }
+    prototype := append([]bool{}, dots...)
+    protoEnergy := append([]float64{}, energy...)
+
+    rank := make([]int, n)
+    // Ranks below the prototype: take away dots, tightest first
+    for r := ones - 1; r >= 0; r-- {
+        p := tightest()
+        toggle(p, false)
+        rank[p] = r
+    }
+    // Ranks above it: fill the largest voids until every pixel has a dot
+    copy(dots, prototype)
+    copy(energy, protoEnergy)
+    for r := ones; r < n; r++ {
+        p := largest()
+        toggle(p, true)
+        rank[p] = r
+    }
+
+    mask := make([]uint8, n)
+    for p, r := range rank {
+        mask[p] = uint8((r*256 + 128) / n)
+    }
+    return mask
+}
+
+func blueNoiseDither(img image.Image) *image.Gray {
+    mask := blueNoiseMask()
+    b := img.Bounds()
+    out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        row := (y % BLUE_NOISE_SIZE) * BLUE_NOISE_SIZE
+        for x := 0; x < b.Dx(); x++ {
+            level := luminance(img.At(b.Min.X+x, b.Min.Y+y))
+            if level > mask[row+x%BLUE_NOISE_SIZE] {
+                out.Pix[y*out.Stride+x] = 255
+            }
+        }
+    }
+    return out
+}
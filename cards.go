@@ -0,0 +1,137 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "image/draw"
+    "sort"
+    "strings"
+    "time"
+
+    "golang.org/x/image/font"
+)
+
+// Cards are small prints generated from the config and a few parameters
+// instead of an uploaded image, such as today's sun and moon (sun.go), market
+// prices (ticker.go) or the days left until a date (countdown.go). Each
+// registers itself by name. The CLI prints one with "catprinter card", the
+// daemon with POST /print/<name>, and the daily digest (digest.go) stacks
+// several into one print.
+
+// CardFunc draws a card, PRINTER_WIDTH wide, for the given moment, with its
+// labels in the given locale (see i18n.go). Unknown parameters are ignored.
+type CardFunc func(cfg *Config, params map[string]string, now time.Time, l *Locale) (image.Image, error)
+
+var cards = map[string]CardFunc{}
+
+func registerCard(name string, render CardFunc) {
+    cards[name] = render
+}
+
+func cardList() string {
+    names := make([]string, 0, len(cards))
+    for name := range cards {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return strings.Join(names, ", ")
+}
+
+// CardRequest names a card and its parameters.
+type CardRequest struct {
+    Name   string            `json:"name"`
+    Params map[string]string `json:"params"`
+}
+
+// Blank rows between cards printed together
+const CARD_GAP = 24
+
+// renderCards draws the cards and stacks them top to bottom. Each is in its
+// own "locale" parameter, else the configured locale.
+func renderCards(cfg *Config, requests []CardRequest, now time.Time) (*image.Gray, error) {
+    var drawn []image.Image
+    height := 0
+    for _, req := range requests {
+        render, ok := cards[req.Name]
+        if !ok {
+            return nil, fmt.Errorf("unknown card %q (known: %s)", req.Name, cardList())
+        }
+        locale := req.Params["locale"]
+        if locale == "" {
+            locale = cfg.Locale
+        }
+        img, err := render(cfg, req.Params, now, NewLocale(locale))
+        if err != nil {
+            return nil, fmt.Errorf("%s card: %v", req.Name, err)
+        }
+        if len(drawn) > 0 {
+            height += CARD_GAP
+        }
+        drawn = append(drawn, img)
+        height += img.Bounds().Dy()
+    }
+    out := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, height))
+    draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+    y := 0
+    for _, img := range drawn {
+        b := img.Bounds()
+        draw.Draw(out, image.Rect(0, y, b.Dx(), y+b.Dy()), img, b.Min, draw.Src)
+        y += b.Dy() + CARD_GAP
+    }
+    return out, nil
+}
+
+// cardText draws lines of text in the given font, for building cards.
+func cardText(fontPath string, size float64, lineHeight int, lines ...string) (*image.Gray, error) {
+    t, err := newTextRenderer(fontPath, size, lineHeight)
+    if err != nil {
+        return nil, err
+    }
+    var wrapped []string
+    for _, line := range lines {
+        wrapped = append(wrapped, t.wrap(line)...)
+    }
+    return t.render(wrapped), nil
+}
+
+// cardCentered is cardText with every line centred on the paper.
+func cardCentered(fontPath string, size float64, lineHeight int, lines ...string) (*image.Gray, error) {
+    t, err := newTextRenderer(fontPath, size, lineHeight)
+    if err != nil {
+        return nil, err
+    }
+    var wrapped []string
+    for _, line := range lines {
+        wrapped = append(wrapped, t.wrap(line)...)
+    }
+    img := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, len(wrapped)*lineHeight))
+    draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+    for i, line := range wrapped {
+        x := (PRINTER_WIDTH - font.MeasureString(t.face, line).Ceil()) / 2
+        y := i * lineHeight
+        draw.Draw(img, image.Rect(x, y, PRINTER_WIDTH, y+lineHeight), t.renderLine(line), image.Point{}, draw.Src)
+    }
+    return img, nil
+}
+
+// cardColumns draws label and value pairs as two columns, the values
+// starting valueX dots in.
+func cardColumns(valueX int, pairs ...[2]string) (*image.Gray, error) {
+    var labels, values []string
+    for _, pair := range pairs {
+        labels = append(labels, pair[0])
+        values = append(values, pair[1])
+    }
+    left, err := cardText(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT, labels...)
+    if err != nil {
+        return nil, err
+    }
+    right, err := cardText(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT, values...)
+    if err != nil {
+        return nil, err
+    }
+    out := image.NewGray(left.Bounds())
+    draw.Draw(out, out.Bounds(), left, image.Point{}, draw.Src)
+    draw.Draw(out, image.Rect(valueX, 0, PRINTER_WIDTH, right.Bounds().Dy()), right, image.Point{}, draw.Src)
+    return out, nil
+}
@@ -0,0 +1,128 @@
+//go:build daemon
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "slices"
+    "sort"
+    "strings"
+)
+
+// POST /print/<card> prints one card (see cards.go). The query string holds
+// the card's parameters, next to source, separator, feed and feed_before as
+// on /print/text. Parameters such as a countdown's label end up on paper, so
+// they go through the content filters, and in gallery mode the card waits
+// for an admin like any other submission.
+
+// Query parameters that are print options rather than card parameters
+var CARD_PRINT_OPTIONS = []string{"source", "separator", "feed", "feed_before"}
+
+// cardParamText is what a card request says in its own words, one
+// parameter per line, for the text filters.
+func cardParamText(params map[string]string) string {
+    keys := make([]string, 0, len(params))
+    for key := range params {
+        if !slices.Contains(CARD_PRINT_OPTIONS, key) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    lines := make([]string, len(keys))
+    for i, key := range keys {
+        lines[i] = params[key]
+    }
+    return strings.Join(lines, "\n")
+}
+
+func (api *HTTPAPI) registerCardHandlers(mux *http.ServeMux) {
+    config := api.engine.Config()
+    for name := range cards {
+        name := name
+        mux.HandleFunc("/print/"+name, func(w http.ResponseWriter, r *http.Request) {
+            if r.Method != "POST" {
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+            }
+            user, ok := api.requireUser(w, r)
+            if !ok {
+                return
+            }
+            if api.guard.Enabled() && user == nil && !api.isAdmin(r) {
+                if err := api.guard.Verify(r); err != nil {
+                    http.Error(w, err.Error(), http.StatusForbidden)
+                    return
+                }
+            }
+            query := r.URL.Query()
+            opts := PrintOptions{Source: query.Get("source"), Separator: query.Get("separator")}
+            if opts.Source == "" {
+                opts.Source = name
+            }
+            if !validSeparator(opts.Separator) {
+                http.Error(w, "Unknown separator", http.StatusBadRequest)
+                return
+            }
+            var err error
+            if opts.FeedAfter, err = parseJobFeed(query.Get("feed")); err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            if opts.FeedBefore, err = parseJobFeed(query.Get("feed_before")); err != nil {
+                http.Error(w, "feed_before: "+err.Error(), http.StatusBadRequest)
+                return
+            }
+            params := map[string]string{}
+            for key := range query {
+                params[key] = query.Get(key)
+            }
+            if !api.takeQuota(w, user, 1) {
+                return
+            }
+            opts = api.engine.WithSourceDefaults(opts)
+
+            // Moderated cards get a job once approved, as on /print
+            moderated := config.Moderation && !api.isAdmin(r)
+            jobID := ""
+            if !moderated {
+                jobID = api.engine.NewJob(opts.Source)
+                w.Header().Set("X-Job-ID", jobID)
+            }
+            prepared, err := api.engine.PrepareCards(jobID, []CardRequest{{Name: name, Params: params}}, opts.Source)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            if err := api.filters.Check(opts.Source, cardParamText(params), prepared); err != nil {
+                api.engine.Reject(jobID, err)
+                writeFilterError(w, err)
+                return
+            }
+            if moderated {
+                job, err := api.moderation.Submit(prepared, opts)
+                if err != nil {
+                    http.Error(w, err.Error(), http.StatusServiceUnavailable)
+                    return
+                }
+                log.Printf("Queued %s for moderation", job.ID)
+                w.Header().Set("Content-Type", "application/json")
+                w.WriteHeader(http.StatusAccepted)
+                json.NewEncoder(w).Encode(map[string]string{"id": job.ID, "status": "pending"})
+                return
+            }
+            if err := api.engine.Print(jobID, prepared, opts); err != nil {
+                if err == errJobCanceled || err == errJobExpired {
+                    http.Error(w, err.Error(), http.StatusConflict)
+                    return
+                }
+                log.Printf("Print failed: %v", err)
+                http.Error(w, fmt.Sprintf("Print failed: %v", err), http.StatusInternalServerError)
+                return
+            }
+            w.Write([]byte("Printed successfully"))
+        })
+    }
+}
@@ -0,0 +1,40 @@
+//go:build daemon
+
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestCardsAreFilteredAndModerated(t *testing.T) {
+    tests := []struct {
+        name   string
+        config string
+        query  string
+        want   int
+    }{
+        {"banned label", `{"filters": {"*": {"banned_words": "(?i)\\bspam\\b"}}}`, "to=2030-01-01&label=SPAM", http.StatusUnprocessableEntity},
+        // Held for moderation once past the filters, so nothing prints
+        {"option values aren't card text", `{"moderation": true, "admin_token": "admin-token-0123456789", "filters": {"*": {"max_chars": 20}}}`, "to=2030-01-01&label=New+year&source=a-long-source-name", http.StatusAccepted},
+        {"gallery mode", `{"moderation": true, "admin_token": "admin-token-0123456789"}`, "to=2030-01-01&label=Launch", http.StatusAccepted},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            handler := newTestAPI(t, tt.config)
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, httptest.NewRequest("POST", "/print/countdown?"+tt.query, nil))
+            if rec.Code != tt.want {
+                t.Errorf("got %d (%s), want %d", rec.Code, rec.Body.String(), tt.want)
+            }
+        })
+    }
+}
+
+func TestCardParamText(t *testing.T) {
+    got := cardParamText(map[string]string{"to": "12-25", "label": "Christmas", "source": "web", "feed": "80"})
+    if want := "Christmas\n12-25"; got != want {
+        t.Errorf("got %q, want %q", got, want)
+    }
+}
@@ -1,251 +1,480 @@
+//go:build !daemon
+
 package main
 
 import (
-    "context"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
     "fmt"
-    "image"
-    "image/png"
     "log"
     "os"
-    "time"
     "strings"
-
-    "github.com/go-ble/ble"
-    "github.com/go-ble/ble/linux"
-)
-
-const (
-    PRINTER_WIDTH       = 384
-    PRINTER_WIDTH_BYTES = PRINTER_WIDTH / 8
-    MIN_DATA_BYTES      = 90 * PRINTER_WIDTH_BYTES
-    CONTROL_WRITE_UUID  = "0000ae01-0000-1000-8000-00805f9b34fb"
-    DATA_WRITE_UUID     = "0000ae03-0000-1000-8000-00805f9b34fb"
+    "time"
 )
 
 func main() {
-    if len(os.Args) < 3 {
-        fmt.Println("Usage: catprinter <image.png> <printer-mac>")
+    if len(os.Args) > 1 && os.Args[1] == "raw" {
+        runRaw(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "banner" {
+        runBanner(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "card" {
+        runCard(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "testpage" {
+        runTestPage(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "papertest" {
+        runPaperTest(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "compat" {
+        runCompat(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "tail" {
+        runTail(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "replay" {
+        runReplay(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "version" {
+        runVersion()
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "protocol" {
+        runProtocol()
+        return
+    }
+    preset := flag.String("preset", "", "option bundle: "+presetList()+" (default from config)")
+    dither := flag.String("dither", "", "dithering: "+ditherModeList()+" (default from config)")
+    frames := flag.Bool("frames", false, "print every frame of an animated GIF as a strip")
+    threshold := flag.Int("threshold", 0, "gray level (1-255) below which pixels print black (default from config, else 128)")
+    brightness := flag.Int("brightness", 0, "brightness adjustment, -100 to 100 (default from config)")
+    contrast := flag.Int("contrast", 0, "contrast adjustment, -100 to 100 (default from config)")
+    gamma := flag.Float64("gamma", 0, "gamma, above 1 lightens midtones (default from config)")
+    align := flag.String("align", "", "keep narrow images at their size: left, center or right (default: scale to fit)")
+    margin := flag.Int("margin", 0, "dots kept clear on both sides (default from config)")
+    density := flag.String("density", "", "full, or half for economy drafts (default from config)")
+    invert := flag.Bool("invert", false, "print a negative (white on black)")
+    flipH := flag.Bool("flip-h", false, "mirror left to right, for iron-on transfers or reading through glass")
+    flipV := flag.Bool("flip-v", false, "flip upside down")
+    sharpen := flag.Float64("sharpen", 0, "unsharp mask amount, 0-5 (default from config)")
+    equalize := flag.Bool("equalize", false, "equalize the histogram first, for faint or low-contrast scans")
+    tearLine := flag.Bool("tear-line", false, "print a scissors line at the end, before the feed")
+    feed := flag.String("feed", "", "blank paper after the print, in lines or mm (e.g. 40 or 10mm; default from config)")
+    feedBefore := flag.String("feed-before", "", "blank paper before the print, like -feed")
+    poster := flag.Int("poster", 0, "cut the image into this many strips, printed one after another, to tape into a poster")
+    posterOverlap := flag.Int("poster-overlap", 0, "dots neighbouring poster strips share, room for tape (8 per mm)")
+    posterLabels := flag.Bool("poster-labels", false, "number the poster strips")
+    scale := flag.Int("scale", 0, fmt.Sprintf("enlarge pixel art, every pixel becoming scale x scale dots (1-%d)", MAX_SCALE))
+    crop := flag.String("crop", "", "print only this region of the image: x,y,w,h in its pixels")
+    pipeline := flag.String("pipeline", "", "image stages to run, in order, comma separated (default: "+strings.Join(DEFAULT_PIPELINE, ",")+")")
+    dryRun := flag.Bool("dry-run", false, "write what would print to a PNG (see -o) instead of printing; no printer address needed")
+    output := flag.String("o", "preview.png", "where -dry-run writes the preview")
+    flag.Usage = func() {
+        fmt.Println("Usage: catprinter [-preset photo] [-dither mode] [-threshold 128] [-brightness 0] [-contrast 0] [-gamma 1] [-sharpen 1] [-equalize] [-frames] [-align center] [-margin 0] [-density half] [-invert] [-flip-h] [-flip-v] [-tear-line] [-feed-before 5mm] [-feed 10mm] [-poster 3 [-poster-overlap 16] [-poster-labels]] [-scale 2] [-crop x,y,w,h] [-pipeline layout,adjust,dither] <image.png|photo.jpg|-|s3://bucket/key|davs://host/path>... <printer-mac>")
+        fmt.Println("       catprinter -dry-run [-o preview.png] [options] <image>...")
+        fmt.Println("       catprinter raw [-data] [-wait 2s] <printer-mac> <cmd-hex> [payload-hex]")
+        fmt.Println("       catprinter banner [-font file.ttf] [-spacing 16] <printer-mac> <text>")
+        fmt.Println("       catprinter card [-o card.png] <printer-mac> <card> [key=value]...")
+        fmt.Println("       catprinter testpage [-intensity 160] <printer-mac>")
+        fmt.Println("       catprinter papertest [-heats 112,144,176,208] [-speeds 0,10,20] [-name blue-sticker] <printer-mac>")
+        fmt.Println("       catprinter compat [-d device] [-e energy] [-b algo] [-s] <filename>")
+        fmt.Println("       catprinter tail [-f] [-n 10] [-rate 30] <printer-mac> <file>")
+        fmt.Println("       catprinter replay [-speed 1] <printer-mac> <traffic.jsonl>")
+        fmt.Println("       catprinter protocol")
+        fmt.Println("       catprinter version")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    if flag.NArg() < 2 && !(*dryRun && flag.NArg() == 1) {
+        flag.Usage()
+        os.Exit(1)
+    }
+    // Several images are stacked into one print
+    imgPaths := flag.Args()[:flag.NArg()-1]
+    macAddr := flag.Arg(flag.NArg() - 1)
+    if *dryRun {
+        imgPaths, macAddr = flag.Args(), ""
+    }
+    if !validDither(DitherMode(*dither)) {
+        log.Printf("Unknown dither mode %q (known: %s)", *dither, ditherModeList())
+        os.Exit(1)
+    }
+    if !validThreshold(*threshold) {
+        log.Printf("Threshold must be between 1 and 255")
+        os.Exit(1)
+    }
+    if !validAlign(*align) || !validMargin(*margin) {
+        log.Printf("Align must be left, center or right, margin 0-%d", MAX_MARGIN)
+        os.Exit(1)
+    }
+    if !validScale(*scale) {
+        log.Printf("Scale must be between 1 and %d", MAX_SCALE)
+        os.Exit(1)
+    }
+    if !validPreset(*preset) {
+        log.Printf("Unknown preset %q (known: %s)", *preset, presetList())
+        os.Exit(1)
+    }
+    if !validDensity(*density) {
+        log.Printf("Density must be full or half")
+        os.Exit(1)
+    }
+    if err := validPoster(*poster, *posterOverlap); err != nil {
+        log.Printf("%v", err)
+        os.Exit(1)
+    }
+    cropRegion, err := parseCrop(*crop)
+    if err != nil {
+        log.Printf("%v", err)
+        os.Exit(1)
+    }
+    stages, err := parsePipeline(*pipeline)
+    if err != nil {
+        log.Printf("%v", err)
+        os.Exit(1)
+    }
+    adjust := Adjustments{Equalize: *equalize, Brightness: *brightness, Contrast: *contrast, Gamma: *gamma, Sharpen: *sharpen}
+    if err := adjust.validate(); err != nil {
+        log.Printf("%v", err)
+        os.Exit(1)
+    }
+    feedRows, err := parseJobFeed(*feed)
+    if err != nil {
+        log.Printf("%v", err)
+        os.Exit(1)
+    }
+    feedBeforeRows, err := parseJobFeed(*feedBefore)
+    if err != nil {
+        log.Printf("-feed-before: %v", err)
         os.Exit(1)
     }
-    imgPath := os.Args[1]
-    macAddr := os.Args[2]
 
-    img, err := loadAndBinarizeImage(imgPath)
+    cfg, err := loadConfig()
     if err != nil {
-        log.Printf("Failed to load image: %v", err)
+        log.Printf("Failed to load config: %v", err)
         os.Exit(1)
     }
-    buffer := encodeImageToBuffer(img)
+    cfg.stdin = true
+    opts := PrintOptions{Render: RenderOptions{Dither: DitherMode(*dither), Frames: *frames, Threshold: *threshold, Adjust: adjust, Invert: *invert, FlipH: *flipH, FlipV: *flipV, Align: *align, Margin: *margin, Density: *density, Preset: *preset, Poster: *poster, PosterOverlap: *posterOverlap, PosterLabels: *posterLabels, Scale: *scale, Crop: cropRegion, Pipeline: stages}, FeedAfter: feedRows, FeedBefore: feedBeforeRows, TearLine: *tearLine}
 
-    // Try to create device with retries
-    var d ble.Device
-    var deviceErr error
-    maxRetries := 3
-    
-    for i := 0; i < maxRetries; i++ {
-        d, deviceErr = linux.NewDevice()
-        if deviceErr == nil {
-            break
+    if *dryRun {
+        preview, err := NewEngine("", cfg).DryRun(imgPaths, opts)
+        if err == nil {
+            err = os.WriteFile(*output, preview, 0644)
         }
-        log.Printf("Attempt %d: Can't create device: %s", i+1, deviceErr)
-        if i < maxRetries-1 {
-            time.Sleep(2 * time.Second)
+        if err != nil {
+            log.Printf("Dry run failed: %v", err)
+            os.Exit(1)
         }
+        fmt.Printf("Preview written to %s\n", *output)
+        return
     }
-    
-    if deviceErr != nil {
-        log.Printf("Failed to create device after %d attempts: %s", maxRetries, deviceErr)
-        os.Exit(1)
-    }
-    ble.SetDefaultDevice(d)
+
+    // The CLI drives the same engine as the daemon, so it gets the same
+    // retries and temperature throttling.
+    engine := NewEngine(macAddr, cfg)
     defer func() {
-        if d != nil {
-            fmt.Println("Stopping Bluetooth device...")
-            d.Stop()
-            fmt.Println("Bluetooth device stopped.")
-        }
+        fmt.Println("Stopping Bluetooth device...")
+        engine.Close()
+        fmt.Println("Bluetooth device stopped.")
     }()
 
-    // Try to connect with retries
-    var client ble.Client
-    var connectErr error
-    ctx := ble.WithSigHandler(context.WithTimeout(context.Background(), 60*time.Second))
-    
-    for i := 0; i < maxRetries; i++ {
-        client, connectErr = ble.Dial(ctx, ble.NewAddr(macAddr))
-        if connectErr == nil {
-            break
-        }
-        log.Printf("Attempt %d: Failed to connect: %v", i+1, connectErr)
-        if i < maxRetries-1 {
-            time.Sleep(2 * time.Second)
+    events, stopEvents := engine.Subscribe()
+    go func() {
+        for job := range events {
+            fmt.Printf("Job %s: %s\n", job.ID, job.State)
         }
+    }()
+    defer stopEvents()
+
+    fmt.Println("Sending print job...")
+    if err := engine.PrintImages(imgPaths, opts); err != nil {
+        log.Printf("Print failed: %v", err)
+        engine.Close()
+        os.Exit(1)
+    }
+    fmt.Println("Print job completed successfully!")
+}
+
+// runBanner implements "catprinter banner": huge characters running along
+// the roll.
+func runBanner(args []string) {
+    fs := flag.NewFlagSet("banner", flag.ExitOnError)
+    fontPath := fs.String("font", DEFAULT_BANNER_FONT, "TrueType/OpenType font to render with")
+    spacing := fs.Int("spacing", 16, "gap between characters, in dots (8 per mm)")
+    fs.Usage = func() {
+        fmt.Println("Usage: catprinter banner [-font file.ttf] [-spacing 16] <printer-mac> <text>")
+        fs.PrintDefaults()
     }
-    
-    if connectErr != nil {
-        log.Printf("Failed to connect after %d attempts: %v", maxRetries, connectErr)
+    fs.Parse(args)
+
+    rest := fs.Args()
+    if len(rest) < 2 {
+        fs.Usage()
         os.Exit(1)
     }
-    defer func() {
-        fmt.Println("Cancelling BLE connection...")
-        client.CancelConnection()
-        fmt.Println("BLE connection cancelled.")
-    }()
+    macAddr := rest[0]
+    text := strings.Join(rest[1:], " ")
 
-    // Find characteristics
-    prof, err := client.DiscoverProfile(true)
+    cfg, err := loadConfig()
     if err != nil {
-        log.Printf("Failed to discover profile: %v", err)
+        log.Printf("Failed to load config: %v", err)
         os.Exit(1)
     }
-    var controlChar, dataChar *ble.Characteristic
-    for _, s := range prof.Services {
-        for _, c := range s.Characteristics {
-            fmt.Printf("Service: %s\n  Characteristic: %s - Properties: %d\n", s.UUID.String(), c.UUID.String(), c.Property)
-            if strings.HasSuffix(strings.ToLower(c.UUID.String()), "ae01") {
-                controlChar = c
-            }
-            if strings.HasSuffix(strings.ToLower(c.UUID.String()), "ae03") {
-                dataChar = c
-            }
-        }
+    img, err := renderBanner(text, BannerOptions{FontPath: *fontPath, Spacing: *spacing})
+    if err != nil {
+        log.Printf("Failed to render banner: %v", err)
+        os.Exit(1)
+    }
+    fmt.Printf("Banner is %.2f m long\n", float64(img.Bounds().Dy())/8/1000)
+
+    engine := NewEngine(macAddr, cfg)
+    opts := PrintOptions{Source: "banner"}
+    err = engine.Print(engine.NewJob(opts.Source), newPreparedImage("banner", img), opts)
+    engine.Close()
+    if err != nil {
+        log.Printf("Print failed: %v", err)
+        os.Exit(1)
     }
-    if controlChar == nil || dataChar == nil {
-        log.Printf("Could not find required characteristics")
+    fmt.Println("Banner printed!")
+}
+
+// runTestPage implements "catprinter testpage": ramps, checkerboards and
+// line patterns for setting the intensity and spotting dead dots.
+func runTestPage(args []string) {
+    fs := flag.NewFlagSet("testpage", flag.ExitOnError)
+    intensity := fs.Int("intensity", 0, "print head heat, 1-255 (default from config)")
+    fs.Usage = func() {
+        fmt.Println("Usage: catprinter testpage [-intensity 160] <printer-mac>")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+
+    if fs.NArg() != 1 || *intensity < 0 || *intensity > 255 {
+        fs.Usage()
         os.Exit(1)
     }
+    macAddr := fs.Arg(0)
 
-    // Set intensity
-    fmt.Println("Writing set intensity...")
-    err = client.WriteCharacteristic(controlChar, createCommand(0xA2, []byte{0xA0}), true)
+    cfg, err := loadConfig()
     if err != nil {
-        log.Printf("Failed to write set intensity: %v", err)
+        log.Printf("Failed to load config: %v", err)
         os.Exit(1)
     }
-    fmt.Println("Set intensity written.")
-    time.Sleep(1 * time.Second)
+    title := "catprinter test page"
+    if *intensity > 0 {
+        title = fmt.Sprintf("%s, intensity %d", title, *intensity)
+    }
+    prepared := newPreparedImage("testpage", renderTestPage(title))
+    prepared.intensity = byte(*intensity)
 
-    // Print request
-    fmt.Println("Writing print request...")
-    numRows := img.Bounds().Dy()
-    err = client.WriteCharacteristic(controlChar, createCommand(0xA9, []byte{
-        byte(numRows & 0xFF),
-        byte((numRows >> 8) & 0xFF),
-        0x30, 0x00,
-    }), true)
+    engine := NewEngine(macAddr, cfg)
+    opts := PrintOptions{Source: "testpage"}
+    err = engine.Print(engine.NewJob(opts.Source), prepared, opts)
+    engine.Close()
     if err != nil {
-        log.Printf("Failed to write print request: %v", err)
+        log.Printf("Print failed: %v", err)
         os.Exit(1)
     }
-    fmt.Println("Print request written.")
-    time.Sleep(1 * time.Second)
+    fmt.Println("Test page printed!")
+}
 
-    // Send image data
-    fmt.Println("Sending image data...")
-    for i := 0; i < len(buffer); i += PRINTER_WIDTH_BYTES {
-        row := buffer[i : i+PRINTER_WIDTH_BYTES]
-        for j := 0; j < PRINTER_WIDTH_BYTES; j += 20 {
-            end := j + 20
-            if end > PRINTER_WIDTH_BYTES {
-                end = PRINTER_WIDTH_BYTES
-            }
-            chunk := row[j:end]
-            fmt.Printf("Writing sub-chunk (len=%d)\n", len(chunk))
-            err = client.WriteCharacteristic(dataChar, chunk, true)
-            if err != nil {
-                log.Printf("Failed to write image data sub-chunk: %v", err)
-                os.Exit(1)
-            }
-            time.Sleep(5 * time.Millisecond)
+// runCard implements "catprinter card": one generated card (see cards.go),
+// printed or saved as a PNG.
+func runCard(args []string) {
+    fs := flag.NewFlagSet("card", flag.ExitOnError)
+    output := fs.String("o", "", "write the card to this PNG instead of printing it (no printer-mac then)")
+    fs.Usage = func() {
+        fmt.Println("Usage: catprinter card [-o card.png] <printer-mac> <card> [key=value]...")
+        fmt.Println("Cards: " + cardList())
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+
+    rest := fs.Args()
+    var macAddr string
+    if *output == "" && len(rest) > 0 {
+        macAddr, rest = rest[0], rest[1:]
+    }
+    if len(rest) < 1 {
+        fs.Usage()
+        os.Exit(1)
+    }
+    req := CardRequest{Name: rest[0], Params: map[string]string{}}
+    for _, param := range rest[1:] {
+        key, value, ok := strings.Cut(param, "=")
+        if !ok {
+            log.Printf("Card parameters are key=value, got %q", param)
+            os.Exit(1)
         }
+        req.Params[key] = value
     }
-    fmt.Println("Image data sent.")
 
-    // Flush after image data
-    fmt.Println("Writing flush command...")
-    err = client.WriteCharacteristic(controlChar, createCommand(0xAD, []byte{0x00}), true)
+    cfg, err := loadConfig()
     if err != nil {
-        log.Printf("Failed to write flush: %v", err)
+        log.Printf("Failed to load config: %v", err)
         os.Exit(1)
     }
-    fmt.Println("Flush written after image data.")
-    fmt.Println("Print job sent!")
-    
-    // Ensure proper cleanup
-    time.Sleep(2 * time.Second) // Give printer time to process
-    fmt.Println("Print job completed successfully!")
+    if *output != "" {
+        img, err := renderCards(cfg, []CardRequest{req}, time.Now().In(cfg.location))
+        var preview []byte
+        if err == nil {
+            preview, err = newPreparedImage(req.Name, img).png()
+        }
+        if err == nil {
+            err = os.WriteFile(*output, preview, 0644)
+        }
+        if err != nil {
+            log.Printf("Card failed: %v", err)
+            os.Exit(1)
+        }
+        fmt.Printf("Card written to %s\n", *output)
+        return
+    }
+
+    engine := NewEngine(macAddr, cfg)
+    err = engine.PrintCards([]CardRequest{req}, PrintOptions{Source: req.Name})
+    engine.Close()
+    if err != nil {
+        log.Printf("Print failed: %v", err)
+        os.Exit(1)
+    }
+    fmt.Println("Card printed!")
 }
 
-func loadAndBinarizeImage(path string) (image.Image, error) {
-    f, err := os.Open(path)
+// runReplay implements "catprinter replay": send what an app sent to the
+// virtual printer to a real one again.
+func runReplay(args []string) {
+    fs := flag.NewFlagSet("replay", flag.ExitOnError)
+    speed := fs.Float64("speed", 1, "playback speed, 2 halves the gaps between packets")
+    fs.Usage = func() {
+        fmt.Println("Usage: catprinter replay [-speed 1] <printer-mac> <traffic.jsonl>")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+
+    rest := fs.Args()
+    if len(rest) < 2 {
+        fs.Usage()
+        os.Exit(1)
+    }
+    records, err := readTraffic(rest[1])
     if err != nil {
-        return nil, err
+        log.Printf("Failed to read recording: %v", err)
+        os.Exit(1)
     }
-    defer f.Close()
-    img, err := png.Decode(f)
+    cfg, err := loadConfig()
     if err != nil {
-        return nil, err
+        log.Printf("Failed to load config: %v", err)
+        os.Exit(1)
     }
-    // Assume image is already 1-bit, 384px wide. If not, preprocess in Node.js.
-    return img, nil
+    engine := NewEngine(rest[0], cfg)
+    err = engine.Replay(records, *speed)
+    engine.Close()
+    if err != nil {
+        log.Printf("Replay failed: %v", err)
+        os.Exit(1)
+    }
+    fmt.Printf("Replayed %d records.\n", len(records))
+}
+
+// runVersion prints the same build info as the daemon's /version.
+func runVersion() {
+    cfg, err := loadConfig()
+    if err != nil {
+        log.Printf("Failed to load config: %v", err)
+        os.Exit(1)
+    }
+    out, _ := json.MarshalIndent(versionInfo(cfg), "", "  ")
+    fmt.Println(string(out))
 }
 
-func encodeImageToBuffer(img image.Image) []byte {
-    bounds := img.Bounds()
-    width := bounds.Dx()
-    height := bounds.Dy()
-    buffer := make([]byte, 0, height*PRINTER_WIDTH_BYTES)
-    for y := 0; y < height; y++ {
-        for xByte := 0; xByte < PRINTER_WIDTH_BYTES; xByte++ {
-            var b byte
-            for bit := 0; bit < 8; bit++ {
-                x := xByte*8 + bit
-                if x < width {
-                    r, g, bCol, _ := img.At(x, y).RGBA()
-                    // If pixel is black, set bit (LSB-first)
-                    if r < 0x8000 && g < 0x8000 && bCol < 0x8000 {
-                        b |= 1 << bit
-                    }
-                }
+// runProtocol prints the same wire format description as the daemon's
+// /protocol, for the configured model.
+func runProtocol() {
+    cfg, err := loadConfig()
+    if err != nil {
+        log.Printf("Failed to load config: %v", err)
+        os.Exit(1)
+    }
+    out, _ := json.MarshalIndent(describeProtocol(cfg.profile), "", "  ")
+    fmt.Println(string(out))
+}
+
+// runRaw implements "catprinter raw": send one command (framed with the
+// header/CRC/footer) or a raw AE03 data write, then dump the notifications.
+func runRaw(args []string) {
+    fs := flag.NewFlagSet("raw", flag.ExitOnError)
+    toData := fs.Bool("data", false, "write the bytes unframed to the data characteristic (AE03)")
+    wait := fs.Duration("wait", 2*time.Second, "how long to collect notifications")
+    fs.Usage = func() {
+        fmt.Println("Usage: catprinter raw [-data] [-wait 2s] <printer-mac> <cmd-hex> [payload-hex]")
+        fmt.Println("       catprinter raw -data <printer-mac> <bytes-hex>")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+
+    rest := fs.Args()
+    if len(rest) < 2 {
+        fs.Usage()
+        os.Exit(1)
+    }
+    macAddr := rest[0]
+
+    var cmdID byte
+    var payload []byte
+    var err error
+    if *toData {
+        payload, err = parseHexBytes(rest[1])
+    } else {
+        var cmd []byte
+        cmd, err = parseHexBytes(rest[1])
+        if err == nil && len(cmd) != 1 {
+            err = fmt.Errorf("command ID must be a single byte")
+        }
+        if err == nil {
+            cmdID = cmd[0]
+            if len(rest) > 2 {
+                payload, err = parseHexBytes(rest[2])
+            } else {
+                payload = []byte{0x00}
             }
-            buffer = append(buffer, b)
         }
     }
-    // Pad to minimum size
-    for len(buffer) < MIN_DATA_BYTES {
-        buffer = append(buffer, 0)
+    if err != nil {
+        log.Printf("Invalid arguments: %v", err)
+        os.Exit(1)
     }
-    return buffer
-}
 
-func createCommand(cmdId byte, payload []byte) []byte {
-    header := []byte{0x22, 0x21, cmdId, 0x00, byte(len(payload)), byte(len(payload) >> 8)}
-    crc := calculateCRC8(payload)
-    return append(append(header, payload...), crc, 0xFF)
-}
+    cfg, err := loadConfig()
+    if err != nil {
+        log.Printf("Failed to load config: %v", err)
+        os.Exit(1)
+    }
+    engine := NewEngine(macAddr, cfg)
+    result, err := engine.SendRaw(*toData, cmdID, payload, *wait)
+    engine.Close()
+    if err != nil {
+        log.Printf("Raw command failed: %v", err)
+        os.Exit(1)
+    }
 
-func calculateCRC8(data []byte) byte {
-    table := [256]byte{
-        0x00,0x07,0x0E,0x09,0x1C,0x1B,0x12,0x15,0x38,0x3F,0x36,0x31,0x24,0x23,0x2A,0x2D,
-        0x70,0x77,0x7E,0x79,0x6C,0x6B,0x62,0x65,0x48,0x4F,0x46,0x41,0x54,0x53,0x5A,0x5D,
-        0xE0,0xE7,0xEE,0xE9,0xFC,0xFB,0xF2,0xF5,0xD8,0xDF,0xD6,0xD1,0xC4,0xC3,0xCA,0xCD,
-        0x90,0x97,0x9E,0x99,0x8C,0x8B,0x82,0x85,0xA8,0xAF,0xA6,0xA1,0xB4,0xB3,0xBA,0xBD,
-        0xC7,0xC0,0xC9,0xCE,0xDB,0xDC,0xD5,0xD2,0xFF,0xF8,0xF1,0xF6,0xE3,0xE4,0xED,0xEA,
-        0xB7,0xB0,0xB9,0xBE,0xAB,0xAC,0xA5,0xA2,0x8F,0x88,0x81,0x86,0x93,0x94,0x9D,0x9A,
-        0x27,0x20,0x29,0x2E,0x3B,0x3C,0x35,0x32,0x1F,0x18,0x11,0x16,0x03,0x04,0x0D,0x0A,
-        0x57,0x50,0x59,0x5E,0x4B,0x4C,0x45,0x42,0x6F,0x68,0x61,0x66,0x73,0x74,0x7D,0x7A,
-        0x89,0x8E,0x87,0x80,0x95,0x92,0x9B,0x9C,0xB1,0xB6,0xBF,0xB8,0xAD,0xAA,0xA3,0xA4,
-        0xF9,0xFE,0xF7,0xF0,0xE5,0xE2,0xEB,0xEC,0xC1,0xC6,0xCF,0xC8,0xDD,0xDA,0xD3,0xD4,
-        0x69,0x6E,0x67,0x60,0x75,0x72,0x7B,0x7C,0x51,0x56,0x5F,0x58,0x4D,0x4A,0x43,0x44,
-        0x19,0x1E,0x17,0x10,0x05,0x02,0x0B,0x0C,0x21,0x26,0x2F,0x28,0x3D,0x3A,0x33,0x34,
-        0x4E,0x49,0x40,0x47,0x52,0x55,0x5C,0x5B,0x76,0x71,0x78,0x7F,0x6A,0x6D,0x64,0x63,
-        0x3E,0x39,0x30,0x37,0x22,0x25,0x2C,0x2B,0x06,0x01,0x08,0x0F,0x1A,0x1D,0x14,0x13,
-        0xAE,0xA9,0xA0,0xA7,0xB2,0xB5,0xBC,0xBB,0x96,0x91,0x98,0x9F,0x8A,0x8D,0x84,0x83,
-        0xDE,0xD9,0xD0,0xD7,0xC2,0xC5,0xCC,0xCB,0xE6,0xE1,0xE8,0xEF,0xFA,0xFD,0xF4,0xF3,
-    }
-    crc := byte(0)
-    for _, b := range data {
-        crc = table[(crc^b)&0xFF]
-    }
-    return crc
-}
\ No newline at end of file
+    fmt.Printf("Sent: %s\n", hex.EncodeToString(result.Sent))
+    for _, n := range result.Notifications {
+        fmt.Printf("Notification: %s\n", describeNotification(cfg.profile.Framing, n))
+    }
+    if len(result.Notifications) == 0 {
+        fmt.Println("No notifications received.")
+    }
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Checkpoints: with "checkpoints": true, long jobs are always sent in
+// segments (split.go), and after every segment the job's progress is saved
+// under spool_dir/checkpoints. A job that fails part way (the link dropped,
+// the daemon was restarted) leaves its checkpoint behind, and can be resumed
+// from the first segment that didn't finish instead of starting over. At
+// most one segment prints twice.
+//
+// Each checkpoint is two files: <job>.bin holds the encoded print, written
+// once, and <job>.json the progress, rewritten after each segment.
+
+const CHECKPOINT_DIR = "checkpoints"
+
+var errNoCheckpoint = errors.New("no such checkpoint")
+
+// Checkpoint is the saved progress of a long job.
+type Checkpoint struct {
+    JobID       string    `json:"job_id"`
+    Source      string    `json:"source,omitempty"`
+    Rows        int       `json:"rows"`
+    SegmentRows int       `json:"segment_rows"`
+    Segments    int       `json:"segments"`
+    Done        int       `json:"done"`
+    Intensity   byte      `json:"intensity,omitempty"`
+    Gray        bool      `json:"gray,omitempty"`
+    Error       string    `json:"error,omitempty"`
+    Updated     time.Time `json:"updated"`
+}
+
+// jobCheckpoint tracks one running job; nil when the job isn't checkpointed,
+// and all its methods are then no-ops.
+type jobCheckpoint struct {
+    dir string
+    Checkpoint
+}
+
+func (pd *PrinterDaemon) checkpointDir() string {
+    return filepath.Join(pd.config.SpoolDir, CHECKPOINT_DIR)
+}
+
+// startCheckpoint saves a job that is about to print in several segments.
+func (pd *PrinterDaemon) startCheckpoint(jobID string, prepared *preparedImage, segments int) *jobCheckpoint {
+    if !pd.config.Checkpoints || jobID == "" || segments < 2 {
+        return nil
+    }
+    c := &jobCheckpoint{
+        dir: pd.checkpointDir(),
+        Checkpoint: Checkpoint{
+            JobID:       jobID,
+            Source:      prepared.source,
+            Rows:        prepared.numRows,
+            SegmentRows: pd.splitRows(),
+            Segments:    segments,
+            Intensity:   prepared.intensity,
+            Gray:        prepared.gray,
+        },
+    }
+    if job, ok := pd.jobs.Get(jobID); ok {
+        c.Source = job.Source
+    }
+    if err := os.MkdirAll(c.dir, 0755); err != nil {
+        log.Printf("Failed to create checkpoint dir: %v", err)
+        return nil
+    }
+    if err := os.WriteFile(filepath.Join(c.dir, jobID+".bin"), prepared.buffer, 0600); err != nil {
+        log.Printf("Failed to save checkpoint for job %s: %v", jobID, err)
+        return nil
+    }
+    c.save()
+    return c
+}
+
+// advance records that the first done segments have printed.
+func (c *jobCheckpoint) advance(done int) {
+    if c == nil {
+        return
+    }
+    c.Done = done
+    c.save()
+}
+
+// fail keeps the checkpoint for a resume, unless the job was stopped on
+// purpose.
+func (c *jobCheckpoint) fail(err error) {
+    if c == nil {
+        return
+    }
+    if errors.Is(err, errJobCanceled) || errors.Is(err, errJobExpired) {
+        c.finish()
+        return
+    }
+    c.Error = err.Error()
+    c.save()
+    log.Printf("Print job %s stopped after %d/%d segments, checkpoint kept", c.JobID, c.Done, c.Segments)
+}
+
+// finish drops the checkpoint of a job that has no more use for it.
+func (c *jobCheckpoint) finish() {
+    if c == nil {
+        return
+    }
+    removeCheckpoint(c.dir, c.JobID)
+}
+
+func (c *jobCheckpoint) save() {
+    c.Updated = time.Now()
+    data, err := json.MarshalIndent(c.Checkpoint, "", "  ")
+    if err != nil {
+        return
+    }
+    path := filepath.Join(c.dir, c.JobID+".json")
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        log.Printf("Failed to save checkpoint for job %s: %v", c.JobID, err)
+        return
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        log.Printf("Failed to save checkpoint for job %s: %v", c.JobID, err)
+    }
+}
+
+func removeCheckpoint(dir, jobID string) {
+    os.Remove(filepath.Join(dir, jobID+".json"))
+    os.Remove(filepath.Join(dir, jobID+".bin"))
+}
+
+// Checkpoints lists the jobs that can be resumed, oldest first.
+func (pd *PrinterDaemon) Checkpoints() ([]Checkpoint, error) {
+    files, err := filepath.Glob(filepath.Join(pd.checkpointDir(), "*.json"))
+    if err != nil {
+        return nil, err
+    }
+    list := []Checkpoint{}
+    for _, file := range files {
+        c, err := readCheckpoint(file)
+        if err != nil {
+            log.Printf("Skipping checkpoint %s: %v", filepath.Base(file), err)
+            continue
+        }
+        list = append(list, c)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Updated.Before(list[j].Updated) })
+    return list, nil
+}
+
+func readCheckpoint(path string) (Checkpoint, error) {
+    var c Checkpoint
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return c, err
+    }
+    if err := json.Unmarshal(data, &c); err != nil {
+        return c, err
+    }
+    return c, nil
+}
+
+// loadCheckpoint reads a checkpoint and what is left to print.
+func (pd *PrinterDaemon) loadCheckpoint(id string) (Checkpoint, *preparedImage, error) {
+    if id == "" || strings.ContainsAny(id, `/\.`) {
+        return Checkpoint{}, nil, errNoCheckpoint
+    }
+    dir := pd.checkpointDir()
+    c, err := readCheckpoint(filepath.Join(dir, id+".json"))
+    if err != nil {
+        return c, nil, errNoCheckpoint
+    }
+    buffer, err := os.ReadFile(filepath.Join(dir, id+".bin"))
+    if err != nil {
+        return c, nil, fmt.Errorf("checkpoint data missing: %v", err)
+    }
+    start := c.Done * c.SegmentRows
+    rest := &preparedImage{source: c.Source, intensity: c.Intensity, gray: c.Gray}
+    if c.SegmentRows <= 0 || start >= c.Rows || start*rest.rowBytes() >= len(buffer) {
+        return c, nil, fmt.Errorf("checkpoint has nothing left to print")
+    }
+    rest.buffer, rest.numRows = buffer[start*rest.rowBytes():], c.Rows-start
+    return c, rest, nil
+}
+
+// ResumeCheckpoint prints the rest of an interrupted job as a new job and
+// returns its ID. The rest already carries the original job's tear line
+// and feed, so nothing is added around it.
+func (pd *PrinterDaemon) ResumeCheckpoint(id string) (string, error) {
+    c, rest, err := pd.loadCheckpoint(id)
+    if err != nil {
+        return "", err
+    }
+    jobID := pd.jobs.New(c.Source)
+    pd.jobs.SetRows(jobID, rest.numRows)
+    log.Printf("Resuming job %s as %s at segment %d/%d", id, jobID, c.Done+1, c.Segments)
+
+    pd.lockForJob()
+    defer pd.jobMu.Unlock()
+
+    err = pd.resumeJob(jobID, rest)
+    pd.jobs.Finish(jobID, err)
+    // The new job has a checkpoint of its own if it got anywhere
+    if _, statErr := os.Stat(filepath.Join(pd.checkpointDir(), jobID+".json")); err == nil || statErr == nil {
+        removeCheckpoint(pd.checkpointDir(), id)
+    }
+    return jobID, err
+}
+
+func (pd *PrinterDaemon) resumeJob(jobID string, rest *preparedImage) error {
+    pd.jobs.Set(jobID, JobConnecting, nil)
+    if err := pd.connectForJob(jobID); err != nil {
+        return err
+    }
+    defer pd.releaseConnection()
+    defer pd.watchJob(jobID)()
+    return pd.printPrepared(jobID, rest)
+}
+
+// DiscardCheckpoint drops a checkpoint that won't be resumed.
+func (pd *PrinterDaemon) DiscardCheckpoint(id string) error {
+    if _, _, err := pd.loadCheckpoint(id); err == errNoCheckpoint {
+        return err
+    }
+    removeCheckpoint(pd.checkpointDir(), id)
+    return nil
+}
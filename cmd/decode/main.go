@@ -0,0 +1,453 @@
+// Command decode reads a btsnoop HCI log (Android's "Bluetooth HCI snoop
+// log", or a Wireshark/PacketLogger btsnoop export) with cat printer traffic
+// and prints an annotated command list, then rebuilds every printed image
+// as a PNG. It also reads the JSONL recordings of the daemon's virtual
+// printer.
+//
+//	go run ./cmd/decode btsnoop_hci.log
+//	go run ./cmd/decode -out captures/ -control 0x0009 -data 0x000d btsnoop_hci.log
+//
+// Characteristic handles are taken from the GATT discovery in the log when
+// it is there; otherwise they are guessed from the traffic, or can be given
+// with -control/-data/-notify.
+//
+// This is a standalone program: it keeps its own copy of the framing rules
+// and command names instead of importing the main package. commandNames
+// must match COMMANDS in protocol.go; protocol_test.go checks that.
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "image"
+    "image/color"
+    "image/png"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    WIDTH_BYTES = 48
+
+    BTSNOOP_MAGIC = "btsnoop\x00"
+    // Datalink types: H4 carries a packet type byte, "unencapsulated" HCI
+    // says it in the record flags
+    DATALINK_HCI_UNENCAPSULATED = 1001
+    DATALINK_HCI_UART           = 1002
+
+    HCI_ACL = 0x02
+    ATT_CID = 0x0004
+
+    ATT_READ_BY_TYPE_RSP = 0x09
+    ATT_WRITE_REQ        = 0x12
+    ATT_NOTIFICATION     = 0x1B
+    ATT_WRITE_CMD        = 0x52
+)
+
+// Microseconds between year 0 and the Unix epoch, btsnoop's time base
+const BTSNOOP_EPOCH_DELTA = 0x00dcddb30f2f8000
+
+var PREAMBLE = []byte{0x22, 0x21}
+
+var commandNames = map[byte]string{
+    0xA1: "status",
+    0xA2: "set intensity",
+    0xA3: "unknown A3",
+    0xA7: "query count",
+    0xA9: "print request",
+    0xAA: "print complete",
+    0xAB: "battery level",
+    0xAC: "cancel",
+    0xAD: "flush",
+    0xAE: "flow control",
+    0xB0: "print type",
+    0xB1: "version",
+    0xB2: "unknown B2",
+    0xB3: "unknown B3",
+}
+
+// packet is one ATT write or notification.
+type packet struct {
+    time   time.Time
+    fromUs bool // written by the app (as opposed to notified by the printer)
+    handle uint16
+    char   string // ae01/ae02/ae03 once known
+    data   []byte
+}
+
+func main() {
+    out := flag.String("out", ".", "directory for the rebuilt images")
+    control := flag.String("control", "", "ATT handle of AE01 (e.g. 0x0009), if the log lacks discovery")
+    dataHandle := flag.String("data", "", "ATT handle of AE03")
+    notify := flag.String("notify", "", "ATT handle of AE02")
+    flag.Usage = func() {
+        fmt.Println("Usage: decode [-out dir] [-control h] [-data h] [-notify h] <btsnoop.log|traffic.jsonl>")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+    if flag.NArg() < 1 {
+        flag.Usage()
+        os.Exit(1)
+    }
+
+    raw, err := os.ReadFile(flag.Arg(0))
+    if err != nil {
+        log.Fatalf("Failed to read log: %v", err)
+    }
+    var packets []packet
+    if bytes.HasPrefix(raw, []byte(BTSNOOP_MAGIC)) {
+        var handles map[uint16]string
+        packets, handles, err = readBTSnoop(raw)
+        if err != nil {
+            log.Fatalf("Failed to parse btsnoop log: %v", err)
+        }
+        for flagValue, char := range map[*string]string{control: "ae01", dataHandle: "ae03", notify: "ae02"} {
+            if *flagValue != "" {
+                h, err := strconv.ParseUint(*flagValue, 0, 16)
+                if err != nil {
+                    log.Fatalf("Invalid handle %q", *flagValue)
+                }
+                handles[uint16(h)] = char
+            }
+        }
+        if len(handles) == 0 {
+            handles = guessHandles(packets)
+        }
+        for i := range packets {
+            packets[i].char = handles[packets[i].handle]
+        }
+    } else {
+        packets, err = readJSONL(raw)
+        if err != nil {
+            log.Fatalf("Failed to parse recording: %v", err)
+        }
+    }
+
+    images := annotate(packets, os.Stdout)
+    if err := os.MkdirAll(*out, 0755); err != nil {
+        log.Fatalf("Failed to create %s: %v", *out, err)
+    }
+    for i, img := range images {
+        path := filepath.Join(*out, fmt.Sprintf("print-%d.png", i+1))
+        if err := writePNG(path, img.rows, img.data); err != nil {
+            log.Fatalf("Failed to write %s: %v", path, err)
+        }
+        fmt.Printf("Wrote %s (%d rows)\n", path, img.rows)
+    }
+}
+
+// readBTSnoop extracts ATT writes and notifications, reassembling
+// fragmented ACL packets, and learns characteristic handles from discovery.
+func readBTSnoop(raw []byte) ([]packet, map[uint16]string, error) {
+    if len(raw) < 16 {
+        return nil, nil, fmt.Errorf("short header")
+    }
+    datalink := binary.BigEndian.Uint32(raw[12:16])
+    if datalink != DATALINK_HCI_UART && datalink != DATALINK_HCI_UNENCAPSULATED {
+        return nil, nil, fmt.Errorf("unsupported datalink %d", datalink)
+    }
+
+    var packets []packet
+    handles := make(map[uint16]string)
+    partial := make(map[uint16][]byte)
+    for off := 16; off+24 <= len(raw); {
+        included := int(binary.BigEndian.Uint32(raw[off+4:]))
+        flags := binary.BigEndian.Uint32(raw[off+8:])
+        ts := int64(binary.BigEndian.Uint64(raw[off+16:]))
+        off += 24
+        if off+included > len(raw) {
+            break
+        }
+        rec := raw[off : off+included]
+        off += included
+
+        received := flags&1 == 1
+        if datalink == DATALINK_HCI_UART {
+            if len(rec) == 0 || rec[0] != HCI_ACL {
+                continue
+            }
+            rec = rec[1:]
+        } else if flags&2 != 0 {
+            // Command or event, not data
+            continue
+        }
+        if len(rec) < 4 {
+            continue
+        }
+        conn := binary.LittleEndian.Uint16(rec) & 0x0FFF
+        boundary := (binary.LittleEndian.Uint16(rec) >> 12) & 0x3
+        body := rec[4:]
+        // Separate buffers for each direction of each connection
+        key := conn
+        if received {
+            key |= 0x8000
+        }
+        if boundary == 0x1 {
+            partial[key] = append(partial[key], body...)
+        } else {
+            partial[key] = append([]byte(nil), body...)
+        }
+        l2cap := partial[key]
+        if len(l2cap) < 4 || len(l2cap) < 4+int(binary.LittleEndian.Uint16(l2cap)) {
+            continue
+        }
+        delete(partial, key)
+        if binary.LittleEndian.Uint16(l2cap[2:]) != ATT_CID {
+            continue
+        }
+        att := l2cap[4 : 4+int(binary.LittleEndian.Uint16(l2cap))]
+        if len(att) == 0 {
+            continue
+        }
+        when := time.UnixMicro(ts - BTSNOOP_EPOCH_DELTA)
+
+        switch att[0] {
+        case ATT_READ_BY_TYPE_RSP:
+            learnHandles(att, handles)
+        case ATT_WRITE_CMD, ATT_WRITE_REQ, ATT_NOTIFICATION:
+            if len(att) < 3 {
+                continue
+            }
+            packets = append(packets, packet{
+                time:   when,
+                fromUs: att[0] != ATT_NOTIFICATION,
+                handle: binary.LittleEndian.Uint16(att[1:]),
+                data:   append([]byte(nil), att[3:]...),
+            })
+        }
+    }
+    return packets, handles, nil
+}
+
+// learnHandles reads characteristic declarations out of a Read By Type
+// response: handle, properties, value handle, UUID.
+func learnHandles(att []byte, handles map[uint16]string) {
+    if len(att) < 2 {
+        return
+    }
+    size := int(att[1])
+    if size != 7 && size != 21 {
+        return
+    }
+    for entry := att[2:]; len(entry) >= size; entry = entry[size:] {
+        valueHandle := binary.LittleEndian.Uint16(entry[3:])
+        var uuid uint16
+        if size == 7 {
+            uuid = binary.LittleEndian.Uint16(entry[5:])
+        } else {
+            // 128-bit UUID, little-endian: the short form sits at bytes 12-13
+            uuid = binary.LittleEndian.Uint16(entry[5+12:])
+        }
+        switch uuid {
+        case 0xAE01:
+            handles[valueHandle] = "ae01"
+        case 0xAE02:
+            handles[valueHandle] = "ae02"
+        case 0xAE03:
+            handles[valueHandle] = "ae03"
+        }
+    }
+}
+
+// guessHandles picks the control handle as the one the app writes framed
+// packets to, the notify handle as the one framed notifications come from,
+// and the data handle as the busiest other write handle.
+func guessHandles(packets []packet) map[uint16]string {
+    handles := make(map[uint16]string)
+    writes := make(map[uint16]int)
+    for _, p := range packets {
+        if !bytes.HasPrefix(p.data, PREAMBLE) {
+            if p.fromUs {
+                writes[p.handle]++
+            }
+            continue
+        }
+        if p.fromUs {
+            handles[p.handle] = "ae01"
+        } else {
+            handles[p.handle] = "ae02"
+        }
+    }
+    best, bestCount := uint16(0), 0
+    for h, n := range writes {
+        if _, known := handles[h]; !known && n > bestCount {
+            best, bestCount = h, n
+        }
+    }
+    if bestCount > 0 {
+        handles[best] = "ae03"
+    }
+    if len(handles) > 0 {
+        var list []string
+        for h, c := range handles {
+            list = append(list, fmt.Sprintf("%s=0x%04x", c, h))
+        }
+        sort.Strings(list)
+        fmt.Printf("Guessed handles: %s\n", strings.Join(list, " "))
+    }
+    return handles
+}
+
+// readJSONL reads a virtual printer recording.
+func readJSONL(raw []byte) ([]packet, error) {
+    var packets []packet
+    scanner := bufio.NewScanner(bytes.NewReader(raw))
+    scanner.Buffer(make([]byte, 64<<10), 1<<20)
+    for n := 1; scanner.Scan(); n++ {
+        var rec struct {
+            Time time.Time `json:"time"`
+            From string    `json:"from"`
+            Char string    `json:"char"`
+            Data string    `json:"data"`
+        }
+        if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+            return nil, fmt.Errorf("line %d: %v", n, err)
+        }
+        data, err := hex.DecodeString(rec.Data)
+        if err != nil {
+            return nil, fmt.Errorf("line %d: %v", n, err)
+        }
+        packets = append(packets, packet{time: rec.Time, fromUs: rec.From == "app", char: rec.Char, data: data})
+    }
+    return packets, scanner.Err()
+}
+
+type printedImage struct {
+    rows int
+    data []byte
+}
+
+// annotate prints one line per control packet or notification, summarizes
+// data transfers, and collects the image data between print request and
+// flush.
+func annotate(packets []packet, w io.Writer) []printedImage {
+    var images []printedImage
+    var current *printedImage
+    dataWrites, dataBytes := 0, 0
+    var start time.Time
+    flushData := func() {
+        if dataWrites > 0 {
+            fmt.Fprintf(w, "%12s  app  AE03  %d data writes, %d bytes (%d rows)\n", "", dataWrites, dataBytes, dataBytes/WIDTH_BYTES)
+            dataWrites, dataBytes = 0, 0
+        }
+    }
+
+    for _, p := range packets {
+        if start.IsZero() {
+            start = p.time
+        }
+        if p.char == "ae03" {
+            dataWrites++
+            dataBytes += len(p.data)
+            if current != nil {
+                current.data = append(current.data, p.data...)
+            }
+            continue
+        }
+        flushData()
+
+        from := "prn"
+        if p.fromUs {
+            from = "app"
+        }
+        char := strings.ToUpper(p.char)
+        if char == "" {
+            char = fmt.Sprintf("%04x", p.handle)
+        }
+        offset := fmt.Sprintf("+%.3fs", p.time.Sub(start).Seconds())
+        cmd, payload, ok := decodeFrame(p.data)
+        if !ok {
+            fmt.Fprintf(w, "%12s  %s  %s  raw %s\n", offset, from, char, hex.EncodeToString(p.data))
+            continue
+        }
+        fmt.Fprintf(w, "%12s  %s  %s  %02X %-15s %s%s\n", offset, from, char, cmd, commandName(cmd), hex.EncodeToString(payload), describe(cmd, payload, p.fromUs))
+
+        if p.fromUs && cmd == 0xA9 && len(payload) >= 2 {
+            images = append(images, printedImage{rows: int(binary.LittleEndian.Uint16(payload))})
+            current = &images[len(images)-1]
+        }
+        if p.fromUs && cmd == 0xAD {
+            current = nil
+        }
+    }
+    flushData()
+    return images
+}
+
+func decodeFrame(data []byte) (byte, []byte, bool) {
+    if len(data) < 6 || !bytes.HasPrefix(data, PREAMBLE) {
+        return 0, nil, false
+    }
+    length := int(binary.LittleEndian.Uint16(data[4:]))
+    if len(data) < 6+length {
+        return 0, nil, false
+    }
+    return data[2], data[6 : 6+length], true
+}
+
+func commandName(cmd byte) string {
+    if name, ok := commandNames[cmd]; ok {
+        return name
+    }
+    return "?"
+}
+
+// describe adds what we know about a payload.
+func describe(cmd byte, payload []byte, fromUs bool) string {
+    switch {
+    case cmd == 0xA2 && fromUs && len(payload) >= 1:
+        return fmt.Sprintf("  (intensity %d)", payload[0])
+    case cmd == 0xA9 && fromUs && len(payload) >= 4:
+        mode := "1bpp"
+        if payload[3] != 0 {
+            mode = fmt.Sprintf("mode %d", payload[3])
+        }
+        return fmt.Sprintf("  (%d rows, %s)", binary.LittleEndian.Uint16(payload), mode)
+    case cmd == 0xA9 && !fromUs && len(payload) >= 1:
+        if payload[0] == 0 {
+            return "  (accepted)"
+        }
+        return "  (rejected)"
+    case cmd == 0xA1 && !fromUs && len(payload) >= 13:
+        s := fmt.Sprintf("  (state %d, battery %d, temp %d", payload[6], payload[9], payload[10])
+        if payload[12] != 0 && len(payload) >= 14 {
+            s += fmt.Sprintf(", error %d", payload[13])
+        }
+        return s + ")"
+    }
+    return ""
+}
+
+// writePNG renders 1bpp rows (LSB first, set bit is black) as a PNG.
+func writePNG(path string, rows int, data []byte) error {
+    if rows == 0 || rows*WIDTH_BYTES > len(data) {
+        rows = len(data) / WIDTH_BYTES
+    }
+    img := image.NewGray(image.Rect(0, 0, WIDTH_BYTES*8, rows))
+    for y := 0; y < rows; y++ {
+        for x := 0; x < WIDTH_BYTES*8; x++ {
+            if data[y*WIDTH_BYTES+x/8]&(1<<(x%8)) != 0 {
+                img.SetGray(x, y, color.Gray{0})
+            } else {
+                img.SetGray(x, y, color.Gray{0xFF})
+            }
+        }
+    }
+    f, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    return png.Encode(f, img)
+}
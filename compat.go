@@ -0,0 +1,151 @@
+//go:build !daemon
+
+package main
+
+import (
+    "bufio"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// "catprinter compat" takes the command line of the Python catprinter tool,
+// so scripts written for it only need the command swapped:
+//
+//   python print.py -d 48:0F:57:12:30:9D -b atkinson -e 0x8000 photo.png
+//   catprinter compat -d 48:0F:57:12:30:9D -b atkinson -e 0x8000 photo.png
+//
+// Like argparse, flags may come before or after the file, with one or two
+// dashes. A device name instead of an address (or no -d at all) scans for
+// a printer advertising that name.
+
+const COMPAT_SCAN_TIMEOUT = 10 * time.Second
+
+var macAddress = regexp.MustCompile(`^[0-9A-Fa-f]{2}(:[0-9A-Fa-f]{2}){5}$`)
+
+// compatDither maps the Python tool's binarization algorithms to ours;
+// halftone is closest to ordered dithering.
+var compatDither = map[string]DitherMode{
+    "mean-threshold":  DITHER_THRESHOLD,
+    "none":            DITHER_THRESHOLD,
+    "floyd-steinberg": DITHER_FLOYD_STEINBERG,
+    "atkinson":        DITHER_ATKINSON,
+    "halftone":        DITHER_BAYER,
+}
+
+// runCompat implements "catprinter compat".
+func runCompat(args []string) {
+    fs := flag.NewFlagSet("compat", flag.ExitOnError)
+    var device, energy, algo, logLevel string
+    var preview bool
+    for _, name := range []string{"d", "device", "devicename"} {
+        fs.StringVar(&device, name, "", "printer address, or the name it advertises (default: scan for "+DEFAULT_PRINTER_NAME+")")
+    }
+    for _, name := range []string{"e", "energy"} {
+        fs.StringVar(&energy, name, "", "thermal energy, 0x0000 (light) to 0xffff (dark) (default from config)")
+    }
+    for _, name := range []string{"b", "img-binarization-algo"} {
+        fs.StringVar(&algo, name, "floyd-steinberg", "mean-threshold, floyd-steinberg, atkinson, halftone or none")
+    }
+    for _, name := range []string{"s", "show-preview"} {
+        fs.BoolVar(&preview, name, false, "save a preview PNG and ask before printing")
+    }
+    for _, name := range []string{"l", "log-level"} {
+        fs.StringVar(&logLevel, name, "info", "accepted for compatibility, ignored")
+    }
+    fs.Usage = func() {
+        fmt.Println("Usage: catprinter compat [-d device] [-e energy] [-b algo] [-s] <filename>")
+        fs.PrintDefaults()
+    }
+
+    // argparse takes flags after the file too
+    fs.Parse(args)
+    var files []string
+    for fs.NArg() > 0 {
+        files = append(files, fs.Arg(0))
+        fs.Parse(fs.Args()[1:])
+    }
+    if len(files) != 1 {
+        fs.Usage()
+        os.Exit(1)
+    }
+    dither, ok := compatDither[algo]
+    if !ok {
+        log.Printf("Unknown binarization algorithm %q", algo)
+        os.Exit(1)
+    }
+    intensity := 0
+    if energy != "" {
+        value, err := strconv.ParseUint(energy, 0, 16)
+        if err != nil {
+            log.Printf("Energy must be between 0x0000 and 0xffff")
+            os.Exit(1)
+        }
+        // The MXW01 takes one byte of heat
+        intensity = max(int(value>>8), 1)
+    }
+
+    cfg, err := loadConfig()
+    if err != nil {
+        log.Printf("Failed to load config: %v", err)
+        os.Exit(1)
+    }
+    macAddr := device
+    if !macAddress.MatchString(device) {
+        name := device
+        if name == "" {
+            name = DEFAULT_PRINTER_NAME
+        }
+        fmt.Printf("Looking for %s...\n", name)
+        if macAddr, err = discoverPrinter(cfg.HCI, name, COMPAT_SCAN_TIMEOUT); err != nil {
+            log.Printf("%v", err)
+            os.Exit(1)
+        }
+        fmt.Printf("Found %s at %s\n", name, macAddr)
+    }
+
+    engine := NewEngine(macAddr, cfg)
+    opts := PrintOptions{Render: RenderOptions{Dither: dither, Intensity: intensity}}
+    jobID := engine.NewJob(opts.Source)
+    prepared, err := engine.PrepareImages(jobID, files, opts.Render)
+    if err == nil && preview && !confirmPreview(prepared) {
+        engine.Reject(jobID, errJobCanceled)
+        engine.Close()
+        return
+    }
+    if err == nil {
+        err = engine.Print(jobID, prepared, opts)
+    }
+    engine.Close()
+    if err != nil {
+        log.Printf("Print failed: %v", err)
+        os.Exit(1)
+    }
+    fmt.Println("Print job completed successfully!")
+}
+
+// confirmPreview stands in for the Python tool's preview window: the image
+// as it will print is saved to a temp file, then we ask.
+func confirmPreview(prepared *preparedImage) bool {
+    png, err := prepared.png()
+    if err != nil {
+        log.Printf("Failed to render preview: %v", err)
+        return false
+    }
+    file, err := os.CreateTemp("", "catprinter-preview-*.png")
+    if err != nil {
+        log.Printf("Failed to save preview: %v", err)
+        return false
+    }
+    file.Write(png)
+    file.Close()
+    fmt.Printf("Preview saved to %s\nPrint it? [y/N] ", file.Name())
+    answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+    answer = strings.ToLower(strings.TrimSpace(answer))
+    return answer == "y" || answer == "yes"
+}
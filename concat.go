@@ -0,0 +1,47 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Several images can go out as one job, stacked top to bottom into one
+// continuous print: no separators, feeds or print request boundaries between
+// them, unlike a batch. Each image is rendered on its own (and fitted to the
+// paper), then the encoded rows are joined.
+
+// Most images stacked into one print
+const MAX_CONCAT_IMAGES = 16
+
+// prepareImages loads, renders and stacks refs into one print.
+func (pd *PrinterDaemon) prepareImages(refs []string, render RenderOptions) (*preparedImage, error) {
+    if len(refs) == 0 {
+        return nil, fmt.Errorf("no images")
+    }
+    if len(refs) > MAX_CONCAT_IMAGES {
+        return nil, fmt.Errorf("%d images, the limit is %d", len(refs), MAX_CONCAT_IMAGES)
+    }
+    parts := make([]*preparedImage, len(refs))
+    for i, ref := range refs {
+        p, err := pd.prepareImage(ref, render)
+        if err != nil {
+            if len(refs) > 1 {
+                return nil, fmt.Errorf("image %d: %v", i+1, err)
+            }
+            return nil, err
+        }
+        parts[i] = p
+    }
+    stacked := concatPrepared(parts)
+    stacked.source = strings.Join(refs, " + ")
+    return stacked, nil
+}
+
+// concatPrepared joins prepared images into one, top to bottom.
+func concatPrepared(parts []*preparedImage) *preparedImage {
+    out := parts[0]
+    for _, p := range parts[1:] {
+        out = appendRows(out, p.rows())
+    }
+    return out
+}
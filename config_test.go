@@ -0,0 +1,31 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// loadTestConfig loads a config file with the given JSON.
+func loadTestConfig(t *testing.T, configJSON string) *Config {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "catprinter.json")
+    if err := os.WriteFile(path, []byte(configJSON), 0600); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("CATPRINTER_CONFIG", path)
+    cfg, err := loadConfig()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return cfg
+}
+
+func TestMaxJobDuration(t *testing.T) {
+    if cfg := loadTestConfig(t, `{}`); cfg.maxJobDuration != 0 {
+        t.Errorf("default is %v, want off", cfg.maxJobDuration)
+    }
+    if cfg := loadTestConfig(t, `{"max_job_duration": "45m"}`); cfg.maxJobDuration.Minutes() != 45 {
+        t.Errorf("got %v, want 45m", cfg.maxJobDuration)
+    }
+}
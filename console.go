@@ -0,0 +1,188 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// Console mode prints lines as they arrive, like a teletype: chat, build
+// logs... Lines come from POST /append, a named pipe (console_pipe) or
+// "catprinter tail". Lines that arrive close together are printed as one
+// job, and the connection is kept open between jobs until the console has
+// been idle for a while.
+//
+// Lines are printed top row first (not rotated like print.js messages), so
+// the output reads in order as it comes out of the printer.
+//
+// Every print ends with the printer's own feed, so a line at a time wastes
+// paper. console_min_lines holds lines back until there are enough of them
+// (or none has come for console_flush_after), and console_collapse_blank
+// prints runs of blank lines as one.
+
+const (
+    CONSOLE_BUFFER = 1000
+    // Wait this long for more lines before printing what we have
+    CONSOLE_BATCH_WINDOW = 150 * time.Millisecond
+    CONSOLE_MAX_BATCH    = 40
+    CONSOLE_IDLE_TIMEOUT = time.Minute
+    // Default wait for console_min_lines
+    CONSOLE_FLUSH_AFTER   = 10 * time.Second
+    MAX_CONSOLE_MIN_LINES = 200
+    // Console prints and their content filters go by this source
+    CONSOLE_SOURCE = "console"
+)
+
+type Console struct {
+    pd    *PrinterDaemon
+    text  *textRenderer
+    lines chan string
+    // pending counts lines appended but not printed yet
+    pending sync.WaitGroup
+
+    minLines      int
+    flushAfter    time.Duration
+    collapseBlank bool
+    // lastBlank is whether the last line kept was blank, across batches
+    lastBlank bool
+}
+
+func newConsole(pd *PrinterDaemon) (*Console, error) {
+    text, err := newTextRenderer(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT)
+    if err != nil {
+        return nil, err
+    }
+    c := &Console{
+        pd:            pd,
+        text:          text,
+        lines:         make(chan string, CONSOLE_BUFFER),
+        minLines:      pd.config.ConsoleMinLines,
+        flushAfter:    pd.config.consoleFlush,
+        collapseBlank: pd.config.ConsoleCollapseBlank,
+    }
+    if c.flushAfter <= 0 {
+        // A Config that didn't come from loadConfig
+        c.flushAfter = CONSOLE_FLUSH_AFTER
+    }
+    go c.run()
+    return c, nil
+}
+
+// Append queues a line for printing without waiting for it.
+func (c *Console) Append(line string) error {
+    c.pending.Add(1)
+    select {
+    case c.lines <- line:
+        return nil
+    default:
+        c.pending.Done()
+        return fmt.Errorf("console buffer is full")
+    }
+}
+
+// Flush waits until every appended line has been printed (or failed). With
+// console_min_lines that can take up to console_flush_after.
+func (c *Console) Flush() {
+    c.pending.Wait()
+}
+
+func (c *Console) run() {
+    idle := time.NewTimer(CONSOLE_IDLE_TIMEOUT)
+    for {
+        select {
+        case line := <-c.lines:
+            batch, n := c.collect(line)
+            if len(batch) > 0 {
+                if err := c.print(batch); err != nil {
+                    log.Printf("Console print failed: %v", err)
+                }
+            }
+            c.pending.Add(-n)
+            idle.Reset(CONSOLE_IDLE_TIMEOUT)
+        case <-idle.C:
+            c.pd.disconnectIdle()
+        }
+    }
+}
+
+// collect gathers the lines that arrive within the batch window, or while
+// there are fewer than minLines of them. It returns the wrapped lines and
+// how many appended lines they came from.
+func (c *Console) collect(first string) ([]string, int) {
+    batch := c.add(nil, first)
+    n := 1
+    start, last := time.Now(), time.Now()
+    for len(batch) < max(CONSOLE_MAX_BATCH, c.minLines) {
+        deadline := start.Add(CONSOLE_BATCH_WINDOW)
+        if len(batch) < c.minLines {
+            deadline = last.Add(c.flushAfter)
+        }
+        window := time.NewTimer(time.Until(deadline))
+        select {
+        case line := <-c.lines:
+            window.Stop()
+            batch = c.add(batch, line)
+            n++
+            last = time.Now()
+        case <-window.C:
+            return batch, n
+        }
+    }
+    return batch, n
+}
+
+// add wraps line onto batch, dropping repeated blank lines if asked to.
+func (c *Console) add(batch []string, line string) []string {
+    for _, wrapped := range c.text.wrap(line) {
+        blank := strings.TrimSpace(wrapped) == ""
+        if blank && c.lastBlank && c.collapseBlank {
+            continue
+        }
+        c.lastBlank = blank
+        batch = append(batch, wrapped)
+    }
+    return batch
+}
+
+func (c *Console) print(lines []string) error {
+    prepared := newPreparedImage(CONSOLE_SOURCE, c.text.render(lines))
+    return c.pd.printWarm(prepared)
+}
+
+// readConsolePipe creates the named pipe if needed and prints every line
+// written to it, e.g. `some-command > /run/catprinter.fifo`. Writers can come
+// and go; the pipe is reopened after each one closes it.
+func (e *Engine) readConsolePipe(path string) {
+    if _, err := os.Stat(path); os.IsNotExist(err) {
+        if err := syscall.Mkfifo(path, 0620); err != nil {
+            log.Printf("Failed to create console pipe: %v", err)
+            return
+        }
+    }
+    log.Printf("Reading console lines from %s", path)
+    for {
+        f, err := os.Open(path)
+        if err != nil {
+            log.Printf("Failed to open console pipe: %v", err)
+            return
+        }
+        scanner := bufio.NewScanner(f)
+        for scanner.Scan() {
+            if err := e.Append(scanner.Text()); err != nil {
+                log.Printf("Console: %v", err)
+            }
+        }
+        f.Close()
+
+        select {
+        case <-e.stop:
+            return
+        default:
+        }
+    }
+}
@@ -0,0 +1,265 @@
+//go:build daemon
+
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+    "text/template"
+    "time"
+)
+
+// Consumers print messages from a message bus, for event-driven displays:
+//
+//   "consumers": [
+//     {"type": "nats", "url": "nats://localhost:4222", "subject": "alerts.>",
+//      "match": "(?i)critical", "template": "{{.Subject}}\n{{.Data.host}}: {{.Data.message}}"}
+//   ]
+//
+// The template gets the subject/topic, the raw message as Text and, for JSON
+// messages, the decoded object as Data. With "format": "job" messages are
+// print jobs instead, so existing job systems can submit without HTTP:
+//
+//   {"image": "s3://bucket/key.png", "dither": "atkinson", "ttl": "1h"}
+//   {"text": "Order #42 ready"}
+//
+// With "format": "snapshot" every message is an image, such as the JPEG a
+// camera or Frigate publishes on an event. It prints as a card with the
+// template's text as its caption, at most once per "min_interval"; match is
+// checked against the subject, since the message is binary.
+//
+// Each bus lives in its own build-tagged file (consumer_nats.go,
+// consumer_kafka.go, consumer_redis.go, consumer_mqtt.go) and registers
+// itself here.
+
+const CONSUMER_RETRY_INTERVAL = 30 * time.Second
+
+const (
+    CONSUMER_FORMAT_TEXT     = "text"
+    CONSUMER_FORMAT_JOB      = "job"
+    CONSUMER_FORMAT_SNAPSHOT = "snapshot"
+)
+
+const (
+    DEFAULT_SNAPSHOT_INTERVAL = time.Minute
+    DEFAULT_SNAPSHOT_TEMPLATE = "{{.Subject}}\n{{.Time.Format \"2006-01-02 15:04:05\"}}"
+)
+
+// consumerJob is a message in the "job" format.
+type consumerJob struct {
+    Image   string `json:"image"`
+    Text    string `json:"text"`
+    Source  string `json:"source"`
+    ReplyTo string `json:"reply_to"`
+    JobOptions
+}
+
+// ConsumerMessage is what templates see.
+type ConsumerMessage struct {
+    Subject string
+    Text    string
+    Data    map[string]interface{}
+    Time    time.Time
+}
+
+// consumerRunner consumes until it fails, calling handle for every message.
+type consumerRunner func(cfg ConsumerConfig, handle func(subject string, data []byte)) error
+
+var consumerTypes = map[string]consumerRunner{}
+
+func registerConsumerType(name string, run consumerRunner) {
+    consumerTypes[name] = run
+}
+
+type consumer struct {
+    cfg      ConsumerConfig
+    run      consumerRunner
+    match    *regexp.Regexp
+    template *template.Template
+    engine   *Engine
+    hooks    *ScriptHooks
+
+    // Snapshot rate limit
+    minInterval  time.Duration
+    mu           sync.Mutex
+    lastSnapshot time.Time
+}
+
+// startConsumers checks every configured consumer and starts them in the
+// background.
+func startConsumers(engine *Engine, configs []ConsumerConfig, hooks *ScriptHooks) error {
+    var consumers []*consumer
+    for i, cfg := range configs {
+        run, ok := consumerTypes[cfg.Type]
+        if !ok {
+            return fmt.Errorf("consumer %d: unknown type %q (built with: %s)", i, cfg.Type, consumerTypeList())
+        }
+        if cfg.Format == "" {
+            cfg.Format = CONSUMER_FORMAT_TEXT
+        }
+        if cfg.Format != CONSUMER_FORMAT_TEXT && cfg.Format != CONSUMER_FORMAT_JOB && cfg.Format != CONSUMER_FORMAT_SNAPSHOT {
+            return fmt.Errorf("consumer %d: unknown format %q", i, cfg.Format)
+        }
+        c := &consumer{cfg: cfg, run: run, engine: engine, hooks: hooks, minInterval: DEFAULT_SNAPSHOT_INTERVAL}
+        if cfg.MinInterval != "" {
+            d, err := time.ParseDuration(cfg.MinInterval)
+            if err != nil || d < 0 {
+                return fmt.Errorf("consumer %d: invalid min_interval %q", i, cfg.MinInterval)
+            }
+            c.minInterval = d
+        }
+        if cfg.Match != "" {
+            re, err := regexp.Compile(cfg.Match)
+            if err != nil {
+                return fmt.Errorf("consumer %d: invalid match: %v", i, err)
+            }
+            c.match = re
+        }
+        text := cfg.Template
+        switch {
+        case text != "":
+        case cfg.Format == CONSUMER_FORMAT_SNAPSHOT:
+            text = DEFAULT_SNAPSHOT_TEMPLATE
+        default:
+            text = "{{.Text}}"
+        }
+        tmpl, err := template.New(cfg.Type).Option("missingkey=zero").Parse(text)
+        if err != nil {
+            return fmt.Errorf("consumer %d: invalid template: %v", i, err)
+        }
+        c.template = tmpl
+        consumers = append(consumers, c)
+    }
+    for _, c := range consumers {
+        go c.loop()
+    }
+    return nil
+}
+
+func consumerTypeList() string {
+    if len(consumerTypes) == 0 {
+        return "none, use -tags nats, kafka, redis or mqtt"
+    }
+    names := make([]string, 0, len(consumerTypes))
+    for name := range consumerTypes {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return strings.Join(names, ", ")
+}
+
+func (c *consumer) loop() {
+    for {
+        log.Printf("Consuming %s from %s %s", c.cfg.Subject, c.cfg.Type, c.cfg.URL)
+        err := c.run(c.cfg, c.handle)
+        log.Printf("Consumer %s %s stopped: %v, retrying in %v", c.cfg.Type, c.cfg.Subject, err, CONSUMER_RETRY_INTERVAL)
+        time.Sleep(CONSUMER_RETRY_INTERVAL)
+    }
+}
+
+func (c *consumer) handle(subject string, data []byte) {
+    if c.cfg.Format == CONSUMER_FORMAT_SNAPSHOT {
+        if c.match != nil && !c.match.MatchString(subject) {
+            return
+        }
+        if err := c.printSnapshot(subject, data); err != nil {
+            log.Printf("Consumer %s: snapshot failed: %v", c.cfg.Type, err)
+        }
+        return
+    }
+    msg := ConsumerMessage{Subject: subject, Text: string(data), Time: time.Now()}
+    if c.match != nil && !c.match.MatchString(msg.Text) {
+        return
+    }
+    if c.cfg.Format == CONSUMER_FORMAT_JOB {
+        if err := c.printJob(data); err != nil {
+            log.Printf("Consumer %s: job failed: %v", c.cfg.Type, err)
+        }
+        return
+    }
+    // Not an error if it isn't JSON, Data just stays empty
+    json.Unmarshal(data, &msg.Data)
+
+    var out bytes.Buffer
+    if err := c.template.Execute(&out, msg); err != nil {
+        log.Printf("Consumer %s: template failed: %v", c.cfg.Type, err)
+        return
+    }
+    if strings.TrimSpace(out.String()) == "" {
+        return
+    }
+    if c.hooks.Enabled() {
+        // Scripts see every message as a job; the subject is just for them
+        job, _ := json.Marshal(map[string]string{"text": out.String(), "source": c.cfg.Type, "subject": subject})
+        if err := c.printJob(job); err != nil {
+            log.Printf("Consumer %s: job failed: %v", c.cfg.Type, err)
+        }
+        return
+    }
+    if err := c.engine.PrintText(out.String(), c.engine.WithSourceDefaults(PrintOptions{Source: c.cfg.Type})); err != nil {
+        log.Printf("Consumer %s: print failed: %v", c.cfg.Type, err)
+    }
+}
+
+func (c *consumer) printJob(data []byte) error {
+    data, err := c.hooks.OnMessage(data)
+    if err != nil {
+        return err
+    }
+    var job consumerJob
+    if err := json.Unmarshal(data, &job); err != nil {
+        return fmt.Errorf("invalid job: %v", err)
+    }
+    opts, err := job.printOptions()
+    if err != nil {
+        return err
+    }
+    opts.Source = job.Source
+    if len(job.ReplyTo) > MAX_REPLY_TO {
+        return fmt.Errorf("reply_to is too long")
+    }
+    opts.ReplyTo = job.ReplyTo
+    if opts.Source == "" {
+        opts.Source = c.cfg.Type
+    }
+    opts = c.engine.WithSourceDefaults(opts)
+    switch {
+    case job.Image != "":
+        return c.engine.PrintImage(job.Image, opts)
+    case job.Text != "":
+        return c.engine.PrintText(job.Text, opts)
+    }
+    return fmt.Errorf("job has neither image nor text")
+}
+
+// printSnapshot prints a camera image as a card, unless the last one printed
+// less than minInterval ago. Script hooks don't see snapshots.
+func (c *consumer) printSnapshot(subject string, data []byte) error {
+    now := time.Now().In(c.engine.Config().location)
+    c.mu.Lock()
+    if !c.lastSnapshot.IsZero() && now.Sub(c.lastSnapshot) < c.minInterval {
+        c.mu.Unlock()
+        log.Printf("Consumer %s: dropped snapshot from %s, last one printed %v ago", c.cfg.Type, subject, now.Sub(c.lastSnapshot).Round(time.Second))
+        return nil
+    }
+    c.lastSnapshot = now
+    c.mu.Unlock()
+
+    var caption bytes.Buffer
+    if err := c.template.Execute(&caption, ConsumerMessage{Subject: subject, Time: now}); err != nil {
+        return fmt.Errorf("template failed: %v", err)
+    }
+    card, err := composeSnapshotCard(caption.String(), data)
+    if err != nil {
+        return err
+    }
+    defer os.Remove(card)
+    return c.engine.PrintImage(card, c.engine.WithSourceDefaults(PrintOptions{Source: c.cfg.Type}))
+}
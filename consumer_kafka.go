@@ -0,0 +1,43 @@
+//go:build daemon && kafka
+
+package main
+
+import (
+    "context"
+    "strings"
+
+    "github.com/segmentio/kafka-go"
+)
+
+func init() {
+    registerConsumerType("kafka", runKafkaConsumer)
+    registerCapability(Capability{Name: "kafka", Description: "Print messages from Kafka topics", BuildTag: "kafka"})
+}
+
+const DEFAULT_KAFKA_GROUP = "catprinter"
+
+func runKafkaConsumer(cfg ConsumerConfig, handle func(subject string, data []byte)) error {
+    group := cfg.Group
+    if group == "" {
+        group = DEFAULT_KAFKA_GROUP
+    }
+    r := kafka.NewReader(kafka.ReaderConfig{
+        Brokers: strings.Split(cfg.URL, ","),
+        Topic:   cfg.Subject,
+        GroupID: group,
+    })
+    defer r.Close()
+
+    for {
+        // Offsets are committed once the message has been handled, so a
+        // message that was being printed during a restart is printed again
+        m, err := r.FetchMessage(context.Background())
+        if err != nil {
+            return err
+        }
+        handle(m.Topic, m.Value)
+        if err := r.CommitMessages(context.Background(), m); err != nil {
+            return err
+        }
+    }
+}
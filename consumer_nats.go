@@ -0,0 +1,38 @@
+//go:build daemon && nats
+
+package main
+
+import (
+    "github.com/nats-io/nats.go"
+)
+
+func init() {
+    registerConsumerType("nats", runNATSConsumer)
+    registerCapability(Capability{Name: "nats", Description: "Print messages from NATS subjects", BuildTag: "nats"})
+}
+
+func runNATSConsumer(cfg ConsumerConfig, handle func(subject string, data []byte)) error {
+    closed := make(chan error, 1)
+    nc, err := nats.Connect(cfg.URL,
+        nats.Name("catprinter"),
+        nats.MaxReconnects(-1),
+        nats.ClosedHandler(func(nc *nats.Conn) { closed <- nc.LastError() }),
+    )
+    if err != nil {
+        return err
+    }
+    defer nc.Close()
+
+    // Messages are handled one at a time, in order, on the subscription's
+    // goroutine; prints queue up on the printer anyway
+    callback := func(m *nats.Msg) { handle(m.Subject, m.Data) }
+    if cfg.Group != "" {
+        _, err = nc.QueueSubscribe(cfg.Subject, cfg.Group, callback)
+    } else {
+        _, err = nc.Subscribe(cfg.Subject, callback)
+    }
+    if err != nil {
+        return err
+    }
+    return <-closed
+}
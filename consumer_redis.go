@@ -0,0 +1,71 @@
+//go:build daemon && redis
+
+package main
+
+import (
+    "context"
+    "os"
+
+    "github.com/redis/go-redis/v9"
+)
+
+// Redis keys are read as a list (BRPOP, so LPUSH to submit) or, when a
+// group is configured, as a stream read with XREADGROUP (XADD to submit,
+// with the message in a "data" field).
+
+const REDIS_STREAM_FIELD = "data"
+
+func init() {
+    registerConsumerType("redis", runRedisConsumer)
+    registerCapability(Capability{Name: "redis", Description: "Print jobs from Redis lists and streams", BuildTag: "redis"})
+}
+
+func runRedisConsumer(cfg ConsumerConfig, handle func(subject string, data []byte)) error {
+    opts, err := redis.ParseURL(cfg.URL)
+    if err != nil {
+        return err
+    }
+    // Blocking reads wait forever; don't let the client time them out
+    opts.ReadTimeout = -1
+    rdb := redis.NewClient(opts)
+    defer rdb.Close()
+    ctx := context.Background()
+
+    if cfg.Group == "" {
+        for {
+            res, err := rdb.BRPop(ctx, 0, cfg.Subject).Result()
+            if err != nil {
+                return err
+            }
+            handle(res[0], []byte(res[1]))
+        }
+    }
+
+    err = rdb.XGroupCreateMkStream(ctx, cfg.Subject, cfg.Group, "$").Err()
+    if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+        return err
+    }
+    name, _ := os.Hostname()
+    for {
+        streams, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+            Group:    cfg.Group,
+            Consumer: name,
+            Streams:  []string{cfg.Subject, ">"},
+            Count:    1,
+            Block:    0,
+        }).Result()
+        if err != nil {
+            return err
+        }
+        for _, stream := range streams {
+            for _, m := range stream.Messages {
+                data, _ := m.Values[REDIS_STREAM_FIELD].(string)
+                handle(stream.Stream, []byte(data))
+                // Acknowledged once handled, like Kafka offsets
+                if err := rdb.XAck(ctx, cfg.Subject, cfg.Group, m.ID).Err(); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "image/draw"
+    "time"
+)
+
+// The countdown card: a big "24 days until Christmas". The date is either a
+// day ("2026-12-25") or a month and day ("12-25"), which means the next one,
+// so birthdays and holidays count down again every year. Past days count up
+// instead ("3 days since ..."). Countdowns in the config are also printed by
+// themselves every morning before their date, like an advent calendar (see
+// countdown_schedule.go).
+
+const (
+    COUNTDOWN_NUMBER_SIZE   = 112
+    COUNTDOWN_NUMBER_HEIGHT = 96
+)
+
+func init() {
+    registerCard("countdown", renderCountdownCard)
+}
+
+// parseCountdownDate reads "2006-01-02" or "01-02", the latter being the
+// next such day from now on, today included. "02-29" counts down to 28
+// February in years without a 29th.
+func parseCountdownDate(value string, now time.Time) (time.Time, error) {
+    if day, err := time.ParseInLocation("2006-01-02", value, now.Location()); err == nil {
+        return day, nil
+    }
+    day, err := time.Parse("01-02", value)
+    if err != nil {
+        return time.Time{}, fmt.Errorf("invalid date %q, want 2006-01-02 or 01-02 for every year", value)
+    }
+    next := yearlyDate(now.Year(), day.Month(), day.Day(), now.Location())
+    if daysBetween(now, next) < 0 {
+        next = yearlyDate(now.Year()+1, day.Month(), day.Day(), now.Location())
+    }
+    return next, nil
+}
+
+// yearlyDate is month and day in the given year, with 29 February on the
+// 28th in years without one rather than time.Date's 1 March.
+func yearlyDate(year int, month time.Month, day int, loc *time.Location) time.Time {
+    if month == time.February && day == 29 && time.Date(year, time.March, 0, 0, 0, 0, 0, loc).Day() != 29 {
+        day = 28
+    }
+    return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// daysBetween counts calendar days from a to b, whatever the clocks did in
+// between.
+func daysBetween(a, b time.Time) int {
+    da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+    db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+    return int(db.Sub(da).Hours() / 24)
+}
+
+// renderCountdownCard takes the parameters to (required), label, tz and
+// locale.
+func renderCountdownCard(cfg *Config, params map[string]string, now time.Time, l *Locale) (image.Image, error) {
+    if params["to"] == "" {
+        return nil, fmt.Errorf("to is required, like 2026-12-25")
+    }
+    if value := params["tz"]; value != "" {
+        loc, err := time.LoadLocation(value)
+        if err != nil {
+            return nil, fmt.Errorf("unknown time zone %q", value)
+        }
+        now = now.In(loc)
+    }
+    day, err := parseCountdownDate(params["to"], now)
+    if err != nil {
+        return nil, err
+    }
+    label := params["label"]
+    date := l.Date(day, "date_long")
+
+    days := daysBetween(now, day)
+    var headline, sub string
+    switch {
+    case days == 0:
+        headline, sub = l.T("countdown_today"), l.T("countdown_the_day")
+        if label != "" {
+            sub = l.T("countdown_is", "label", label)
+        }
+    default:
+        what := label
+        if what == "" {
+            // Nothing to name, so the date takes the label's place
+            what = l.Date(day, "date_medium")
+            date = ""
+        }
+        key := "countdown_until"
+        if days < 0 {
+            key = "countdown_since"
+        }
+        if days == 1 || days == -1 {
+            key += "_one"
+        }
+        headline = fmt.Sprint(days)
+        if days < 0 {
+            headline = fmt.Sprint(-days)
+        }
+        sub = l.T(key, "what", what)
+    }
+
+    size, height := float64(COUNTDOWN_NUMBER_SIZE), COUNTDOWN_NUMBER_HEIGHT
+    if days == 0 {
+        // A word, not a number; it has to fit across the paper
+        size, height = size*2/3, height*2/3
+    }
+    big, err := cardCentered(DEFAULT_BANNER_FONT, size, height, headline)
+    if err != nil {
+        return nil, err
+    }
+    middle, err := cardCentered(DEFAULT_BANNER_FONT, 28, 34, sub)
+    if err != nil {
+        return nil, err
+    }
+    lines := []*image.Gray{big, middle}
+    if date != "" {
+        small, err := cardCentered(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT, date)
+        if err != nil {
+            return nil, err
+        }
+        lines = append(lines, small)
+    }
+
+    height = 0
+    for _, img := range lines {
+        height += img.Bounds().Dy() + 4
+    }
+    card := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, height-4))
+    draw.Draw(card, card.Bounds(), image.White, image.Point{}, draw.Src)
+    y := 0
+    for _, img := range lines {
+        draw.Draw(card, img.Bounds().Add(image.Pt(0, y)), img, image.Point{}, draw.Src)
+        y += img.Bounds().Dy() + 4
+    }
+    return card, nil
+}
@@ -0,0 +1,63 @@
+//go:build daemon
+
+package main
+
+import (
+    "log"
+    "time"
+)
+
+// Daily countdown prints, one per entry in "countdowns" (see countdown.go):
+//
+//   "countdowns": [{"to": "12-25", "label": "Christmas", "at": "07:00", "days": 24}]
+//
+// prints "24 days until Christmas" on the morning of 1 December, one fewer
+// every day after, and "Today is Christmas" on the day.
+
+const COUNTDOWN_SOURCE = "countdown"
+
+func init() {
+    daemonServices = append(daemonServices, startCountdowns)
+}
+
+func startCountdowns(engine *Engine, config *Config) error {
+    for _, countdown := range config.Countdowns {
+        go runCountdown(engine, config, countdown)
+    }
+    return nil
+}
+
+func runCountdown(engine *Engine, config *Config, countdown CountdownConfig) {
+    card := CardRequest{Name: "countdown", Params: map[string]string{"to": countdown.To, "label": countdown.Label, "tz": countdown.TimeZone}}
+    for {
+        at, err := parsePrintAt(countdown.At, countdown.TimeZone, config)
+        if err != nil {
+            log.Printf("Countdown to %s stopped: %v", countdown.To, err)
+            return
+        }
+        day, err := parseCountdownDate(countdown.To, at)
+        if err != nil {
+            log.Printf("Countdown to %s stopped: %v", countdown.To, err)
+            return
+        }
+        days := daysBetween(at, day)
+        if days < 0 {
+            log.Printf("Countdown to %s is over, no more prints", countdown.To)
+            return
+        }
+        if countdown.Days > 0 && days > countdown.Days {
+            // Sleep through to the first day of the countdown
+            at = at.AddDate(0, 0, days-countdown.Days)
+        }
+        log.Printf("Next countdown print to %s at %s", countdown.To, at.Format(time.RFC3339))
+        select {
+        case <-time.After(time.Until(at)):
+        case <-engine.stop:
+            return
+        }
+        opts := engine.WithSourceDefaults(PrintOptions{Source: COUNTDOWN_SOURCE})
+        if err := engine.PrintCards([]CardRequest{card}, opts); err != nil {
+            log.Printf("Countdown print failed: %v", err)
+        }
+    }
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseCountdownDate(t *testing.T) {
+    day := func(s string) time.Time {
+        d, err := time.ParseInLocation("2006-01-02", s, time.UTC)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return d
+    }
+    tests := []struct {
+        value, now, want string
+    }{
+        {"12-25", "2026-10-15", "2026-12-25"},
+        {"12-25", "2026-12-26", "2027-12-25"},
+        {"10-15", "2026-10-15", "2026-10-15"},
+        {"02-29", "2027-01-10", "2027-02-28"},
+        {"02-29", "2027-03-01", "2028-02-29"},
+        {"02-29", "2028-02-29", "2028-02-29"},
+        {"02-29", "2028-03-01", "2029-02-28"},
+        {"2030-01-01", "2026-10-15", "2030-01-01"},
+    }
+    for _, tt := range tests {
+        got, err := parseCountdownDate(tt.value, day(tt.now).Add(7*time.Hour))
+        if err != nil {
+            t.Errorf("%s from %s: %v", tt.value, tt.now, err)
+            continue
+        }
+        if want := day(tt.want); !got.Equal(want) {
+            t.Errorf("%s from %s: got %s, want %s", tt.value, tt.now, got.Format("2006-01-02"), tt.want)
+        }
+    }
+
+    for _, value := range []string{"2027-02-29", "02-30", "13-01", "christmas"} {
+        if _, err := parseCountdownDate(value, day("2026-10-15")); err == nil {
+            t.Errorf("%s: no error", value)
+        }
+    }
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "strconv"
+    "strings"
+)
+
+// Cropping prints just a region of an image, e.g. one window of a
+// screenshot. The region is "x,y,w,h" in the image's own pixels, measured
+// from its top left corner once it is upright (see exif.go), and is cut out
+// before anything else: the region is what gets fitted to the paper.
+
+// parseCrop parses "x,y,w,h"; empty is no crop.
+func parseCrop(value string) (image.Rectangle, error) {
+    if value == "" {
+        return image.Rectangle{}, nil
+    }
+    parts := strings.Split(value, ",")
+    if len(parts) != 4 {
+        return image.Rectangle{}, fmt.Errorf("crop must be x,y,w,h")
+    }
+    var n [4]int
+    for i, part := range parts {
+        v, err := strconv.Atoi(strings.TrimSpace(part))
+        if err != nil || v < 0 {
+            return image.Rectangle{}, fmt.Errorf("crop must be x,y,w,h in whole pixels")
+        }
+        n[i] = v
+    }
+    if n[2] == 0 || n[3] == 0 {
+        return image.Rectangle{}, fmt.Errorf("crop width and height can't be 0")
+    }
+    return image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3]), nil
+}
+
+// cropImage cuts region out of img. A region running past the edges is
+// trimmed to the image; one entirely outside it is an error.
+func cropImage(img image.Image, region image.Rectangle) (image.Image, error) {
+    b := img.Bounds()
+    r := region.Add(b.Min).Intersect(b)
+    if r.Empty() {
+        return nil, fmt.Errorf("crop %d,%d,%d,%d is outside the %dx%d image", region.Min.X, region.Min.Y, region.Dx(), region.Dy(), b.Dx(), b.Dy())
+    }
+    if sub, ok := img.(interface {
+        SubImage(image.Rectangle) image.Image
+    }); ok {
+        return sub.SubImage(r), nil
+    }
+    out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+    for y := 0; y < r.Dy(); y++ {
+        for x := 0; x < r.Dx(); x++ {
+            out.Set(x, y, img.At(r.Min.X+x, r.Min.Y+y))
+        }
+    }
+    return out, nil
+}
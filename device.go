@@ -0,0 +1,94 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/go-ble/ble"
+    "github.com/go-ble/ble/linux"
+)
+
+// Bluetooth adapters are opened through here, never through go-ble's
+// package-level default device. An adapter can only be opened once per
+// process, so every engine (and the virtual printer) on the same adapter
+// shares one ble.Device, which is stopped when the last of them lets go.
+// Printers on different adapters ("hci" in the config) don't touch each
+// other at all.
+
+var (
+    devicesMu sync.Mutex
+    devices   = map[int]*openDevice{}
+)
+
+type openDevice struct {
+    device ble.Device
+    users  int
+}
+
+// acquireDevice opens adapter hci<n>, or shares it if it is already open.
+func acquireDevice(hci int) (ble.Device, error) {
+    devicesMu.Lock()
+    defer devicesMu.Unlock()
+    if d, ok := devices[hci]; ok {
+        d.users++
+        return d.device, nil
+    }
+    device, err := linux.NewDevice(ble.OptDeviceID(hci))
+    if err != nil {
+        return nil, fmt.Errorf("failed to open hci%d: %v", hci, err)
+    }
+    devices[hci] = &openDevice{device: device, users: 1}
+    return device, nil
+}
+
+// releaseDevice gives up one use of adapter hci<n>, stopping it after the
+// last one.
+func releaseDevice(hci int) {
+    devicesMu.Lock()
+    defer devicesMu.Unlock()
+    d, ok := devices[hci]
+    if !ok {
+        return
+    }
+    if d.users--; d.users > 0 {
+        return
+    }
+    delete(devices, hci)
+    d.device.Stop()
+}
+
+// The name MXW01 printers advertise
+const DEFAULT_PRINTER_NAME = "MXW01"
+
+// discoverPrinter scans adapter hci<n> for a printer advertising name and
+// returns its address.
+func discoverPrinter(hci int, name string, timeout time.Duration) (string, error) {
+    device, err := acquireDevice(hci)
+    if err != nil {
+        return "", err
+    }
+    defer releaseDevice(hci)
+
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    found := make(chan string, 1)
+    device.Scan(ctx, false, func(a ble.Advertisement) {
+        if !strings.EqualFold(a.LocalName(), name) {
+            return
+        }
+        select {
+        case found <- a.Addr().String():
+            cancel()
+        default:
+        }
+    })
+    select {
+    case addr := <-found:
+        return addr, nil
+    default:
+        return "", fmt.Errorf("no printer named %s found within %v", name, timeout)
+    }
+}
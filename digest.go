@@ -0,0 +1,81 @@
+//go:build daemon
+
+package main
+
+import (
+    "fmt"
+    "log"
+    "time"
+)
+
+// Daily digest: an opt-in print of several cards (see cards.go) stacked into
+// one job at the same time every day, like a small morning paper.
+//
+//   "digest": {"at": "07:00", "cards": [{"name": "sun"}]}
+//
+// Its cards print in the digest's "locale", else the config's (see i18n.go).
+
+const DIGEST_SOURCE = "digest"
+
+func init() {
+    daemonServices = append(daemonServices, startDigest)
+}
+
+func startDigest(engine *Engine, config *Config) error {
+    digest := config.Digest
+    if digest == nil {
+        return nil
+    }
+    if _, err := time.Parse("15:04", digest.At); err != nil {
+        return fmt.Errorf("digest: at must be a time of day like \"07:00\"")
+    }
+    if err := checkTimeZone(digest.TimeZone); err != nil {
+        return fmt.Errorf("digest: %v", err)
+    }
+    if len(digest.Cards) == 0 {
+        return fmt.Errorf("digest: no cards")
+    }
+    if digest.Locale != "" && !validLocale(digest.Locale) {
+        return fmt.Errorf("digest: invalid locale %q, want a language tag like \"de-DE\"", digest.Locale)
+    }
+    requests := make([]CardRequest, len(digest.Cards))
+    for i, card := range digest.Cards {
+        if _, ok := cards[card.Name]; !ok {
+            return fmt.Errorf("digest: unknown card %q (known: %s)", card.Name, cardList())
+        }
+        requests[i] = digestCard(card, digest.Locale)
+    }
+
+    go func() {
+        for {
+            at, err := parsePrintAt(digest.At, digest.TimeZone, config)
+            if err != nil {
+                log.Printf("Digest schedule stopped: %v", err)
+                return
+            }
+            log.Printf("Next digest print at %s", at.Format(time.RFC3339))
+            select {
+            case <-time.After(time.Until(at)):
+            case <-engine.stop:
+                return
+            }
+            opts := engine.WithSourceDefaults(PrintOptions{Source: DIGEST_SOURCE})
+            if err := engine.PrintCards(requests, opts); err != nil {
+                log.Printf("Digest print failed: %v", err)
+            }
+        }
+    }()
+    return nil
+}
+
+// digestCard gives a card the digest's locale unless it names its own.
+func digestCard(card CardRequest, locale string) CardRequest {
+    if locale == "" || card.Params["locale"] != "" {
+        return card
+    }
+    params := map[string]string{"locale": locale}
+    for key, value := range card.Params {
+        params[key] = value
+    }
+    return CardRequest{Name: card.Name, Params: params}
+}
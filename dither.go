@@ -0,0 +1,220 @@
+package main
+
+import (
+    "image"
+    "strings"
+)
+
+// Dithering turns grayscale and colour images into the printer's 1-bit dots
+// with visible tone. The default is a plain threshold, which suits images
+// that are already black and white.
+
+// DitherMode names a dithering algorithm. The same names are used by the CLI
+// flag, the daemon's dither parameter and the config.
+type DitherMode string
+
+const (
+    DITHER_THRESHOLD       DitherMode = "threshold"
+    DITHER_FLOYD_STEINBERG DitherMode = "floyd-steinberg"
+    // Atkinson only passes on 3/4 of the error, which keeps highlights and
+    // shadows clean: the classic look on small thermal heads
+    DITHER_ATKINSON DitherMode = "atkinson"
+    // Bayer is an ordered dither: a fixed 8x8 pattern instead of diffused
+    // error, so flat areas in line art and screenshots stay regular
+    DITHER_BAYER DitherMode = "bayer"
+    // Blue noise thresholds against a scattered mask (see bluenoise.go): no
+    // grid like Bayer, no worms like error diffusion; good for photos
+    DITHER_BLUE_NOISE DitherMode = "blue-noise"
+    // Wider kernels spread the error over three rows: smoother gradients and
+    // fewer worm artifacts than Floyd-Steinberg, at the cost of some detail
+    DITHER_JARVIS DitherMode = "jarvis"
+    DITHER_STUCKI DitherMode = "stucki"
+    DITHER_SIERRA DitherMode = "sierra"
+    // Sierra Lite is a cheaper two-row kernel, close to Floyd-Steinberg
+    DITHER_SIERRA_LITE DitherMode = "sierra-lite"
+    // Gray isn't dithered: 16 real tones, for models that print them (see
+    // gray.go)
+    DITHER_GRAY DitherMode = "gray"
+)
+
+// DEFAULT_THRESHOLD is the gray level (0-255) below which a pixel prints
+// black, for the threshold mode and the error diffusion modes.
+const DEFAULT_THRESHOLD = 128
+
+var DitherModes = []DitherMode{
+    DITHER_THRESHOLD, DITHER_FLOYD_STEINBERG, DITHER_ATKINSON, DITHER_BAYER, DITHER_BLUE_NOISE,
+    DITHER_JARVIS, DITHER_STUCKI, DITHER_SIERRA, DITHER_SIERRA_LITE, DITHER_GRAY,
+}
+
+// diffusionKernel spreads the quantization error of a pixel onto its
+// not-yet-visited neighbours: weight/divisor to the pixel at (dx, dy).
+type diffusionKernel struct {
+    divisor float32
+    taps    []diffusionTap
+}
+
+type diffusionTap struct {
+    dx, dy int
+    weight float32
+}
+
+var diffusionKernels = map[DitherMode]diffusionKernel{
+    DITHER_FLOYD_STEINBERG: {16, []diffusionTap{{1, 0, 7}, {-1, 1, 3}, {0, 1, 5}, {1, 1, 1}}},
+    DITHER_ATKINSON:        {8, []diffusionTap{{1, 0, 1}, {2, 0, 1}, {-1, 1, 1}, {0, 1, 1}, {1, 1, 1}, {0, 2, 1}}},
+    DITHER_JARVIS: {48, []diffusionTap{
+        {1, 0, 7}, {2, 0, 5},
+        {-2, 1, 3}, {-1, 1, 5}, {0, 1, 7}, {1, 1, 5}, {2, 1, 3},
+        {-2, 2, 1}, {-1, 2, 3}, {0, 2, 5}, {1, 2, 3}, {2, 2, 1},
+    }},
+    DITHER_STUCKI: {42, []diffusionTap{
+        {1, 0, 8}, {2, 0, 4},
+        {-2, 1, 2}, {-1, 1, 4}, {0, 1, 8}, {1, 1, 4}, {2, 1, 2},
+        {-2, 2, 1}, {-1, 2, 2}, {0, 2, 4}, {1, 2, 2}, {2, 2, 1},
+    }},
+    DITHER_SIERRA: {32, []diffusionTap{
+        {1, 0, 5}, {2, 0, 3},
+        {-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+        {-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+    }},
+    DITHER_SIERRA_LITE: {4, []diffusionTap{{1, 0, 2}, {-1, 1, 1}, {0, 1, 1}}},
+}
+
+// bayer8 is the 8x8 Bayer index matrix (values 0-63).
+var bayer8 = [8][8]uint8{
+    {0, 32, 8, 40, 2, 34, 10, 42},
+    {48, 16, 56, 24, 50, 18, 58, 26},
+    {12, 44, 4, 36, 14, 46, 6, 38},
+    {60, 28, 52, 20, 62, 30, 54, 22},
+    {3, 35, 11, 43, 1, 33, 9, 41},
+    {51, 19, 59, 27, 49, 17, 57, 25},
+    {15, 47, 7, 39, 13, 45, 5, 37},
+    {63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+func validDither(mode DitherMode) bool {
+    if mode == "" {
+        return true
+    }
+    for _, m := range DitherModes {
+        if m == mode {
+            return true
+        }
+    }
+    return false
+}
+
+// ditherModeList is for usage and error messages.
+func ditherModeList() string {
+    names := make([]string, len(DitherModes))
+    for i, m := range DitherModes {
+        names[i] = string(m)
+    }
+    return strings.Join(names, ", ")
+}
+
+func validThreshold(threshold int) bool {
+    return threshold >= 0 && threshold <= 255
+}
+
+// spreadsDots reports whether mode makes patterns of dots (and not a hard
+// cut-off), which is where dot gain shows.
+func spreadsDots(mode DitherMode) bool {
+    _, diffused := diffusionKernels[mode]
+    return diffused || mode == DITHER_BAYER || mode == DITHER_BLUE_NOISE
+}
+
+// ditherImage returns a black and white version of img (16 tones of gray
+// for DITHER_GRAY).
+func ditherImage(img image.Image, mode DitherMode, threshold int) image.Image {
+    if mode == DITHER_GRAY {
+        return quantizeGray(img)
+    }
+    if mode == DITHER_BAYER {
+        return orderedDither(img)
+    }
+    if mode == DITHER_BLUE_NOISE {
+        return blueNoiseDither(img)
+    }
+    kernel, ok := diffusionKernels[mode]
+    if !ok {
+        if threshold == DEFAULT_THRESHOLD {
+            // The encoder's own cut-off is the same, skip the extra pass
+            return img
+        }
+        return thresholdImage(img, threshold)
+    }
+    return errorDiffuse(img, kernel, threshold)
+}
+
+func thresholdImage(img image.Image, threshold int) *image.Gray {
+    b := img.Bounds()
+    out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < b.Dx(); x++ {
+            if int(luminance(img.At(b.Min.X+x, b.Min.Y+y))) >= threshold {
+                out.Pix[y*out.Stride+x] = 255
+            }
+        }
+    }
+    return out
+}
+
+func errorDiffuse(img image.Image, kernel diffusionKernel, threshold int) *image.Gray {
+    b := img.Bounds()
+    w, h := b.Dx(), b.Dy()
+    levels := make([]float32, w*h)
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            levels[y*w+x] = float32(luminance(img.At(b.Min.X+x, b.Min.Y+y)))
+        }
+    }
+
+    out := image.NewGray(image.Rect(0, 0, w, h))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            old := levels[y*w+x]
+            var v float32
+            if old >= float32(threshold) {
+                v = 255
+            }
+            out.Pix[y*out.Stride+x] = uint8(v)
+            diff := (old - v) / kernel.divisor
+            for _, t := range kernel.taps {
+                nx, ny := x+t.dx, y+t.dy
+                if nx >= 0 && nx < w && ny < h {
+                    levels[ny*w+nx] += diff * t.weight
+                }
+            }
+        }
+    }
+    return out
+}
+
+// invertImage returns a negative of img, for white-on-black prints.
+func invertImage(img image.Image) *image.Gray {
+    b := img.Bounds()
+    out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < b.Dx(); x++ {
+            out.Pix[y*out.Stride+x] = 255 - luminance(img.At(b.Min.X+x, b.Min.Y+y))
+        }
+    }
+    return out
+}
+
+// orderedDither compares each pixel against its cell of the Bayer matrix.
+func orderedDither(img image.Image) *image.Gray {
+    b := img.Bounds()
+    out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < b.Dx(); x++ {
+            level := int(luminance(img.At(b.Min.X+x, b.Min.Y+y)))
+            // Thresholds spread evenly over 0-255, centred in each step
+            threshold := int(bayer8[y%8][x%8])*4 + 2
+            if level >= threshold {
+                out.Pix[y*out.Stride+x] = 255
+            }
+        }
+    }
+    return out
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "fmt"
+    "image"
+)
+
+// Dot-gain compensation. A heated dot bleeds into the paper around it, so a
+// 50% dither pattern prints noticeably darker than 50% gray and photos come
+// out muddy. The model profile's tone curve lightens the midtones by about
+// as much as they will darken, before dithering. Black and white stay put,
+// and a hard threshold isn't affected.
+//
+// A curve is a list of [input, output] gray levels (0 black, 255 white),
+// joined by straight lines:
+//
+//   "profiles": {"mxw01": {"dot_gain": [[0, 0], [128, 150], [255, 255]]}}
+//
+// An empty list turns compensation off.
+
+// ToneCurve maps gray levels through [input, output] points.
+type ToneCurve [][2]int
+
+// MXW01_DOT_GAIN makes up for about 15% gain at mid-gray on MXW01 paper,
+// which is how much darker a 50% pattern prints.
+var MXW01_DOT_GAIN = ToneCurve{{0, 0}, {64, 100}, {128, 163}, {192, 213}, {255, 255}}
+
+func (c ToneCurve) validate() error {
+    for i, p := range c {
+        if p[0] < 0 || p[0] > 255 || p[1] < 0 || p[1] > 255 {
+            return fmt.Errorf("dot_gain levels must be between 0 and 255")
+        }
+        if i > 0 && p[0] <= c[i-1][0] {
+            return fmt.Errorf("dot_gain inputs must increase")
+        }
+    }
+    if len(c) > 0 && (c[0][0] != 0 || c[len(c)-1][0] != 255) {
+        return fmt.Errorf("dot_gain must run from input 0 to 255")
+    }
+    return nil
+}
+
+// lut interpolates the curve for every level.
+func (c ToneCurve) lut() [256]uint8 {
+    var table [256]uint8
+    for i := range table {
+        table[i] = uint8(i)
+    }
+    for n := 1; n < len(c); n++ {
+        x0, y0, x1, y1 := c[n-1][0], c[n-1][1], c[n][0], c[n][1]
+        for x := x0; x <= x1; x++ {
+            table[x] = uint8(y0 + (y1-y0)*(x-x0)/(x1-x0))
+        }
+    }
+    return table
+}
+
+// compensateDotGain returns a grayscale copy of img run through curve.
+func compensateDotGain(img image.Image, curve ToneCurve) image.Image {
+    if len(curve) == 0 {
+        return img
+    }
+    table := curve.lut()
+    b := img.Bounds()
+    out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < b.Dx(); x++ {
+            out.Pix[y*out.Stride+x] = table[luminance(img.At(b.Min.X+x, b.Min.Y+y))]
+        }
+    }
+    return out
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "io"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/go-ble/ble"
+)
+
+// Engine is the printer core without any HTTP: it loads and encodes images,
+// queues and prints jobs, and tracks their state. The bundled daemon is an
+// Engine plus the handlers in httpapi.go; to embed printing in another
+// server, create an Engine and call it from your own handlers (with your own
+// mux and auth), or mount NewHTTPAPI(engine, ...).Handler() under a prefix.
+//
+// Job IDs are passed explicitly so callers can hand the ID out before the
+// (slow) rendering and printing steps. An empty ID is accepted everywhere
+// and simply isn't tracked.
+type Engine struct {
+    printer *PrinterDaemon
+    config  *Config
+    stop    chan struct{}
+
+    consoleOnce sync.Once
+    console     *Console
+    consoleErr  error
+}
+
+const HEALTH_CHECK_INTERVAL = 30 * time.Second
+
+func NewEngine(macAddr string, config *Config) *Engine {
+    return &Engine{
+        printer: NewPrinterDaemon(macAddr, config),
+        config:  config,
+        stop:    make(chan struct{}),
+    }
+}
+
+// NewEngineWithDevice is NewEngine for programs that already have a BLE
+// device open for other peripherals: the engine dials the printer through
+// it instead of opening the HCI device a second time, and leaves it running
+// on Close.
+func NewEngineWithDevice(macAddr string, config *Config, device ble.Device) *Engine {
+    e := NewEngine(macAddr, config)
+    e.printer.device = device
+    e.printer.sharedDevice = true
+    return e
+}
+
+// NewEngineWithClient prints over a connection to the printer that the
+// caller dialed and keeps: the engine never closes it, so it can't reconnect
+// either, and jobs fail once the caller's connection drops.
+func NewEngineWithClient(config *Config, client ble.Client) *Engine {
+    e := NewEngine(client.Addr().String(), config)
+    e.printer.sharedClient = client
+    return e
+}
+
+func (e *Engine) Config() *Config {
+    return e.config
+}
+
+// Start runs the background connection health check until Close.
+func (e *Engine) Start() {
+    go e.healthCheck()
+    if e.config.ConsolePipe != "" {
+        go e.readConsolePipe(e.config.ConsolePipe)
+    }
+}
+
+// Close stops background work and releases the Bluetooth device.
+func (e *Engine) Close() {
+    select {
+    case <-e.stop:
+    default:
+        close(e.stop)
+    }
+    e.printer.Stop()
+}
+
+// NewJob registers a queued job and returns its ID.
+func (e *Engine) NewJob(source string) string {
+    return e.printer.jobs.New(source)
+}
+
+// Prepare loads and encodes an image for a job. On failure the job is marked
+// failed.
+func (e *Engine) Prepare(jobID, ref string, render RenderOptions) (*preparedImage, error) {
+    e.printer.jobs.Set(jobID, JobRendering, nil)
+    prepared, err := e.printer.prepareImage(ref, render)
+    if err != nil {
+        e.printer.jobs.Finish(jobID, err)
+    }
+    return prepared, err
+}
+
+// PrepareImages is Prepare for several images stacked into one print.
+func (e *Engine) PrepareImages(jobID string, refs []string, render RenderOptions) (*preparedImage, error) {
+    e.printer.jobs.Set(jobID, JobRendering, nil)
+    prepared, err := e.printer.prepareImages(refs, render)
+    if err != nil {
+        e.printer.jobs.Finish(jobID, err)
+    }
+    return prepared, err
+}
+
+// DryRun runs images through the whole pipeline, with the feeds and tear
+// line a job would get, and returns the result as a PNG instead of printing
+// it. It never touches the printer.
+func (e *Engine) DryRun(refs []string, opts PrintOptions) ([]byte, error) {
+    return e.printer.dryRun(refs, opts)
+}
+
+// Reject marks a job failed before it got to the printer, e.g. when a
+// caller's own checks turn it down.
+func (e *Engine) Reject(jobID string, err error) {
+    e.printer.jobs.Finish(jobID, err)
+}
+
+// Print queues a prepared job and blocks until it has printed or failed.
+func (e *Engine) Print(jobID string, prepared *preparedImage, opts PrintOptions) error {
+    return e.printer.PrintJob(jobID, prepared, opts)
+}
+
+// PrintText prints plain text as a new job, wrapped to the paper width.
+func (e *Engine) PrintText(text string, opts PrintOptions) error {
+    jobID := e.NewJob(opts.Source)
+    prepared, err := e.PrepareText(jobID, text, opts.Source)
+    if err != nil {
+        return err
+    }
+    return e.Print(jobID, prepared, opts)
+}
+
+// PrepareText renders plain text for a job, wrapped to the paper width, so
+// it can be checked before it prints.
+func (e *Engine) PrepareText(jobID, text, source string) (*preparedImage, error) {
+    e.printer.jobs.Set(jobID, JobRendering, nil)
+    t, err := newTextRenderer(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT)
+    if err != nil {
+        e.printer.jobs.Finish(jobID, err)
+        return nil, err
+    }
+    var prepared *preparedImage
+    e.printer.renderers.run(func() {
+        var lines []string
+        for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+            lines = append(lines, t.wrap(line)...)
+        }
+        prepared = newPreparedImage(source, t.render(lines))
+    })
+    return prepared, nil
+}
+
+// PrintCards renders cards (see cards.go) and prints them stacked as one job.
+func (e *Engine) PrintCards(requests []CardRequest, opts PrintOptions) error {
+    jobID := e.NewJob(opts.Source)
+    prepared, err := e.PrepareCards(jobID, requests, opts.Source)
+    if err != nil {
+        return err
+    }
+    return e.Print(jobID, prepared, opts)
+}
+
+// PrepareCards renders cards stacked for a job, so they can be checked
+// before they print.
+func (e *Engine) PrepareCards(jobID string, requests []CardRequest, source string) (*preparedImage, error) {
+    e.printer.jobs.Set(jobID, JobRendering, nil)
+    var prepared *preparedImage
+    var err error
+    e.printer.renderers.run(func() {
+        var img image.Image
+        if img, err = renderCards(e.config, requests, time.Now().In(e.config.location)); err == nil {
+            prepared = newPreparedImage(source, img)
+        }
+    })
+    if err != nil {
+        e.printer.jobs.Finish(jobID, err)
+        return nil, err
+    }
+    return prepared, nil
+}
+
+// PrintTextStream prints text as it is read from r (see stream.go).
+func (e *Engine) PrintTextStream(jobID string, r io.Reader, opts PrintOptions) error {
+    return e.printer.PrintTextStream(jobID, r, opts)
+}
+
+// PrintImage prepares and prints in one go under a new job.
+func (e *Engine) PrintImage(ref string, opts PrintOptions) error {
+    return e.printer.PrintImage(ref, opts)
+}
+
+// PrintImages prints several images stacked into one job.
+func (e *Engine) PrintImages(refs []string, opts PrintOptions) error {
+    return e.printer.PrintImages(refs, opts)
+}
+
+// Schedule holds a prepared job until at; it returns straight away.
+func (e *Engine) Schedule(jobID string, at time.Time, prepared *preparedImage, opts PrintOptions) error {
+    return e.printer.SchedulePrint(jobID, at, prepared, opts)
+}
+
+func (e *Engine) PrintBatch(jobs []BatchJob, opts PrintOptions, check func(BatchJob, *preparedImage) error) *BatchResult {
+    return e.printer.PrintBatch(jobs, opts, check)
+}
+
+func (e *Engine) SendRaw(toData bool, cmdID byte, payload []byte, wait time.Duration) (*RawResult, error) {
+    return e.printer.SendRaw(toData, cmdID, payload, wait)
+}
+
+// Notifications streams every notification from the printer, raw, until the
+// returned func is called. Nothing arrives while disconnected.
+func (e *Engine) Notifications() (<-chan []byte, func()) {
+    return e.printer.tapNotifications()
+}
+
+// Replay sends recorded app traffic to the printer (see traffic.go).
+func (e *Engine) Replay(records []TrafficRecord, speed float64) error {
+    return e.printer.Replay(records, speed)
+}
+
+// Append prints lines in console mode, as soon as the printer gets to them.
+func (e *Engine) Append(lines ...string) error {
+    e.consoleOnce.Do(func() {
+        e.console, e.consoleErr = newConsole(e.printer)
+    })
+    if e.consoleErr != nil {
+        return e.consoleErr
+    }
+    for _, line := range lines {
+        if err := e.console.Append(line); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// FlushConsole waits for lines given to Append to print.
+func (e *Engine) FlushConsole() {
+    if e.console != nil {
+        e.console.Flush()
+    }
+}
+
+func (e *Engine) Job(id string) (Job, bool) {
+    return e.printer.jobs.Get(id)
+}
+
+func (e *Engine) Jobs() []Job {
+    return e.printer.jobs.List()
+}
+
+func (e *Engine) Cancel(id string) error {
+    return e.printer.jobs.Cancel(id)
+}
+
+// Subscribe streams job snapshots, one per state change, until the returned
+// func is called.
+func (e *Engine) Subscribe() (<-chan Job, func()) {
+    return e.printer.jobs.Subscribe()
+}
+
+// JobCounts returns jobs per state and state transitions since startup.
+func (e *Engine) JobCounts() (map[JobState]int, map[JobState]uint64) {
+    return e.printer.jobs.Counts()
+}
+
+// WithSourceDefaults fills the options a job left unset from the defaults
+// configured for its source, if any.
+func (e *Engine) WithSourceDefaults(opts PrintOptions) PrintOptions {
+    if defaults, ok := e.config.sourceOptions[opts.Source]; ok {
+        return opts.withDefaults(defaults)
+    }
+    return opts
+}
+
+// JobPreview returns what a finished job sent to the printer as a PNG, or
+// for a job with a reply_to that never got that far, what it would print.
+func (e *Engine) JobPreview(id string) ([]byte, error) {
+    if preview := e.printer.jobs.Preview(id); preview != nil {
+        return preview, nil
+    }
+    prepared := e.printer.jobs.Prepared(id)
+    if prepared == nil {
+        return nil, fmt.Errorf("no preview for job %s", id)
+    }
+    return prepared.png()
+}
+
+// Checkpoints lists interrupted jobs that can be resumed.
+func (e *Engine) Checkpoints() ([]Checkpoint, error) {
+    return e.printer.Checkpoints()
+}
+
+// ResumeCheckpoint prints the rest of an interrupted job under a new job ID,
+// blocking until it has printed or failed.
+func (e *Engine) ResumeCheckpoint(id string) (string, error) {
+    return e.printer.ResumeCheckpoint(id)
+}
+
+func (e *Engine) DiscardCheckpoint(id string) error {
+    return e.printer.DiscardCheckpoint(id)
+}
+
+// Slots lists the replaceable notes and which job each one shows.
+func (e *Engine) Slots() ([]Slot, error) {
+    return e.printer.Slots()
+}
+
+// SlotContent returns a slot and its current content as a PNG.
+func (e *Engine) SlotContent(name string) (Slot, []byte, error) {
+    return e.printer.SlotContent(name)
+}
+
+// Info returns the connection state, last printer status and settings.
+func (e *Engine) Info() PrinterInfo {
+    return e.printer.info()
+}
+
+// Protocol describes the wire format of the configured model (see
+// protocoldoc.go).
+func (e *Engine) Protocol() ProtocolDescription {
+    return describeProtocol(e.config.profile)
+}
+
+// healthCheck probes the printer while idle-connected so a dead link is
+// noticed before the next job (see health.go).
+func (e *Engine) healthCheck() {
+    ticker := time.NewTicker(HEALTH_CHECK_INTERVAL)
+    defer ticker.Stop()
+
+    pd := e.printer
+    for {
+        select {
+        case <-e.stop:
+            return
+        case <-ticker.C:
+        }
+        pd.checkIdleConnection()
+    }
+}
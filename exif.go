@@ -0,0 +1,110 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "image"
+)
+
+// Phone cameras store JPEGs the way the sensor saw them and record how to
+// turn them upright in the EXIF Orientation tag. Only that one tag is read:
+// the APP1 segment's TIFF header, then IFD0.
+
+const EXIF_ORIENTATION_TAG = 0x0112
+
+// jpegOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 if it
+// has none.
+func jpegOrientation(data []byte) int {
+    if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+        return 1
+    }
+    for i := 2; i+4 <= len(data); {
+        if data[i] != 0xFF {
+            return 1
+        }
+        marker := data[i+1]
+        size := int(binary.BigEndian.Uint16(data[i+2:]))
+        if marker == 0xDA || size < 2 || i+2+size > len(data) {
+            // Image data starts, no EXIF before it
+            return 1
+        }
+        segment := data[i+4 : i+2+size]
+        if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+            return tiffOrientation(segment[6:])
+        }
+        i += 2 + size
+    }
+    return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+    if len(tiff) < 8 {
+        return 1
+    }
+    var order binary.ByteOrder
+    switch string(tiff[:2]) {
+    case "II":
+        order = binary.LittleEndian
+    case "MM":
+        order = binary.BigEndian
+    default:
+        return 1
+    }
+    ifd := int(order.Uint32(tiff[4:]))
+    if ifd+2 > len(tiff) {
+        return 1
+    }
+    entries := int(order.Uint16(tiff[ifd:]))
+    for e := 0; e < entries; e++ {
+        entry := ifd + 2 + e*12
+        if entry+12 > len(tiff) {
+            return 1
+        }
+        if order.Uint16(tiff[entry:]) == EXIF_ORIENTATION_TAG {
+            o := int(order.Uint16(tiff[entry+8:]))
+            if o < 1 || o > 8 {
+                return 1
+            }
+            return o
+        }
+    }
+    return 1
+}
+
+// applyOrientation turns an image upright according to its EXIF orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+    if orientation <= 1 || orientation > 8 {
+        return img
+    }
+    b := img.Bounds()
+    w, h := b.Dx(), b.Dy()
+    // 5-8 swap width and height
+    ow, oh := w, h
+    if orientation >= 5 {
+        ow, oh = h, w
+    }
+    out := image.NewRGBA(image.Rect(0, 0, ow, oh))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            var dx, dy int
+            switch orientation {
+            case 2: // mirrored
+                dx, dy = w-1-x, y
+            case 3: // upside down
+                dx, dy = w-1-x, h-1-y
+            case 4: // upside down, mirrored
+                dx, dy = x, h-1-y
+            case 5: // transposed
+                dx, dy = y, x
+            case 6: // rotated 90° clockwise to be upright
+                dx, dy = h-1-y, x
+            case 7: // transversed
+                dx, dy = h-1-y, w-1-x
+            case 8: // rotated 90° counter-clockwise to be upright
+                dx, dy = y, w-1-x
+            }
+            out.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+        }
+    }
+    return out
+}
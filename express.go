@@ -0,0 +1,103 @@
+package main
+
+import (
+    "fmt"
+    "log"
+)
+
+// Express jobs: with express_rows set, a job of at most that many rows (a
+// short note, a label) doesn't wait for a long photo to finish. Long jobs
+// are sent in segments (split.go), and between two segments the printer
+// takes any express jobs that are waiting, then carries on with the long
+// one on the same connection. When nothing is printing, an express job just
+// prints like any other.
+
+// Largest express job, 10cm of paper
+const MAX_EXPRESS_ROWS = 100 * DOTS_PER_MM
+
+type expressJob struct {
+    jobID    string
+    prepared *preparedImage
+    opts     PrintOptions
+    done     chan error
+}
+
+func validExpressRows(rows int) error {
+    if rows < 0 || rows > MAX_EXPRESS_ROWS {
+        return fmt.Errorf("express_rows must be between 0 and %d", MAX_EXPRESS_ROWS)
+    }
+    return nil
+}
+
+// isExpress reports whether a job may jump ahead of long ones.
+func (pd *PrinterDaemon) isExpress(prepared *preparedImage) bool {
+    return pd.config.ExpressRows > 0 && prepared.numRows <= pd.config.ExpressRows
+}
+
+// queueExpress prints an express job: whichever comes first, the next
+// segment boundary of a running job or the printer itself, prints it.
+func (pd *PrinterDaemon) queueExpress(jobID string, prepared *preparedImage, opts PrintOptions) error {
+    job := &expressJob{jobID: jobID, prepared: prepared, opts: opts, done: make(chan error, 1)}
+    pd.expressMu.Lock()
+    pd.express = append(pd.express, job)
+    pd.expressMu.Unlock()
+
+    go func() {
+        pd.lockForJob()
+        defer pd.jobMu.Unlock()
+        if pd.takeExpress(job) {
+            job.done <- pd.runJob(jobID, prepared, opts)
+        }
+    }()
+    return <-job.done
+}
+
+// takeExpress removes job from the waiting list, reporting whether it was
+// still there to take.
+func (pd *PrinterDaemon) takeExpress(job *expressJob) bool {
+    pd.expressMu.Lock()
+    defer pd.expressMu.Unlock()
+    for i, waiting := range pd.express {
+        if waiting == job {
+            pd.express = append(pd.express[:i], pd.express[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+// serveExpress prints the waiting express jobs on the open connection, in
+// between two segments of a longer job. Callers must hold jobMu.
+func (pd *PrinterDaemon) serveExpress() {
+    pd.expressMu.Lock()
+    jobs := pd.express
+    pd.express = nil
+    pd.expressMu.Unlock()
+
+    for _, job := range jobs {
+        log.Printf("Print job %s: express, printing ahead", job.jobID)
+        job.done <- pd.printExpress(job)
+    }
+}
+
+// printExpress is runJob for a connection that is already open.
+func (pd *PrinterDaemon) printExpress(job *expressJob) error {
+    select {
+    case <-pd.jobs.Canceled(job.jobID):
+        return errJobCanceled
+    default:
+    }
+    if pd.jobs.Expired(job.jobID) {
+        return errJobExpired
+    }
+    prepared, err := pd.decorate(job.jobID, job.prepared, job.opts)
+    if err != nil {
+        return err
+    }
+    defer pd.watchJob(job.jobID)()
+    if err := pd.printPrepared(job.jobID, prepared); err != nil {
+        return err
+    }
+    pd.lastSource = job.opts.Source
+    return nil
+}
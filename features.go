@@ -0,0 +1,41 @@
+package main
+
+import "sort"
+
+// Optional features that pull in heavy dependencies (PDF rasterizer, HEIC,
+// headless HTML rendering...) live in files behind their own build tag, so
+// the default binary stays small enough for a Pi Zero:
+//
+//   go build -tags daemon -o catprinter_daemon .            # core only
+//   go build -tags daemon,heic -o catprinter_daemon .       # plus HEIC
+//
+// Each feature file registers itself from init(); /version reports what the
+// running binary was built with so clients can check before sending.
+
+type Capability struct {
+    Name        string `json:"name"`
+    Description string `json:"description"`
+    // BuildTag is empty for features that are always compiled in
+    BuildTag string `json:"build_tag,omitempty"`
+}
+
+var capabilities = map[string]Capability{}
+
+func registerCapability(c Capability) {
+    capabilities[c.Name] = c
+}
+
+func hasCapability(name string) bool {
+    _, ok := capabilities[name]
+    return ok
+}
+
+// Capabilities lists the compiled-in features, sorted by name.
+func Capabilities() []Capability {
+    list := make([]Capability, 0, len(capabilities))
+    for _, c := range capabilities {
+        list = append(list, c)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+    return list
+}
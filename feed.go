@@ -0,0 +1,108 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Feeding blank paper after a job moves the end of the print past the tear
+// bar, instead of leaving it under the head; feeding before it leaves a top
+// margin. Amounts are given in dot lines ("40") or millimetres ("10mm"); the
+// head does 8 lines per millimetre.
+
+const (
+    DOTS_PER_MM   = 8
+    MAX_FEED_ROWS = 100 * DOTS_PER_MM
+    // FEED_NONE in PrintOptions turns a configured feed off for one job
+    FEED_NONE = -1
+)
+
+// parseFeed reads a feed amount into dot lines.
+func parseFeed(value string) (int, error) {
+    value = strings.TrimSpace(strings.ToLower(value))
+    if value == "" {
+        return 0, nil
+    }
+    scale := 1
+    if strings.HasSuffix(value, "mm") {
+        value = strings.TrimSpace(strings.TrimSuffix(value, "mm"))
+        scale = DOTS_PER_MM
+    }
+    n, err := strconv.ParseFloat(value, 64)
+    if err != nil || n < 0 {
+        return 0, fmt.Errorf("invalid feed %q, want lines or mm (e.g. 40 or 10mm)", value)
+    }
+    rows := int(n*float64(scale) + 0.5)
+    if rows > MAX_FEED_ROWS {
+        return 0, fmt.Errorf("feed of %d lines is over the %d line limit", rows, MAX_FEED_ROWS)
+    }
+    return rows, nil
+}
+
+// parseJobFeed reads a per-job feed: empty keeps the configured default, an
+// explicit 0 turns it off.
+func parseJobFeed(value string) (int, error) {
+    rows, err := parseFeed(value)
+    if err != nil || strings.TrimSpace(value) == "" {
+        return rows, err
+    }
+    if rows == 0 {
+        return FEED_NONE, nil
+    }
+    return rows, nil
+}
+
+// jobFeed is a job's own feed setting, else the configured default.
+func jobFeed(rows, configured int) int {
+    switch {
+    case rows == FEED_NONE:
+        return 0
+    case rows > 0:
+        return rows
+    }
+    return configured
+}
+
+// feedRows is how much to feed after a job.
+func (pd *PrinterDaemon) feedRows(opts PrintOptions) int {
+    return jobFeed(opts.FeedAfter, pd.config.feedAfter)
+}
+
+// feedBeforeRows is how much to feed before a job.
+func (pd *PrinterDaemon) feedBeforeRows(opts PrintOptions) int {
+    return jobFeed(opts.FeedBefore, pd.config.feedBefore)
+}
+
+// withFeed returns a copy of prepared followed by rows blank lines.
+func withFeed(prepared *preparedImage, rows int) *preparedImage {
+    if rows <= 0 {
+        return prepared
+    }
+    return appendRows(prepared, make([]byte, rows*prepared.rowBytes()))
+}
+
+// withFeedBefore returns a copy of prepared after rows blank lines.
+func withFeedBefore(prepared *preparedImage, rows int) *preparedImage {
+    if rows <= 0 {
+        return prepared
+    }
+    return prependRows(prepared, make([]byte, rows*prepared.rowBytes()))
+}
+
+// appendRows returns a copy of prepared with encoded rows (in its format)
+// after it. Padding added when the image was encoded is dropped first, so
+// short prints don't feed twice.
+func appendRows(prepared *preparedImage, rows []byte) *preparedImage {
+    buffer := append(append([]byte{}, prepared.rows()...), rows...)
+    for len(buffer) < MIN_DATA_ROWS*prepared.rowBytes() {
+        buffer = append(buffer, 0)
+    }
+    return prepared.with(buffer, prepared.numRows+len(rows)/prepared.rowBytes())
+}
+
+// prependRows returns a copy of prepared with encoded rows (in its format)
+// in front of it.
+func prependRows(prepared *preparedImage, rows []byte) *preparedImage {
+    return prepared.with(append(append([]byte{}, rows...), prepared.buffer...), prepared.numRows+len(rows)/prepared.rowBytes())
+}
@@ -0,0 +1,164 @@
+//go:build daemon
+
+package main
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "os/exec"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// Content filters run before a job is accepted (printed or queued for
+// moderation). They are configured per source, with "*" as the fallback:
+//
+//   "filters": {
+//     "web": {"max_chars": 500, "max_rows": 2000, "banned_words": "(?i)\\b(foo|bar)\\b",
+//             "image_classifier": ["/usr/local/bin/nsfw-check", "--threshold", "0.8"]}
+//   }
+//
+// The classifier gets the rendered PNG path as its last argument; a non-zero
+// exit rejects the job and its output is used as the reason.
+//
+// The text filters see the text the daemon renders itself: streamed text,
+// console lines, card parameters, the words of an HTML snippet. For images
+// the text is whatever the client says it drew them from, which a direct
+// client can leave out, so only max_rows and the classifier hold for those.
+
+const CLASSIFIER_TIMEOUT = 30 * time.Second
+
+// FilterRejection is returned when a job is turned away by a filter, as
+// opposed to the filter itself failing.
+type FilterRejection struct {
+    Reason string
+}
+
+func (r *FilterRejection) Error() string {
+    return "rejected by content filter: " + r.Reason
+}
+
+type contentFilter struct {
+    FilterConfig
+    banned *regexp.Regexp
+}
+
+type ContentFilters struct {
+    bySource map[string]*contentFilter
+}
+
+func newContentFilters(configs map[string]FilterConfig) (*ContentFilters, error) {
+    filters := &ContentFilters{bySource: make(map[string]*contentFilter)}
+    for source, cfg := range configs {
+        f := &contentFilter{FilterConfig: cfg}
+        if cfg.BannedWords != "" {
+            re, err := regexp.Compile(cfg.BannedWords)
+            if err != nil {
+                return nil, fmt.Errorf("filter %s: invalid banned_words: %v", source, err)
+            }
+            f.banned = re
+        }
+        filters.bySource[source] = f
+    }
+    return filters, nil
+}
+
+func (cf *ContentFilters) forSource(source string) *contentFilter {
+    if f, ok := cf.bySource[source]; ok {
+        return f
+    }
+    return cf.bySource["*"]
+}
+
+// Has reports whether any filter applies to the source.
+func (cf *ContentFilters) Has(source string) bool {
+    return cf.forSource(source) != nil
+}
+
+// CheckText applies the source's text filters.
+func (cf *ContentFilters) CheckText(source, text string) error {
+    f := cf.forSource(source)
+    if f == nil {
+        return nil
+    }
+    if f.MaxChars > 0 && len([]rune(text)) > f.MaxChars {
+        return &FilterRejection{fmt.Sprintf("text is %d characters, the limit is %d", len([]rune(text)), f.MaxChars)}
+    }
+    if f.banned != nil && f.banned.MatchString(text) {
+        return &FilterRejection{"text contains a banned word"}
+    }
+    return nil
+}
+
+// Check applies the source's filters to a prepared image and the text it was
+// rendered from (if any).
+func (cf *ContentFilters) Check(source, text string, prepared *preparedImage) error {
+    if err := cf.CheckText(source, text); err != nil {
+        return err
+    }
+    f := cf.forSource(source)
+    if f == nil {
+        return nil
+    }
+    if f.MaxRows > 0 && prepared.numRows > f.MaxRows {
+        return &FilterRejection{fmt.Sprintf("image is %d rows long, the limit is %d", prepared.numRows, f.MaxRows)}
+    }
+    if len(f.ImageClassifier) > 0 {
+        return runImageClassifier(f.ImageClassifier, prepared)
+    }
+    return nil
+}
+
+// writeFilterError answers a request that failed its checks: 422 when a
+// filter turned it down, 500 when a filter couldn't run.
+func writeFilterError(w http.ResponseWriter, err error) {
+    log.Printf("Print rejected: %v", err)
+    if _, ok := err.(*FilterRejection); ok {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+    } else {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+    }
+}
+
+func runImageClassifier(command []string, prepared *preparedImage) error {
+    preview, err := prepared.png()
+    if err != nil {
+        return fmt.Errorf("failed to render image for classifier: %v", err)
+    }
+    tmp, err := os.CreateTemp("", "catprinter-classify-*.png")
+    if err != nil {
+        return fmt.Errorf("failed to create temp file: %v", err)
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(preview); err != nil {
+        tmp.Close()
+        return fmt.Errorf("failed to write temp file: %v", err)
+    }
+    tmp.Close()
+
+    ctx, cancel := context.WithTimeout(context.Background(), CLASSIFIER_TIMEOUT)
+    defer cancel()
+    args := append(append([]string{}, command[1:]...), tmp.Name())
+    cmd := exec.CommandContext(ctx, command[0], args...)
+    var output bytes.Buffer
+    cmd.Stdout = &output
+    cmd.Stderr = &output
+
+    err = cmd.Run()
+    if _, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+        reason := strings.TrimSpace(output.String())
+        if reason == "" {
+            reason = "image rejected by classifier"
+        }
+        return &FilterRejection{reason}
+    }
+    if err != nil {
+        return fmt.Errorf("image classifier failed: %v", err)
+    }
+    return nil
+}
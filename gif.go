@@ -0,0 +1,84 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/draw"
+    "image/gif"
+    "io"
+)
+
+// GIFs print their first frame, like any other image.Decode format. With the
+// frames option every frame of an animation is printed instead, one under
+// the other: a flip-book strip.
+
+// Longest strip we build from an animation
+const MAX_GIF_FRAMES = 64
+
+func init() {
+    registerCapability(Capability{Name: "gif", Description: "GIF images; animations print the first frame or every frame as a strip"})
+}
+
+// decodeImage decodes any registered format, expanding animated GIFs into a
+// strip when frames is set.
+func decodeImage(r io.Reader, frames bool) (image.Image, error) {
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return nil, err
+    }
+    if frames && bytes.HasPrefix(data, []byte("GIF8")) {
+        anim, err := gif.DecodeAll(bytes.NewReader(data))
+        if err != nil {
+            return nil, err
+        }
+        return gifStrip(anim)
+    }
+    img, _, err := image.Decode(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    // Phone photos come out sideways without this (see exif.go)
+    return applyOrientation(img, jpegOrientation(data)), nil
+}
+
+// gifStrip plays the animation on a canvas (frames are usually just the
+// part that changed) and stacks a snapshot of every frame.
+func gifStrip(anim *gif.GIF) (image.Image, error) {
+    if len(anim.Image) == 0 {
+        return nil, fmt.Errorf("gif has no frames")
+    }
+    if len(anim.Image) > MAX_GIF_FRAMES {
+        return nil, fmt.Errorf("gif has %d frames, the limit is %d", len(anim.Image), MAX_GIF_FRAMES)
+    }
+    w, h := anim.Config.Width, anim.Config.Height
+    if w == 0 || h == 0 {
+        b := anim.Image[0].Bounds()
+        w, h = b.Max.X, b.Max.Y
+    }
+    canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+    draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+    strip := image.NewRGBA(image.Rect(0, 0, w, h*len(anim.Image)))
+
+    for i, frame := range anim.Image {
+        var previous *image.RGBA
+        disposal := byte(0)
+        if i < len(anim.Disposal) {
+            disposal = anim.Disposal[i]
+        }
+        if disposal == gif.DisposalPrevious {
+            previous = image.NewRGBA(canvas.Bounds())
+            draw.Draw(previous, previous.Bounds(), canvas, image.Point{}, draw.Src)
+        }
+        draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+        draw.Draw(strip, image.Rect(0, i*h, w, (i+1)*h), canvas, image.Point{}, draw.Src)
+
+        switch disposal {
+        case gif.DisposalBackground:
+            draw.Draw(canvas, frame.Bounds(), image.White, image.Point{}, draw.Src)
+        case gif.DisposalPrevious:
+            canvas = previous
+        }
+    }
+    return strip, nil
+}
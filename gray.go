@@ -0,0 +1,103 @@
+package main
+
+import (
+    "bytes"
+    "image"
+    "image/color"
+    "image/png"
+)
+
+// Grayscale printing: some firmwares take rows of 4 bits per dot (print
+// mode 0x02) and vary the heat per dot, printing 16 real tones instead of
+// dithered patterns. No query tells which models can, so it is opt-in per
+// model profile ("grayscale": true) and picked per job with the "gray"
+// dither mode.
+//
+// Two dots share a byte, the first in the low nibble (as the 1-bit rows
+// are LSB first); 0 is white and 15 the darkest.
+
+const GRAY_LEVELS = 16
+
+// quantizeGray reduces img to the 16 printable tones.
+func quantizeGray(img image.Image) *image.Gray {
+    b := img.Bounds()
+    out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < b.Dx(); x++ {
+            level := grayLevel(luminance(img.At(b.Min.X+x, b.Min.Y+y)))
+            out.Pix[y*out.Stride+x] = 255 - level*17
+        }
+    }
+    return out
+}
+
+// grayLevel maps a gray value to a printer level, 0 (white) to 15.
+func grayLevel(y uint8) uint8 {
+    return uint8((int(255-y)*(GRAY_LEVELS-1) + 127) / 255)
+}
+
+// encodeGrayRows packs an image into 4bpp printer rows without padding.
+func encodeGrayRows(img image.Image) []byte {
+    b := img.Bounds()
+    buffer := make([]byte, b.Dy()*GRAY_ROW_BYTES)
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < PRINTER_WIDTH && x < b.Dx(); x++ {
+            level := grayLevel(luminance(img.At(b.Min.X+x, b.Min.Y+y)))
+            buffer[y*GRAY_ROW_BYTES+x/2] |= level << (4 * (x % 2))
+        }
+    }
+    return buffer
+}
+
+// newGrayPreparedImage encodes an image rendered in the gray mode.
+func newGrayPreparedImage(source string, img image.Image) *preparedImage {
+    buffer := encodeGrayRows(img)
+    for len(buffer) < MIN_DATA_ROWS*GRAY_ROW_BYTES {
+        buffer = append(buffer, 0)
+    }
+    return &preparedImage{source: source, buffer: buffer, numRows: img.Bounds().Dy(), gray: true}
+}
+
+// grayFromMono converts 1-bit rows (separators, receipt codes...) to 4bpp,
+// black dots at full heat.
+func grayFromMono(rows []byte) []byte {
+    out := make([]byte, len(rows)/PRINTER_WIDTH_BYTES*GRAY_ROW_BYTES)
+    for i, b := range rows {
+        row, xByte := i/PRINTER_WIDTH_BYTES, i%PRINTER_WIDTH_BYTES
+        for bit := 0; bit < 8; bit++ {
+            if b&(1<<bit) != 0 {
+                x := xByte*8 + bit
+                out[row*GRAY_ROW_BYTES+x/2] |= (GRAY_LEVELS - 1) << (4 * (x % 2))
+            }
+        }
+    }
+    return out
+}
+
+// renderGrayPNG is renderBufferPNG for 4bpp rows: a 16-tone gray PNG.
+func renderGrayPNG(buffer []byte, numRows int) ([]byte, error) {
+    numRows = min(numRows, len(buffer)/GRAY_ROW_BYTES)
+    palette := make(color.Palette, GRAY_LEVELS)
+    for level := range palette {
+        palette[level] = color.Gray{Y: uint8(255 - level*17)}
+    }
+    img := image.NewPaletted(image.Rect(0, 0, PRINTER_WIDTH, numRows), palette)
+    for y := 0; y < numRows; y++ {
+        for x := 0; x < PRINTER_WIDTH; x++ {
+            img.Pix[y*img.Stride+x] = buffer[y*GRAY_ROW_BYTES+x/2] >> (4 * (x % 2)) & 0x0F
+        }
+    }
+    var out bytes.Buffer
+    if err := png.Encode(&out, img); err != nil {
+        return nil, err
+    }
+    return out.Bytes(), nil
+}
+
+// renderRowsPNG previews rows in either format.
+func renderRowsPNG(buffer []byte, numRows int, gray bool) ([]byte, error) {
+    if gray {
+        return renderGrayPNG(buffer, numRows)
+    }
+    return renderBufferPNG(buffer, numRows)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+    "log"
+    "time"
+)
+
+// Connection health checks. A probe is an A1 status request answered by an
+// A1 notification, so a link that still takes writes but no longer reaches
+// the printer is caught too. Probes only run with jobMu held, so they never
+// land between the rows of a print, and are skipped when the printer was
+// heard from recently: any notification proves the link works.
+
+const (
+    // How long to wait for the probe's status reply
+    HEALTH_PROBE_TIMEOUT = 3 * time.Second
+    // A notification this recent counts as a passed probe
+    HEALTH_FRESH = 10 * time.Second
+)
+
+// probe checks the open connection. Callers must hold jobMu.
+func (pd *PrinterDaemon) probe() error {
+    if pd.heardWithin(HEALTH_FRESH) {
+        return nil
+    }
+    request := pd.packet(statusRequest())
+    if pd.notifyChar == nil {
+        // No notifications to wait for, a successful write is all we get
+        return pd.client.WriteCharacteristic(pd.controlChar, request, true)
+    }
+
+    notifications, cancel := pd.tapNotifications()
+    defer cancel()
+    if err := pd.client.WriteCharacteristic(pd.controlChar, request, true); err != nil {
+        return err
+    }
+    _, err := pd.awaitReply(notifications, CMD_GET_STATUS, HEALTH_PROBE_TIMEOUT)
+    return err
+}
+
+// heardWithin reports whether a notification arrived in the last d.
+func (pd *PrinterDaemon) heardWithin(d time.Duration) bool {
+    pd.statusMu.Lock()
+    defer pd.statusMu.Unlock()
+    return !pd.lastHeard.IsZero() && time.Since(pd.lastHeard) < d
+}
+
+// checkIdleConnection probes a connection left open between jobs. While a job
+// holds the connection the check is skipped: the job's own traffic shows
+// whether the link works, and a probe would interleave with its data.
+func (pd *PrinterDaemon) checkIdleConnection() {
+    if !pd.jobMu.TryLock() {
+        return
+    }
+    defer pd.jobMu.Unlock()
+    if !pd.connected {
+        return
+    }
+    if err := pd.probe(); err != nil {
+        log.Printf("Health check failed, connection may be broken: %v", err)
+        pd.Disconnect()
+        return
+    }
+    log.Printf("Connection health check passed")
+}
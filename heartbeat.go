@@ -0,0 +1,93 @@
+//go:build daemon
+
+package main
+
+import (
+    "fmt"
+    "log"
+    "strings"
+    "time"
+)
+
+// Heartbeat: an opt-in daily print of one short status line, so an
+// unattended printer gives a physical sign it still works and someone walking
+// past can see the battery and paper running low.
+//
+//   "heartbeat": {"at": "07:00", "roll_length_m": 6}
+//
+// The paper estimate counts what was printed since the printer last reported
+// it was out of paper. Without roll_length_m only the used length is shown.
+
+const HEARTBEAT_SOURCE = "heartbeat"
+
+func init() {
+    daemonServices = append(daemonServices, startHeartbeat)
+}
+
+func startHeartbeat(engine *Engine, config *Config) error {
+    hb := config.Heartbeat
+    if hb == nil {
+        return nil
+    }
+    if _, err := time.Parse("15:04", hb.At); err != nil {
+        return fmt.Errorf("heartbeat: at must be a time of day like \"07:00\"")
+    }
+    if err := checkTimeZone(hb.TimeZone); err != nil {
+        return fmt.Errorf("heartbeat: %v", err)
+    }
+    if hb.RollLengthM < 0 {
+        return fmt.Errorf("heartbeat: roll_length_m must not be negative")
+    }
+
+    go func() {
+        for {
+            at, err := parsePrintAt(hb.At, hb.TimeZone, config)
+            if err != nil {
+                log.Printf("Heartbeat schedule stopped: %v", err)
+                return
+            }
+            log.Printf("Next heartbeat print at %s", at.Format(time.RFC3339))
+            select {
+            case <-time.After(time.Until(at)):
+            case <-engine.stop:
+                return
+            }
+            line := heartbeatLine(engine, hb, time.Now().In(at.Location()))
+            if err := engine.PrintText(line, PrintOptions{Source: HEARTBEAT_SOURCE}); err != nil {
+                log.Printf("Heartbeat print failed: %v", err)
+            }
+        }
+    }()
+    return nil
+}
+
+// heartbeatLine is e.g. "2026-10-15 07:00 bat 62 paper ~4.1m left jobs 12 done 1 failed",
+// with job counts since the daemon started.
+func heartbeatLine(engine *Engine, hb *HeartbeatConfig, now time.Time) string {
+    info := engine.Info()
+    parts := []string{now.Format("2006-01-02 15:04")}
+
+    if info.Status != nil && time.Since(info.Status.Received) < time.Hour {
+        parts = append(parts, fmt.Sprintf("bat %d", info.Status.Battery))
+    } else {
+        parts = append(parts, "bat ?")
+    }
+
+    used := float64(info.PaperUsedMM) / 1000
+    if hb.RollLengthM > 0 {
+        left := hb.RollLengthM - used
+        if left < 0 {
+            left = 0
+        }
+        parts = append(parts, fmt.Sprintf("paper ~%.1fm left", left))
+    } else {
+        parts = append(parts, fmt.Sprintf("paper %.1fm used", used))
+    }
+
+    current, transitions := engine.JobCounts()
+    parts = append(parts, fmt.Sprintf("jobs %d done %d failed", transitions[JobDone], transitions[JobFailed]))
+    if waiting := current[JobQueued]; waiting > 0 {
+        parts = append(parts, fmt.Sprintf("%d queued", waiting))
+    }
+    return strings.Join(parts, " ")
+}
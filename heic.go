@@ -0,0 +1,59 @@
+//go:build heic
+
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/png"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+)
+
+// HEIC/HEIF images (iPhone photos) are decoded with libheif's heif-convert,
+// which has to be installed separately (apt install libheif-examples).
+
+const HEIF_CONVERT = "heif-convert"
+
+func init() {
+    image.RegisterFormat("heic", "????ftypheic", decodeHEIC, nil)
+    image.RegisterFormat("heic", "????ftypheix", decodeHEIC, nil)
+    image.RegisterFormat("heic", "????ftypmif1", decodeHEIC, nil)
+    registerCapability(Capability{Name: "heic", Description: "HEIC/HEIF images via heif-convert", BuildTag: "heic"})
+}
+
+func decodeHEIC(r io.Reader) (image.Image, error) {
+    dir, err := os.MkdirTemp("", "catprinter-heic-")
+    if err != nil {
+        return nil, fmt.Errorf("failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(dir)
+
+    in := filepath.Join(dir, "in.heic")
+    out := filepath.Join(dir, "out.png")
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.WriteFile(in, data, 0600); err != nil {
+        return nil, fmt.Errorf("failed to write temp file: %v", err)
+    }
+
+    var output bytes.Buffer
+    cmd := exec.Command(HEIF_CONVERT, in, out)
+    cmd.Stdout = &output
+    cmd.Stderr = &output
+    if err := cmd.Run(); err != nil {
+        return nil, fmt.Errorf("%s failed: %v: %s", HEIF_CONVERT, err, bytes.TrimSpace(output.Bytes()))
+    }
+
+    f, err := os.Open(out)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return png.Decode(f)
+}
@@ -0,0 +1,167 @@
+//go:build daemon
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// Script hooks let users change or turn down incoming jobs without
+// recompiling. Every script listed under "scripts" in the config may
+// define any of:
+//
+//   on_print(params)  every /print request; params are its query parameters
+//                     (strings, "image" a list), before anything is checked
+//   on_message(job)   every consumer message, in the JSON job form (image,
+//                     text, source, options...), after the template
+//
+// Each returns the job to use instead, or nothing to leave it as it is, and
+// can call reject("reason") to turn it down. Scripts run in the order
+// listed, each seeing what the one before returned. The engines themselves
+// live behind build tags and register by file extension (hooks_starlark.go).
+
+// scriptHook is one loaded script.
+type scriptHook interface {
+    // Call runs the script's function fn on job. It returns nil for "no
+    // change", and ok false when the script doesn't define fn.
+    Call(fn string, job map[string]any) (result map[string]any, ok bool, err error)
+}
+
+// ScriptRejection is a job turned down by a script's reject(), as opposed to
+// the script failing.
+type ScriptRejection struct {
+    Reason string
+}
+
+func (r *ScriptRejection) Error() string {
+    return "rejected by script: " + r.Reason
+}
+
+var scriptEngines = map[string]func(path string) (scriptHook, error){}
+
+func registerScriptEngine(ext string, load func(path string) (scriptHook, error)) {
+    scriptEngines[ext] = load
+}
+
+type ScriptHooks struct {
+    scripts []scriptHook
+}
+
+func loadScriptHooks(paths []string) (*ScriptHooks, error) {
+    hooks := &ScriptHooks{}
+    for _, path := range paths {
+        load, ok := scriptEngines[filepath.Ext(path)]
+        if !ok {
+            return nil, fmt.Errorf("script %s: no engine for %q files (built with: %s)", path, filepath.Ext(path), scriptEngineList())
+        }
+        script, err := load(path)
+        if err != nil {
+            return nil, fmt.Errorf("script %s: %v", path, err)
+        }
+        hooks.scripts = append(hooks.scripts, script)
+    }
+    return hooks, nil
+}
+
+func scriptEngineList() string {
+    if len(scriptEngines) == 0 {
+        return "none, use -tags starlark"
+    }
+    exts := make([]string, 0, len(scriptEngines))
+    for ext := range scriptEngines {
+        exts = append(exts, ext)
+    }
+    sort.Strings(exts)
+    return strings.Join(exts, ", ")
+}
+
+func (h *ScriptHooks) Enabled() bool {
+    return h != nil && len(h.scripts) > 0
+}
+
+// run passes job through fn of every script that has it.
+func (h *ScriptHooks) run(fn string, job map[string]any) (map[string]any, error) {
+    for _, script := range h.scripts {
+        result, ok, err := script.Call(fn, job)
+        if err != nil {
+            return nil, err
+        }
+        if ok && result != nil {
+            job = result
+        }
+    }
+    return job, nil
+}
+
+// OnPrint runs the on_print hooks on a /print request's parameters.
+func (h *ScriptHooks) OnPrint(params url.Values) (url.Values, error) {
+    if !h.Enabled() {
+        return params, nil
+    }
+    job := make(map[string]any, len(params))
+    for key, values := range params {
+        if key == "image" || len(values) > 1 {
+            list := make([]any, len(values))
+            for i, v := range values {
+                list[i] = v
+            }
+            job[key] = list
+        } else {
+            job[key] = values[0]
+        }
+    }
+    job, err := h.run("on_print", job)
+    if err != nil {
+        return nil, err
+    }
+    out := url.Values{}
+    for key, value := range job {
+        switch value := value.(type) {
+        case nil:
+        case []any:
+            for _, v := range value {
+                out.Add(key, paramString(v))
+            }
+        default:
+            out.Set(key, paramString(value))
+        }
+    }
+    return out, nil
+}
+
+// paramString turns a script's value back into a query parameter; flags
+// are "1" when on, like everywhere else.
+func paramString(v any) string {
+    switch v := v.(type) {
+    case string:
+        return v
+    case bool:
+        if v {
+            return "1"
+        }
+        return "0"
+    }
+    return fmt.Sprint(v)
+}
+
+// OnMessage runs the on_message hooks on a consumer message in the JSON job
+// form, and returns it re-encoded.
+func (h *ScriptHooks) OnMessage(data []byte) ([]byte, error) {
+    if !h.Enabled() {
+        return data, nil
+    }
+    var job map[string]any
+    if err := json.Unmarshal(data, &job); err != nil {
+        return nil, fmt.Errorf("invalid job: %v", err)
+    }
+    job, err := h.run("on_message", job)
+    if err != nil {
+        return nil, err
+    }
+    return json.Marshal(job)
+}
@@ -0,0 +1,192 @@
+//go:build daemon && starlark
+
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "go.starlark.net/starlark"
+    "go.starlark.net/syntax"
+)
+
+// Starlark, a small Python dialect, for script hooks (hooks.go):
+//
+//   def on_print(params):
+//       if params.get("source") == "kiosk":
+//           params["preset"] = "photo"
+//           params["tear_line"] = True
+//       return params
+//
+//   def on_message(job):
+//       if "password" in job.get("text", ""):
+//           reject("looks like a secret")
+//       job["text"] = job["text"].upper()
+//       return job
+//
+// Scripts can't read files or reach the network, and each call is cut off
+// after STARLARK_TIMEOUT.
+
+func init() {
+    registerScriptEngine(".star", loadStarlarkScript)
+    registerCapability(Capability{Name: "starlark", Description: "Starlark scripts that change or reject incoming jobs", BuildTag: "starlark"})
+}
+
+const (
+    STARLARK_TIMEOUT   = 2 * time.Second
+    STARLARK_MAX_STEPS = 10_000_000
+)
+
+type starlarkScript struct {
+    path    string
+    globals starlark.StringDict
+}
+
+var starlarkBuiltins = starlark.StringDict{
+    "reject": starlark.NewBuiltin("reject", func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+        var reason string
+        if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &reason); err != nil {
+            return nil, err
+        }
+        return nil, &ScriptRejection{Reason: reason}
+    }),
+}
+
+func loadStarlarkScript(path string) (scriptHook, error) {
+    thread := newStarlarkThread(path)
+    globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, path, nil, starlarkBuiltins)
+    if err != nil {
+        return nil, err
+    }
+    // Frozen, so calls from several requests at once can't interfere
+    globals.Freeze()
+    return &starlarkScript{path: path, globals: globals}, nil
+}
+
+func newStarlarkThread(path string) *starlark.Thread {
+    thread := &starlark.Thread{
+        Name: path,
+        Print: func(_ *starlark.Thread, msg string) {
+            log.Printf("%s: %s", path, msg)
+        },
+    }
+    thread.SetMaxExecutionSteps(STARLARK_MAX_STEPS)
+    return thread
+}
+
+func (s *starlarkScript) Call(fn string, job map[string]any) (map[string]any, bool, error) {
+    callable, ok := s.globals[fn].(starlark.Callable)
+    if !ok {
+        return nil, false, nil
+    }
+    arg, err := toStarlark(job)
+    if err != nil {
+        return nil, true, err
+    }
+    thread := newStarlarkThread(s.path)
+    timer := time.AfterFunc(STARLARK_TIMEOUT, func() { thread.Cancel("timed out") })
+    defer timer.Stop()
+
+    result, err := starlark.Call(thread, callable, starlark.Tuple{arg}, nil)
+    if err != nil {
+        var rejection *ScriptRejection
+        if errors.As(err, &rejection) {
+            return nil, true, rejection
+        }
+        return nil, true, fmt.Errorf("%s: %s: %v", s.path, fn, err)
+    }
+    if result == starlark.None {
+        return nil, true, nil
+    }
+    out, err := fromStarlark(result)
+    if m, isMap := out.(map[string]any); err == nil && isMap {
+        return m, true, nil
+    }
+    return nil, true, fmt.Errorf("%s: %s must return a dict or None", s.path, fn)
+}
+
+// toStarlark converts JSON-like Go values.
+func toStarlark(v any) (starlark.Value, error) {
+    switch v := v.(type) {
+    case nil:
+        return starlark.None, nil
+    case string:
+        return starlark.String(v), nil
+    case bool:
+        return starlark.Bool(v), nil
+    case float64:
+        if v == float64(int64(v)) {
+            return starlark.MakeInt64(int64(v)), nil
+        }
+        return starlark.Float(v), nil
+    case []any:
+        list := make([]starlark.Value, len(v))
+        for i, item := range v {
+            value, err := toStarlark(item)
+            if err != nil {
+                return nil, err
+            }
+            list[i] = value
+        }
+        return starlark.NewList(list), nil
+    case map[string]any:
+        dict := starlark.NewDict(len(v))
+        for key, item := range v {
+            value, err := toStarlark(item)
+            if err != nil {
+                return nil, err
+            }
+            dict.SetKey(starlark.String(key), value)
+        }
+        return dict, nil
+    }
+    return nil, fmt.Errorf("can't pass %T to a script", v)
+}
+
+// fromStarlark converts a script's result back to JSON-like Go values.
+func fromStarlark(v starlark.Value) (any, error) {
+    switch v := v.(type) {
+    case starlark.NoneType:
+        return nil, nil
+    case starlark.String:
+        return string(v), nil
+    case starlark.Bool:
+        return bool(v), nil
+    case starlark.Int:
+        n, ok := v.Int64()
+        if !ok {
+            return nil, fmt.Errorf("number %v is too large", v)
+        }
+        return n, nil
+    case starlark.Float:
+        return float64(v), nil
+    case starlark.Indexable:
+        // Lists and tuples
+        list := make([]any, v.Len())
+        for i := range list {
+            item, err := fromStarlark(v.Index(i))
+            if err != nil {
+                return nil, err
+            }
+            list[i] = item
+        }
+        return list, nil
+    case *starlark.Dict:
+        m := make(map[string]any, v.Len())
+        for _, item := range v.Items() {
+            key, ok := item[0].(starlark.String)
+            if !ok {
+                return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+            }
+            value, err := fromStarlark(item[1])
+            if err != nil {
+                return nil, err
+            }
+            m[string(key)] = value
+        }
+        return m, nil
+    }
+    return nil, fmt.Errorf("can't use a %s from a script", v.Type())
+}
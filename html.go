@@ -0,0 +1,80 @@
+//go:build html
+
+package main
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "image"
+    "image/png"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+// HTML pages (receipts, tickets, labels laid out with CSS) are rendered with
+// wkhtmltoimage, which has to be installed separately (apt install
+// wkhtmltopdf). The page is laid out PRINTER_WIDTH CSS pixels wide, one
+// pixel per dot, and is as tall as its content. JavaScript and local files
+// are off, so a page from a stranger can't read the daemon's disk; remote
+// images and stylesheets are still fetched.
+
+const (
+    WKHTMLTOIMAGE       = "wkhtmltoimage"
+    HTML_RENDER_TIMEOUT = 30 * time.Second
+)
+
+func init() {
+    for _, prefix := range HTML_PREFIXES {
+        image.RegisterFormat("html", prefix, decodeHTML, nil)
+    }
+    registerCapability(Capability{Name: "html", Description: "HTML pages and snippets via wkhtmltoimage, laid out at the paper width", BuildTag: "html"})
+}
+
+func decodeHTML(r io.Reader) (image.Image, error) {
+    data, err := io.ReadAll(io.LimitReader(r, MAX_HTML_BYTES+1))
+    if err != nil {
+        return nil, err
+    }
+    if len(data) > MAX_HTML_BYTES {
+        return nil, fmt.Errorf("html is larger than %d bytes", MAX_HTML_BYTES)
+    }
+    dir, err := os.MkdirTemp("", "catprinter-html-")
+    if err != nil {
+        return nil, fmt.Errorf("failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(dir)
+    out := filepath.Join(dir, "page.png")
+
+    ctx, cancel := context.WithTimeout(context.Background(), HTML_RENDER_TIMEOUT)
+    defer cancel()
+    var output bytes.Buffer
+    // The page comes in on stdin, so no local file has to be readable
+    cmd := exec.CommandContext(ctx, WKHTMLTOIMAGE, "--quiet", "--format", "png",
+        "--width", strconv.Itoa(PRINTER_WIDTH), "--disable-javascript", "--disable-local-file-access",
+        "--encoding", "utf-8", "-", out)
+    cmd.Stdin = bytes.NewReader(data)
+    cmd.Stdout = &output
+    cmd.Stderr = &output
+    if err := cmd.Run(); err != nil {
+        if ctx.Err() != nil {
+            return nil, fmt.Errorf("%s took longer than %v", WKHTMLTOIMAGE, HTML_RENDER_TIMEOUT)
+        }
+        return nil, fmt.Errorf("%s failed: %v: %s", WKHTMLTOIMAGE, err, bytes.TrimSpace(output.Bytes()))
+    }
+
+    f, err := os.Open(out)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    page, err := png.Decode(f)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read rendered page: %v", err)
+    }
+    return page, nil
+}
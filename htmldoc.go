@@ -0,0 +1,63 @@
+package main
+
+import (
+    "bytes"
+    "html"
+    "regexp"
+    "strings"
+)
+
+// HTML documents print like images when the binary is built with the html
+// tag (see html.go). They are recognised by how they start; bare snippets
+// ("<h1>Order 42</h1>...") are wrapped into a document first, with a plain
+// stylesheet sized for the paper.
+
+// Receipts and labels are small; this keeps a stray page out
+const MAX_HTML_BYTES = 256 << 10
+
+// Whitespace and a byte order mark may come before the document
+const HTML_LEADING_SPACE = " \t\r\n\uFEFF"
+
+var HTML_PREFIXES = []string{"<!DOCTYPE html", "<!doctype html", "<html"}
+
+const HTML_SNIPPET_STYLE = `body { margin: 0; width: 384px; background: #fff; color: #000; font-family: sans-serif; font-size: 20px; }
+img { max-width: 100%; }
+table { width: 100%; border-collapse: collapse; }`
+
+func isHTMLDocument(data []byte) bool {
+    trimmed := bytes.TrimLeft(data, HTML_LEADING_SPACE)
+    for _, prefix := range HTML_PREFIXES {
+        if bytes.HasPrefix(trimmed, []byte(prefix)) {
+            return true
+        }
+    }
+    return false
+}
+
+// wrapHTMLSnippet returns data as a whole document, wrapping it unless it is
+// one already.
+func wrapHTMLSnippet(data []byte) []byte {
+    if isHTMLDocument(data) {
+        return bytes.TrimLeft(data, HTML_LEADING_SPACE)
+    }
+    var doc bytes.Buffer
+    doc.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><style>\n")
+    doc.WriteString(HTML_SNIPPET_STYLE)
+    doc.WriteString("\n</style></head><body>\n")
+    doc.Write(data)
+    doc.WriteString("\n</body></html>\n")
+    return doc.Bytes()
+}
+
+var (
+    htmlHidden = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>|<!--.*?-->`)
+    htmlTag    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// htmlText is the words of a page, for the content filters: tags, comments
+// and anything that doesn't show are dropped and entities decoded.
+func htmlText(data []byte) string {
+    text := htmlHidden.ReplaceAll(data, nil)
+    text = htmlTag.ReplaceAll(text, []byte(" "))
+    return strings.Join(strings.Fields(html.UnescapeString(string(text))), " ")
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestHTMLText(t *testing.T) {
+    tests := []struct {
+        html, want string
+    }{
+        {"<h1>Order 42</h1><p>2 &times; coffee</p>", "Order 42 2 × coffee"},
+        {"<!DOCTYPE html><html><head><title>t</title><style>p{}</style></head><body>hi<script>var spam</script></body></html>", "hi"},
+        {"a<!-- spam -->b", "ab"},
+        {"<p\nclass=\"x\">multi\nline</p>", "multi line"},
+    }
+    for _, tt := range tests {
+        if got := htmlText([]byte(tt.html)); got != tt.want {
+            t.Errorf("htmlText(%q) = %q, want %q", tt.html, got, tt.want)
+        }
+    }
+}
+
+func TestIsHTMLDocument(t *testing.T) {
+    tests := []struct {
+        doc  string
+        want bool
+    }{
+        {"<!DOCTYPE html><p>", true},
+        {"\uFEFF \n<html>", true},
+        {"<p>snippet</p>", false},
+        {"GIF89a", false},
+    }
+    for _, tt := range tests {
+        if got := isHTMLDocument([]byte(tt.doc)); got != tt.want {
+            t.Errorf("isHTMLDocument(%q) = %v, want %v", tt.doc, got, tt.want)
+        }
+    }
+}
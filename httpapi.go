@@ -0,0 +1,851 @@
+//go:build daemon
+
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Largest /append body we read
+const MAX_APPEND_BYTES = 64 << 10
+
+// Largest /print/text body for a source with content filters, which is read
+// whole instead of streamed
+const MAX_FILTERED_TEXT_BYTES = 1 << 20
+
+// HTTPAPI is the daemon's HTTP interface on top of an Engine. Handler returns
+// a fresh mux, so it can be mounted under a prefix in another server.
+// Authorize replaces the admin token check when set, for servers that bring
+// their own auth.
+type HTTPAPI struct {
+    engine     *Engine
+    moderation *ModerationQueue
+    guard      *AbuseGuard
+    filters    *ContentFilters
+    hooks      *ScriptHooks
+    quota      *QuotaTracker
+    oidc       *OIDCProvider
+    tokens     *TokenStore
+
+    Authorize func(r *http.Request) bool
+}
+
+func NewHTTPAPI(engine *Engine) (*HTTPAPI, error) {
+    config := engine.Config()
+    filters, err := newContentFilters(config.Filters)
+    if err != nil {
+        return nil, fmt.Errorf("invalid content filters: %v", err)
+    }
+    hooks, err := loadScriptHooks(config.Scripts)
+    if err != nil {
+        return nil, err
+    }
+    tokens, err := openTokenStore(config.TokenStore)
+    if err != nil {
+        return nil, err
+    }
+    return &HTTPAPI{
+        engine:     engine,
+        moderation: &ModerationQueue{},
+        guard:      newAbuseGuard(config.AbuseProtection),
+        filters:    filters,
+        hooks:      hooks,
+        quota:      newQuotaTracker(config.location),
+        oidc:       newOIDCProvider(config.OIDC),
+        tokens:     tokens,
+    }, nil
+}
+
+func (api *HTTPAPI) Handler() http.Handler {
+    mux := http.NewServeMux()
+    config := api.engine.Config()
+
+    mux.HandleFunc("/print", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+
+        if api.hooks.Enabled() {
+            params, err := api.hooks.OnPrint(r.URL.Query())
+            if err != nil {
+                log.Printf("Print rejected: %v", err)
+                if _, ok := err.(*ScriptRejection); ok {
+                    http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+                } else {
+                    http.Error(w, err.Error(), http.StatusInternalServerError)
+                }
+                return
+            }
+            r.URL.RawQuery = params.Encode()
+        }
+
+        // Expect image path (or s3:// / WebDAV URL) in the query string;
+        // repeated, the images are stacked into one print
+        imagePaths := r.URL.Query()["image"]
+        if len(imagePaths) == 0 || slices.Contains(imagePaths, "") {
+            http.Error(w, "Missing image parameter", http.StatusBadRequest)
+            return
+        }
+        if len(imagePaths) > MAX_CONCAT_IMAGES {
+            http.Error(w, fmt.Sprintf("Too many images, the limit is %d", MAX_CONCAT_IMAGES), http.StatusBadRequest)
+            return
+        }
+        // A dry run sends back whatever the daemon can read, with its own
+        // credentials, so once there is any login it is gated like /jobs
+        dryRun := r.URL.Query().Get("dry_run") == "1"
+        if dryRun && api.readGated() && !api.requireRead(w, r) {
+            return
+        }
+        var user *UserConfig
+        if !dryRun {
+            var ok bool
+            if user, ok = api.requireUser(w, r); !ok {
+                return
+            }
+            // Logged-in users are known, so only anonymous requests are challenged
+            if api.guard.Enabled() && user == nil && !api.isAdmin(r) {
+                if err := api.guard.Verify(r); err != nil {
+                    http.Error(w, err.Error(), http.StatusForbidden)
+                    return
+                }
+            }
+        }
+
+        opts := PrintOptions{
+            Source:    r.URL.Query().Get("source"),
+            Separator: r.URL.Query().Get("separator"),
+            ReplyTo:   r.URL.Query().Get("reply_to"),
+            Slot:      r.URL.Query().Get("slot"),
+        }
+        if len(opts.ReplyTo) > MAX_REPLY_TO {
+            http.Error(w, "reply_to is too long", http.StatusBadRequest)
+            return
+        }
+        if err := validSlot(opts.Slot); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if value := r.URL.Query().Get("ttl"); value != "" {
+            ttl, err := time.ParseDuration(value)
+            if err != nil || ttl <= 0 {
+                http.Error(w, "Invalid ttl", http.StatusBadRequest)
+                return
+            }
+            opts.TTL = ttl
+        }
+        if config.Receipts || r.URL.Query().Get("receipt") == "1" {
+            opts.Receipt = newReceiptCode()
+        }
+        if !validSeparator(opts.Separator) {
+            http.Error(w, "Unknown separator", http.StatusBadRequest)
+            return
+        }
+        feed, err := parseJobFeed(r.URL.Query().Get("feed"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        opts.FeedAfter = feed
+        if opts.FeedBefore, err = parseJobFeed(r.URL.Query().Get("feed_before")); err != nil {
+            http.Error(w, "feed_before: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        opts.TearLine = r.URL.Query().Get("tear_line") == "1"
+        opts.JobQR = r.URL.Query().Get("job_qr") == "1"
+        opts.Header = r.URL.Query().Get("header")
+        opts.Footer = r.URL.Query().Get("footer")
+        opts.Label = r.URL.Query().Get("label")
+        for name, stamp := range map[string]string{"header": opts.Header, "footer": opts.Footer} {
+            if err := validStamp(stamp); err != nil {
+                http.Error(w, name+": "+err.Error(), http.StatusBadRequest)
+                return
+            }
+        }
+        opts.Render.Dither = DitherMode(r.URL.Query().Get("dither"))
+        opts.Render.Frames = r.URL.Query().Get("frames") == "1"
+        opts.Render.Invert = r.URL.Query().Get("invert") == "1"
+        opts.Render.FlipH = r.URL.Query().Get("flip_h") == "1"
+        opts.Render.FlipV = r.URL.Query().Get("flip_v") == "1"
+        opts.Render.Align = r.URL.Query().Get("align")
+        opts.Render.Density = r.URL.Query().Get("density")
+        opts.Render.Preset = r.URL.Query().Get("preset")
+        if !validPreset(opts.Render.Preset) {
+            http.Error(w, "Unknown preset, want "+presetList(), http.StatusBadRequest)
+            return
+        }
+        if !validDensity(opts.Render.Density) {
+            http.Error(w, "Unknown density, want full or half", http.StatusBadRequest)
+            return
+        }
+        if !validAlign(opts.Render.Align) {
+            http.Error(w, "Unknown align, want left, center or right", http.StatusBadRequest)
+            return
+        }
+        if opts.Render.Crop, err = parseCrop(r.URL.Query().Get("crop")); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if opts.Render.Pipeline, err = parsePipeline(r.URL.Query().Get("pipeline")); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if value := r.URL.Query().Get("margin"); value != "" {
+            margin, err := strconv.Atoi(value)
+            if err != nil || !validMargin(margin) {
+                http.Error(w, fmt.Sprintf("Invalid margin, want 0-%d", MAX_MARGIN), http.StatusBadRequest)
+                return
+            }
+            opts.Render.Margin = margin
+        }
+        if value := r.URL.Query().Get("scale"); value != "" {
+            scale, err := strconv.Atoi(value)
+            if err != nil || !validScale(scale) {
+                http.Error(w, fmt.Sprintf("Invalid scale, want 1-%d", MAX_SCALE), http.StatusBadRequest)
+                return
+            }
+            opts.Render.Scale = scale
+        }
+        if value := r.URL.Query().Get("threshold"); value != "" {
+            threshold, err := strconv.Atoi(value)
+            if err != nil || threshold < 1 || !validThreshold(threshold) {
+                http.Error(w, "Invalid threshold, want 1-255", http.StatusBadRequest)
+                return
+            }
+            opts.Render.Threshold = threshold
+        }
+        adjust, err := parseAdjustments(r.URL.Query())
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        opts.Render.Adjust = adjust
+        if value := r.URL.Query().Get("poster"); value != "" {
+            if opts.Render.Poster, err = strconv.Atoi(value); err != nil {
+                http.Error(w, "Invalid poster, want a number of strips", http.StatusBadRequest)
+                return
+            }
+        }
+        if value := r.URL.Query().Get("poster_overlap"); value != "" {
+            if opts.Render.PosterOverlap, err = strconv.Atoi(value); err != nil {
+                http.Error(w, "Invalid poster_overlap, want dots", http.StatusBadRequest)
+                return
+            }
+        }
+        opts.Render.PosterLabels = r.URL.Query().Get("poster_labels") == "1"
+        if err := validPoster(opts.Render.Poster, opts.Render.PosterOverlap); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if !validDither(opts.Render.Dither) {
+            http.Error(w, "Unknown dither mode", http.StatusBadRequest)
+            return
+        }
+
+        if dryRun {
+            // Nothing prints, so no quota, moderation or job
+            preview, err := api.engine.DryRun(imagePaths, api.engine.WithSourceDefaults(opts))
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            w.Header().Set("Content-Type", "image/png")
+            w.Write(preview)
+            return
+        }
+
+        // Moderated submissions get a job once approved; the tracker ignores
+        // the empty ID until then
+        moderated := config.Moderation && !api.isAdmin(r)
+
+        var printAt time.Time
+        if value := r.URL.Query().Get("print_at"); value != "" {
+            if moderated {
+                http.Error(w, "print_at is not available while moderation is on", http.StatusBadRequest)
+                return
+            }
+            at, err := parsePrintAt(value, r.URL.Query().Get("tz"), config)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            printAt = at
+        }
+        if !api.takeQuota(w, user, 1) {
+            return
+        }
+
+        opts = api.engine.WithSourceDefaults(opts)
+
+        jobID := ""
+        if !moderated {
+            jobID = api.engine.NewJob(opts.Source)
+            w.Header().Set("X-Job-ID", jobID)
+        }
+        if opts.Receipt != "" {
+            w.Header().Set("X-Receipt-Code", opts.Receipt)
+        }
+        prepared, err := api.engine.PrepareImages(jobID, imagePaths, opts.Render)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        // text is only what the client says it drew the image from
+        if err := api.filters.Check(opts.Source, r.URL.Query().Get("text"), prepared); err != nil {
+            api.engine.Reject(jobID, err)
+            writeFilterError(w, err)
+            return
+        }
+
+        // In gallery mode anonymous submissions wait for an admin
+        if moderated {
+            job, err := api.moderation.Submit(prepared, opts)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusServiceUnavailable)
+                return
+            }
+            log.Printf("Queued %s for moderation", job.ID)
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(http.StatusAccepted)
+            json.NewEncoder(w).Encode(map[string]string{"id": job.ID, "status": "pending", "receipt": opts.Receipt})
+            return
+        }
+
+        if !printAt.IsZero() {
+            if err := api.engine.Schedule(jobID, printAt, prepared, opts); err != nil {
+                api.engine.Reject(jobID, err)
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(http.StatusAccepted)
+            json.NewEncoder(w).Encode(map[string]string{"id": jobID, "status": "scheduled", "print_at": printAt.Format(time.RFC3339), "receipt": opts.Receipt})
+            return
+        }
+
+        if err := api.engine.Print(jobID, prepared, opts); err != nil {
+            if err == errJobCanceled || err == errJobExpired {
+                http.Error(w, err.Error(), http.StatusConflict)
+                return
+            }
+            log.Printf("Print failed: %v", err)
+            http.Error(w, fmt.Sprintf("Print failed: %v", err), http.StatusInternalServerError)
+            return
+        }
+
+        w.WriteHeader(http.StatusOK)
+        w.Write([]byte("Printed successfully"))
+    })
+
+    // Several images printed back-to-back, all-or-nothing
+    mux.HandleFunc("/print/batch", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if config.Moderation && !api.requireAdmin(w, r) {
+            return
+        }
+        user, ok := api.requireUser(w, r)
+        if !ok {
+            return
+        }
+        // Logged-in users are known, so only anonymous requests are challenged
+        if api.guard.Enabled() && user == nil && !api.isAdmin(r) {
+            if err := api.guard.Verify(r); err != nil {
+                http.Error(w, err.Error(), http.StatusForbidden)
+                return
+            }
+        }
+
+        var req struct {
+            Jobs    []BatchJob `json:"jobs"`
+            Source  string     `json:"source"`
+            ReplyTo string     `json:"reply_to"`
+            Receipt bool       `json:"receipt"`
+            JobOptions
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+            return
+        }
+
+        opts, err := req.printOptions()
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        opts.Source = req.Source
+        opts.ReplyTo = req.ReplyTo
+        if len(opts.ReplyTo) > MAX_REPLY_TO {
+            http.Error(w, "reply_to is too long", http.StatusBadRequest)
+            return
+        }
+        if config.Receipts || req.Receipt {
+            opts.Receipt = newReceiptCode()
+            w.Header().Set("X-Receipt-Code", opts.Receipt)
+        }
+        if !api.takeQuota(w, user, len(req.Jobs)) {
+            return
+        }
+        opts = api.engine.WithSourceDefaults(opts)
+        result := api.engine.PrintBatch(req.Jobs, opts, func(job BatchJob, p *preparedImage) error {
+            return api.filters.Check(req.Source, job.Text, p)
+        })
+        code := http.StatusOK
+        switch result.Status {
+        case BATCH_INVALID:
+            code = http.StatusBadRequest
+        case BATCH_FAILED:
+            log.Printf("Batch failed: %s", result.Error)
+            code = http.StatusInternalServerError
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(code)
+        json.NewEncoder(w).Encode(result)
+    })
+
+    // An HTML snippet or page in the body, laid out at the paper width (see
+    // htmldoc.go; needs the html build tag)
+    mux.HandleFunc("/print/html", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if !hasCapability("html") {
+            http.Error(w, "HTML rendering is not built in (build with -tags html)", http.StatusNotImplemented)
+            return
+        }
+        user, ok := api.requireUser(w, r)
+        if !ok {
+            return
+        }
+        if api.guard.Enabled() && user == nil && !api.isAdmin(r) {
+            if err := api.guard.Verify(r); err != nil {
+                http.Error(w, err.Error(), http.StatusForbidden)
+                return
+            }
+        }
+        if config.Moderation && !api.isAdmin(r) {
+            http.Error(w, "HTML snippets can't be moderated, use /print with an HTML file", http.StatusBadRequest)
+            return
+        }
+        body, err := io.ReadAll(io.LimitReader(r.Body, MAX_HTML_BYTES+1))
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to read body: %v", err), http.StatusBadRequest)
+            return
+        }
+        if len(body) > MAX_HTML_BYTES {
+            http.Error(w, fmt.Sprintf("HTML is larger than %d bytes", MAX_HTML_BYTES), http.StatusRequestEntityTooLarge)
+            return
+        }
+        query := r.URL.Query()
+        jobOpts := JobOptions{
+            Separator:  query.Get("separator"),
+            Feed:       query.Get("feed"),
+            FeedBefore: query.Get("feed_before"),
+            TearLine:   query.Get("tear_line") == "1",
+            Dither:     DitherMode(query.Get("dither")),
+        }
+        opts, err := jobOpts.printOptions()
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        opts.Source = query.Get("source")
+        if !api.takeQuota(w, user, 1) {
+            return
+        }
+        opts = api.engine.WithSourceDefaults(opts)
+
+        tmp, err := os.CreateTemp("", "catprinter-*.html")
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to create temp file: %v", err), http.StatusInternalServerError)
+            return
+        }
+        defer os.Remove(tmp.Name())
+        _, err = tmp.Write(wrapHTMLSnippet(body))
+        tmp.Close()
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to write temp file: %v", err), http.StatusInternalServerError)
+            return
+        }
+        jobID := api.engine.NewJob(opts.Source)
+        w.Header().Set("X-Job-ID", jobID)
+        prepared, err := api.engine.PrepareImages(jobID, []string{tmp.Name()}, opts.Render)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if err := api.filters.Check(opts.Source, htmlText(body), prepared); err != nil {
+            api.engine.Reject(jobID, err)
+            writeFilterError(w, err)
+            return
+        }
+        if err := api.engine.Print(jobID, prepared, opts); err != nil {
+            if err == errJobCanceled || err == errJobExpired {
+                http.Error(w, err.Error(), http.StatusConflict)
+                return
+            }
+            log.Printf("Print failed: %v", err)
+            http.Error(w, fmt.Sprintf("Print failed: %v", err), http.StatusInternalServerError)
+            return
+        }
+        w.Write([]byte("Printed successfully"))
+    })
+
+    // Long text printed while the body is still uploading (see stream.go)
+    mux.HandleFunc("/print/text", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        user, ok := api.requireUser(w, r)
+        if !ok {
+            return
+        }
+        if api.guard.Enabled() && user == nil && !api.isAdmin(r) {
+            if err := api.guard.Verify(r); err != nil {
+                http.Error(w, err.Error(), http.StatusForbidden)
+                return
+            }
+        }
+        opts := PrintOptions{
+            Source:    r.URL.Query().Get("source"),
+            Separator: r.URL.Query().Get("separator"),
+            TearLine:  r.URL.Query().Get("tear_line") == "1",
+        }
+        if config.Moderation && !api.isAdmin(r) {
+            http.Error(w, "Streamed text can't be moderated, use /print", http.StatusBadRequest)
+            return
+        }
+        if !validSeparator(opts.Separator) {
+            http.Error(w, "Unknown separator", http.StatusBadRequest)
+            return
+        }
+        var err error
+        if opts.FeedAfter, err = parseJobFeed(r.URL.Query().Get("feed")); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if opts.FeedBefore, err = parseJobFeed(r.URL.Query().Get("feed_before")); err != nil {
+            http.Error(w, "feed_before: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        if config.Receipts || r.URL.Query().Get("receipt") == "1" {
+            opts.Receipt = newReceiptCode()
+            w.Header().Set("X-Receipt-Code", opts.Receipt)
+        }
+        if !api.takeQuota(w, user, 1) {
+            return
+        }
+        opts = api.engine.WithSourceDefaults(opts)
+
+        jobID := api.engine.NewJob(opts.Source)
+        w.Header().Set("X-Job-ID", jobID)
+        if api.filters.Has(opts.Source) {
+            // Filters need the whole text before anything prints, so there
+            // is no streaming for this source
+            api.printFilteredText(w, r, jobID, opts)
+            return
+        }
+        if err := api.engine.PrintTextStream(jobID, r.Body, opts); err != nil {
+            if err == errJobCanceled || err == errJobExpired {
+                http.Error(w, err.Error(), http.StatusConflict)
+                return
+            }
+            log.Printf("Print failed: %v", err)
+            http.Error(w, fmt.Sprintf("Print failed: %v", err), http.StatusInternalServerError)
+            return
+        }
+        w.Write([]byte("Printed successfully"))
+    })
+
+    // Console mode: every line of the body prints as soon as possible
+    mux.HandleFunc("/append", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        user, ok := api.requireUser(w, r)
+        if !ok {
+            return
+        }
+        // Logged-in users are known, so only anonymous requests are challenged
+        if api.guard.Enabled() && user == nil && !api.isAdmin(r) {
+            if err := api.guard.Verify(r); err != nil {
+                http.Error(w, err.Error(), http.StatusForbidden)
+                return
+            }
+        }
+        if config.Moderation && !api.isAdmin(r) {
+            http.Error(w, "Console lines can't be moderated, use /print", http.StatusBadRequest)
+            return
+        }
+
+        body, err := io.ReadAll(io.LimitReader(r.Body, MAX_APPEND_BYTES))
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Failed to read body: %v", err), http.StatusBadRequest)
+            return
+        }
+        lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+        // Console lines go to the "console" source's filters, line by line
+        for _, line := range lines {
+            if err := api.filters.CheckText(CONSOLE_SOURCE, line); err != nil {
+                writeFilterError(w, err)
+                return
+            }
+        }
+        // Each append counts as one print against a submitter's quota
+        if !api.takeQuota(w, user, 1) {
+            return
+        }
+        if err := api.engine.Append(lines...); err != nil {
+            http.Error(w, fmt.Sprintf("Append failed: %v", err), http.StatusServiceUnavailable)
+            return
+        }
+        w.WriteHeader(http.StatusAccepted)
+        w.Write([]byte("Queued"))
+    })
+
+    api.registerModerationHandlers(mux)
+    api.registerJobHandlers(mux)
+    api.registerUserHandlers(mux)
+    api.registerTokenHandlers(mux)
+    api.registerProtocolConsoleHandlers(mux)
+    api.registerCardHandlers(mux)
+
+    // Build info and what this binary can do, for bug reports and clients
+    // checking before they send
+    mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(versionInfo(config))
+    })
+
+    // The wire format as JSON, for other implementations and the debug
+    // console (see protocoldoc.go)
+    mux.HandleFunc("/protocol", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(api.engine.Protocol())
+    })
+
+    // Connection state, last printer status and settings read back on
+    // connect (see settings.go)
+    mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Cache-Control", "no-store")
+        json.NewEncoder(w).Encode(api.engine.Info())
+    })
+
+    // Proof-of-work challenge / Turnstile site key for public clients
+    mux.HandleFunc("/challenge", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Cache-Control", "no-store")
+        json.NewEncoder(w).Encode(api.guard.Challenge())
+    })
+
+    // Raw command passthrough for protocol research (requires admin_token)
+    mux.HandleFunc("/admin/raw", func(w http.ResponseWriter, r *http.Request) {
+        if !api.requireAdmin(w, r) {
+            return
+        }
+        if r.Method != "POST" {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        api.handleRaw(w, r)
+    })
+
+    return mux
+}
+
+// requireAdmin checks the admin token (or an admin user's token) from
+// "Authorization: Bearer <token>" or X-Admin-Token and writes an error
+// response if it doesn't match.
+func (api *HTTPAPI) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+    if !api.adminEnabled() {
+        http.Error(w, "Admin endpoints are disabled (set admin_token or add an admin user in config)", http.StatusForbidden)
+        return false
+    }
+    if !api.isAdmin(r) {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return false
+    }
+    return true
+}
+
+func (api *HTTPAPI) isAdmin(r *http.Request) bool {
+    if api.Authorize != nil {
+        return api.Authorize(r)
+    }
+    if user := api.caller(r); user != nil {
+        return user.Role == ROLE_ADMIN
+    }
+    config := api.engine.Config()
+    if config.AdminToken == "" {
+        return false
+    }
+    return subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(config.AdminToken)) == 1
+}
+
+type rawRequest struct {
+    Command        string `json:"command"`
+    Payload        string `json:"payload"`
+    Characteristic string `json:"characteristic"`
+    WaitMs         int    `json:"wait_ms"`
+}
+
+type rawNotification struct {
+    Command string `json:"command,omitempty"`
+    Name    string `json:"name,omitempty"`
+    Payload string `json:"payload,omitempty"`
+    Raw     string `json:"raw"`
+}
+
+// decodeNotification splits a notification into command and payload when it
+// is framed for the configured model.
+func decodeNotification(framing Framing, n []byte) rawNotification {
+    rn := rawNotification{Raw: hex.EncodeToString(n)}
+    if cmd, p, ok := framing.Decode(n); ok {
+        rn.Command = fmt.Sprintf("%02X", cmd)
+        rn.Name = COMMANDS[cmd].Name
+        rn.Payload = hex.EncodeToString(p)
+    }
+    return rn
+}
+
+// printFilteredText prints a /print/text body once the source's filters have
+// passed it.
+func (api *HTTPAPI) printFilteredText(w http.ResponseWriter, r *http.Request, jobID string, opts PrintOptions) {
+    body, err := io.ReadAll(io.LimitReader(r.Body, MAX_FILTERED_TEXT_BYTES+1))
+    if err != nil {
+        api.engine.Reject(jobID, err)
+        http.Error(w, fmt.Sprintf("Failed to read body: %v", err), http.StatusBadRequest)
+        return
+    }
+    if len(body) > MAX_FILTERED_TEXT_BYTES {
+        err := fmt.Errorf("text is larger than %d bytes", MAX_FILTERED_TEXT_BYTES)
+        api.engine.Reject(jobID, err)
+        http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+        return
+    }
+    prepared, err := api.engine.PrepareText(jobID, string(body), opts.Source)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if err := api.filters.Check(opts.Source, string(body), prepared); err != nil {
+        api.engine.Reject(jobID, err)
+        writeFilterError(w, err)
+        return
+    }
+    if err := api.engine.Print(jobID, prepared, opts); err != nil {
+        if err == errJobCanceled || err == errJobExpired {
+            http.Error(w, err.Error(), http.StatusConflict)
+            return
+        }
+        log.Printf("Print failed: %v", err)
+        http.Error(w, fmt.Sprintf("Print failed: %v", err), http.StatusInternalServerError)
+        return
+    }
+    w.Write([]byte("Printed successfully"))
+}
+
+func (api *HTTPAPI) handleRaw(w http.ResponseWriter, r *http.Request) {
+    var req rawRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+        return
+    }
+
+    toData := req.Characteristic == "data"
+    if !toData && req.Characteristic != "" && req.Characteristic != "control" {
+        http.Error(w, "characteristic must be \"control\" or \"data\"", http.StatusBadRequest)
+        return
+    }
+    payload, err := parseHexBytes(req.Payload)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    var cmdID byte
+    if !toData {
+        cmd, err := parseHexBytes(req.Command)
+        if err != nil || len(cmd) != 1 {
+            http.Error(w, "command must be a single hex byte", http.StatusBadRequest)
+            return
+        }
+        cmdID = cmd[0]
+    }
+    wait := time.Second
+    if req.WaitMs > 0 {
+        wait = time.Duration(req.WaitMs) * time.Millisecond
+    }
+
+    target := "control"
+    if toData {
+        target = "data"
+    }
+    log.Printf("Raw %s write: cmd=%02X payload=%s", target, cmdID, hex.EncodeToString(payload))
+    result, err := api.engine.SendRaw(toData, cmdID, payload, wait)
+    if err != nil {
+        http.Error(w, fmt.Sprintf("Raw command failed: %v", err), http.StatusInternalServerError)
+        return
+    }
+
+    resp := struct {
+        Sent          string            `json:"sent"`
+        Notifications []rawNotification `json:"notifications"`
+    }{Sent: hex.EncodeToString(result.Sent), Notifications: []rawNotification{}}
+    framing := api.engine.Config().profile.Framing
+    for _, n := range result.Notifications {
+        resp.Notifications = append(resp.Notifications, decodeNotification(framing, n))
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(resp)
+}
+
+// parseAdjustments reads the equalize, brightness, contrast, gamma and
+// sharpen query parameters.
+func parseAdjustments(query url.Values) (Adjustments, error) {
+    a := Adjustments{Equalize: query.Get("equalize") == "1"}
+    var err error
+    if value := query.Get("brightness"); value != "" {
+        if a.Brightness, err = strconv.Atoi(value); err != nil {
+            return a, fmt.Errorf("invalid brightness %q", value)
+        }
+    }
+    if value := query.Get("contrast"); value != "" {
+        if a.Contrast, err = strconv.Atoi(value); err != nil {
+            return a, fmt.Errorf("invalid contrast %q", value)
+        }
+    }
+    if value := query.Get("gamma"); value != "" {
+        if a.Gamma, err = strconv.ParseFloat(value, 64); err != nil {
+            return a, fmt.Errorf("invalid gamma %q", value)
+        }
+    }
+    if value := query.Get("sharpen"); value != "" {
+        if a.Sharpen, err = strconv.ParseFloat(value, 64); err != nil {
+            return a, fmt.Errorf("invalid sharpen %q", value)
+        }
+    }
+    if value := query.Get("sharpen_radius"); value != "" {
+        if a.SharpenRadius, err = strconv.ParseFloat(value, 64); err != nil {
+            return a, fmt.Errorf("invalid sharpen_radius %q", value)
+        }
+    }
+    return a, a.validate()
+}
@@ -0,0 +1,160 @@
+//go:build daemon
+
+package main
+
+import (
+    "image"
+    "image/png"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// newTestAPI builds the HTTP API on a config file with the given JSON. The
+// printer is never connected.
+func newTestAPI(t *testing.T, configJSON string) http.Handler {
+    t.Helper()
+    dir := t.TempDir()
+    path := filepath.Join(dir, "catprinter.json")
+    if err := os.WriteFile(path, []byte(configJSON), 0600); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("CATPRINTER_CONFIG", path)
+    cfg, err := loadConfig()
+    if err != nil {
+        t.Fatal(err)
+    }
+    cfg.TokenStore = filepath.Join(dir, "tokens.json")
+    api, err := NewHTTPAPI(NewEngine("", cfg))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return api.Handler()
+}
+
+func writeTestImage(t *testing.T) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "secret.png")
+    f, err := os.Create(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestDryRunIsGatedLikeJobs(t *testing.T) {
+    const adminToken = "admin-token-0123456789"
+    withAdmin := `{"admin_token": "` + adminToken + `"}`
+    local := writeTestImage(t)
+
+    tests := []struct {
+        name   string
+        config string
+        image  string
+        token  string
+        want   int
+    }{
+        {"anonymous, no login", `{}`, local, "", http.StatusOK},
+        {"anonymous", withAdmin, local, "", http.StatusUnauthorized},
+        {"anonymous file URL", withAdmin, "file://" + local, "", http.StatusUnauthorized},
+        {"admin", withAdmin, local, adminToken, http.StatusOK},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            handler := newTestAPI(t, tt.config)
+            req := httptest.NewRequest("POST", "/print?dry_run=1&image="+url.QueryEscape(tt.image), nil)
+            if tt.token != "" {
+                req.Header.Set("Authorization", "Bearer "+tt.token)
+            }
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, req)
+            if rec.Code != tt.want {
+                t.Fatalf("got %d (%s), want %d", rec.Code, rec.Body.String(), tt.want)
+            }
+            if tt.want == http.StatusOK && rec.Header().Get("Content-Type") != "image/png" {
+                t.Errorf("got Content-Type %q, want image/png", rec.Header().Get("Content-Type"))
+            }
+        })
+    }
+}
+
+func TestJobViewsNeedReadAccess(t *testing.T) {
+    const adminToken = "admin-token-0123456789"
+    paths := []string{"/jobs", "/jobs/abc", "/jobs/abc/preview", "/slots", "/slots/desk", "/events"}
+    tests := []struct {
+        name   string
+        config string
+        token  string
+        // want is the status for every path; 0 means anything but 401 or 403
+        want int
+    }{
+        {"open daemon", `{}`, "", 0},
+        {"admin token set, anonymous", `{"admin_token": "` + adminToken + `"}`, "", http.StatusUnauthorized},
+        {"admin token set, admin", `{"admin_token": "` + adminToken + `"}`, adminToken, 0},
+        {"users, submitter", `{"users": [{"name": "ada", "token": "submitter-token-0123", "role": "submitter"}]}`, "submitter-token-0123", http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            handler := newTestAPI(t, tt.config)
+            for _, path := range paths {
+                if path == "/events" && tt.want == 0 {
+                    // Streams until the client goes away
+                    continue
+                }
+                req := httptest.NewRequest("GET", path, nil)
+                if tt.token != "" {
+                    req.Header.Set("Authorization", "Bearer "+tt.token)
+                }
+                rec := httptest.NewRecorder()
+                handler.ServeHTTP(rec, req)
+                denied := rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden
+                if tt.want == 0 && denied || tt.want != 0 && rec.Code != tt.want {
+                    t.Errorf("%s: got %d (%s), want %d", path, rec.Code, rec.Body.String(), tt.want)
+                }
+            }
+        })
+    }
+}
+
+func TestTextFiltersSeeTheBody(t *testing.T) {
+    handler := newTestAPI(t, `{"filters": {"*": {"banned_words": "(?i)\\bspam\\b"}}}`)
+    for _, path := range []string{"/print/text", "/print/text?source=web"} {
+        req := httptest.NewRequest("POST", path, strings.NewReader("hello\nbuy SPAM now\n"))
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        if rec.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: got %d (%s), want 422", path, rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestAppendChecksEveryLine(t *testing.T) {
+    tests := []struct {
+        name   string
+        config string
+        body   string
+        want   int
+    }{
+        {"banned line", `{"filters": {"console": {"banned_words": "(?i)\\bspam\\b"}}}`, "ok\nspam here\n", http.StatusUnprocessableEntity},
+        {"long line", `{"filters": {"*": {"max_chars": 10}}}`, "short\nthis line is too long\n", http.StatusUnprocessableEntity},
+        {"gallery mode", `{"moderation": true, "admin_token": "admin-token-0123456789"}`, "hello\n", http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            handler := newTestAPI(t, tt.config)
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, httptest.NewRequest("POST", "/append", strings.NewReader(tt.body)))
+            if rec.Code != tt.want {
+                t.Errorf("got %d (%s), want %d", rec.Code, rec.Body.String(), tt.want)
+            }
+        })
+    }
+}
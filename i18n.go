@@ -0,0 +1,109 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Printed labels come from the same message catalogs as the web UI (see
+// i18n.js): locales/<lang>.json, where "de-AT" is looked up in de-AT.json,
+// then de.json, then en.json, and {name} placeholders are filled in. Go has
+// no Intl, so month and day names and the date layouts are catalog entries
+// too. Cards print in the "locale" parameter, else "locale" from the config.
+
+const (
+    LOCALES_DIR    = "locales"
+    DEFAULT_LOCALE = "en"
+)
+
+// A language tag such as "de" or "pt-BR"; anything else never becomes a
+// file name.
+var LOCALE_PATTERN = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+var (
+    catalogsMu sync.Mutex
+    catalogs   = map[string]map[string]string{}
+)
+
+// Locale looks up printed labels for one language.
+type Locale struct {
+    // Catalogs to try in order, English last
+    catalogs []map[string]string
+}
+
+func validLocale(name string) bool {
+    return LOCALE_PATTERN.MatchString(name)
+}
+
+// loadCatalog reads locales/<lang>.json once. A missing catalog is nil and
+// is looked for again next time, so one can be added without a restart.
+func loadCatalog(lang string) map[string]string {
+    catalogsMu.Lock()
+    defer catalogsMu.Unlock()
+    if catalog, ok := catalogs[lang]; ok {
+        return catalog
+    }
+    data, err := os.ReadFile(filepath.Join(LOCALES_DIR, lang+".json"))
+    if err != nil {
+        return nil
+    }
+    var catalog map[string]string
+    if err := json.Unmarshal(data, &catalog); err != nil {
+        log.Printf("Ignoring locales/%s.json: %v", lang, err)
+        return nil
+    }
+    catalogs[lang] = catalog
+    return catalog
+}
+
+// NewLocale finds the catalogs for a language tag, falling back to English
+// for an empty, malformed or unknown one.
+func NewLocale(name string) *Locale {
+    l := &Locale{}
+    candidates := []string{DEFAULT_LOCALE}
+    if name = strings.ReplaceAll(strings.TrimSpace(name), "_", "-"); validLocale(name) {
+        candidates = []string{name, strings.SplitN(name, "-", 2)[0], DEFAULT_LOCALE}
+    }
+    for _, lang := range candidates {
+        if catalog := loadCatalog(lang); catalog != nil {
+            l.catalogs = append(l.catalogs, catalog)
+        }
+    }
+    return l
+}
+
+// T returns the label for key with {name} placeholders filled in from vars,
+// given as name, value pairs. A key no catalog has comes back as is.
+func (l *Locale) T(key string, vars ...string) string {
+    message := key
+    for _, catalog := range l.catalogs {
+        if m, ok := catalog[key]; ok {
+            message = m
+            break
+        }
+    }
+    for i := 0; i+1 < len(vars); i += 2 {
+        message = strings.ReplaceAll(message, "{"+vars[i]+"}", vars[i+1])
+    }
+    return message
+}
+
+// Date formats t with a date layout from the catalog, such as date_long
+// ("{weekday} {day} {month} {year}"). {time} is the clock, 15:04.
+func (l *Locale) Date(t time.Time, layout string) string {
+    return l.T(layout,
+        "weekday", l.T(fmt.Sprintf("weekday_%d", t.Weekday())),
+        "weekday_short", l.T(fmt.Sprintf("weekday_short_%d", t.Weekday())),
+        "day", fmt.Sprint(t.Day()),
+        "month", l.T(fmt.Sprintf("month_%d", t.Month())),
+        "month_short", l.T(fmt.Sprintf("month_short_%d", t.Month())),
+        "year", fmt.Sprint(t.Year()),
+        "time", t.Format("15:04"))
+}
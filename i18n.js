@@ -0,0 +1,62 @@
+// Locale support for text printed by the built-in renderers.
+//
+// Dates and numbers are formatted with Intl for the requested locale. Labels
+// come from message catalogs in locales/<lang>.json; to add a language, copy
+// locales/en.json and translate the values. Missing keys fall back to English.
+
+const fs = require('fs');
+const path = require('path');
+
+const LOCALES_DIR = path.join(__dirname, 'locales');
+const DEFAULT_LOCALE = process.env.CATPRINTER_LOCALE || 'en-US';
+
+const catalogs = {};
+
+function loadCatalog(lang) {
+  if (!(lang in catalogs)) {
+    try {
+      catalogs[lang] = JSON.parse(fs.readFileSync(path.join(LOCALES_DIR, `${lang}.json`), 'utf8'));
+    } catch (e) {
+      catalogs[lang] = null;
+    }
+  }
+  return catalogs[lang];
+}
+
+// Returns a locale Intl can use, falling back to the default
+function resolveLocale(requested) {
+  if (requested) {
+    try {
+      return Intl.getCanonicalLocales(requested)[0];
+    } catch (e) {
+      console.warn('[WARN] Ignoring invalid locale:', requested);
+    }
+  }
+  return DEFAULT_LOCALE;
+}
+
+// Looks up a label for "de-AT" in de-AT.json, then de.json, then en.json.
+// {name} placeholders are replaced from vars.
+function t(locale, key, vars = {}) {
+  const lang = resolveLocale(locale);
+  const candidates = [lang, lang.split('-')[0], 'en'];
+  let message = key;
+  for (const c of candidates) {
+    const catalog = loadCatalog(c);
+    if (catalog && key in catalog) {
+      message = catalog[key];
+      break;
+    }
+  }
+  return message.replace(/\{(\w+)\}/g, (m, name) => (name in vars ? String(vars[name]) : m));
+}
+
+function formatDate(locale, date, options = { weekday: 'long', year: 'numeric', month: 'long', day: 'numeric' }) {
+  return date.toLocaleDateString(resolveLocale(locale), options);
+}
+
+function formatNumber(locale, n, options = {}) {
+  return new Intl.NumberFormat(resolveLocale(locale), options).format(n);
+}
+
+module.exports = { resolveLocale, t, formatDate, formatNumber };
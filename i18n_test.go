@@ -0,0 +1,54 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestLocaleFallback(t *testing.T) {
+    dir := t.TempDir()
+    if err := os.Mkdir(filepath.Join(dir, LOCALES_DIR), 0755); err != nil {
+        t.Fatal(err)
+    }
+    for name, data := range map[string]string{
+        "xx-YY.json": `{"a": "regional"}`,
+        "xx.json":    `{"a": "base", "b": "b {n}"}`,
+    } {
+        if err := os.WriteFile(filepath.Join(dir, LOCALES_DIR, name), []byte(data), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    t.Chdir(dir)
+
+    tests := []struct {
+        locale, key, want string
+    }{
+        {"xx-YY", "a", "regional"},
+        {"xx_YY", "a", "regional"},
+        {"xx-YY", "b", "b 1"},
+        {"xx-ZZ", "a", "base"},
+        {"../xx", "a", "a"},
+    }
+    for _, tt := range tests {
+        if got := NewLocale(tt.locale).T(tt.key, "n", "1"); got != tt.want {
+            t.Errorf("%s %s: got %q, want %q", tt.locale, tt.key, got, tt.want)
+        }
+    }
+}
+
+func TestEnglishDates(t *testing.T) {
+    l := NewLocale("")
+    day := time.Date(2026, 10, 15, 7, 30, 0, 0, time.UTC)
+    tests := map[string]string{
+        "date_long":       "Thursday 15 October 2026",
+        "date_medium":     "15 October 2026",
+        "date_time_short": "Thu 15 Oct 2026 07:30",
+    }
+    for layout, want := range tests {
+        if got := l.Date(day, layout); got != want {
+            t.Errorf("%s: got %q, want %q", layout, got, want)
+        }
+    }
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "bytes"
+    "image"
+    "image/color"
+    "image/draw"
+    _ "image/gif"
+    _ "image/jpeg"
+    "image/png"
+
+    _ "golang.org/x/image/bmp"
+    _ "golang.org/x/image/tiff"
+)
+
+func init() {
+    registerCapability(Capability{Name: "png", Description: "PNG images, scaled to 384px wide"})
+    registerCapability(Capability{Name: "jpeg", Description: "JPEG photos, scaled to 384px wide"})
+    registerCapability(Capability{Name: "bmp", Description: "BMP images"})
+    registerCapability(Capability{Name: "tiff", Description: "TIFF images (e.g. from scanner software)"})
+}
+
+func loadAndBinarizeImage(cfg *Config, ref string, render RenderOptions) (image.Image, error) {
+    f, err := openImageSource(cfg, ref)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    // The format is sniffed from the content, not the name. PNG, JPEG, GIF,
+    // BMP and TIFF are always available; optional formats register with the
+    // image package from their own build-tagged files (see features.go)
+    img, err := decodeImage(f, render.Frames)
+    if err != nil {
+        return nil, err
+    }
+    // Scaled to the printer width later, in the layout stage (pipeline.go)
+    return flattenAlpha(img), nil
+}
+
+// flattenAlpha composites an image with transparency over white paper.
+// Without it transparent pixels, which often hold black, would print.
+func flattenAlpha(img image.Image) image.Image {
+    if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+        return img
+    }
+    b := img.Bounds()
+    out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+    draw.Draw(out, out.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+    draw.Draw(out, out.Bounds(), img, b.Min, draw.Over)
+    return out
+}
+
+func encodeImageToBuffer(img image.Image) []byte {
+    buffer := encodeImageRows(img)
+    // Pad to minimum size
+    for len(buffer) < MIN_DATA_BYTES {
+        buffer = append(buffer, 0)
+    }
+    return buffer
+}
+
+// encodeHalfWidth packs an image rendered at half the printer width, firing
+// two neighbouring dots for every pixel (the economy density).
+func encodeHalfWidth(img image.Image) []byte {
+    b := img.Bounds()
+    wide := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        for x := 0; x < PRINTER_WIDTH; x++ {
+            wide.Pix[y*wide.Stride+x] = 255
+            if x/2 < b.Dx() {
+                wide.Pix[y*wide.Stride+x] = luminance(img.At(b.Min.X+x/2, b.Min.Y+y))
+            }
+        }
+    }
+    return encodeImageToBuffer(wide)
+}
+
+// luminance is the brightness of c as the eye sees it (Rec. 601 weights, as
+// color.GrayModel uses). Everything that thresholds or dithers goes through
+// it, so saturated colours come out by how dark they look: pure red is a dark
+// grey, not white. Transparency shows the paper through, as flattenAlpha
+// does for decoded images.
+func luminance(c color.Color) uint8 {
+    // GrayModel works on premultiplied values, which is the colour over
+    // black; lifting it by the missing alpha puts it over white instead
+    _, _, _, a := c.RGBA()
+    return color.GrayModel.Convert(c).(color.Gray).Y + uint8((0xffff-a)>>8)
+}
+
+// encodeImageRows packs an image into 1bpp printer rows without padding.
+func encodeImageRows(img image.Image) []byte {
+    bounds := img.Bounds()
+    width := bounds.Dx()
+    height := bounds.Dy()
+    buffer := make([]byte, 0, height*PRINTER_WIDTH_BYTES)
+    for y := 0; y < height; y++ {
+        for xByte := 0; xByte < PRINTER_WIDTH_BYTES; xByte++ {
+            var b byte
+            for bit := 0; bit < 8; bit++ {
+                x := xByte*8 + bit
+                if x < width {
+                    // Dark pixels fire a dot (LSB-first)
+                    if luminance(img.At(bounds.Min.X+x, bounds.Min.Y+y)) < 0x80 {
+                        b |= 1 << bit
+                    }
+                }
+            }
+            buffer = append(buffer, b)
+        }
+    }
+    return buffer
+}
+
+// renderBufferPNG turns encoded printer rows back into a PNG, so what will
+// actually be printed can be previewed. It is a 1-bit PNG, dot for dot.
+func renderBufferPNG(buffer []byte, numRows int) ([]byte, error) {
+    if numRows*PRINTER_WIDTH_BYTES > len(buffer) {
+        numRows = len(buffer) / PRINTER_WIDTH_BYTES
+    }
+    img := image.NewPaletted(image.Rect(0, 0, PRINTER_WIDTH, numRows), color.Palette{color.White, color.Black})
+    for y := 0; y < numRows; y++ {
+        for x := 0; x < PRINTER_WIDTH; x++ {
+            b := buffer[y*PRINTER_WIDTH_BYTES+x/8]
+            if b&(1<<(x%8)) != 0 {
+                img.Pix[y*img.Stride+x] = 1
+            }
+        }
+    }
+    var out bytes.Buffer
+    if err := png.Encode(&out, img); err != nil {
+        return nil, err
+    }
+    return out.Bytes(), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "image"
+    "image/color"
+    "testing"
+)
+
+// Colours by Rec. 601 luminance: 0.299 R + 0.587 G + 0.114 B
+var lumaTests = []struct {
+    name string
+    c    color.Color
+    want uint8
+    dot  bool
+}{
+    {"red", color.RGBA{255, 0, 0, 255}, 76, true},
+    {"green", color.RGBA{0, 255, 0, 255}, 150, false},
+    {"blue", color.RGBA{0, 0, 255, 255}, 29, true},
+    {"yellow", color.RGBA{255, 255, 0, 255}, 226, false},
+    {"transparent", color.NRGBA{0, 0, 0, 0}, 255, false},
+    {"half transparent black", color.NRGBA{0, 0, 0, 128}, 127, true},
+}
+
+func solidImage(c color.Color) image.Image {
+    img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+    img.Set(0, 0, c)
+    return img
+}
+
+func TestLuminance(t *testing.T) {
+    for _, tt := range lumaTests {
+        if got := luminance(tt.c); got != tt.want {
+            t.Errorf("%s: luminance %d, want %d", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestColourInputs(t *testing.T) {
+    for _, tt := range lumaTests {
+        t.Run(tt.name, func(t *testing.T) {
+            img := solidImage(tt.c)
+            white := uint8(255)
+            if tt.dot {
+                white = 0
+            }
+            if got := thresholdImage(img, DEFAULT_THRESHOLD).Pix[0]; got != white {
+                t.Errorf("thresholdImage: got %d, want %d", got, white)
+            }
+            // One pixel, so there is no neighbour to take the error
+            if got := errorDiffuse(img, diffusionKernels[DITHER_FLOYD_STEINBERG], DEFAULT_THRESHOLD).Pix[0]; got != white {
+                t.Errorf("errorDiffuse: got %d, want %d", got, white)
+            }
+            row := encodeImageRows(img)
+            if len(row) != PRINTER_WIDTH_BYTES {
+                t.Fatalf("encodeImageRows: got %d bytes, want %d", len(row), PRINTER_WIDTH_BYTES)
+            }
+            if got := row[0]&1 != 0; got != tt.dot {
+                t.Errorf("encodeImageRows: dot %v, want %v", got, tt.dot)
+            }
+        })
+    }
+}
+
+// Images that don't start at the origin are read from their own bounds.
+func TestEncodeImageRowsOffset(t *testing.T) {
+    img := image.NewGray(image.Rect(10, 20, 18, 21))
+    for x := 10; x < 18; x++ {
+        img.SetGray(x, 20, color.Gray{255})
+    }
+    img.SetGray(10, 20, color.Gray{0})
+    img.SetGray(17, 20, color.Gray{0})
+    if got := encodeImageRows(img)[0]; got != 0x81 {
+        t.Errorf("got %08b, want 10000001", got)
+    }
+}
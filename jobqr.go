@@ -0,0 +1,81 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "image/color"
+    "time"
+
+    "github.com/boombuler/barcode/qr"
+)
+
+// Job QR footers: with "job_qr": true (or job_qr=1 on a job) a small QR code
+// goes under the printout, holding the job ID, source and time as JSON:
+//
+//   {"job":"a1b2c3d4","source":"telegram","time":"2026-10-15T09:30:00Z"}
+//
+// Scanning it finds the job in the history (GET /jobs/<id>) long after the
+// print came off the roll.
+
+const (
+    // Dots per QR module; 3 keeps the code about 1.5cm wide and easy to scan
+    JOB_QR_MODULE = 3
+    // White space around the code, in modules (the quiet zone QR needs)
+    JOB_QR_QUIET = 4
+)
+
+type jobQRData struct {
+    Job    string    `json:"job"`
+    Source string    `json:"source,omitempty"`
+    Time   time.Time `json:"time"`
+}
+
+// jobQRRows renders the footer as encoded printer rows (unpadded), centred
+// and, like the receipt, meant to go in front of the job.
+func jobQRRows(job Job) ([]byte, error) {
+    data, err := json.Marshal(jobQRData{Job: job.ID, Source: job.Source, Time: job.Created.UTC().Truncate(time.Second)})
+    if err != nil {
+        return nil, err
+    }
+    code, err := qr.Encode(string(data), qr.M, qr.Auto)
+    if err != nil {
+        return nil, fmt.Errorf("job QR: %v", err)
+    }
+    b := code.Bounds()
+    size := b.Dx() * JOB_QR_MODULE
+    if size > PRINTER_WIDTH {
+        return nil, fmt.Errorf("job QR is %d dots wide, more than the paper", size)
+    }
+    height := size + 2*JOB_QR_QUIET*JOB_QR_MODULE
+    img := blankCanvas(height)
+    left := (PRINTER_WIDTH - size) / 2
+    top := JOB_QR_QUIET * JOB_QR_MODULE
+    for my := 0; my < b.Dy(); my++ {
+        for mx := 0; mx < b.Dx(); mx++ {
+            r, _, _, _ := code.At(b.Min.X+mx, b.Min.Y+my).RGBA()
+            if r >= 0x8000 {
+                continue
+            }
+            for dy := 0; dy < JOB_QR_MODULE; dy++ {
+                for dx := 0; dx < JOB_QR_MODULE; dx++ {
+                    img.SetGray(left+mx*JOB_QR_MODULE+dx, top+my*JOB_QR_MODULE+dy, color.Gray{0})
+                }
+            }
+        }
+    }
+    return encodeImageRows(img), nil
+}
+
+// withJobQR returns a copy of prepared with the job's QR footer, or
+// prepared itself if the job isn't known.
+func (pd *PrinterDaemon) withJobQR(jobID string, prepared *preparedImage) (*preparedImage, error) {
+    job, ok := pd.jobs.Get(jobID)
+    if !ok {
+        return prepared, nil
+    }
+    rows, err := jobQRRows(job)
+    if err != nil {
+        return nil, err
+    }
+    return prependRows(prepared, prepared.encodeRows(rows)), nil
+}
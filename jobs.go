@@ -0,0 +1,470 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+)
+
+// JobState is the lifecycle of a print job. The same names are used in the
+// HTTP API, the /events stream, the state label on /metrics and CLI output.
+type JobState string
+
+const (
+    JobQueued         JobState = "queued"
+    JobRendering      JobState = "rendering"
+    JobConnecting     JobState = "connecting"
+    JobTransferring   JobState = "transferring"
+    JobCooling        JobState = "cooling"
+    JobPausedPaperOut JobState = "paused-paper-out"
+    JobFinishing      JobState = "finishing"
+    JobDone           JobState = "done"
+    JobFailed         JobState = "failed"
+    JobCanceled       JobState = "canceled"
+    JobExpired        JobState = "expired"
+)
+
+var AllJobStates = []JobState{
+    JobQueued, JobRendering, JobConnecting, JobTransferring, JobCooling,
+    JobPausedPaperOut, JobFinishing, JobDone, JobFailed, JobCanceled, JobExpired,
+}
+
+func (s JobState) Terminal() bool {
+    return s == JobDone || s == JobFailed || s == JobCanceled || s == JobExpired
+}
+
+// How many finished jobs to remember for the API
+const MAX_FINISHED_JOBS = 200
+
+var (
+    errJobCanceled = errors.New("job canceled")
+    errJobExpired  = errors.New("job expired before it could print")
+    errJobTimedOut = errors.New("job ran longer than max_job_duration and was aborted")
+)
+
+// Job is a snapshot of one print job. Trackers hand out copies; use the
+// tracker to change state.
+type Job struct {
+    ID      string   `json:"id"`
+    Source  string   `json:"source,omitempty"`
+    State   JobState `json:"state"`
+    Error   string   `json:"error,omitempty"`
+    Rows    int      `json:"rows,omitempty"`
+    Receipt string   `json:"receipt,omitempty"`
+    // Slot is the replaceable note the job printed into (see slots.go), and
+    // SupersededBy the job that replaced it there
+    Slot         string `json:"slot,omitempty"`
+    SupersededBy string `json:"superseded_by,omitempty"`
+    // ReplyTo is the caller's reference for the result webhook, e.g. the
+    // chat and message a job came from
+    ReplyTo string `json:"reply_to,omitempty"`
+    // Output is the spool file for jobs written to disk instead of printed
+    Output string `json:"output,omitempty"`
+    // Expires is when the job gives up if it hasn't started transferring
+    Expires *time.Time `json:"expires,omitempty"`
+    Created time.Time  `json:"created"`
+    Updated time.Time  `json:"updated"`
+}
+
+type trackedJob struct {
+    Job
+    canceled chan struct{}
+    // Set when the job was stopped for running too long, not by a person
+    timedOut bool
+    // What gets printed, kept for the result webhook's preview
+    prepared *preparedImage
+    // The rows sent to the printer so far, turned into preview once the job
+    // is over
+    sent     []byte
+    sentGray bool
+    preview  []byte
+}
+
+type JobTracker struct {
+    mu          sync.Mutex
+    jobs        map[string]*trackedJob
+    order       []string
+    transitions map[JobState]uint64
+    listeners   []chan Job
+}
+
+func NewJobTracker() *JobTracker {
+    return &JobTracker{
+        jobs:        make(map[string]*trackedJob),
+        transitions: make(map[JobState]uint64),
+    }
+}
+
+func newJobID() string {
+    return randomHex(6)
+}
+
+// randomHex is n random bytes in hex, for IDs, tokens and secrets.
+func randomHex(n int) string {
+    b := make([]byte, n)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// New registers a job in the queued state and returns its ID.
+func (t *JobTracker) New(source string) string {
+    now := time.Now()
+    job := &trackedJob{
+        Job:      Job{ID: newJobID(), Source: source, State: JobQueued, Created: now, Updated: now},
+        canceled: make(chan struct{}),
+    }
+
+    t.mu.Lock()
+    t.jobs[job.ID] = job
+    t.order = append(t.order, job.ID)
+    t.transitions[JobQueued]++
+    t.pruneLocked()
+    snapshot := job.Job
+    t.mu.Unlock()
+
+    t.publish(snapshot)
+    return job.ID
+}
+
+// Set moves a job to a new state. Terminal states are final: later updates
+// (e.g. a failure after a cancel) are ignored.
+func (t *JobTracker) Set(id string, state JobState, err error) {
+    t.mu.Lock()
+    job, ok := t.jobs[id]
+    if !ok || job.State.Terminal() || (job.State == state && err == nil) {
+        t.mu.Unlock()
+        return
+    }
+    job.State = state
+    job.Updated = time.Now()
+    if err != nil {
+        job.Error = err.Error()
+    }
+    t.transitions[state]++
+    snapshot := job.Job
+    var sent []byte
+    var gray bool
+    if state.Terminal() {
+        sent, gray = job.sent, job.sentGray
+        job.sent = nil
+    }
+    t.mu.Unlock()
+
+    if sent != nil {
+        // Before the event goes out, so listeners can fetch the preview
+        t.savePreview(id, sent, gray)
+    }
+    t.publish(snapshot)
+}
+
+// AddSent records rows as written to the printer, for the job's preview
+// once it finishes.
+func (t *JobTracker) AddSent(id string, rows []byte, gray bool) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok && !job.State.Terminal() {
+        job.sent = append(job.sent, rows...)
+        job.sentGray = gray
+    }
+}
+
+func (t *JobTracker) savePreview(id string, sent []byte, gray bool) {
+    rowBytes := PRINTER_WIDTH_BYTES
+    if gray {
+        rowBytes = GRAY_ROW_BYTES
+    }
+    preview, err := renderRowsPNG(sent, len(sent)/rowBytes, gray)
+    if err != nil {
+        log.Printf("Failed to save preview of job %s: %v", id, err)
+        return
+    }
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok {
+        job.preview = preview
+    }
+}
+
+// Preview returns the 1-bit PNG of what a finished job sent to the printer,
+// or nil if it sent nothing.
+func (t *JobTracker) Preview(id string) []byte {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok {
+        return job.preview
+    }
+    return nil
+}
+
+// Finish records the outcome of a job: done, canceled or failed.
+func (t *JobTracker) Finish(id string, err error) {
+    switch {
+    case err == nil:
+        t.Set(id, JobDone, nil)
+    case errors.Is(err, errJobCanceled):
+        t.Set(id, JobCanceled, nil)
+    case errors.Is(err, errJobExpired):
+        t.Set(id, JobExpired, nil)
+    default:
+        t.Set(id, JobFailed, err)
+    }
+}
+
+func (t *JobTracker) SetRows(id string, rows int) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok {
+        job.Rows = rows
+    }
+}
+
+// SetReply records where the job's result should go, and what it prints so
+// a preview can go along with it.
+func (t *JobTracker) SetReply(id, replyTo string, prepared *preparedImage) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok {
+        job.ReplyTo = replyTo
+        job.prepared = prepared
+    }
+}
+
+// Prepared returns what a job with a reply prints, or nil.
+func (t *JobTracker) Prepared(id string) *preparedImage {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok {
+        return job.prepared
+    }
+    return nil
+}
+
+func (t *JobTracker) SetReceipt(id, code string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok {
+        job.Receipt = code
+    }
+}
+
+func (t *JobTracker) SetSlot(id, slot string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok {
+        job.Slot = slot
+    }
+}
+
+// Supersede marks a job's printout as replaced by a newer one in its slot.
+func (t *JobTracker) Supersede(id, by string) {
+    t.mu.Lock()
+    job, ok := t.jobs[id]
+    if !ok {
+        t.mu.Unlock()
+        return
+    }
+    job.SupersededBy = by
+    job.Updated = time.Now()
+    snapshot := job.Job
+    t.mu.Unlock()
+
+    t.publish(snapshot)
+}
+
+// SetExpiry gives a job a deadline. A job still queued at that point moves to
+// expired straight away; one that is further along is checked by the printer
+// before it starts transferring.
+func (t *JobTracker) SetExpiry(id string, at time.Time) {
+    t.mu.Lock()
+    job, ok := t.jobs[id]
+    if ok {
+        job.Expires = &at
+    }
+    t.mu.Unlock()
+    if !ok {
+        return
+    }
+
+    time.AfterFunc(time.Until(at), func() {
+        t.mu.Lock()
+        job, ok := t.jobs[id]
+        queued := ok && job.State == JobQueued
+        t.mu.Unlock()
+        if queued {
+            t.Set(id, JobExpired, nil)
+        }
+    })
+}
+
+// Expired reports whether a job is past its deadline (or already expired).
+func (t *JobTracker) Expired(id string) bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    job, ok := t.jobs[id]
+    if !ok {
+        return false
+    }
+    return job.State == JobExpired || (job.Expires != nil && time.Now().After(*job.Expires))
+}
+
+func (t *JobTracker) SetOutput(id, path string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok {
+        job.Output = path
+    }
+}
+
+func (t *JobTracker) Get(id string) (Job, bool) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    job, ok := t.jobs[id]
+    if !ok {
+        return Job{}, false
+    }
+    return job.Job, true
+}
+
+func (t *JobTracker) List() []Job {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    jobs := make([]Job, 0, len(t.order))
+    for _, id := range t.order {
+        jobs = append(jobs, t.jobs[id].Job)
+    }
+    return jobs
+}
+
+// Cancel asks a job to stop. Queued jobs never start; running jobs stop at
+// the next chunk boundary.
+func (t *JobTracker) Cancel(id string) error {
+    t.mu.Lock()
+    job, ok := t.jobs[id]
+    if !ok {
+        t.mu.Unlock()
+        return fmt.Errorf("no such job")
+    }
+    if job.State.Terminal() {
+        t.mu.Unlock()
+        return fmt.Errorf("job already %s", job.State)
+    }
+    select {
+    case <-job.canceled:
+    default:
+        close(job.canceled)
+    }
+    queued := job.State == JobQueued
+    t.mu.Unlock()
+
+    if queued {
+        t.Set(id, JobCanceled, nil)
+    }
+    return nil
+}
+
+// TimeOut stops a running job that went past its time limit. It looks like
+// a cancel to the print loop, which then reports errJobTimedOut instead.
+func (t *JobTracker) TimeOut(id string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    job, ok := t.jobs[id]
+    if !ok || job.State.Terminal() {
+        return
+    }
+    job.timedOut = true
+    select {
+    case <-job.canceled:
+    default:
+        close(job.canceled)
+    }
+}
+
+// TimedOut reports whether the job was stopped by TimeOut.
+func (t *JobTracker) TimedOut(id string) bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    job, ok := t.jobs[id]
+    return ok && job.timedOut
+}
+
+// Canceled returns a channel that is closed when the job is canceled.
+func (t *JobTracker) Canceled(id string) <-chan struct{} {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if job, ok := t.jobs[id]; ok {
+        return job.canceled
+    }
+    return nil
+}
+
+// Counts returns how many tracked jobs are in each state, and how many times
+// each state has been entered since startup.
+func (t *JobTracker) Counts() (map[JobState]int, map[JobState]uint64) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    current := make(map[JobState]int)
+    for _, job := range t.jobs {
+        current[job.State]++
+    }
+    transitions := make(map[JobState]uint64, len(t.transitions))
+    for s, n := range t.transitions {
+        transitions[s] = n
+    }
+    return current, transitions
+}
+
+// Subscribe returns a channel of job snapshots, one per state change.
+func (t *JobTracker) Subscribe() (<-chan Job, func()) {
+    ch := make(chan Job, 64)
+    t.mu.Lock()
+    t.listeners = append(t.listeners, ch)
+    t.mu.Unlock()
+
+    return ch, func() {
+        t.mu.Lock()
+        defer t.mu.Unlock()
+        for i, l := range t.listeners {
+            if l == ch {
+                t.listeners = append(t.listeners[:i], t.listeners[i+1:]...)
+                close(ch)
+                break
+            }
+        }
+    }
+}
+
+func (t *JobTracker) publish(job Job) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    for _, l := range t.listeners {
+        select {
+        case l <- job:
+        default:
+            // Slow listener, drop the event rather than stall printing
+        }
+    }
+}
+
+// pruneLocked drops the oldest finished jobs beyond MAX_FINISHED_JOBS.
+func (t *JobTracker) pruneLocked() {
+    finished := 0
+    for _, id := range t.order {
+        if t.jobs[id].State.Terminal() {
+            finished++
+        }
+    }
+    kept := t.order[:0]
+    for _, id := range t.order {
+        if finished > MAX_FINISHED_JOBS && t.jobs[id].State.Terminal() {
+            delete(t.jobs, id)
+            finished--
+            continue
+        }
+        kept = append(kept, id)
+    }
+    t.order = kept
+}
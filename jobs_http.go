@@ -0,0 +1,200 @@
+//go:build daemon
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+// Job API:
+//
+//   GET  /jobs             recent jobs, oldest first
+//   GET  /jobs/<id>        one job
+//   POST /jobs/<id>/cancel cancel a queued or running job
+//   GET  /jobs/<id>/preview  what a finished job sent to the printer, as a PNG
+//   GET  /checkpoints      interrupted jobs that can be resumed (checkpoint.go)
+//   POST /checkpoints/<id>/resume  print the rest of one, as a new job
+//   POST /checkpoints/<id>/discard forget one
+//   GET  /slots            replaceable notes and the job each one shows (slots.go)
+//   GET  /slots/<name>     a slot's current content as a PNG
+//   GET  /events           server-sent events, one "job" event per state change
+//   GET  /metrics          Prometheus text format, labelled by job state
+//
+// Everything that shows jobs or their prints needs a read token or an admin
+// once the daemon has any login at all (readGated).
+
+func (api *HTTPAPI) registerJobHandlers(mux *http.ServeMux) {
+    mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+        if api.readGated() && !api.requireRead(w, r) {
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(api.engine.Jobs())
+    })
+
+    mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+        // Cancelling checks for an admin itself
+        if !strings.HasSuffix(id, "/cancel") && api.readGated() && !api.requireRead(w, r) {
+            return
+        }
+        if strings.HasSuffix(id, "/preview") {
+            preview, err := api.engine.JobPreview(strings.TrimSuffix(id, "/preview"))
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusNotFound)
+                return
+            }
+            w.Header().Set("Content-Type", "image/png")
+            w.Write(preview)
+            return
+        }
+        if strings.HasSuffix(id, "/cancel") {
+            if r.Method != "POST" {
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+            }
+            if api.adminEnabled() && !api.requireAdmin(w, r) {
+                return
+            }
+            id = strings.TrimSuffix(id, "/cancel")
+            if err := api.engine.Cancel(id); err != nil {
+                http.Error(w, err.Error(), http.StatusConflict)
+                return
+            }
+        }
+        job, ok := api.engine.Job(id)
+        if !ok {
+            http.Error(w, "No such job", http.StatusNotFound)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(job)
+    })
+
+    mux.HandleFunc("/checkpoints", func(w http.ResponseWriter, r *http.Request) {
+        if api.adminEnabled() && !api.requireAdmin(w, r) {
+            return
+        }
+        list, err := api.engine.Checkpoints()
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(list)
+    })
+
+    mux.HandleFunc("/checkpoints/", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if api.adminEnabled() && !api.requireAdmin(w, r) {
+            return
+        }
+        id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/checkpoints/"), "/")
+        switch action {
+        case "resume":
+            jobID, err := api.engine.ResumeCheckpoint(id)
+            if err == errNoCheckpoint {
+                http.Error(w, err.Error(), http.StatusNotFound)
+                return
+            }
+            if err != nil && jobID == "" {
+                http.Error(w, err.Error(), http.StatusConflict)
+                return
+            }
+            if err != nil {
+                http.Error(w, fmt.Sprintf("Resumed job %s failed: %v", jobID, err), http.StatusInternalServerError)
+                return
+            }
+            w.Header().Set("Content-Type", "application/json")
+            json.NewEncoder(w).Encode(map[string]string{"id": jobID, "status": "printed"})
+        case "discard":
+            if err := api.engine.DiscardCheckpoint(id); err != nil {
+                http.Error(w, err.Error(), http.StatusNotFound)
+                return
+            }
+            w.WriteHeader(http.StatusNoContent)
+        default:
+            http.Error(w, "Not found", http.StatusNotFound)
+        }
+    })
+
+    mux.HandleFunc("/slots", func(w http.ResponseWriter, r *http.Request) {
+        if api.readGated() && !api.requireRead(w, r) {
+            return
+        }
+        list, err := api.engine.Slots()
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(list)
+    })
+
+    mux.HandleFunc("/slots/", func(w http.ResponseWriter, r *http.Request) {
+        if api.readGated() && !api.requireRead(w, r) {
+            return
+        }
+        slot, content, err := api.engine.SlotContent(strings.TrimPrefix(r.URL.Path, "/slots/"))
+        if err == errNoSlot {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "image/png")
+        w.Header().Set("X-Slot-Job", slot.JobID)
+        w.Header().Set("Last-Modified", slot.Updated.UTC().Format(http.TimeFormat))
+        w.Write(content)
+    })
+
+    mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+        if api.readGated() && !api.requireRead(w, r) {
+            return
+        }
+        flusher, ok := w.(http.Flusher)
+        if !ok {
+            http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+            return
+        }
+        events, cancel := api.engine.Subscribe()
+        defer cancel()
+
+        w.Header().Set("Content-Type", "text/event-stream")
+        w.Header().Set("Cache-Control", "no-cache")
+        flusher.Flush()
+        for {
+            select {
+            case job := <-events:
+                data, _ := json.Marshal(job)
+                fmt.Fprintf(w, "event: job\ndata: %s\n\n", data)
+                flusher.Flush()
+            case <-r.Context().Done():
+                return
+            }
+        }
+    })
+
+    mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+        current, transitions := api.engine.JobCounts()
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        fmt.Fprintln(w, "# HELP catprinter_jobs Tracked print jobs by state.")
+        fmt.Fprintln(w, "# TYPE catprinter_jobs gauge")
+        for _, s := range AllJobStates {
+            fmt.Fprintf(w, "catprinter_jobs{state=%q} %d\n", s, current[s])
+        }
+        fmt.Fprintln(w, "# HELP catprinter_job_transitions_total Times a job entered each state.")
+        fmt.Fprintln(w, "# TYPE catprinter_job_transitions_total counter")
+        for _, s := range AllJobStates {
+            fmt.Fprintf(w, "catprinter_job_transitions_total{state=%q} %d\n", s, transitions[s])
+        }
+    })
+}
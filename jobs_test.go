@@ -0,0 +1,44 @@
+package main
+
+import (
+    "bytes"
+    "image/png"
+    "testing"
+)
+
+// Rows sent before the job moves to transferring still end up in the
+// preview once it is done.
+func TestPreviewKeepsRowsAcrossStates(t *testing.T) {
+    tracker := NewJobTracker()
+    id := tracker.New("test")
+    row := make([]byte, PRINTER_WIDTH_BYTES)
+
+    tracker.AddSent(id, row, false)
+    tracker.Set(id, JobTransferring, nil)
+    tracker.AddSent(id, row, false)
+    tracker.Set(id, JobCooling, nil)
+    tracker.AddSent(id, row, false)
+    tracker.Set(id, JobDone, nil)
+
+    preview := tracker.Preview(id)
+    if preview == nil {
+        t.Fatal("no preview")
+    }
+    img, err := png.Decode(bytes.NewReader(preview))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rows := img.Bounds().Dy(); rows != 3 {
+        t.Errorf("preview has %d rows, want 3", rows)
+    }
+}
+
+func TestNoPreviewWithoutRows(t *testing.T) {
+    tracker := NewJobTracker()
+    id := tracker.New("test")
+    tracker.Set(id, JobTransferring, nil)
+    tracker.Set(id, JobFailed, nil)
+    if tracker.Preview(id) != nil {
+        t.Error("got a preview for a job that sent nothing")
+    }
+}
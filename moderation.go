@@ -0,0 +1,248 @@
+//go:build daemon
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// Gallery mode: with "moderation" enabled, /print requests without the admin
+// token are snapshotted into an in-memory queue instead of printing. An admin
+// approves or rejects them from /admin, which also lists the print queue with
+// a cancel button per job. Pending jobs don't survive a restart.
+
+const MAX_PENDING_JOBS = 100
+
+type pendingJob struct {
+    ID        string    `json:"id"`
+    Submitted time.Time `json:"submitted"`
+    Source    string    `json:"source,omitempty"`
+    Rows      int       `json:"rows"`
+
+    prepared *preparedImage
+    opts     PrintOptions
+}
+
+type ModerationQueue struct {
+    mu   sync.Mutex
+    jobs []*pendingJob
+}
+
+func (q *ModerationQueue) Submit(prepared *preparedImage, opts PrintOptions) (*pendingJob, error) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if len(q.jobs) >= MAX_PENDING_JOBS {
+        return nil, fmt.Errorf("moderation queue is full")
+    }
+    job := &pendingJob{
+        ID:        newJobID(),
+        Submitted: time.Now(),
+        Source:    opts.Source,
+        Rows:      prepared.numRows,
+        prepared:  prepared,
+        opts:      opts,
+    }
+    q.jobs = append(q.jobs, job)
+    return job, nil
+}
+
+func (q *ModerationQueue) List() []*pendingJob {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return append([]*pendingJob{}, q.jobs...)
+}
+
+func (q *ModerationQueue) Get(id string) *pendingJob {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    for _, job := range q.jobs {
+        if job.ID == id {
+            return job
+        }
+    }
+    return nil
+}
+
+// Take removes a job from the queue so it can only be decided once.
+func (q *ModerationQueue) Take(id string) *pendingJob {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    for i, job := range q.jobs {
+        if job.ID == id {
+            q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+            return job
+        }
+    }
+    return nil
+}
+
+func (api *HTTPAPI) registerModerationHandlers(mux *http.ServeMux) {
+    queue := api.moderation
+    mux.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+        // The page itself is public; every API call it makes needs an admin
+        // token
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(moderationPage))
+    })
+
+    mux.HandleFunc("/admin/moderation", func(w http.ResponseWriter, r *http.Request) {
+        if !api.requireRead(w, r) {
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(queue.List())
+    })
+
+    mux.HandleFunc("/admin/moderation/preview", func(w http.ResponseWriter, r *http.Request) {
+        if !api.requireRead(w, r) {
+            return
+        }
+        job := queue.Get(r.URL.Query().Get("id"))
+        if job == nil {
+            http.Error(w, "No such job", http.StatusNotFound)
+            return
+        }
+        preview, err := job.prepared.png()
+        if err != nil {
+            http.Error(w, fmt.Sprintf("Preview failed: %v", err), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "image/png")
+        w.Write(preview)
+    })
+
+    mux.HandleFunc("/admin/moderation/approve", func(w http.ResponseWriter, r *http.Request) {
+        if !api.requireAdmin(w, r) {
+            return
+        }
+        if r.Method != "POST" {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        job := queue.Take(r.URL.Query().Get("id"))
+        if job == nil {
+            http.Error(w, "No such job", http.StatusNotFound)
+            return
+        }
+        log.Printf("Moderation: approved %s", job.ID)
+        if err := api.engine.Print(api.engine.NewJob(job.opts.Source), job.prepared, job.opts); err != nil {
+            log.Printf("Print failed: %v", err)
+            http.Error(w, fmt.Sprintf("Print failed: %v", err), http.StatusInternalServerError)
+            return
+        }
+        w.Write([]byte("Printed successfully"))
+    })
+
+    mux.HandleFunc("/admin/moderation/reject", func(w http.ResponseWriter, r *http.Request) {
+        if !api.requireAdmin(w, r) {
+            return
+        }
+        if r.Method != "POST" {
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        job := queue.Take(r.URL.Query().Get("id"))
+        if job == nil {
+            http.Error(w, "No such job", http.StatusNotFound)
+            return
+        }
+        log.Printf("Moderation: rejected %s", job.ID)
+        w.Write([]byte("Rejected"))
+    })
+}
+
+const moderationPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <title>Cat Printer - Admin</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <style>
+    body { font-family: sans-serif; max-width: 480px; margin: 1em auto; padding: 0 1em; background: #f8f9fa; }
+    .job { background: #fff; border: 1px solid #dee2e6; border-radius: 6px; padding: .75em; margin-bottom: 1em; }
+    .job img { width: 100%; image-rendering: pixelated; border: 1px solid #ccc; }
+    button { padding: .5em 1em; margin-right: .5em; }
+    #queue p { display: flex; justify-content: space-between; align-items: center; margin: .25em 0; }
+  </style>
+</head>
+<body>
+  <p><input id="token" type="password" placeholder="Admin token"> <button onclick="saveToken()">Save</button> <a href="/admin/console">Protocol console</a> <span id="who"></span></p>
+  <h2>Print queue</h2>
+  <div id="queue"></div>
+  <h2>Moderation queue</h2>
+  <div id="jobs"></div>
+  <script>
+    const tokenInput = document.getElementById('token');
+    tokenInput.value = localStorage.getItem('adminToken') || '';
+    function saveToken() { localStorage.setItem('adminToken', tokenInput.value); load(); }
+    function api(path, opts = {}) {
+      opts.headers = { 'Authorization': 'Bearer ' + tokenInput.value };
+      return fetch(path, opts);
+    }
+    async function loadQueue() {
+      const list = document.getElementById('queue');
+      const who = await api('/whoami');
+      const me = who.ok ? await who.json() : {};
+      document.getElementById('who').textContent = me.role ? 'Logged in as ' + (me.name || 'admin') + ' (' + me.role + ')' : '';
+      const res = await api('/jobs', { cache: 'no-store' });
+      if (!res.ok) { list.textContent = await res.text(); return; }
+      const jobs = (await res.json()).filter(job => !['done', 'failed', 'canceled', 'expired'].includes(job.state));
+      list.innerHTML = jobs.length ? '' : '<p>Nothing queued.</p>';
+      for (const job of jobs) {
+        const row = document.createElement('p');
+        const info = document.createElement('span');
+        info.textContent = new Date(job.created).toLocaleTimeString() + ' ' + job.state + (job.source ? ' - ' + job.source : '');
+        const cancel = document.createElement('button');
+        cancel.textContent = 'Cancel';
+        cancel.onclick = async () => {
+          cancel.disabled = true;
+          const res = await api('/jobs/' + job.id + '/cancel', { method: 'POST' });
+          if (!res.ok) alert(await res.text());
+          load();
+        };
+        row.append(info, cancel);
+        list.append(row);
+      }
+    }
+    async function load() {
+      loadQueue();
+      const list = document.getElementById('jobs');
+      const res = await api('/admin/moderation');
+      if (!res.ok) { list.textContent = await res.text(); return; }
+      const jobs = await res.json();
+      list.innerHTML = jobs.length ? '' : '<p>Nothing waiting.</p>';
+      for (const job of jobs) {
+        const div = document.createElement('div');
+        div.className = 'job';
+        const info = document.createElement('p');
+        info.textContent = new Date(job.submitted).toLocaleString() + (job.source ? ' - ' + job.source : '');
+        const img = document.createElement('img');
+        api('/admin/moderation/preview?id=' + job.id).then(r => r.blob()).then(b => img.src = URL.createObjectURL(b));
+        const approve = document.createElement('button');
+        approve.textContent = 'Approve & print';
+        approve.onclick = () => decide(job.id, 'approve', approve);
+        const reject = document.createElement('button');
+        reject.textContent = 'Reject';
+        reject.onclick = () => decide(job.id, 'reject', reject);
+        div.append(info, img, approve, reject);
+        list.append(div);
+      }
+    }
+    async function decide(id, action, button) {
+      button.disabled = true;
+      const res = await api('/admin/moderation/' + action + '?id=' + id, { method: 'POST' });
+      if (!res.ok) alert(await res.text());
+      load();
+    }
+    load();
+    setInterval(load, 15000);
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,443 @@
+//go:build daemon
+
+package main
+
+import (
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "log"
+    "math/big"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Single sign-on through an OpenID Connect provider. The browser goes to
+// /login, which sends it to the provider; the provider sends it back to
+// /login/callback with a code, which we swap for an ID token. A valid token
+// starts a session, kept in a cookie. Cookies ignore the port, so the web UI
+// on :3000 sends the daemon's cookie along and server.js forwards it.
+//
+// API clients can skip the browser and send an ID token from the provider
+// (audience client_id) as "Authorization: Bearer <token>".
+//
+// Only the authorization code flow and RS256/ES256 signed tokens are
+// supported. Sessions are kept in memory and end when the daemon restarts.
+
+const (
+    OIDC_SESSION_COOKIE = "catprinter_session"
+    OIDC_SESSION_TTL    = 12 * time.Hour
+    OIDC_LOGIN_TTL      = 10 * time.Minute
+    // Unknown key IDs refetch the provider's keys at most this often
+    OIDC_JWKS_REFRESH = time.Minute
+    // Clock skew allowed when checking token expiry
+    OIDC_LEEWAY = time.Minute
+    // Prefixed to OIDC user names, as "token:" is to API tokens
+    OIDC_USER_PREFIX = "oidc:"
+)
+
+type oidcDiscovery struct {
+    Issuer                string `json:"issuer"`
+    AuthorizationEndpoint string `json:"authorization_endpoint"`
+    TokenEndpoint         string `json:"token_endpoint"`
+    JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcLogin struct {
+    nonce    string
+    redirect string
+    expires  time.Time
+}
+
+type oidcSession struct {
+    user    UserConfig
+    expires time.Time
+}
+
+type OIDCProvider struct {
+    cfg    *OIDCConfig
+    client *http.Client
+
+    mu          sync.Mutex
+    discovery   *oidcDiscovery
+    keys        map[string]crypto.PublicKey
+    keysFetched time.Time
+    logins      map[string]oidcLogin
+    sessions    map[string]oidcSession
+}
+
+func newOIDCProvider(cfg *OIDCConfig) *OIDCProvider {
+    if cfg == nil {
+        return nil
+    }
+    return &OIDCProvider{
+        cfg:      cfg,
+        client:   &http.Client{Timeout: 10 * time.Second},
+        logins:   make(map[string]oidcLogin),
+        sessions: make(map[string]oidcSession),
+    }
+}
+
+func (p *OIDCProvider) getJSON(url string, v interface{}) error {
+    resp, err := p.client.Get(url)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("%s: %s", url, resp.Status)
+    }
+    return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover fetches the provider's endpoints on first use, so the daemon
+// still starts while the provider is down.
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.discovery != nil {
+        return p.discovery, nil
+    }
+    var d oidcDiscovery
+    if err := p.getJSON(strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+        return nil, fmt.Errorf("oidc discovery failed: %v", err)
+    }
+    if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+        return nil, fmt.Errorf("oidc discovery is missing endpoints")
+    }
+    // The document must be about the issuer we asked, or its endpoints and
+    // keys could be anyone's (OpenID Connect Discovery 1.0, section 4.3)
+    if strings.TrimSuffix(d.Issuer, "/") != strings.TrimSuffix(p.cfg.Issuer, "/") {
+        return nil, fmt.Errorf("oidc discovery is for issuer %q, not %q", d.Issuer, p.cfg.Issuer)
+    }
+    p.discovery = &d
+    return p.discovery, nil
+}
+
+// key returns the signing key with the given ID, refetching the key set
+// when it's unknown (the provider rotated its keys).
+func (p *OIDCProvider) key(kid string) (crypto.PublicKey, error) {
+    d, err := p.discover()
+    if err != nil {
+        return nil, err
+    }
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if key, ok := p.keys[kid]; ok {
+        return key, nil
+    }
+    if time.Since(p.keysFetched) < OIDC_JWKS_REFRESH {
+        return nil, fmt.Errorf("unknown signing key %q", kid)
+    }
+    p.keysFetched = time.Now()
+    var set struct {
+        Keys []struct {
+            Kty string `json:"kty"`
+            Kid string `json:"kid"`
+            N   string `json:"n"`
+            E   string `json:"e"`
+            Crv string `json:"crv"`
+            X   string `json:"x"`
+            Y   string `json:"y"`
+        } `json:"keys"`
+    }
+    if err := p.getJSON(d.JWKSURI, &set); err != nil {
+        return nil, fmt.Errorf("fetching signing keys failed: %v", err)
+    }
+    p.keys = make(map[string]crypto.PublicKey)
+    for _, k := range set.Keys {
+        switch {
+        case k.Kty == "RSA":
+            n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+            e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+            if err1 == nil && err2 == nil {
+                p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+            }
+        case k.Kty == "EC" && k.Crv == "P-256":
+            x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+            y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+            if err1 == nil && err2 == nil {
+                p.keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+            }
+        }
+    }
+    if key, ok := p.keys[kid]; ok {
+        return key, nil
+    }
+    return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// verify checks an ID token's signature, issuer, audience, expiry and (for
+// logins) nonce, and returns its claims.
+func (p *OIDCProvider) verify(token, nonce string) (map[string]interface{}, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return nil, fmt.Errorf("malformed token")
+    }
+    var header struct {
+        Alg string `json:"alg"`
+        Kid string `json:"kid"`
+    }
+    raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+    if err != nil || json.Unmarshal(raw, &header) != nil {
+        return nil, fmt.Errorf("malformed token header")
+    }
+    sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return nil, fmt.Errorf("malformed token signature")
+    }
+    key, err := p.key(header.Kid)
+    if err != nil {
+        return nil, err
+    }
+    digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+    switch k := key.(type) {
+    case *rsa.PublicKey:
+        if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+            return nil, fmt.Errorf("bad token signature")
+        }
+    case *ecdsa.PublicKey:
+        if header.Alg != "ES256" || len(sig) != 64 ||
+            !ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+            return nil, fmt.Errorf("bad token signature")
+        }
+    default:
+        return nil, fmt.Errorf("unsupported signing key")
+    }
+
+    var claims map[string]interface{}
+    raw, err = base64.RawURLEncoding.DecodeString(parts[1])
+    if err != nil || json.Unmarshal(raw, &claims) != nil {
+        return nil, fmt.Errorf("malformed token claims")
+    }
+    if iss, _ := claims["iss"].(string); iss != p.discovery.Issuer {
+        return nil, fmt.Errorf("token from unexpected issuer %q", iss)
+    }
+    if !claimHas(claims["aud"], p.cfg.ClientID) {
+        return nil, fmt.Errorf("token is not for this client")
+    }
+    exp, _ := claims["exp"].(float64)
+    if time.Unix(int64(exp), 0).Add(OIDC_LEEWAY).Before(time.Now()) {
+        return nil, fmt.Errorf("token expired")
+    }
+    if got, _ := claims["nonce"].(string); nonce != "" && got != nonce {
+        return nil, fmt.Errorf("token nonce mismatch")
+    }
+    return claims, nil
+}
+
+// claimHas reports whether a string or list claim contains want.
+func claimHas(claim interface{}, want string) bool {
+    switch v := claim.(type) {
+    case string:
+        return v == want
+    case []interface{}:
+        for _, item := range v {
+            if s, _ := item.(string); s == want {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// userFromClaims maps an ID token to a user: named by verified email (or
+// username, or subject), admin by verified email or group, a submitter
+// otherwise. Names get the OIDC_USER_PREFIX, so they never share a quota
+// with a user from the config.
+func (p *OIDCProvider) userFromClaims(claims map[string]interface{}) UserConfig {
+    // Providers with self-registration let anyone claim any address
+    email, _ := claims["email"].(string)
+    if verified, _ := claims["email_verified"].(bool); !verified {
+        email = ""
+    }
+    name := email
+    if name == "" {
+        name, _ = claims["preferred_username"].(string)
+    }
+    if name == "" {
+        name, _ = claims["sub"].(string)
+    }
+    user := UserConfig{Name: OIDC_USER_PREFIX + name, Role: ROLE_SUBMITTER, DailyQuota: p.cfg.DailyQuota}
+    for _, admin := range p.cfg.AdminEmails {
+        if email != "" && strings.EqualFold(email, admin) {
+            user.Role = ROLE_ADMIN
+        }
+    }
+    if p.cfg.AdminGroup != "" && claimHas(claims[p.cfg.GroupsClaim], p.cfg.AdminGroup) {
+        user.Role = ROLE_ADMIN
+    }
+    if user.Role == ROLE_ADMIN {
+        user.DailyQuota = 0
+    }
+    return user
+}
+
+// User is the logged-in user of a request, from the session cookie or a
+// bearer ID token, or nil.
+func (p *OIDCProvider) User(r *http.Request) *UserConfig {
+    if cookie, err := r.Cookie(OIDC_SESSION_COOKIE); err == nil {
+        p.mu.Lock()
+        session, ok := p.sessions[cookie.Value]
+        p.mu.Unlock()
+        if ok && time.Now().Before(session.expires) {
+            return &session.user
+        }
+    }
+    // Config tokens never have dots, ID tokens always do
+    if token := bearerToken(r); strings.Count(token, ".") == 2 {
+        claims, err := p.verify(token, "")
+        if err != nil {
+            return nil
+        }
+        user := p.userFromClaims(claims)
+        return &user
+    }
+    return nil
+}
+
+// safeRedirect only allows going back to a page on the daemon's own host,
+// so /login can't be used to send people elsewhere.
+func (p *OIDCProvider) safeRedirect(target string) string {
+    u, err := url.Parse(target)
+    if err != nil || target == "" {
+        return "/"
+    }
+    own, _ := url.Parse(p.cfg.RedirectURL)
+    if u.Host == "" && strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") && !strings.HasPrefix(target, "/\\") {
+        return target
+    }
+    if (u.Scheme == "http" || u.Scheme == "https") && own != nil && u.Hostname() == own.Hostname() {
+        return target
+    }
+    return "/"
+}
+
+func (p *OIDCProvider) registerHandlers(mux *http.ServeMux) {
+    // /login?redirect=<page> starts a login and comes back to the page
+    mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+        d, err := p.discover()
+        if err != nil {
+            log.Printf("%v", err)
+            http.Error(w, "Login provider unavailable", http.StatusBadGateway)
+            return
+        }
+        state, nonce := randomHex(16), randomHex(16)
+        p.mu.Lock()
+        for id, login := range p.logins {
+            if time.Now().After(login.expires) {
+                delete(p.logins, id)
+            }
+        }
+        p.logins[state] = oidcLogin{nonce: nonce, redirect: p.safeRedirect(r.URL.Query().Get("redirect")), expires: time.Now().Add(OIDC_LOGIN_TTL)}
+        p.mu.Unlock()
+        query := url.Values{
+            "response_type": {"code"},
+            "client_id":     {p.cfg.ClientID},
+            "redirect_uri":  {p.cfg.RedirectURL},
+            "scope":         {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+            "state":         {state},
+            "nonce":         {nonce},
+        }
+        http.Redirect(w, r, d.AuthorizationEndpoint+"?"+query.Encode(), http.StatusFound)
+    })
+
+    mux.HandleFunc("/login/callback", func(w http.ResponseWriter, r *http.Request) {
+        state := r.URL.Query().Get("state")
+        p.mu.Lock()
+        login, ok := p.logins[state]
+        delete(p.logins, state)
+        p.mu.Unlock()
+        if !ok || time.Now().After(login.expires) {
+            http.Error(w, "Login expired, try again", http.StatusBadRequest)
+            return
+        }
+        if msg := r.URL.Query().Get("error"); msg != "" {
+            http.Error(w, "Login failed: "+msg, http.StatusUnauthorized)
+            return
+        }
+        claims, err := p.exchange(r.URL.Query().Get("code"), login.nonce)
+        if err != nil {
+            log.Printf("OIDC login failed: %v", err)
+            http.Error(w, "Login failed", http.StatusUnauthorized)
+            return
+        }
+        user := p.userFromClaims(claims)
+        id := randomHex(32)
+        p.mu.Lock()
+        for sid, session := range p.sessions {
+            if time.Now().After(session.expires) {
+                delete(p.sessions, sid)
+            }
+        }
+        p.sessions[id] = oidcSession{user: user, expires: time.Now().Add(OIDC_SESSION_TTL)}
+        p.mu.Unlock()
+        log.Printf("OIDC login: %s (%s)", user.Name, user.Role)
+        http.SetCookie(w, &http.Cookie{
+            Name:     OIDC_SESSION_COOKIE,
+            Value:    id,
+            Path:     "/",
+            MaxAge:   int(OIDC_SESSION_TTL.Seconds()),
+            HttpOnly: true,
+            Secure:   strings.HasPrefix(p.cfg.RedirectURL, "https://"),
+            SameSite: http.SameSiteLaxMode,
+        })
+        http.Redirect(w, r, login.redirect, http.StatusFound)
+    })
+
+    mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+        if cookie, err := r.Cookie(OIDC_SESSION_COOKIE); err == nil {
+            p.mu.Lock()
+            delete(p.sessions, cookie.Value)
+            p.mu.Unlock()
+        }
+        http.SetCookie(w, &http.Cookie{Name: OIDC_SESSION_COOKIE, Path: "/", MaxAge: -1})
+        http.Redirect(w, r, p.safeRedirect(r.URL.Query().Get("redirect")), http.StatusFound)
+    })
+}
+
+// exchange swaps a login's code for an ID token and verifies it.
+func (p *OIDCProvider) exchange(code, nonce string) (map[string]interface{}, error) {
+    if code == "" {
+        return nil, fmt.Errorf("no code in callback")
+    }
+    d, err := p.discover()
+    if err != nil {
+        return nil, err
+    }
+    form := url.Values{
+        "grant_type":   {"authorization_code"},
+        "code":         {code},
+        "redirect_uri": {p.cfg.RedirectURL},
+    }
+    req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+    resp, err := p.client.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("token request failed: %v", err)
+    }
+    defer resp.Body.Close()
+    var tokens struct {
+        IDToken string `json:"id_token"`
+        Error   string `json:"error"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+        return nil, fmt.Errorf("bad token response: %v", err)
+    }
+    if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+        return nil, fmt.Errorf("token request failed: %s %s", resp.Status, tokens.Error)
+    }
+    return p.verify(tokens.IDToken, nonce)
+}
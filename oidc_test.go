@@ -0,0 +1,68 @@
+//go:build daemon
+
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestUserFromClaims(t *testing.T) {
+    p := &OIDCProvider{cfg: &OIDCConfig{AdminEmails: []string{"admin@example.com"}, DailyQuota: 5}}
+    tests := []struct {
+        name   string
+        claims map[string]interface{}
+        want   UserConfig
+    }{
+        {"verified admin", map[string]interface{}{"email": "Admin@example.com", "email_verified": true, "sub": "1"},
+            UserConfig{Name: "oidc:Admin@example.com", Role: ROLE_ADMIN}},
+        {"unverified admin address", map[string]interface{}{"email": "admin@example.com", "preferred_username": "mallory", "sub": "2"},
+            UserConfig{Name: "oidc:mallory", Role: ROLE_SUBMITTER, DailyQuota: 5}},
+        {"email_verified as a string", map[string]interface{}{"email": "admin@example.com", "email_verified": "true", "sub": "3"},
+            UserConfig{Name: "oidc:3", Role: ROLE_SUBMITTER, DailyQuota: 5}},
+        {"verified submitter", map[string]interface{}{"email": "ada@example.com", "email_verified": true, "sub": "4"},
+            UserConfig{Name: "oidc:ada@example.com", Role: ROLE_SUBMITTER, DailyQuota: 5}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := p.userFromClaims(tt.claims); got != tt.want {
+                t.Errorf("got %+v, want %+v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestDiscoveryChecksIssuer(t *testing.T) {
+    var issuer string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]string{
+            "issuer":                 issuer,
+            "authorization_endpoint": "https://idp.example.com/authorize",
+            "token_endpoint":         "https://idp.example.com/token",
+            "jwks_uri":               "https://idp.example.com/jwks",
+        })
+    }))
+    defer server.Close()
+
+    tests := []struct {
+        name    string
+        issuer  string
+        wantErr bool
+    }{
+        {"same issuer", server.URL, false},
+        {"trailing slash", server.URL + "/", false},
+        {"other issuer", "https://idp.example.com", true},
+        {"no issuer", "", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            issuer = tt.issuer
+            p := newOIDCProvider(&OIDCConfig{Issuer: server.URL})
+            if _, err := p.discover(); (err != nil) != tt.wantErr {
+                t.Errorf("got error %v, want error %v", err, tt.wantErr)
+            }
+        })
+    }
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+// JobOptions is the JSON form of the per-job options, shared by batches,
+// queued jobs and the per-source defaults in the config. Zero values mean
+// "not set".
+type JobOptions struct {
+    Separator string     `json:"separator"`
+    TTL       string     `json:"ttl"`
+    Dither    DitherMode `json:"dither"`
+    Frames    bool       `json:"frames"`
+    Feed      string     `json:"feed"`
+    // FeedBefore is blank paper before the image, like feed after it
+    FeedBefore string `json:"feed_before"`
+    Threshold  int    `json:"threshold"`
+    TearLine   bool   `json:"tear_line"`
+    JobQR      bool   `json:"job_qr"`
+    // Header, footer and label for the stamps (see stamp.go)
+    Header  string `json:"header"`
+    Footer  string `json:"footer"`
+    Label   string `json:"label"`
+    Invert  bool   `json:"invert"`
+    FlipH   bool   `json:"flip_h"`
+    FlipV   bool   `json:"flip_v"`
+    Align   string `json:"align"`
+    Margin  int    `json:"margin"`
+    Density string `json:"density"`
+    Preset  string `json:"preset"`
+    // Poster strips, see poster.go
+    Poster        int  `json:"poster"`
+    PosterOverlap int  `json:"poster_overlap"`
+    PosterLabels  bool `json:"poster_labels"`
+    Scale         int  `json:"scale"`
+    // Crop is "x,y,w,h" (see crop.go)
+    Crop string `json:"crop"`
+    // Pipeline lists the image stages to run, in order (see pipeline.go)
+    Pipeline []string `json:"pipeline"`
+    Adjustments
+}
+
+// printOptions validates the options and converts them.
+func (o JobOptions) printOptions() (PrintOptions, error) {
+    opts := PrintOptions{
+        Separator: o.Separator,
+        TearLine:  o.TearLine,
+        JobQR:     o.JobQR,
+        Header:    o.Header,
+        Footer:    o.Footer,
+        Label:     o.Label,
+        Render: RenderOptions{
+            Dither:    o.Dither,
+            Frames:    o.Frames,
+            Threshold: o.Threshold,
+            Adjust:    o.Adjustments,
+            Invert:    o.Invert,
+            FlipH:     o.FlipH,
+            FlipV:     o.FlipV,
+            Align:     o.Align,
+            Margin:    o.Margin,
+            Scale:     o.Scale,
+            Density:   o.Density,
+            Preset:    o.Preset,
+            Pipeline:  o.Pipeline,
+
+            Poster:        o.Poster,
+            PosterOverlap: o.PosterOverlap,
+            PosterLabels:  o.PosterLabels,
+        },
+    }
+    if !validSeparator(o.Separator) {
+        return opts, fmt.Errorf("unknown separator %q", o.Separator)
+    }
+    if !validDither(o.Dither) {
+        return opts, fmt.Errorf("unknown dither mode %q (known: %s)", o.Dither, ditherModeList())
+    }
+    if !validThreshold(o.Threshold) {
+        return opts, fmt.Errorf("invalid threshold %d, want 1-255", o.Threshold)
+    }
+    if err := o.Adjustments.validate(); err != nil {
+        return opts, err
+    }
+    if !validAlign(o.Align) {
+        return opts, fmt.Errorf("unknown align %q, want left, center or right", o.Align)
+    }
+    if !validMargin(o.Margin) {
+        return opts, fmt.Errorf("invalid margin %d, want 0-%d", o.Margin, MAX_MARGIN)
+    }
+    if !validScale(o.Scale) {
+        return opts, fmt.Errorf("invalid scale %d, want 1-%d", o.Scale, MAX_SCALE)
+    }
+    if !validDensity(o.Density) {
+        return opts, fmt.Errorf("unknown density %q, want full or half", o.Density)
+    }
+    if !validPreset(o.Preset) {
+        return opts, fmt.Errorf("unknown preset %q (known: %s)", o.Preset, presetList())
+    }
+    if err := validPoster(o.Poster, o.PosterOverlap); err != nil {
+        return opts, err
+    }
+    if err := validPipeline(o.Pipeline); err != nil {
+        return opts, err
+    }
+    crop, err := parseCrop(o.Crop)
+    if err != nil {
+        return opts, err
+    }
+    opts.Render.Crop = crop
+    if err := validStamp(o.Header); err != nil {
+        return opts, fmt.Errorf("header: %v", err)
+    }
+    if err := validStamp(o.Footer); err != nil {
+        return opts, fmt.Errorf("footer: %v", err)
+    }
+    if o.TTL != "" {
+        ttl, err := time.ParseDuration(o.TTL)
+        if err != nil || ttl <= 0 {
+            return opts, fmt.Errorf("invalid ttl %q", o.TTL)
+        }
+        opts.TTL = ttl
+    }
+    feed, err := parseJobFeed(o.Feed)
+    if err != nil {
+        return opts, err
+    }
+    opts.FeedAfter = feed
+    if opts.FeedBefore, err = parseJobFeed(o.FeedBefore); err != nil {
+        return opts, fmt.Errorf("feed_before: %v", err)
+    }
+    return opts, nil
+}
+
+// withDefaults fills the options a job left unset from defaults.
+func (opts PrintOptions) withDefaults(defaults PrintOptions) PrintOptions {
+    if opts.Separator == "" {
+        opts.Separator = defaults.Separator
+    }
+    if opts.TTL == 0 {
+        opts.TTL = defaults.TTL
+    }
+    if opts.FeedAfter == 0 {
+        opts.FeedAfter = defaults.FeedAfter
+    }
+    if opts.FeedBefore == 0 {
+        opts.FeedBefore = defaults.FeedBefore
+    }
+    opts.TearLine = opts.TearLine || defaults.TearLine
+    opts.JobQR = opts.JobQR || defaults.JobQR
+    if opts.Header == "" {
+        opts.Header = defaults.Header
+    }
+    if opts.Footer == "" {
+        opts.Footer = defaults.Footer
+    }
+    if opts.Label == "" {
+        opts.Label = defaults.Label
+    }
+
+    r, d := &opts.Render, defaults.Render
+    if r.Dither == "" {
+        r.Dither = d.Dither
+    }
+    if r.Threshold == 0 {
+        r.Threshold = d.Threshold
+    }
+    if r.Align == ALIGN_FIT {
+        r.Align = d.Align
+    }
+    if r.Margin == 0 {
+        r.Margin = d.Margin
+    }
+    if r.Density == "" {
+        r.Density = d.Density
+    }
+    if r.Preset == "" {
+        r.Preset = d.Preset
+    }
+    if r.Intensity == 0 {
+        r.Intensity = d.Intensity
+    }
+    if r.Scale == 0 {
+        r.Scale = d.Scale
+    }
+    if r.Crop.Empty() {
+        r.Crop = d.Crop
+    }
+    if len(r.Pipeline) == 0 {
+        r.Pipeline = d.Pipeline
+    }
+    if r.Poster == 0 {
+        r.Poster = d.Poster
+        r.PosterOverlap = d.PosterOverlap
+        r.PosterLabels = r.PosterLabels || d.PosterLabels
+    }
+    r.Frames = r.Frames || d.Frames
+    r.Invert = r.Invert || d.Invert
+    r.FlipH = r.FlipH || d.FlipH
+    r.FlipV = r.FlipV || d.FlipV
+    r.Adjust = r.Adjust.or(d.Adjust)
+    return opts
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "regexp"
+    "time"
+)
+
+// Paper profiles: thermal paper varies a lot between brands and colours, so
+// the heat and speed that suit one roll print another too light or smudged.
+// "catprinter papertest" prints samples and saves the one picked as a named
+// profile in the paper store; "paper" in the config names the roll loaded:
+//
+//   "paper": "blue-sticker"
+//
+// The profile's heat is used for jobs that don't set one (their preset's
+// wins), and its speed slows every row down, never speeds it up past what
+// temperature throttling asks for.
+
+const (
+    DEFAULT_PAPER_STORE = "catprinter-papers.json"
+    // Slowest speed a sample or profile can ask for
+    MAX_PAPER_ROW_DELAY_MS = 100
+)
+
+var validPaperNames = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// PaperProfile is one saved paper.
+type PaperProfile struct {
+    // Intensity is the head heat, 1-255
+    Intensity int `json:"intensity"`
+    // RowDelayMs is a pause after every row, 0 for full speed; slower rows
+    // leave the head longer on the paper and print darker
+    RowDelayMs int       `json:"row_delay_ms"`
+    Created    time.Time `json:"created"`
+}
+
+func (p PaperProfile) validate() error {
+    if p.Intensity < 0 || p.Intensity > 255 {
+        return fmt.Errorf("paper intensity must be between 1 and 255")
+    }
+    if p.RowDelayMs < 0 || p.RowDelayMs > MAX_PAPER_ROW_DELAY_MS {
+        return fmt.Errorf("paper row delay must be between 0 and %dms", MAX_PAPER_ROW_DELAY_MS)
+    }
+    return nil
+}
+
+func (p PaperProfile) rowDelay() time.Duration {
+    return time.Duration(p.RowDelayMs) * time.Millisecond
+}
+
+// loadPapers reads the paper store; a missing file has no papers.
+func loadPapers(path string) (map[string]PaperProfile, error) {
+    papers := map[string]PaperProfile{}
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return papers, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read paper store: %v", err)
+    }
+    if err := json.Unmarshal(data, &papers); err != nil {
+        return nil, fmt.Errorf("invalid paper store %s: %v", path, err)
+    }
+    return papers, nil
+}
+
+// savePaper adds or replaces a paper in the store.
+func savePaper(path, name string, paper PaperProfile) error {
+    if !validPaperNames.MatchString(name) {
+        return fmt.Errorf("paper names are lowercase letters, digits, - and _ (up to 32)")
+    }
+    if err := paper.validate(); err != nil {
+        return err
+    }
+    papers, err := loadPapers(path)
+    if err != nil {
+        return err
+    }
+    papers[name] = paper
+    data, err := json.MarshalIndent(papers, "", "  ")
+    if err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0644); err != nil {
+        return fmt.Errorf("failed to write paper store: %v", err)
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        return fmt.Errorf("failed to write paper store: %v", err)
+    }
+    return nil
+}
+
+// resolvePaper loads the configured paper, if any.
+func (c *Config) resolvePaper() error {
+    if c.Paper == "" {
+        return nil
+    }
+    papers, err := loadPapers(c.PaperStore)
+    if err != nil {
+        return err
+    }
+    paper, ok := papers[c.Paper]
+    if !ok {
+        return fmt.Errorf("unknown paper %q, make one with catprinter papertest", c.Paper)
+    }
+    if err := paper.validate(); err != nil {
+        return fmt.Errorf("paper %s: %v", c.Paper, err)
+    }
+    c.paper = paper
+    return nil
+}
@@ -0,0 +1,156 @@
+//go:build !daemon
+
+package main
+
+import (
+    "bufio"
+    "flag"
+    "fmt"
+    "image"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// "catprinter papertest" finds the heat and speed for a new paper stock. It
+// prints one small sample for every heat and speed asked for, each labelled
+// with a code (A1, A2... B1...: the letter is the heat, the digit the
+// speed), then asks which looks best and saves it as a paper profile (see
+// papers.go).
+
+const (
+    DEFAULT_PAPERTEST_HEATS  = "112,144,176,208"
+    DEFAULT_PAPERTEST_SPEEDS = "0,10,20"
+    PAPERTEST_SAMPLE_TEXT    = "Aa Gg 0123 the quick brown fox"
+    MAX_PAPERTEST_HEATS      = 8
+    MAX_PAPERTEST_SPEEDS     = 5
+)
+
+type paperSample struct {
+    code  string
+    paper PaperProfile
+}
+
+// runPaperTest implements "catprinter papertest".
+func runPaperTest(args []string) {
+    fs := flag.NewFlagSet("papertest", flag.ExitOnError)
+    heats := fs.String("heats", DEFAULT_PAPERTEST_HEATS, "head heats to try, 1-255")
+    speeds := fs.String("speeds", DEFAULT_PAPERTEST_SPEEDS, fmt.Sprintf("row delays to try in ms, 0 (full speed) to %d", MAX_PAPER_ROW_DELAY_MS))
+    name := fs.String("name", "", "name to save the chosen sample under (asked for if not set)")
+    fs.Usage = func() {
+        fmt.Println("Usage: catprinter papertest [-heats 112,144,176,208] [-speeds 0,10,20] [-name blue-sticker] <printer-mac>")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        fs.Usage()
+        os.Exit(1)
+    }
+    heatList, err := parseIntList(*heats, 1, 255, MAX_PAPERTEST_HEATS)
+    if err != nil {
+        log.Printf("-heats: %v", err)
+        os.Exit(1)
+    }
+    speedList, err := parseIntList(*speeds, 0, MAX_PAPER_ROW_DELAY_MS, MAX_PAPERTEST_SPEEDS)
+    if err != nil {
+        log.Printf("-speeds: %v", err)
+        os.Exit(1)
+    }
+    cfg, err := loadConfig()
+    if err != nil {
+        log.Printf("Failed to load config: %v", err)
+        os.Exit(1)
+    }
+    text, err := newTextRenderer(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT)
+    if err != nil {
+        log.Printf("The paper test needs the text font for its labels: %v", err)
+        os.Exit(1)
+    }
+    // Samples are compared with each other, so nothing else on them
+    cfg.Header, cfg.Footer, cfg.JobQR, cfg.Receipts = "", "", false, false
+
+    var samples []paperSample
+    for i, heat := range heatList {
+        for j, speed := range speedList {
+            code := fmt.Sprintf("%c%d", 'A'+i, j+1)
+            samples = append(samples, paperSample{code: code, paper: PaperProfile{Intensity: heat, RowDelayMs: speed}})
+        }
+    }
+    fmt.Printf("Printing %d samples: letters are heats %s, digits row delays %s ms\n", len(samples), *heats, *speeds)
+    engine := NewEngine(fs.Arg(0), cfg)
+    opts := PrintOptions{Source: "papertest"}
+    for _, s := range samples {
+        // Each sample prints as if its paper were loaded
+        cfg.paper = s.paper
+        prepared := newPreparedImage("papertest "+s.code, renderPaperSample(text, s))
+        if err := engine.Print(engine.NewJob(opts.Source), prepared, opts); err != nil {
+            engine.Close()
+            log.Printf("Print failed: %v", err)
+            os.Exit(1)
+        }
+    }
+    engine.Close()
+
+    in := bufio.NewReader(os.Stdin)
+    var chosen *paperSample
+    for chosen == nil {
+        fmt.Print("Which sample looks best? (e.g. B2, empty to quit) ")
+        answer, _ := in.ReadString('\n')
+        answer = strings.ToUpper(strings.TrimSpace(answer))
+        if answer == "" {
+            return
+        }
+        for i := range samples {
+            if samples[i].code == answer {
+                chosen = &samples[i]
+            }
+        }
+        if chosen == nil {
+            fmt.Printf("There is no sample %s\n", answer)
+        }
+    }
+    for *name == "" {
+        fmt.Print("Name for this paper (e.g. blue-sticker): ")
+        answer, _ := in.ReadString('\n')
+        *name = strings.ToLower(strings.TrimSpace(answer))
+    }
+    chosen.paper.Created = time.Now()
+    if err := savePaper(cfg.PaperStore, *name, chosen.paper); err != nil {
+        log.Printf("%v", err)
+        os.Exit(1)
+    }
+    fmt.Printf("Saved %s (heat %d, row delay %dms) to %s\n", *name, chosen.paper.Intensity, chosen.paper.RowDelayMs, cfg.PaperStore)
+    fmt.Printf("To print with it, set \"paper\": %q in catprinter.json\n", *name)
+}
+
+// renderPaperSample draws one labelled sample: solid black to check for
+// smudging, gray steps and fine checkers to check detail, and some text.
+func renderPaperSample(text *textRenderer, s paperSample) image.Image {
+    label := fmt.Sprintf("%s  heat %d, row delay %dms", s.code, s.paper.Intensity, s.paper.RowDelayMs)
+    return stackSections([]image.Image{
+        text.render([]string{label}),
+        testPattern(TEST_PATTERN_ROWS, func(x, y int) bool { return true }),
+        blankCanvas(TEST_GAP_ROWS),
+        ditherImage(testRamp(TEST_RAMP_STEPS/2), DITHER_BAYER, DEFAULT_THRESHOLD),
+        testPattern(TEST_PATTERN_ROWS/2, func(x, y int) bool { return (x+y)%2 == 0 }),
+        text.render(text.wrap(PAPERTEST_SAMPLE_TEXT)),
+    })
+}
+
+// parseIntList parses "1,2,3", each between lo and hi, at most n of them.
+func parseIntList(value string, lo, hi, n int) ([]int, error) {
+    var out []int
+    for _, part := range strings.Split(value, ",") {
+        v, err := strconv.Atoi(strings.TrimSpace(part))
+        if err != nil || v < lo || v > hi {
+            return nil, fmt.Errorf("want numbers between %d and %d, separated by commas", lo, hi)
+        }
+        out = append(out, v)
+    }
+    if len(out) > n {
+        return nil, fmt.Errorf("at most %d values", n)
+    }
+    return out, nil
+}
@@ -0,0 +1,119 @@
+//go:build pdf
+
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/draw"
+    "image/png"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strconv"
+)
+
+// PDFs (receipts, shipping labels) are rasterized with poppler's pdftoppm,
+// which has to be installed separately (apt install poppler-utils). Pages
+// are rendered at the print head's 203 DPI and stacked top to bottom, so a
+// multi-page PDF prints its pages one after another.
+
+const (
+    PDFTOPPM = "pdftoppm"
+    // The head's resolution, 8 dots per millimetre
+    PDF_DPI = 203
+    // Pages past this are left out, a long PDF is rarely meant for a receipt roll
+    MAX_PDF_PAGES = 20
+)
+
+func init() {
+    image.RegisterFormat("pdf", "%PDF-", decodePDF, nil)
+    registerCapability(Capability{Name: "pdf", Description: "PDF documents via pdftoppm, every page printed in order", BuildTag: "pdf"})
+}
+
+func decodePDF(r io.Reader) (image.Image, error) {
+    dir, err := os.MkdirTemp("", "catprinter-pdf-")
+    if err != nil {
+        return nil, fmt.Errorf("failed to create temp dir: %v", err)
+    }
+    defer os.RemoveAll(dir)
+
+    in := filepath.Join(dir, "in.pdf")
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.WriteFile(in, data, 0600); err != nil {
+        return nil, fmt.Errorf("failed to write temp file: %v", err)
+    }
+
+    var output bytes.Buffer
+    cmd := exec.Command(PDFTOPPM, "-png", "-gray", "-r", strconv.Itoa(PDF_DPI),
+        "-l", strconv.Itoa(MAX_PDF_PAGES), in, filepath.Join(dir, "page"))
+    cmd.Stdout = &output
+    cmd.Stderr = &output
+    if err := cmd.Run(); err != nil {
+        return nil, fmt.Errorf("%s failed: %v: %s", PDFTOPPM, err, bytes.TrimSpace(output.Bytes()))
+    }
+
+    // page-1.png or page-01.png..., padded so name order is page order
+    files, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+    if err != nil {
+        return nil, err
+    }
+    if len(files) == 0 {
+        return nil, fmt.Errorf("pdf has no pages")
+    }
+    sort.Strings(files)
+
+    pages := make([]image.Image, 0, len(files))
+    for _, file := range files {
+        page, err := decodePDFPage(file)
+        if err != nil {
+            return nil, err
+        }
+        pages = append(pages, page)
+    }
+    return stackPages(pages), nil
+}
+
+// decodePDFPage reads one rendered page. Pages wider than the paper (A4,
+// letter) are scaled down right away so a long PDF doesn't hold every page
+// at full resolution.
+func decodePDFPage(path string) (image.Image, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    page, err := png.Decode(f)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read %s: %v", filepath.Base(path), err)
+    }
+    if page.Bounds().Dx() > PRINTER_WIDTH {
+        page = fitToWidth(page)
+    }
+    return page, nil
+}
+
+// stackPages puts pages one under the other on a white strip as wide as
+// the widest page.
+func stackPages(pages []image.Image) image.Image {
+    width, height := 0, 0
+    for _, page := range pages {
+        width = max(width, page.Bounds().Dx())
+        height += page.Bounds().Dy()
+    }
+    strip := image.NewGray(image.Rect(0, 0, width, height))
+    draw.Draw(strip, strip.Bounds(), image.White, image.Point{}, draw.Src)
+    y := 0
+    for _, page := range pages {
+        b := page.Bounds()
+        draw.Draw(strip, image.Rect(0, y, b.Dx(), y+b.Dy()), page, b.Min, draw.Src)
+        y += b.Dy()
+    }
+    return strip
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "sort"
+    "strings"
+)
+
+// The image pipeline. A decoded image goes through an ordered list of
+// stages before it is encoded into printer rows:
+//
+//   decode → crop → scale → layout → watermark → halve → adjust →
+//   dot-gain → dither → invert → flip → encode
+//
+// Decoding (which also turns photos upright, see exif.go) and encoding frame
+// the pipeline; every stage in between is a Processor, registered by name.
+// Stages with nothing to do for a job hand the image on untouched. A job can
+// list its own stages to reorder or skip them ("pipeline": ["layout",
+// "adjust", "dither"]), and new filters plug in with registerProcessor.
+// Posters run the stages before layout on the whole image and the rest on
+// every strip.
+
+// Processor is one stage of the image pipeline.
+type Processor interface {
+    Process(img image.Image, job *pipelineJob) (image.Image, error)
+}
+
+// ProcessorFunc lets a plain function be a Processor.
+type ProcessorFunc func(img image.Image, job *pipelineJob) (image.Image, error)
+
+func (f ProcessorFunc) Process(img image.Image, job *pipelineJob) (image.Image, error) {
+    return f(img, job)
+}
+
+// pipelineJob is what stages see: the printer they render for and the job's
+// options, which a stage may change for the stages after it.
+type pipelineJob struct {
+    pd     *PrinterDaemon
+    render RenderOptions
+}
+
+const (
+    STAGE_CROP      = "crop"
+    STAGE_SCALE     = "scale"
+    STAGE_LAYOUT    = "layout"
+    STAGE_WATERMARK = "watermark"
+    STAGE_HALVE     = "halve"
+    STAGE_ADJUST    = "adjust"
+    STAGE_DOT_GAIN  = "dot-gain"
+    STAGE_DITHER    = "dither"
+    STAGE_INVERT    = "invert"
+    STAGE_FLIP      = "flip"
+)
+
+// DEFAULT_PIPELINE is the order of stages for jobs that don't bring their own.
+var DEFAULT_PIPELINE = []string{STAGE_CROP, STAGE_SCALE, STAGE_LAYOUT, STAGE_WATERMARK, STAGE_HALVE, STAGE_ADJUST, STAGE_DOT_GAIN, STAGE_DITHER, STAGE_INVERT, STAGE_FLIP}
+
+var processors = map[string]Processor{}
+
+func registerProcessor(name string, p Processor) {
+    processors[name] = p
+}
+
+func init() {
+    registerProcessor(STAGE_CROP, ProcessorFunc(cropStage))
+    registerProcessor(STAGE_SCALE, ProcessorFunc(scaleStage))
+    registerProcessor(STAGE_LAYOUT, ProcessorFunc(layoutStage))
+    registerProcessor(STAGE_WATERMARK, ProcessorFunc(watermarkStage))
+    registerProcessor(STAGE_HALVE, ProcessorFunc(halveStage))
+    registerProcessor(STAGE_ADJUST, ProcessorFunc(adjustStage))
+    registerProcessor(STAGE_DOT_GAIN, ProcessorFunc(dotGainStage))
+    registerProcessor(STAGE_DITHER, ProcessorFunc(ditherStage))
+    registerProcessor(STAGE_INVERT, ProcessorFunc(invertStage))
+    registerProcessor(STAGE_FLIP, ProcessorFunc(flipStage))
+}
+
+func processorList() string {
+    names := make([]string, 0, len(processors))
+    for name := range processors {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return strings.Join(names, ", ")
+}
+
+// validPipeline checks a job's own list of stages; empty means the default.
+// Every image has to be fit to the paper, so layout can't be left out.
+func validPipeline(stages []string) error {
+    if len(stages) == 0 {
+        return nil
+    }
+    seen := map[string]bool{}
+    for _, name := range stages {
+        if _, ok := processors[name]; !ok {
+            return fmt.Errorf("unknown pipeline stage %q (known: %s)", name, processorList())
+        }
+        if seen[name] {
+            return fmt.Errorf("pipeline stage %q is listed twice", name)
+        }
+        seen[name] = true
+    }
+    if !seen[STAGE_LAYOUT] {
+        return fmt.Errorf("the pipeline needs the %s stage, which fits the image to the paper", STAGE_LAYOUT)
+    }
+    return nil
+}
+
+// parsePipeline reads a comma separated list of stages, as /print and the
+// CLI take them.
+func parsePipeline(s string) ([]string, error) {
+    if strings.TrimSpace(s) == "" {
+        return nil, nil
+    }
+    var stages []string
+    for _, name := range strings.Split(s, ",") {
+        stages = append(stages, strings.TrimSpace(name))
+    }
+    return stages, validPipeline(stages)
+}
+
+// stages returns the names of the job's stages, in order.
+func (pd *PrinterDaemon) stages(render RenderOptions) []string {
+    if len(render.Pipeline) > 0 {
+        return render.Pipeline
+    }
+    return DEFAULT_PIPELINE
+}
+
+// splitStages cuts a list of stages in front of name.
+func splitStages(stages []string, name string) ([]string, []string) {
+    for i, s := range stages {
+        if s == name {
+            return stages[:i], stages[i:]
+        }
+    }
+    return stages, nil
+}
+
+func runStages(img image.Image, job *pipelineJob, stages []string) (image.Image, error) {
+    for _, name := range stages {
+        var err error
+        if img, err = processors[name].Process(img, job); err != nil {
+            return nil, err
+        }
+    }
+    return img, nil
+}
+
+func cropStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    if job.render.Crop.Empty() {
+        return img, nil
+    }
+    return cropImage(img, job.render.Crop)
+}
+
+func scaleStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    if job.render.Scale <= 1 {
+        return img, nil
+    }
+    if job.render.Align == ALIGN_FIT && job.pd.config.Align == ALIGN_FIT {
+        // Scaled to fit, it would be resampled after all
+        job.render.Align = ALIGN_CENTER
+    }
+    return scaleUp(img, job.render.Scale)
+}
+
+func layoutStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    align := job.render.Align
+    if align == ALIGN_FIT {
+        align = job.pd.config.Align
+    }
+    margin := job.render.Margin
+    if margin == 0 {
+        margin = job.pd.config.Margin
+    }
+    if job.render.Poster > 1 {
+        // Poster strips are cut to the full width already
+        align, margin = ALIGN_FIT, 0
+    }
+    return layoutImage(img, align, margin), nil
+}
+
+func watermarkStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    if job.pd.config.Watermark == nil || job.render.Poster > 1 {
+        return img, nil
+    }
+    return job.pd.config.Watermark.apply(img), nil
+}
+
+func halveStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    if job.pd.density(job.render) != DENSITY_HALF {
+        return img, nil
+    }
+    // Dithered at half resolution, the encoder doubles every pixel
+    return halveWidth(img), nil
+}
+
+func adjustStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    return adjustImage(img, job.render.Adjust.or(job.pd.config.Adjustments)), nil
+}
+
+func dotGainStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    if !spreadsDots(job.pd.ditherMode(job.render)) {
+        return img, nil
+    }
+    return compensateDotGain(img, job.pd.config.profile.DotGain), nil
+}
+
+func ditherStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    threshold := job.render.Threshold
+    if threshold == 0 {
+        threshold = job.pd.config.Threshold
+    }
+    if threshold == 0 {
+        threshold = DEFAULT_THRESHOLD
+    }
+    return ditherImage(img, job.pd.ditherMode(job.render), threshold), nil
+}
+
+func invertStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    if !job.render.Invert {
+        return img, nil
+    }
+    // After dithering, so the dots are flipped exactly
+    return invertImage(img), nil
+}
+
+func flipStage(img image.Image, job *pipelineJob) (image.Image, error) {
+    if !job.render.FlipH && !job.render.FlipV {
+        return img, nil
+    }
+    // The whole paper width, so alignment and margins mirror too
+    return flipImage(img, job.render.FlipH, job.render.FlipV), nil
+}
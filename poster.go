@@ -0,0 +1,88 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "image/draw"
+    "slices"
+)
+
+// Poster mode cuts an image into vertical strips, each a full paper width,
+// and prints them one after another in a single job. Taped side by side the
+// strips make an image several times wider than the roll. Strips can overlap
+// a little, which leaves room for tape and hides small misalignments, and can
+// carry a "2/4" label so they are easy to put in order.
+
+const (
+    MAX_POSTER_STRIPS = 8
+    // 12mm, more than enough for tape
+    MAX_POSTER_OVERLAP = 12 * DOTS_PER_MM
+)
+
+func validPoster(strips, overlap int) error {
+    if strips < 0 || strips == 1 || strips > MAX_POSTER_STRIPS {
+        return fmt.Errorf("poster must be between 2 and %d strips", MAX_POSTER_STRIPS)
+    }
+    if overlap < 0 || overlap > MAX_POSTER_OVERLAP {
+        return fmt.Errorf("poster overlap must be between 0 and %d dots", MAX_POSTER_OVERLAP)
+    }
+    return nil
+}
+
+// posterStrips scales img to the poster's width and cuts it into strips.
+func posterStrips(img image.Image, strips, overlap int) []image.Image {
+    step := PRINTER_WIDTH - overlap
+    scaled := scaleToWidth(img, strips*step+overlap)
+    height := scaled.Bounds().Dy()
+    out := make([]image.Image, strips)
+    for i := range out {
+        strip := image.NewRGBA(image.Rect(0, 0, PRINTER_WIDTH, height))
+        draw.Draw(strip, strip.Bounds(), scaled, scaled.Bounds().Min.Add(image.Pt(i*step, 0)), draw.Src)
+        out[i] = strip
+    }
+    return out
+}
+
+// renderPoster prints img as poster strips, each run through the rest of the
+// job's pipeline like a normal image, with a label under each one and a cut
+// line between them.
+func (pd *PrinterDaemon) renderPoster(source string, img image.Image, job *pipelineJob, stages []string) (*preparedImage, error) {
+    render := job.render
+    var text *textRenderer
+    if render.PosterLabels {
+        var err error
+        if text, err = newTextRenderer(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT); err != nil {
+            return nil, err
+        }
+    }
+    cut, _ := separatorRows(SEPARATOR_SCISSORS)
+
+    out := &preparedImage{source: source, intensity: byte(render.Intensity), gray: pd.ditherMode(render) == DITHER_GRAY}
+    strips := posterStrips(img, render.Poster, render.PosterOverlap)
+    if render.FlipH {
+        // Each strip is mirrored when rendered; mirroring the whole poster
+        // also swaps their order
+        slices.Reverse(strips)
+    }
+    for i, strip := range strips {
+        rendered, err := runStages(strip, job, stages)
+        if err != nil {
+            return nil, err
+        }
+        rows := encodeImageRows(rendered)
+        switch {
+        case out.gray:
+            rows = encodeGrayRows(rendered)
+        case pd.density(render) == DENSITY_HALF:
+            rows = encodeHalfWidth(rendered)[:rendered.Bounds().Dy()*PRINTER_WIDTH_BYTES]
+        }
+        if text != nil {
+            rows = append(rows, out.encodeRows(encodeImageRows(text.render([]string{fmt.Sprintf("%d/%d", i+1, len(strips))})))...)
+        }
+        if i < len(strips)-1 {
+            rows = append(rows, out.encodeRows(cut)...)
+        }
+        out = appendRows(out, rows)
+    }
+    return out, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "sort"
+    "strings"
+)
+
+// Presets bundle the image options that suit a kind of content, so casual
+// users can pick "photo" instead of learning dithering, gamma and heat
+// levels. Options set explicitly on the job still win over the preset, and
+// the preset wins over the config defaults.
+
+var PRESETS = map[string]RenderOptions{
+    // Atkinson keeps highlights clean; photos print dark, so lift the
+    // midtones and heat a little less
+    "photo": {Dither: DITHER_ATKINSON, Adjust: Adjustments{Gamma: 1.4, Sharpen: 0.8}, Intensity: 0x90},
+    // Hard cut-off slightly above mid-gray keeps thin strokes of
+    // anti-aliased text, heated well for crisp glyphs
+    "text": {Dither: DITHER_THRESHOLD, Threshold: 150, Adjust: Adjustments{Sharpen: 1.5}, Intensity: 0xC0},
+    // Line art and sketches: a lower cut-off drops paper texture and faint
+    // pencil guide lines
+    "lineart": {Dither: DITHER_THRESHOLD, Threshold: 110, Adjust: Adjustments{Contrast: 20}},
+    // QR and barcodes: never dithered, kept at their size in the middle,
+    // printed as dark as the head goes so scanners read them
+    "qr": {Dither: DITHER_THRESHOLD, Threshold: DEFAULT_THRESHOLD, Align: ALIGN_CENTER, Intensity: 0xFF},
+}
+
+func validPreset(name string) bool {
+    if name == "" {
+        return true
+    }
+    _, ok := PRESETS[name]
+    return ok
+}
+
+// presetList is for usage and error messages.
+func presetList() string {
+    names := make([]string, 0, len(PRESETS))
+    for name := range PRESETS {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return strings.Join(names, ", ")
+}
+
+// withPreset fills the options a job left unset from its preset (or the
+// configured one).
+func (pd *PrinterDaemon) withPreset(render RenderOptions) RenderOptions {
+    name := render.Preset
+    if name == "" {
+        name = pd.config.Preset
+    }
+    preset, ok := PRESETS[name]
+    if !ok {
+        return render
+    }
+    if render.Dither == "" {
+        render.Dither = preset.Dither
+    }
+    if render.Threshold == 0 {
+        render.Threshold = preset.Threshold
+    }
+    if render.Align == ALIGN_FIT {
+        render.Align = preset.Align
+    }
+    if render.Intensity == 0 {
+        render.Intensity = preset.Intensity
+    }
+    render.Adjust = render.Adjust.or(preset.Adjust)
+    return render
+}
@@ -1,12 +1,16 @@
 #!/usr/bin/env node
 
 // MXW01 Cat Printer CLI for Raspberry Pi
-// Usage: node print.js "Your message here"
+// Usage: node print.js [--vertical] [--dir=ltr|rtl|auto] <printer-mac> "Your message here"
 
 const path = require('path');
 const { registerFont, createCanvas } = require('canvas');
 const { spawn } = require('child_process');
 const { shouldPrintDate } = require('./printTracker');
+const { t, formatDate } = require('./i18n');
+
+// Locale for dates and labels, e.g. "de-DE" (set by server.js from the browser)
+const LOCALE = process.env.CATPRINTER_LOCALE;
 
 // Register DotMatrix font if available
 const fontPath = path.join(__dirname, 'fonts', 'dotmatrix.ttf');
@@ -99,77 +103,118 @@ function prepareImageDataBuffer(imageRowsBool) {
   return buffer;
 }
 
-function textToImageRows(text, includeDateHeader = false) {
+// Right-to-left scripts: Hebrew, Arabic, Syriac, Thaana, N'Ko and their
+// presentation forms
+const RTL_CHARS = /[\u0590-\u08FF\uFB1D-\uFDFF\uFE70-\uFEFF]/;
+
+// "rtl" or "ltr"; anything else picks from the text itself
+function textDirection(text, requested) {
+  if (requested === 'rtl' || requested === 'ltr') return requested;
+  return RTL_CHARS.test(text) ? 'rtl' : 'ltr';
+}
+
+// Options:
+//   vertical:  rotate the text 90° so lines run along the paper, at a font
+//              size that fills the paper width (for long banners)
+//   direction: "ltr", "rtl" or "auto"
+function textToImageRows(text, includeDateHeader = false, options = {}) {
+  const direction = textDirection(text, options.direction);
+  const rtl = direction === 'rtl';
+
   // Render multi-line text to a canvas, using DotMatrix or monospace font
-  const fontSize = 18;
-  const lineHeight = 22;
-  
   let lines = text.split(/\r?\n/);
   
   // Add date footer if requested
   if (includeDateHeader) {
-    const today = new Date();
-    const dateStr = today.toLocaleDateString('en-US', { 
-      weekday: 'long', 
-      year: 'numeric', 
-      month: 'long', 
-      day: 'numeric' 
-    });
+    const dateStr = t(LOCALE, 'date_footer', { date: formatDate(LOCALE, new Date()) });
     lines = [...lines, '', dateStr]; // Add empty line, then date at the end
   }
+
+  // Vertical text uses the paper width as its line height budget
+  const fontSize = options.vertical ? Math.max(18, Math.floor(PRINTER_WIDTH / lines.length / 1.25)) : 18;
+  const lineHeight = options.vertical ? Math.round(fontSize * 1.2) : 22;
+  const font = `${fontSize}px DotMatrixBold, DotMatrix, monospace`;
+
+  let width = PRINTER_WIDTH;
+  if (options.vertical) {
+    const measureCtx = createCanvas(1, 1).getContext('2d');
+    measureCtx.font = font;
+    width = Math.ceil(Math.max(...lines.map(l => measureCtx.measureText(l).width))) + 20;
+  }
   
-  const canvas = createCanvas(PRINTER_WIDTH, lines.length * lineHeight + 20);
+  const canvas = createCanvas(width, lines.length * lineHeight + (options.vertical ? 0 : 20));
   const ctx = canvas.getContext('2d');
   ctx.fillStyle = 'white';
   ctx.fillRect(0, 0, canvas.width, canvas.height);
   ctx.fillStyle = 'black';
   ctx.textBaseline = 'top';
-  ctx.font = `${fontSize}px DotMatrixBold, DotMatrix, monospace`;
+  ctx.font = font;
+  ctx.direction = direction;
   
-  let y = 10;
+  let y = options.vertical ? 0 : 10;
   for (let i = 0; i < lines.length; i++) {
     const line = lines[i];
-    ctx.textAlign = 'left';
+    // RTL lines hang from the right edge
+    ctx.textAlign = rtl ? 'right' : 'left';
+    const x = rtl ? canvas.width : 0;
     
     // If this is the date footer (last line when includeDateHeader is true)
     if (includeDateHeader && i === lines.length - 1) {
       // Draw inverted background for date footer
       const textWidth = ctx.measureText(line).width;
       ctx.fillStyle = 'black';
-      ctx.fillRect(0, y - 2, textWidth + 4, lineHeight);
+      ctx.fillRect(rtl ? canvas.width - textWidth - 4 : 0, y - 2, textWidth + 4, lineHeight);
       ctx.fillStyle = 'white';
-      ctx.fillText(line, 2, y);
+      ctx.fillText(line, rtl ? x - 2 : 2, y);
       ctx.fillStyle = 'black'; // Reset for other text
     } else {
-      ctx.fillText(line, 0, y);
+      ctx.fillText(line, x, y);
     }
     y += lineHeight;
   }
 
-  let imageData = ctx.getImageData(0, 0, canvas.width, canvas.height);
-
-  // Rotate the image 180 degrees
-  const rotatedCanvas = createCanvas(canvas.width, canvas.height);
-  const rotatedCtx = rotatedCanvas.getContext('2d');
-  rotatedCtx.translate(canvas.width, canvas.height);
-  rotatedCtx.rotate(Math.PI);
-  rotatedCtx.drawImage(canvas, 0, 0);
+  let out;
+  if (options.vertical) {
+    // Turn the text so its baseline runs along the roll. The start of the
+    // text (left for LTR, right for RTL) comes out of the printer first.
+    out = createCanvas(PRINTER_WIDTH, canvas.width);
+    const outCtx = out.getContext('2d');
+    outCtx.fillStyle = 'white';
+    outCtx.fillRect(0, 0, out.width, out.height);
+    const margin = Math.floor((PRINTER_WIDTH - canvas.height) / 2);
+    if (rtl) {
+      outCtx.translate(margin, canvas.width);
+      outCtx.rotate(-Math.PI / 2);
+    } else {
+      outCtx.translate(margin + canvas.height, 0);
+      outCtx.rotate(Math.PI / 2);
+    }
+    outCtx.drawImage(canvas, 0, 0);
+  } else {
+    // Rotate the image 180 degrees
+    out = createCanvas(canvas.width, canvas.height);
+    const outCtx = out.getContext('2d');
+    outCtx.translate(canvas.width, canvas.height);
+    outCtx.rotate(Math.PI);
+    outCtx.drawImage(canvas, 0, 0);
+  }
 
   // Save debug PNG (returns a promise)
   const fs = require('fs');
-  const out = fs.createWriteStream('debug-receipt.png');
-  const stream = rotatedCanvas.createPNGStream();
+  const outFile = fs.createWriteStream('debug-receipt.png');
+  const stream = out.createPNGStream();
   const savePromise = new Promise((resolve, reject) => {
-    stream.pipe(out);
-    out.on('finish', () => {
+    stream.pipe(outFile);
+    outFile.on('finish', () => {
       console.log('[DEBUG] Saved debug-receipt.png');
       resolve();
     });
-    out.on('error', reject);
+    outFile.on('error', reject);
   });
 
+  const imageData = out.getContext('2d').getImageData(0, 0, out.width, out.height);
   const rows = [];
-  for (let y = 0; y < canvas.height; y++) {
+  for (let y = 0; y < out.height; y++) {
     const row = [];
     for (let x = 0; x < PRINTER_WIDTH; x++) {
       const idx = (y * PRINTER_WIDTH + x) * 4;
@@ -184,10 +229,25 @@ function textToImageRows(text, includeDateHeader = false) {
 }
 
 async function main() {
-  const [macAddr, ...msgParts] = process.argv.slice(2);
+  // Layout flags come before the MAC address; server.js passes them as env
+  const args = process.argv.slice(2);
+  const textOptions = {
+    vertical: process.env.CATPRINTER_VERTICAL === '1',
+    direction: process.env.CATPRINTER_DIRECTION || 'auto'
+  };
+  while (args.length && args[0].startsWith('--')) {
+    const flag = args.shift();
+    if (flag === '--vertical') textOptions.vertical = true;
+    else if (flag.startsWith('--dir=')) textOptions.direction = flag.slice('--dir='.length);
+    else {
+      console.error('Unknown option:', flag);
+      process.exit(1);
+    }
+  }
+  const [macAddr, ...msgParts] = args;
   const message = msgParts.join(' ');
   if (!macAddr || !message) {
-    console.error('Usage: node print.js <printer-mac-address> "Your message here"');
+    console.error('Usage: node print.js [--vertical] [--dir=ltr|rtl|auto] <printer-mac-address> "Your message here"');
     process.exit(1);
   }
   console.log('Rendering text to image...');
@@ -195,7 +255,7 @@ async function main() {
   if (includeDateHeader) {
     console.log('First print of the day - adding date footer');
   }
-  const { savePromise } = textToImageRows(message, includeDateHeader); // always saves as debug-receipt.png
+  const { savePromise } = textToImageRows(message, includeDateHeader, textOptions); // always saves as debug-receipt.png
   await savePromise;
   const imagePath = 'debug-receipt.png';
   console.log('Calling Go print worker...');
@@ -209,15 +269,22 @@ async function main() {
   const options = {
     hostname: "localhost",
     port: 8080,
-    path: `/print?image=${encodeURIComponent(imagePath)}`,
+    // Source and text let the daemon apply its per-source content filters
+    path: `/print?image=${encodeURIComponent(imagePath)}&source=${encodeURIComponent(process.env.CATPRINTER_SOURCE || "cli")}&text=${encodeURIComponent(message)}`,
     method: "POST",
     headers: {
       "Content-Type": "application/x-www-form-urlencoded",
-      "Content-Length": Buffer.byteLength(postData)
+      "Content-Length": Buffer.byteLength(postData),
+      // Abuse-protection proof forwarded by server.js, if any
+      ...JSON.parse(process.env.CATPRINTER_EXTRA_HEADERS || "{}")
     }
   };
   
   const req = http.request(options, (res) => {
+    if (res.headers["x-receipt-code"]) {
+      // server.js picks this line up and shows the code in the web UI
+      console.log(`Receipt code: ${res.headers["x-receipt-code"]}`);
+    }
     let data = "";
     res.on("data", (chunk) => {
       data += chunk;
@@ -225,6 +292,14 @@ async function main() {
     res.on("end", () => {
       if (res.statusCode === 200) {
         console.log("Print successful:", data);
+      } else if (res.statusCode === 202) {
+        // Gallery mode: held for an admin to approve
+        console.log("Submitted for approval:", data);
+        process.exit(3);
+      } else if (res.statusCode === 401 || res.statusCode === 429) {
+        // No login / quota used up, told apart by server.js
+        console.error(`Print refused with status ${res.statusCode}:`, data);
+        process.exit(res.statusCode === 401 ? 4 : 5);
       } else {
         console.error(`Print failed with status ${res.statusCode}:`, data);
       }
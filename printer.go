@@ -0,0 +1,741 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "image"
+    "log"
+    "slices"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/go-ble/ble"
+)
+
+type PrinterDaemon struct {
+    device      ble.Device
+    client      ble.Client
+    controlChar *ble.Characteristic
+    dataChar    *ble.Characteristic
+    notifyChar  *ble.Characteristic
+    macAddr     string
+    connected   bool
+    config      *Config
+
+    // jobMu serializes print jobs and raw commands on the shared connection
+    jobMu sync.Mutex
+    // Jobs waiting for jobMu, see workers.go
+    transmit transmitQueue
+    // Limits how many images render at once
+    renderers renderSlots
+
+    // Small jobs waiting to slip in between segments, see express.go
+    expressMu sync.Mutex
+    express   []*expressJob
+    // Failed writes since startup, guarded by jobMu; jobs watch it to notice
+    // a flaky link
+    writeErrors int
+
+    statusMu  sync.Mutex
+    status    PrinterStatus
+    hasStatus bool
+    // When the last notification of any kind arrived
+    lastHeard time.Time
+    // Settings read back on connect, if the model supports it
+    settings *PrinterSettings
+    // Rows printed since the printer last reported it was out of paper,
+    // i.e. roughly since the roll was changed
+    paperRows int
+
+    tapMu sync.Mutex
+    taps  []chan []byte
+
+    // A device or connection the embedding program handed us (see
+    // NewEngineWithDevice); they stay open when we are done with them
+    sharedDevice bool
+    sharedClient ble.Client
+
+    // Source of the last job printed, for separators between same-source jobs
+    lastSource string
+
+    jobs *JobTracker
+}
+
+// PrintOptions are per-job settings; the zero value prints the image as is.
+type PrintOptions struct {
+    // Source identifies who submitted the job (an integration name, a user...)
+    Source string
+    // Separator style printed before this job when the previous job came from
+    // the same source (or batch); empty uses the configured default
+    Separator string
+    // Receipt code stamped under the printout, if any (see receipt.go)
+    Receipt string
+    // Slot makes the job the current content of a named note, replacing
+    // the one printed there before (see slots.go)
+    Slot string
+    // Header and Footer replace the configured stamp templates, and Label
+    // is what they show as {{.Label}} (see stamp.go)
+    Header string
+    Footer string
+    Label  string
+    // JobQR prints a QR code with the job ID under the printout (also on
+    // for every job with the job_qr setting)
+    JobQR  bool
+    Render RenderOptions
+    // TTL drops the job if it can't start printing in time; zero uses the
+    // configured job_ttl
+    TTL time.Duration
+    // FeedAfter is blank lines fed after the job; zero uses the configured
+    // feed_after, FEED_NONE feeds nothing
+    FeedAfter int
+    // FeedBefore is the same for blank lines fed before the job
+    FeedBefore int
+    // TearLine prints a scissors line at the end of the job (also on for
+    // every job with the tear_line setting)
+    TearLine bool
+    // ReplyTo is passed back in the result webhook (see webhook.go)
+    ReplyTo string
+}
+
+// RenderOptions control how an image is turned into printer dots. They are
+// applied when the job is prepared; the zero value uses the config defaults.
+type RenderOptions struct {
+    Dither DitherMode
+    // Frames prints every frame of an animated GIF as a strip
+    Frames bool
+    // Threshold is the gray level (1-255) below which pixels print black
+    Threshold int
+    // Adjust is brightness, contrast and gamma; unset values use the config
+    Adjust Adjustments
+    // Invert prints a negative: black becomes white and white black
+    Invert bool
+    // FlipH mirrors the print left to right (iron-on transfers, prints read
+    // through glass); FlipV turns it upside down
+    FlipH bool
+    FlipV bool
+    // Align keeps narrow images at their size and places them left, center
+    // or right; empty scales to the paper width
+    Align string
+    // Margin is dots kept clear on both sides
+    Margin int
+    // Density is "full" or "half" (economy); empty uses the config
+    Density string
+    // Preset names a bundle of the options above (see presets.go)
+    Preset string
+    // Intensity is the print head heat (1-255); zero uses the default
+    Intensity int
+    // Poster cuts the image into this many strips printed one after another
+    // (see poster.go); PosterOverlap is how many dots neighbouring strips
+    // share, and PosterLabels numbers them
+    Poster        int
+    PosterOverlap int
+    PosterLabels  bool
+    // Scale enlarges the image by a whole number (2 = every pixel 2x2
+    // dots) without smoothing, for pixel art; 0 or 1 leaves it
+    Scale int
+    // Crop prints only this region of the image (see crop.go); empty for all
+    // of it
+    Crop image.Rectangle
+    // Pipeline lists the image stages in order (see pipeline.go); empty for
+    // the default
+    Pipeline []string
+}
+
+func NewPrinterDaemon(macAddr string, config *Config) *PrinterDaemon {
+    return &PrinterDaemon{
+        macAddr:   macAddr,
+        config:    config,
+        connected: false,
+        jobs:      NewJobTracker(),
+        renderers: newRenderSlots(config.renderWorkers()),
+    }
+}
+
+func (pd *PrinterDaemon) Connect() error {
+    client := pd.sharedClient
+    if client == nil {
+        // Open the adapter once (see device.go)
+        if pd.device == nil {
+            d, err := acquireDevice(pd.config.HCI)
+            if err != nil {
+                return fmt.Errorf("failed to create device: %v", err)
+            }
+            pd.device = d
+        }
+
+        // Connect to printer. Dialing through our own device rather than
+        // the package default leaves other go-ble users in the process alone.
+        ctx := ble.WithSigHandler(context.WithTimeout(context.Background(), 30*time.Second))
+        var err error
+        if client, err = pd.device.Dial(ctx, ble.NewAddr(pd.macAddr)); err != nil {
+            return fmt.Errorf("failed to connect: %v", err)
+        }
+    }
+
+    // Discover characteristics
+    prof, err := client.DiscoverProfile(true)
+    if err != nil {
+        pd.closeClient(client, nil)
+        return fmt.Errorf("failed to discover profile: %v", err)
+    }
+
+    var controlChar, dataChar, notifyChar *ble.Characteristic
+    for _, s := range prof.Services {
+        for _, c := range s.Characteristics {
+            if strings.HasSuffix(strings.ToLower(c.UUID.String()), "ae01") {
+                controlChar = c
+            }
+            if strings.HasSuffix(strings.ToLower(c.UUID.String()), "ae02") {
+                notifyChar = c
+            }
+            if strings.HasSuffix(strings.ToLower(c.UUID.String()), "ae03") {
+                dataChar = c
+            }
+        }
+    }
+
+    if controlChar == nil || dataChar == nil {
+        pd.closeClient(client, nil)
+        return fmt.Errorf("could not find required characteristics")
+    }
+
+    // Notifications are optional: without them we just can't throttle on temperature
+    if notifyChar != nil {
+        if err := client.Subscribe(notifyChar, false, pd.handleNotification); err != nil {
+            log.Printf("Failed to subscribe to notifications: %v", err)
+            notifyChar = nil
+        }
+    }
+
+    pd.client = client
+    pd.controlChar = controlChar
+    pd.dataChar = dataChar
+    pd.notifyChar = notifyChar
+    pd.connected = true
+
+    log.Printf("Connected to printer %s", pd.macAddr)
+    pd.readSettings()
+    return nil
+}
+
+func (pd *PrinterDaemon) ensureConnected() error {
+    if pd.connected {
+        if err := pd.probe(); err == nil {
+            return nil // Connection is healthy
+        }
+
+        // Connection is broken, reset state
+        log.Printf("Connection test failed, reconnecting...")
+        pd.Disconnect()
+    }
+
+    // Try to connect with retries
+    maxRetries := 3
+    for i := 0; i < maxRetries; i++ {
+        if err := pd.Connect(); err != nil {
+            log.Printf("Connection attempt %d failed: %v", i+1, err)
+            if i < maxRetries-1 {
+                time.Sleep(2 * time.Second)
+            }
+        } else {
+            return nil
+        }
+    }
+
+    return fmt.Errorf("failed to connect after %d attempts", maxRetries)
+}
+
+func (pd *PrinterDaemon) writeWithRetry(char *ble.Characteristic, data []byte) error {
+    maxRetries := 3
+    for i := 0; i < maxRetries; i++ {
+        err := pd.client.WriteCharacteristic(char, data, true)
+        if err == nil {
+            return nil
+        }
+
+        log.Printf("Write attempt %d failed: %v", i+1, err)
+        pd.writeErrors++
+
+        if i < maxRetries-1 {
+            // Try to reconnect before next attempt
+            if reconnectErr := pd.ensureConnected(); reconnectErr != nil {
+                return fmt.Errorf("failed to reconnect: %v", reconnectErr)
+            }
+            time.Sleep(1 * time.Second)
+        }
+    }
+
+    return fmt.Errorf("failed to write after %d attempts", maxRetries)
+}
+
+func (pd *PrinterDaemon) Disconnect() {
+    if pd.client != nil {
+        pd.closeClient(pd.client, pd.notifyChar)
+        pd.client = nil
+    }
+    // Clear characteristics to ensure fresh discovery on next connect
+    pd.controlChar = nil
+    pd.dataChar = nil
+    pd.notifyChar = nil
+    pd.connected = false
+    // What we heard says nothing about the next connection
+    pd.statusMu.Lock()
+    pd.lastHeard = time.Time{}
+    pd.statusMu.Unlock()
+    log.Printf("Disconnected from printer")
+}
+
+// closeClient ends a connection. One the caller handed us stays open for
+// them, only our notification subscription is dropped.
+func (pd *PrinterDaemon) closeClient(client ble.Client, notifyChar *ble.Characteristic) {
+    if client != pd.sharedClient {
+        client.CancelConnection()
+        return
+    }
+    if notifyChar != nil {
+        if err := client.Unsubscribe(notifyChar, false); err != nil {
+            log.Printf("Failed to unsubscribe from notifications: %v", err)
+        }
+    }
+}
+
+func (pd *PrinterDaemon) Stop() {
+    pd.Disconnect()
+    // A shared device is not ours to stop
+    if pd.device != nil && !pd.sharedDevice {
+        releaseDevice(pd.config.HCI)
+        pd.device = nil
+    }
+}
+
+// preparedImage is an image that has been loaded and encoded, ready to send.
+type preparedImage struct {
+    source  string
+    buffer  []byte
+    numRows int
+    // Print head heat, zero for DEFAULT_INTENSITY
+    intensity byte
+    // gray rows hold 4 bits per dot instead of 1 (see gray.go)
+    gray bool
+}
+
+func (p *preparedImage) rowBytes() int {
+    if p.gray {
+        return GRAY_ROW_BYTES
+    }
+    return PRINTER_WIDTH_BYTES
+}
+
+// rows returns the encoded rows without the padding after them.
+func (p *preparedImage) rows() []byte {
+    return p.buffer[:min(len(p.buffer), p.numRows*p.rowBytes())]
+}
+
+// with returns a copy of p holding other rows in the same format.
+func (p *preparedImage) with(buffer []byte, numRows int) *preparedImage {
+    return &preparedImage{source: p.source, intensity: p.intensity, gray: p.gray, buffer: buffer, numRows: numRows}
+}
+
+// encodeRows converts 1-bit rows (separators, stamps...) to p's format.
+func (p *preparedImage) encodeRows(rows []byte) []byte {
+    if p.gray {
+        return grayFromMono(rows)
+    }
+    return rows
+}
+
+// png previews p, dot for dot.
+func (p *preparedImage) png() ([]byte, error) {
+    return renderRowsPNG(p.buffer, p.numRows, p.gray)
+}
+
+func (pd *PrinterDaemon) prepareImage(imagePath string, render RenderOptions) (prepared *preparedImage, err error) {
+    pd.renderers.run(func() {
+        prepared, err = pd.renderPrepared(imagePath, render)
+    })
+    return prepared, err
+}
+
+func (pd *PrinterDaemon) renderPrepared(imagePath string, render RenderOptions) (*preparedImage, error) {
+    render = pd.withPreset(render)
+    if pd.ditherMode(render) == DITHER_GRAY {
+        if !pd.config.profile.Grayscale {
+            return nil, fmt.Errorf("the %s profile doesn't print grayscale (set \"grayscale\": true in its profile if the printer can)", pd.config.profile.Name)
+        }
+        if pd.density(render) == DENSITY_HALF {
+            return nil, fmt.Errorf("gray prints can't use the half density")
+        }
+    }
+    if pd.density(render) == DENSITY_HALF && !slices.Contains(pd.stages(render), STAGE_HALVE) {
+        return nil, fmt.Errorf("the half density needs the %s stage in the pipeline", STAGE_HALVE)
+    }
+    img, err := loadAndBinarizeImage(pd.config, imagePath, render)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load image: %v", err)
+    }
+    job := &pipelineJob{pd: pd, render: render}
+    whole, perStrip := splitStages(pd.stages(render), STAGE_LAYOUT)
+    if img, err = runStages(img, job, whole); err != nil {
+        return nil, err
+    }
+    if render.Poster > 1 {
+        return pd.renderPoster(imagePath, img, job, perStrip)
+    }
+    rendered, err := runStages(img, job, perStrip)
+    if err != nil {
+        return nil, err
+    }
+    var prepared *preparedImage
+    switch {
+    case pd.ditherMode(render) == DITHER_GRAY:
+        prepared = newGrayPreparedImage(imagePath, rendered)
+    case pd.density(render) == DENSITY_HALF:
+        prepared = newPreparedImage(imagePath, rendered)
+        prepared.buffer = encodeHalfWidth(rendered)
+    default:
+        prepared = newPreparedImage(imagePath, rendered)
+    }
+    prepared.intensity = byte(render.Intensity)
+    return prepared, nil
+}
+
+// density returns the job's density, or the configured one.
+func (pd *PrinterDaemon) density(render RenderOptions) string {
+    if render.Density != "" {
+        return render.Density
+    }
+    return pd.config.Density
+}
+
+// ditherMode returns the job's dither mode, or the configured one.
+func (pd *PrinterDaemon) ditherMode(render RenderOptions) DitherMode {
+    if render.Dither != "" {
+        return render.Dither
+    }
+    return pd.config.Dither
+}
+
+// newPreparedImage encodes an image rendered in memory (a banner, a card...).
+// source is only used for logging.
+func newPreparedImage(source string, img image.Image) *preparedImage {
+    return &preparedImage{
+        source:  source,
+        buffer:  encodeImageToBuffer(img),
+        numRows: img.Bounds().Dy(),
+    }
+}
+
+func (pd *PrinterDaemon) PrintImage(imagePath string, opts PrintOptions) error {
+    return pd.PrintImages([]string{imagePath}, opts)
+}
+
+// PrintImages prints several images stacked into one job (see concat.go).
+func (pd *PrinterDaemon) PrintImages(imagePaths []string, opts PrintOptions) error {
+    jobID := pd.jobs.New(opts.Source)
+    pd.jobs.Set(jobID, JobRendering, nil)
+
+    // Load and process images before touching the printer
+    prepared, err := pd.prepareImages(imagePaths, opts.Render)
+    if err != nil {
+        pd.jobs.Finish(jobID, err)
+        return err
+    }
+    return pd.PrintJob(jobID, prepared, opts)
+}
+
+// PrintPrepared prints an image that was loaded earlier, e.g. one that sat in
+// the moderation queue.
+func (pd *PrinterDaemon) PrintPrepared(prepared *preparedImage, opts PrintOptions) error {
+    return pd.PrintJob(pd.jobs.New(opts.Source), prepared, opts)
+}
+
+// PrintJob prints a prepared image under an existing job ID, recording every
+// state change in the job tracker.
+func (pd *PrinterDaemon) PrintJob(jobID string, prepared *preparedImage, opts PrintOptions) error {
+    pd.jobs.SetReceipt(jobID, opts.Receipt)
+    pd.jobs.SetSlot(jobID, opts.Slot)
+    if opts.ReplyTo != "" {
+        pd.jobs.SetReply(jobID, opts.ReplyTo, prepared)
+    }
+    pd.jobs.SetRows(jobID, prepared.numRows)
+    pd.jobs.Set(jobID, JobQueued, nil)
+    if ttl := pd.jobTTL(opts); ttl > 0 {
+        pd.jobs.SetExpiry(jobID, time.Now().Add(ttl))
+    }
+
+    if pd.isExpress(prepared) {
+        err := pd.queueExpress(jobID, prepared, opts)
+        pd.finishJob(jobID, prepared, opts, err)
+        return err
+    }
+
+    pd.lockForJob()
+    defer pd.jobMu.Unlock()
+
+    err := pd.runJob(jobID, prepared, opts)
+    pd.finishJob(jobID, prepared, opts, err)
+    return err
+}
+
+// finishJob records a job's outcome, and for a printed job with a slot,
+// its new content.
+func (pd *PrinterDaemon) finishJob(jobID string, prepared *preparedImage, opts PrintOptions, err error) {
+    pd.jobs.Finish(jobID, err)
+    if err == nil && opts.Slot != "" {
+        pd.fillSlot(opts.Slot, jobID, prepared)
+    }
+}
+
+func (pd *PrinterDaemon) runJob(jobID string, prepared *preparedImage, opts PrintOptions) error {
+    select {
+    case <-pd.jobs.Canceled(jobID):
+        return errJobCanceled
+    default:
+    }
+    if pd.jobs.Expired(jobID) {
+        return errJobExpired
+    }
+
+    prepared, err := pd.decorate(jobID, prepared, opts)
+    if err != nil {
+        return err
+    }
+
+    // Always try to ensure we're connected
+    pd.jobs.Set(jobID, JobConnecting, nil)
+    if err := pd.connectForJob(jobID); err != nil {
+        if pd.config.Offline != OFFLINE_FILE || err == errJobCanceled || err == errJobExpired {
+            return err
+        }
+        log.Printf("%v", err)
+        return pd.spoolJob(jobID, prepared)
+    }
+    // Ensure we always let go of the connection at the end of a job, even on
+    // errors
+    defer pd.releaseConnection()
+    defer pd.watchJob(jobID)()
+    if pd.jobs.Expired(jobID) {
+        // Connecting took long enough that the job is no longer wanted
+        return errJobExpired
+    }
+
+    if err := pd.printPrepared(jobID, prepared); err != nil {
+        return err
+    }
+    pd.lastSource = opts.Source
+    return nil
+}
+
+func (pd *PrinterDaemon) jobTTL(opts PrintOptions) time.Duration {
+    if opts.TTL > 0 {
+        return opts.TTL
+    }
+    return pd.config.jobTTL
+}
+
+func (pd *PrinterDaemon) separatorStyle(opts PrintOptions) string {
+    if opts.Separator != "" {
+        return opts.Separator
+    }
+    return pd.config.Separator
+}
+
+// printWarm prints an untracked image and leaves the connection open for
+// the next one (see console.go).
+func (pd *PrinterDaemon) printWarm(prepared *preparedImage) error {
+    pd.jobMu.Lock()
+    defer pd.jobMu.Unlock()
+
+    if err := pd.ensureConnected(); err != nil {
+        return fmt.Errorf("failed to connect: %v", err)
+    }
+    if err := pd.printPrepared("", prepared); err != nil {
+        pd.Disconnect()
+        return err
+    }
+    return nil
+}
+
+// disconnectIdle drops a connection left open by printWarm, unless a job is
+// using it.
+func (pd *PrinterDaemon) disconnectIdle() {
+    if !pd.jobMu.TryLock() {
+        return
+    }
+    defer pd.jobMu.Unlock()
+    if pd.connected {
+        pd.Disconnect()
+    }
+}
+
+// decorate adds what goes around a job's image: the header and footer, the
+// receipt code, the job QR, feeds, the separator from the previous job and
+// the tear line.
+func (pd *PrinterDaemon) decorate(jobID string, prepared *preparedImage, opts PrintOptions) (*preparedImage, error) {
+    prepared, err := pd.annotate(jobID, prepared, opts)
+    if err != nil {
+        return nil, err
+    }
+    prepared = withFeedBefore(prepared, pd.feedBeforeRows(opts))
+    if opts.Source != "" && opts.Source == pd.lastSource {
+        if prepared, err = withSeparator(prepared, pd.separatorStyle(opts)); err != nil {
+            return nil, err
+        }
+    }
+    if opts.TearLine || pd.config.TearLine {
+        prepared = withTearLine(prepared)
+    }
+    return withFeed(prepared, pd.feedRows(opts)), nil
+}
+
+// annotate adds what tells a job apart on paper: the header and footer, the
+// receipt code and the job QR. Batches (batch.go) do the rest of decorate
+// themselves, once for the whole batch.
+func (pd *PrinterDaemon) annotate(jobID string, prepared *preparedImage, opts PrintOptions) (*preparedImage, error) {
+    prepared, err := pd.withStamps(jobID, prepared, opts)
+    if err != nil {
+        return nil, err
+    }
+    if opts.Receipt != "" {
+        prepared = withReceipt(prepared, opts.Receipt)
+    }
+    if opts.JobQR || pd.config.JobQR {
+        if prepared, err = pd.withJobQR(jobID, prepared); err != nil {
+            return nil, err
+        }
+    }
+    return prepared, nil
+}
+
+// dryRun is runJob up to the point where the printer comes in. No
+// separator, there is no previous job to separate from.
+func (pd *PrinterDaemon) dryRun(refs []string, opts PrintOptions) ([]byte, error) {
+    prepared, err := pd.prepareImages(refs, opts.Render)
+    if err != nil {
+        return nil, err
+    }
+    opts.Source = ""
+    if prepared, err = pd.decorate("", prepared, opts); err != nil {
+        return nil, err
+    }
+    return prepared.png()
+}
+
+// watchJob starts the job's max_job_duration clock, which aborts the job
+// if it runs over. Call the returned func once the job is done.
+func (pd *PrinterDaemon) watchJob(jobID string) func() {
+    if pd.config.maxJobDuration <= 0 {
+        return func() {}
+    }
+    timer := time.AfterFunc(pd.config.maxJobDuration, func() {
+        pd.jobs.TimeOut(jobID)
+    })
+    return func() { timer.Stop() }
+}
+
+// printPrepared sends one image over an already established connection.
+// Callers must hold jobMu.
+func (pd *PrinterDaemon) printPrepared(jobID string, prepared *preparedImage) error {
+    if err := pd.printSegments(jobID, prepared); err != nil {
+        return err
+    }
+    pd.statusMu.Lock()
+    pd.paperRows += prepared.numRows
+    pd.statusMu.Unlock()
+    log.Printf("Print job %s completed successfully", jobID)
+    return nil
+}
+
+func (pd *PrinterDaemon) handleNotification(data []byte) {
+    pd.statusMu.Lock()
+    pd.lastHeard = time.Now()
+    pd.statusMu.Unlock()
+
+    // Record a status before anyone waiting on it sees the reply, so the
+    // job's throttle reads the temperature it just asked for
+    if cmd, payload, ok := pd.config.profile.Framing.Decode(data); ok && cmd == CMD_GET_STATUS {
+        if status, ok := parseStatusPayload(payload); ok {
+            pd.statusMu.Lock()
+            pd.status = status
+            pd.hasStatus = true
+            if status.PaperOut() {
+                pd.paperRows = 0
+            }
+            pd.statusMu.Unlock()
+        }
+    }
+
+    pd.tapMu.Lock()
+    for _, tap := range pd.taps {
+        select {
+        case tap <- append([]byte(nil), data...):
+        default:
+            // Slow reader, drop rather than block the BLE stack
+        }
+    }
+    pd.tapMu.Unlock()
+}
+
+func (pd *PrinterDaemon) lastStatus() string {
+    pd.statusMu.Lock()
+    defer pd.statusMu.Unlock()
+    if !pd.hasStatus {
+        return "no status"
+    }
+    return pd.status.String()
+}
+
+// throttleDelay returns the extra delay to insert after each row based on the
+// most recent head temperature. It ramps linearly from zero at StartTemp to
+// MaxRowDelay at MaxTemp, so we back off well before the overheat cutoff.
+// With no throttle configured it is always zero.
+func (pd *PrinterDaemon) throttleDelay() time.Duration {
+    pd.statusMu.Lock()
+    status, ok := pd.status, pd.hasStatus
+    pd.statusMu.Unlock()
+
+    t := pd.config.Throttle
+    if !ok || t.MaxTemp <= t.StartTemp || time.Since(status.Received) > time.Minute {
+        return 0
+    }
+    temp := int(status.Temperature)
+    if temp <= t.StartTemp {
+        return 0
+    }
+    maxDelay := time.Duration(t.MaxRowDelayMs) * time.Millisecond
+    if temp >= t.MaxTemp {
+        return maxDelay
+    }
+    return maxDelay * time.Duration(temp-t.StartTemp) / time.Duration(t.MaxTemp-t.StartTemp)
+}
+
+// tapNotifications returns a channel receiving a copy of every notification
+// until the returned cancel func is called.
+func (pd *PrinterDaemon) tapNotifications() (<-chan []byte, func()) {
+    ch := make(chan []byte, 32)
+    pd.tapMu.Lock()
+    pd.taps = append(pd.taps, ch)
+    pd.tapMu.Unlock()
+
+    return ch, func() {
+        pd.tapMu.Lock()
+        defer pd.tapMu.Unlock()
+        for i, tap := range pd.taps {
+            if tap == ch {
+                pd.taps = append(pd.taps[:i], pd.taps[i+1:]...)
+                break
+            }
+        }
+    }
+}
+
+// command frames a control command for the configured printer model.
+func (pd *PrinterDaemon) command(cmdID byte, payload []byte) []byte {
+    return pd.config.profile.Framing.Encode(cmdID, payload)
+}
+
+// packet frames a packet built with the helpers in protocol.go.
+func (pd *PrinterDaemon) packet(p Packet) []byte {
+    return pd.config.profile.Framing.EncodePacket(p)
+}
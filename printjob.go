@@ -0,0 +1,461 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "time"
+)
+
+// A print job runs as a small state machine driven by notifications and
+// timeouts:
+//
+//   SetEnergy -> CheckStatus (A1 ack) -> PrintRequest (A9 ack) ->
+//   Transfer (paced, honours flow control) -> Flush -> AwaitComplete (AA) -> Done
+//
+// Every wait has a timeout with a fallback so printers that stay quiet still
+// print, they just don't get the early error reporting. The tracker sees the
+// coarser JobState: connecting until the print request is accepted, then
+// transferring (or cooling while throttled), then finishing.
+
+type printState int
+
+const (
+    stateSetEnergy printState = iota
+    stateCheckStatus
+    statePrintRequest
+    stateTransfer
+    stateFlush
+    stateAwaitComplete
+    stateDone
+)
+
+func (s printState) String() string {
+    switch s {
+    case stateSetEnergy:
+        return "set-energy"
+    case stateCheckStatus:
+        return "check-status"
+    case statePrintRequest:
+        return "print-request"
+    case stateTransfer:
+        return "transfer"
+    case stateFlush:
+        return "flush"
+    case stateAwaitComplete:
+        return "await-complete"
+    case stateDone:
+        return "done"
+    default:
+        return fmt.Sprintf("state(%d)", int(s))
+    }
+}
+
+const (
+    // How long to wait for an acknowledgment notification before falling back
+    ACK_TIMEOUT = 3 * time.Second
+    // Settle time used instead of an ack when the printer can't notify us
+    NO_ACK_DELAY = 1 * time.Second
+    // Pacing between 20-byte data chunks; AE03 is write-without-response so
+    // this is the only thing keeping the printer's buffer from overflowing
+    CHUNK_INTERVAL = 5 * time.Millisecond
+    // How long a flow-control pause may last before we give up on the job
+    FLOW_PAUSE_TIMEOUT = 10 * time.Second
+    // Time allowed for the physical print after flush: base plus per row
+    COMPLETE_TIMEOUT_BASE    = 10 * time.Second
+    COMPLETE_TIMEOUT_PER_ROW = 10 * time.Millisecond
+    // Settle time after flush when the printer can't notify us
+    NO_NOTIFY_COMPLETE_DELAY = 2 * time.Second
+    // While out of paper, how often to re-check and how long to wait at most
+    PAPER_POLL_INTERVAL = 2 * time.Second
+    PAPER_OUT_TIMEOUT   = 10 * time.Minute
+    // Data is written in chunks of this size, 20 bytes being what fits in
+    // one write on the default ATT MTU
+    CHUNK_SIZE = 20
+    // After this many link errors (failed writes, corrupt notifications,
+    // error statuses) the rest of the job is sent in smaller chunks at a
+    // slower pace: slower, but a flaky link is far more likely to deliver
+    // the whole print
+    DEGRADE_AFTER_ERRORS    = 3
+    DEGRADED_CHUNK_SIZE     = 10
+    DEGRADED_CHUNK_INTERVAL = 20 * time.Millisecond
+    // Paper fed after a job is aborted for running too long, and how long
+    // that feed may take before we give up on it too
+    ABORT_FEED_ROWS    = 10 * DOTS_PER_MM
+    ABORT_FEED_TIMEOUT = 30 * time.Second
+)
+
+var errAckTimeout = errors.New("timed out waiting for acknowledgment")
+
+type printJob struct {
+    pd        *PrinterDaemon
+    jobID     string
+    buffer    []byte
+    numRows   int
+    intensity byte
+    gray      bool
+
+    notifications <-chan []byte
+    canceled      <-chan struct{}
+
+    // Link errors seen during this job, and whether we slowed down for them
+    linkErrors int
+    degraded   bool
+    // Set on the blank feed printed after a timed-out job, which must not
+    // trigger another one
+    afterAbort bool
+}
+
+func newPrintJob(pd *PrinterDaemon, jobID string, prepared *preparedImage) *printJob {
+    intensity := prepared.intensity
+    if intensity == 0 {
+        intensity = byte(pd.config.paper.Intensity)
+    }
+    if intensity == 0 {
+        intensity = DEFAULT_INTENSITY
+    }
+    return &printJob{
+        pd:        pd,
+        jobID:     jobID,
+        buffer:    prepared.buffer,
+        numRows:   prepared.numRows,
+        intensity: intensity,
+        gray:      prepared.gray,
+        canceled:  pd.jobs.Canceled(jobID),
+    }
+}
+
+func (j *printJob) run() error {
+    // One tap for the whole job so no ack can arrive between states unseen
+    notifications, cancel := j.pd.tapNotifications()
+    defer cancel()
+    j.notifications = notifications
+
+    state := stateSetEnergy
+    for state != stateDone {
+        select {
+        case <-j.canceled:
+            return j.abort()
+        default:
+        }
+        next, err := j.step(state)
+        if err == errJobCanceled {
+            return j.abort()
+        }
+        if err == errJobExpired {
+            return err
+        }
+        if err != nil {
+            return fmt.Errorf("%s: %v", state, err)
+        }
+        if next != state {
+            log.Printf("Print job: %s -> %s", state, next)
+        }
+        state = next
+    }
+    return nil
+}
+
+func (j *printJob) step(state printState) (printState, error) {
+    switch state {
+    case stateSetEnergy:
+        // The printer doesn't acknowledge A2, the status check below does
+        if err := j.pd.writeWithRetry(j.pd.controlChar, j.pd.packet(setIntensity(j.intensity))); err != nil {
+            return state, fmt.Errorf("failed to write set intensity: %v", err)
+        }
+        return stateCheckStatus, nil
+
+    case stateCheckStatus:
+        payload, err := j.request(statusRequest(), ACK_TIMEOUT)
+        if err == errAckTimeout {
+            log.Printf("No status response, continuing anyway")
+        } else if err != nil {
+            return state, fmt.Errorf("failed to request status: %v", err)
+        } else if status, ok := parseStatusPayload(payload); ok && !status.OK {
+            if !status.PaperOut() {
+                return state, fmt.Errorf("printer not ready: %s", status)
+            }
+            if err := j.waitForPaper(); err != nil {
+                return state, err
+            }
+        }
+        return statePrintRequest, nil
+
+    case statePrintRequest:
+        mode := PRINT_MODE_1BPP
+        if j.gray {
+            mode = PRINT_MODE_4BPP
+        }
+        payload, err := j.request(printRequest(j.numRows, mode), ACK_TIMEOUT)
+        if err == errAckTimeout {
+            log.Printf("No print request acknowledgment, continuing anyway")
+        } else if err != nil {
+            return state, fmt.Errorf("failed to write print request: %v", err)
+        } else if len(payload) > 0 && payload[0] != STATUS_OK {
+            return state, fmt.Errorf("printer rejected print request (status 0x%02X)", payload[0])
+        }
+        j.pd.jobs.Set(j.jobID, JobTransferring, nil)
+        return stateTransfer, nil
+
+    case stateTransfer:
+        if err := j.transfer(); err != nil {
+            return state, err
+        }
+        return stateFlush, nil
+
+    case stateFlush:
+        j.pd.jobs.Set(j.jobID, JobFinishing, nil)
+        if err := j.pd.writeWithRetry(j.pd.controlChar, j.pd.packet(flushRequest())); err != nil {
+            return state, fmt.Errorf("failed to write flush: %v", err)
+        }
+        return stateAwaitComplete, nil
+
+    case stateAwaitComplete:
+        if j.pd.notifyChar == nil {
+            <-time.After(NO_NOTIFY_COMPLETE_DELAY)
+            return stateDone, nil
+        }
+        timeout := COMPLETE_TIMEOUT_BASE + time.Duration(j.numRows)*COMPLETE_TIMEOUT_PER_ROW
+        if _, err := j.await(CMD_PRINT_COMPLETE, timeout); err == errAckTimeout {
+            log.Printf("No print complete notification after %v, assuming done", timeout)
+        }
+        return stateDone, nil
+    }
+    return state, fmt.Errorf("unknown state %d", int(state))
+}
+
+// request writes a control command and waits for the reply with the same ID.
+func (j *printJob) request(p Packet, timeout time.Duration) ([]byte, error) {
+    if err := j.pd.writeWithRetry(j.pd.controlChar, j.pd.packet(p)); err != nil {
+        return nil, err
+    }
+    if j.pd.notifyChar == nil {
+        <-time.After(NO_ACK_DELAY)
+        return nil, errAckTimeout
+    }
+    return j.await(p.Cmd, timeout)
+}
+
+func (j *printJob) await(cmdID byte, timeout time.Duration) ([]byte, error) {
+    deadline := time.After(timeout)
+    for {
+        select {
+        case n := <-j.notifications:
+            if cmd, reply, ok := j.pd.config.profile.Framing.Decode(n); ok && cmd == cmdID {
+                return reply, nil
+            }
+        case <-deadline:
+            return nil, errAckTimeout
+        }
+    }
+}
+
+// transfer streams the image rows in 20-byte chunks (smaller once the link
+// turns out to be flaky). Between chunks it reacts
+// to notifications: a status error aborts the job, and an AE flow-control
+// packet (0x10 = pause, 0x00 = resume, as on the GB-series firmwares) holds
+// the stream until the printer is ready again.
+func (j *printJob) transfer() error {
+    pacer := time.NewTicker(CHUNK_INTERVAL)
+    defer pacer.Stop()
+
+    // The loaded paper's speed, or slower when the head runs hot. The
+    // temperature comes from the check-status reply; asking again mid-raster
+    // isn't safe on every model, so a long print re-reads it between
+    // segments (see split.go).
+    paperDelay := j.pd.config.paper.rowDelay()
+    throttle := j.pd.throttleDelay()
+    if throttle > 0 {
+        log.Printf("Row delay %v (%s)", throttle, j.pd.lastStatus())
+        j.pd.jobs.Set(j.jobID, JobCooling, nil)
+    }
+    chunkSize := CHUNK_SIZE
+    writeErrors := j.pd.writeErrors
+    rowBytes := PRINTER_WIDTH_BYTES
+    if j.gray {
+        rowBytes = GRAY_ROW_BYTES
+    }
+    for i := 0; i < len(j.buffer); i += rowBytes {
+        if !j.degraded && j.linkErrors+j.pd.writeErrors-writeErrors >= DEGRADE_AFTER_ERRORS {
+            log.Printf("Link looks flaky (%d errors), slowing down for the rest of the job", j.linkErrors+j.pd.writeErrors-writeErrors)
+            j.degraded = true
+            chunkSize = DEGRADED_CHUNK_SIZE
+            pacer.Reset(DEGRADED_CHUNK_INTERVAL)
+        }
+        row := j.buffer[i : i+rowBytes]
+        for c := 0; c < rowBytes; c += chunkSize {
+            end := c + chunkSize
+            if end > rowBytes {
+                end = rowBytes
+            }
+            if err := j.waitForSlot(pacer.C); err != nil {
+                return err
+            }
+            if err := j.pd.writeWithRetry(j.pd.dataChar, row[c:end]); err != nil {
+                return fmt.Errorf("failed to write image data sub-chunk: %v", err)
+            }
+        }
+        // The preview shows what actually went out, segment by segment
+        j.pd.jobs.AddSent(j.jobID, row, j.gray)
+        if rowDelay := max(throttle, paperDelay); rowDelay > 0 {
+            select {
+            case <-time.After(rowDelay):
+            case <-j.canceled:
+                return errJobCanceled
+            }
+        }
+    }
+    return nil
+}
+
+// waitForSlot blocks until the pacer allows the next chunk, handling any
+// notifications that arrive in the meantime.
+func (j *printJob) waitForSlot(pacer <-chan time.Time) error {
+    for {
+        select {
+        case <-pacer:
+            return nil
+        case <-j.canceled:
+            return errJobCanceled
+        case n := <-j.notifications:
+            paused, err := j.handleTransferNotification(n)
+            if err != nil {
+                return err
+            }
+            if paused {
+                if err := j.waitForResume(); err != nil {
+                    return err
+                }
+            }
+        }
+    }
+}
+
+func (j *printJob) handleTransferNotification(n []byte) (bool, error) {
+    cmd, payload, ok := j.pd.config.profile.Framing.Decode(n)
+    if !ok {
+        // Corrupt frame (bad CRC or footer, or truncated), a sign of a poor
+        // link
+        j.linkErrors++
+        return false, nil
+    }
+    switch cmd {
+    case CMD_GET_STATUS:
+        status, ok := parseStatusPayload(payload)
+        if !ok || !status.OK {
+            j.linkErrors++
+        }
+        if ok && !status.OK {
+            if statusErrorName(status.ErrorCode) != "unknown" {
+                return false, fmt.Errorf("printer reported %s", status)
+            }
+            // No error the printer is known to send: more likely garbled
+            // on the way than a fault worth stopping for
+            log.Printf("Ignoring status with unknown error %d during transfer", status.ErrorCode)
+        }
+    case CMD_FLOW_CONTROL:
+        return len(payload) > 0 && payload[0] == FLOW_PAUSE, nil
+    }
+    return false, nil
+}
+
+func (j *printJob) waitForResume() error {
+    log.Printf("Printer asked us to pause")
+    deadline := time.After(FLOW_PAUSE_TIMEOUT)
+    for {
+        select {
+        case n := <-j.notifications:
+            cmd, payload, ok := j.pd.config.profile.Framing.Decode(n)
+            if !ok {
+                continue
+            }
+            if cmd == CMD_FLOW_CONTROL && len(payload) > 0 && payload[0] == FLOW_RESUME {
+                log.Printf("Printer resumed")
+                return nil
+            }
+            if _, err := j.handleTransferNotification(n); err != nil {
+                return err
+            }
+        case <-j.canceled:
+            return errJobCanceled
+        case <-deadline:
+            return fmt.Errorf("printer stayed paused for %v", FLOW_PAUSE_TIMEOUT)
+        }
+    }
+}
+
+// waitForPaper holds the job in paused-paper-out until a status check comes
+// back clean, instead of failing the job outright.
+func (j *printJob) waitForPaper() error {
+    log.Printf("Printer is out of paper, waiting")
+    j.pd.jobs.Set(j.jobID, JobPausedPaperOut, nil)
+    deadline := time.After(PAPER_OUT_TIMEOUT)
+    for {
+        select {
+        case <-time.After(PAPER_POLL_INTERVAL):
+        case <-j.canceled:
+            return errJobCanceled
+        case <-deadline:
+            return fmt.Errorf("printer out of paper for %v", PAPER_OUT_TIMEOUT)
+        }
+        if j.pd.jobs.Expired(j.jobID) {
+            return errJobExpired
+        }
+        payload, err := j.request(statusRequest(), ACK_TIMEOUT)
+        if err == errAckTimeout {
+            continue
+        }
+        if err != nil {
+            return fmt.Errorf("failed to request status: %v", err)
+        }
+        if status, ok := parseStatusPayload(payload); ok {
+            if status.OK {
+                log.Printf("Paper loaded, resuming")
+                j.pd.jobs.Set(j.jobID, JobConnecting, nil)
+                return nil
+            }
+            if !status.PaperOut() {
+                return fmt.Errorf("printer not ready: %s", status)
+            }
+        }
+    }
+}
+
+// abort tells the printer to drop the job (best effort) after a cancel. A
+// job stopped for running too long also gets a flush and some blank paper,
+// so the printer is left ready and the partial print can be torn off.
+func (j *printJob) abort() error {
+    timedOut := !j.afterAbort && j.pd.jobs.TimedOut(j.jobID)
+    if timedOut {
+        log.Printf("Print job %s ran past %v, aborting", j.jobID, j.pd.config.maxJobDuration)
+    } else {
+        log.Printf("Print job %s canceled", j.jobID)
+    }
+    if err := j.pd.client.WriteCharacteristic(j.pd.controlChar, j.pd.packet(cancelRequest()), true); err != nil {
+        log.Printf("Failed to send cancel: %v", err)
+    }
+    if !timedOut {
+        return errJobCanceled
+    }
+    if err := j.pd.client.WriteCharacteristic(j.pd.controlChar, j.pd.packet(flushRequest()), true); err != nil {
+        log.Printf("Failed to send flush: %v", err)
+    }
+    j.feedAfterAbort()
+    return errJobTimedOut
+}
+
+// feedAfterAbort prints a short run of blank rows, with its own time limit
+// since the printer has just misbehaved.
+func (j *printJob) feedAfterAbort() {
+    blank := withFeed(&preparedImage{intensity: j.intensity}, ABORT_FEED_ROWS)
+    stop := make(chan struct{})
+    timer := time.AfterFunc(ABORT_FEED_TIMEOUT, func() { close(stop) })
+    defer timer.Stop()
+
+    feed := newPrintJob(j.pd, j.jobID, blank)
+    feed.canceled = stop
+    feed.afterAbort = true
+    if err := feed.run(); err != nil {
+        log.Printf("Failed to feed after aborting job %s: %v", j.jobID, err)
+    }
+}
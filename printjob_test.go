@@ -0,0 +1,226 @@
+package main
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/go-ble/ble"
+)
+
+// fakeClient is a printer that accepts everything: it records the size of
+// data writes and the control commands, and answers those like an idle
+// printer at the given head temperature would. The rest of ble.Client is
+// never called.
+type fakeClient struct {
+    ble.Client
+    pd          *PrinterDaemon
+    temperature byte
+
+    mu       sync.Mutex
+    writes   []int
+    commands []byte
+}
+
+func (c *fakeClient) WriteCharacteristic(char *ble.Characteristic, value []byte, noRsp bool) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if char != c.pd.controlChar {
+        c.writes = append(c.writes, len(value))
+        return nil
+    }
+    framing := c.pd.config.profile.Framing
+    cmd, _, _ := framing.Decode(value)
+    c.commands = append(c.commands, cmd)
+    switch cmd {
+    case CMD_GET_STATUS:
+        status := make([]byte, STATUS_MIN_LENGTH)
+        status[STATUS_TEMPERATURE_OFFSET] = c.temperature
+        c.pd.handleNotification(framing.Encode(CMD_GET_STATUS, status))
+    case CMD_PRINT_REQUEST:
+        c.pd.handleNotification(framing.Encode(CMD_PRINT_REQUEST, []byte{STATUS_OK}))
+    case CMD_FLUSH:
+        c.pd.handleNotification(framing.Encode(CMD_PRINT_COMPLETE, []byte{0x00}))
+    }
+    return nil
+}
+
+func newTestPrintJob(rows int) (*printJob, *fakeClient) {
+    pd := NewPrinterDaemon("", &Config{profile: ModelProfile{Framing: MXW01_FRAMING}})
+    client := &fakeClient{pd: pd}
+    pd.client = client
+    pd.controlChar = &ble.Characteristic{}
+    pd.dataChar = &ble.Characteristic{}
+    pd.notifyChar = &ble.Characteristic{}
+    jobID := pd.jobs.New("test")
+    j := newPrintJob(pd, jobID, &preparedImage{buffer: make([]byte, rows*PRINTER_WIDTH_BYTES), numRows: rows})
+    return j, client
+}
+
+// Corrupt frames and error statuses arriving during the transfer switch the
+// rest of the job to smaller chunks.
+func TestTransferDegradesOnLinkErrors(t *testing.T) {
+    f := MXW01_FRAMING
+    status := make([]byte, STATUS_ERROR_OFFSET+1)
+    status[STATUS_FLAG_OFFSET] = STATUS_OK + 1
+    status[STATUS_ERROR_OFFSET] = 0x77
+    good := f.Encode(CMD_GET_STATUS, make([]byte, STATUS_MIN_LENGTH))
+    badCRC := append([]byte{}, good...)
+    badCRC[len(badCRC)-2] ^= 0x01
+    badFooter := append([]byte{}, good...)
+    badFooter[len(badFooter)-1] = 0x00
+
+    tests := []struct {
+        name          string
+        notifications [][]byte
+        degraded      bool
+    }{
+        {"clean link", [][]byte{f.Encode(CMD_FLOW_CONTROL, []byte{FLOW_RESUME})}, false},
+        {"corrupt frames", [][]byte{badCRC, good[:8], badFooter}, true},
+        {"garbled statuses", [][]byte{f.Encode(CMD_GET_STATUS, status), f.Encode(CMD_GET_STATUS, status), f.Encode(CMD_GET_STATUS, []byte{0x00})}, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            j, client := newTestPrintJob(4)
+            notifications := make(chan []byte, len(tt.notifications))
+            for _, n := range tt.notifications {
+                notifications <- n
+            }
+            j.notifications = notifications
+            if err := j.transfer(); err != nil {
+                t.Fatal(err)
+            }
+            if j.degraded != tt.degraded {
+                t.Fatalf("degraded %v, want %v (%d link errors)", j.degraded, tt.degraded, j.linkErrors)
+            }
+            last := client.writes[len(client.writes)-1]
+            if tt.degraded && last > DEGRADED_CHUNK_SIZE || !tt.degraded && last == DEGRADED_CHUNK_SIZE {
+                t.Errorf("last chunk was %d bytes", last)
+            }
+        })
+    }
+}
+
+// A fault the printer is known to report still stops the job.
+func TestTransferStopsOnKnownFault(t *testing.T) {
+    status := make([]byte, STATUS_ERROR_OFFSET+1)
+    status[STATUS_FLAG_OFFSET] = STATUS_OK + 1
+    status[STATUS_ERROR_OFFSET] = 4 // overheated
+    j, _ := newTestPrintJob(2)
+    notifications := make(chan []byte, 1)
+    notifications <- MXW01_FRAMING.Encode(CMD_GET_STATUS, status)
+    j.notifications = notifications
+    if err := j.transfer(); err == nil {
+        t.Error("transfer went on after an overheated status")
+    }
+}
+
+// A job still transferring after max_job_duration is canceled on the
+// printer, flushed and followed by a blank feed.
+func TestJobAbortsAfterMaxDuration(t *testing.T) {
+    j, client := newTestPrintJob(400)
+    j.pd.config.maxJobDuration = 50 * time.Millisecond
+    stop := j.pd.watchJob(j.jobID)
+    defer stop()
+
+    if err := j.run(); err != errJobTimedOut {
+        t.Fatalf("got %v, want %v", err, errJobTimedOut)
+    }
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    cancel := bytes.IndexByte(client.commands, CMD_CANCEL_PRINT)
+    if cancel < 0 || bytes.IndexByte(client.commands[cancel:], CMD_FLUSH) < 0 {
+        t.Errorf("no cancel and flush after the timeout: % X", client.commands)
+    }
+    // The feed is a print of its own after the flush
+    if bytes.Count(client.commands, []byte{CMD_PRINT_REQUEST}) != 2 {
+        t.Errorf("want a second print request for the feed: % X", client.commands)
+    }
+}
+
+// Without max_job_duration the same job prints to the end.
+func TestJobRunsWithoutMaxDuration(t *testing.T) {
+    j, client := newTestPrintJob(20)
+    stop := j.pd.watchJob(j.jobID)
+    defer stop()
+
+    if err := j.run(); err != nil {
+        t.Fatal(err)
+    }
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    if bytes.IndexByte(client.commands, CMD_CANCEL_PRINT) >= 0 {
+        t.Errorf("job was canceled: % X", client.commands)
+    }
+}
+
+// Throttling is off unless configured, and ramps between the two
+// temperatures once it is.
+func TestThrottleDelay(t *testing.T) {
+    if cfg := loadTestConfig(t, `{}`); cfg.Throttle != (ThrottleConfig{}) {
+        t.Errorf("default throttle is %+v, want off", cfg.Throttle)
+    }
+    tests := []struct {
+        throttle    ThrottleConfig
+        temperature byte
+        want        time.Duration
+    }{
+        {ThrottleConfig{}, 90, 0},
+        {ThrottleConfig{StartTemp: 50, MaxTemp: 70, MaxRowDelayMs: 40}, 40, 0},
+        {ThrottleConfig{StartTemp: 50, MaxTemp: 70, MaxRowDelayMs: 40}, 60, 20 * time.Millisecond},
+        {ThrottleConfig{StartTemp: 50, MaxTemp: 70, MaxRowDelayMs: 40}, 90, 40 * time.Millisecond},
+    }
+    for _, tt := range tests {
+        j, client := newTestPrintJob(1)
+        j.pd.config.Throttle = tt.throttle
+        client.temperature = tt.temperature
+        client.WriteCharacteristic(j.pd.controlChar, j.pd.packet(statusRequest()), true)
+        if got := j.pd.throttleDelay(); got != tt.want {
+            t.Errorf("%+v at %d: got %v, want %v", tt.throttle, tt.temperature, got, tt.want)
+        }
+    }
+}
+
+// A half-set throttle is a config error rather than silently off.
+func TestThrottleConfigNeedsAllValues(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "catprinter.json")
+    if err := os.WriteFile(path, []byte(`{"throttle": {"max_temp": 65}}`), 0600); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("CATPRINTER_CONFIG", path)
+    if _, err := loadConfig(); err == nil {
+        t.Error("loaded a throttle with no start_temp or max_row_delay_ms")
+    }
+}
+
+// The status is read once before the print request, never between rows,
+// and a hot head slows the whole job down.
+func TestJobReadsStatusBeforeData(t *testing.T) {
+    j, client := newTestPrintJob(65)
+    j.pd.config.Throttle = ThrottleConfig{StartTemp: 50, MaxTemp: 70, MaxRowDelayMs: 1}
+    client.temperature = 90
+    events, unsubscribe := j.pd.jobs.Subscribe()
+
+    if err := j.run(); err != nil {
+        t.Fatal(err)
+    }
+    unsubscribe()
+    cooled := false
+    for job := range events {
+        cooled = cooled || job.State == JobCooling
+    }
+    if !cooled {
+        t.Error("job never went to cooling")
+    }
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    if n := bytes.Count(client.commands, []byte{CMD_GET_STATUS}); n != 1 {
+        t.Errorf("asked for status %d times: % X", n, client.commands)
+    }
+    if bytes.IndexByte(client.commands, CMD_GET_STATUS) > bytes.IndexByte(client.commands, CMD_PRINT_REQUEST) {
+        t.Errorf("status after the print request: % X", client.commands)
+    }
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "encoding/hex"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+const DEFAULT_MODEL = "mxw01"
+
+// ModelProfile captures what differs between printer models and clone
+// firmwares, so a new variant can be supported with a profile entry.
+type ModelProfile struct {
+    Name    string
+    Framing Framing
+    // SettingQueries maps a setting name to the command that reads it (see
+    // settings.go)
+    SettingQueries map[string]byte
+    // DotGain is the tone curve applied before dithering (see dotgain.go)
+    DotGain ToneCurve
+    // Grayscale models take 4bpp rows (see gray.go)
+    Grayscale bool
+}
+
+// builtinProfiles are always available; config profiles with the same name
+// replace them.
+var builtinProfiles = map[string]ModelProfile{
+    "mxw01": {Name: "mxw01", Framing: MXW01_FRAMING, DotGain: MXW01_DOT_GAIN},
+}
+
+// ProfileConfig is the JSON form of a model profile. Byte fields are hex
+// strings, e.g. {"framing": {"preamble": "5178", "checksum": "none", "footer": "ff"}}.
+// Unset framing fields inherit from the MXW01 defaults.
+type ProfileConfig struct {
+    Framing struct {
+        Preamble *string `json:"preamble"`
+        Checksum string  `json:"checksum"`
+        Footer   *string `json:"footer"`
+    } `json:"framing"`
+    // Settings maps a setting name to the hex command that reads it
+    Settings map[string]string `json:"settings"`
+    // DotGain replaces the MXW01 tone curve; [] turns it off
+    DotGain ToneCurve `json:"dot_gain"`
+    // Grayscale allows the gray dither mode
+    Grayscale bool `json:"grayscale"`
+}
+
+func (pc ProfileConfig) toProfile(name string) (ModelProfile, error) {
+    f := MXW01_FRAMING
+    if pc.Framing.Preamble != nil {
+        b, err := hex.DecodeString(strings.ReplaceAll(*pc.Framing.Preamble, " ", ""))
+        if err != nil {
+            return ModelProfile{}, fmt.Errorf("profile %s: invalid preamble: %v", name, err)
+        }
+        f.Preamble = b
+    }
+    if pc.Framing.Checksum != "" {
+        f.Checksum = pc.Framing.Checksum
+    }
+    if pc.Framing.Footer != nil {
+        b, err := hex.DecodeString(strings.ReplaceAll(*pc.Framing.Footer, " ", ""))
+        if err != nil {
+            return ModelProfile{}, fmt.Errorf("profile %s: invalid footer: %v", name, err)
+        }
+        f.Footer = b
+    }
+    if err := f.validate(); err != nil {
+        return ModelProfile{}, fmt.Errorf("profile %s: %v", name, err)
+    }
+    queries, err := parseSettingQueries(name, pc.Settings)
+    if err != nil {
+        return ModelProfile{}, err
+    }
+    curve := MXW01_DOT_GAIN
+    if pc.DotGain != nil {
+        if err := pc.DotGain.validate(); err != nil {
+            return ModelProfile{}, fmt.Errorf("profile %s: %v", name, err)
+        }
+        curve = pc.DotGain
+    }
+    return ModelProfile{Name: name, Framing: f, SettingQueries: queries, DotGain: curve, Grayscale: pc.Grayscale}, nil
+}
+
+// resolveProfile picks the active model profile from config.
+func (c *Config) resolveProfile() error {
+    name := strings.ToLower(c.Model)
+    if name == "" {
+        name = DEFAULT_MODEL
+    }
+    for key, pc := range c.Profiles {
+        if strings.ToLower(key) != name {
+            continue
+        }
+        profile, err := pc.toProfile(name)
+        if err != nil {
+            return err
+        }
+        c.profile = profile
+        return nil
+    }
+    if profile, ok := builtinProfiles[name]; ok {
+        c.profile = profile
+        return nil
+    }
+    return fmt.Errorf("unknown printer model %q (known: %s)", c.Model, strings.Join(knownProfiles(c), ", "))
+}
+
+func knownProfiles(c *Config) []string {
+    var names []string
+    for name := range builtinProfiles {
+        names = append(names, name)
+    }
+    for name := range c.Profiles {
+        if _, ok := builtinProfiles[strings.ToLower(name)]; !ok {
+            names = append(names, strings.ToLower(name))
+        }
+    }
+    sort.Strings(names)
+    return names
+}
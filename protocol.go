@@ -0,0 +1,226 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+)
+
+// Protocol helpers shared by the CLI and the daemon. See PROTOCOL.md for the
+// packet layout. Use the named constants and packet builders below instead of
+// raw bytes; when adding a command, add it here (with its COMMANDS entry,
+// which /protocol publishes, see protocoldoc.go) and to PROTOCOL.md.
+
+const (
+    PRINTER_WIDTH       = 384
+    PRINTER_WIDTH_BYTES = PRINTER_WIDTH / 8
+    // Grayscale rows carry 4 bits per dot (see gray.go)
+    GRAY_ROW_BYTES = PRINTER_WIDTH / 2
+    // Prints are padded to at least this many rows
+    MIN_DATA_ROWS      = 90
+    MIN_DATA_BYTES     = MIN_DATA_ROWS * PRINTER_WIDTH_BYTES
+    CONTROL_WRITE_UUID = "0000ae01-0000-1000-8000-00805f9b34fb"
+    DATA_WRITE_UUID    = "0000ae03-0000-1000-8000-00805f9b34fb"
+    NOTIFY_UUID        = "0000ae02-0000-1000-8000-00805f9b34fb"
+)
+
+// Command IDs, the byte after the preamble. Names follow PROTOCOL.md; the
+// same ID is used for a request and the notification answering it.
+const (
+    CMD_GET_STATUS     byte = 0xA1 // request 0x00, answered with the status payload
+    CMD_SET_INTENSITY  byte = 0xA2 // heat level 0x00-0xFF, not acknowledged
+    CMD_QUERY_COUNT    byte = 0xA7
+    CMD_PRINT_REQUEST  byte = 0xA9 // rows (LE16), 0x30, mode; ack payload 0x00 = OK
+    CMD_PRINT_COMPLETE byte = 0xAA // notification once the paper stops moving
+    CMD_GET_BATTERY    byte = 0xAB
+    CMD_CANCEL_PRINT   byte = 0xAC
+    CMD_FLUSH          byte = 0xAD // end of the image data on AE03
+    CMD_FLOW_CONTROL   byte = 0xAE // notification, payload FLOW_PAUSE or FLOW_RESUME
+    CMD_GET_PRINT_TYPE byte = 0xB0
+    CMD_GET_VERSION    byte = 0xB1
+)
+
+// Payload values
+const (
+    // Intensity sent before every job
+    DEFAULT_INTENSITY byte = 0xA0
+    // Fixed third byte of the print request
+    PRINT_REQUEST_MAGIC byte = 0x30
+    // Print modes in the fourth byte of the print request
+    PRINT_MODE_1BPP byte = 0x00
+    PRINT_MODE_4BPP byte = 0x02
+    // Flow control notification payloads (GB-series firmwares)
+    FLOW_PAUSE  byte = 0x10
+    FLOW_RESUME byte = 0x00
+    // Status reply OK flag and print request ack
+    STATUS_OK byte = 0x00
+)
+
+// Offsets into the status payload (see PROTOCOL.md)
+const (
+    STATUS_STATE_OFFSET       = 6
+    STATUS_BATTERY_OFFSET     = 9
+    STATUS_TEMPERATURE_OFFSET = 10
+    STATUS_FLAG_OFFSET        = 12
+    STATUS_ERROR_OFFSET       = 13
+    STATUS_MIN_LENGTH         = 13
+)
+
+// CommandSpec describes a command: its name in logs, and what its request
+// and reply payloads hold ("" when there is no request or no reply).
+type CommandSpec struct {
+    Name    string
+    Request string
+    Reply   string
+}
+
+var COMMANDS = map[byte]CommandSpec{
+    CMD_GET_STATUS:     {Name: "status", Request: "0x00", Reply: "status payload, see the status offsets"},
+    CMD_SET_INTENSITY:  {Name: "set intensity", Request: "heat level 0x00-0xFF"},
+    CMD_QUERY_COUNT:    {Name: "query count", Request: "0x00", Reply: "unknown, often FF FF..."},
+    CMD_PRINT_REQUEST:  {Name: "print request", Request: "rows (LE16), 0x30, print mode", Reply: "0x00 = OK"},
+    CMD_PRINT_COMPLETE: {Name: "print complete", Reply: "unknown; sent once the paper stops moving"},
+    CMD_GET_BATTERY:    {Name: "battery level", Request: "0x00", Reply: "battery level"},
+    CMD_CANCEL_PRINT:   {Name: "cancel", Request: "0x00"},
+    CMD_FLUSH:          {Name: "flush", Request: "0x00, after the last image row on AE03"},
+    CMD_FLOW_CONTROL:   {Name: "flow control", Reply: "0x10 = pause, 0x00 = resume (GB-series firmwares)"},
+    CMD_GET_PRINT_TYPE: {Name: "print type", Request: "0x00", Reply: "type code"},
+    CMD_GET_VERSION:    {Name: "version", Request: "0x00", Reply: "version string (UTF-8), unknown, type code"},
+}
+
+// commandName is for logs, e.g. "A9 print request".
+func commandName(cmd byte) string {
+    if spec, ok := COMMANDS[cmd]; ok {
+        return fmt.Sprintf("%02X %s", cmd, spec.Name)
+    }
+    return fmt.Sprintf("%02X", cmd)
+}
+
+// Packet is a control command before framing. Build them with the helpers
+// below rather than by hand, and encode them with the model's Framing.
+type Packet struct {
+    Cmd     byte
+    Payload []byte
+}
+
+func statusRequest() Packet {
+    return Packet{CMD_GET_STATUS, []byte{0x00}}
+}
+
+func setIntensity(level byte) Packet {
+    return Packet{CMD_SET_INTENSITY, []byte{level}}
+}
+
+func printRequest(rows int, mode byte) Packet {
+    return Packet{CMD_PRINT_REQUEST, []byte{byte(rows), byte(rows >> 8), PRINT_REQUEST_MAGIC, mode}}
+}
+
+func flushRequest() Packet {
+    return Packet{CMD_FLUSH, []byte{0x00}}
+}
+
+func cancelRequest() Packet {
+    return Packet{CMD_CANCEL_PRINT, []byte{0x00}}
+}
+
+// Framing describes how commands are wrapped on the wire. The MXW01 uses a
+// 0x22 0x21 preamble, a CRC8 over the payload and a 0xFF footer; some clone
+// firmwares change the magic bytes or drop the checksum.
+type Framing struct {
+    Preamble []byte
+    Checksum string // "crc8" (over the payload) or "none"
+    Footer   []byte
+}
+
+var MXW01_FRAMING = Framing{
+    Preamble: []byte{0x22, 0x21},
+    Checksum: "crc8",
+    Footer:   []byte{0xFF},
+}
+
+// Encode builds a control packet: preamble, command ID, a fixed 0x00, the
+// little-endian payload length, the payload, then checksum and footer.
+func (f Framing) Encode(cmdId byte, payload []byte) []byte {
+    cmd := append([]byte{}, f.Preamble...)
+    cmd = append(cmd, cmdId, 0x00, byte(len(payload)), byte(len(payload)>>8))
+    cmd = append(cmd, payload...)
+    if f.Checksum == "crc8" {
+        cmd = append(cmd, calculateCRC8(payload))
+    }
+    return append(cmd, f.Footer...)
+}
+
+// EncodePacket frames a packet built by one of the helpers above.
+func (f Framing) EncodePacket(p Packet) []byte {
+    return f.Encode(p.Cmd, p.Payload)
+}
+
+// Decode splits a notification into its command ID and payload. Notifications
+// use the same preamble and length layout as outgoing commands. With crc8
+// framing the payload is followed by its CRC8 and the footer, or by the
+// footer alone as PROTOCOL.md shows for some replies; a frame that is
+// truncated or has a wrong checksum or footer is rejected as corrupt.
+func (f Framing) Decode(data []byte) (byte, []byte, bool) {
+    n := len(f.Preamble)
+    if len(data) < n+4 || !bytes.Equal(data[:n], f.Preamble) {
+        return 0, nil, false
+    }
+    length := int(data[n+2]) | int(data[n+3])<<8
+    end := n + 4 + length
+    if len(data) < end {
+        return 0, nil, false
+    }
+    payload := data[end-length : end]
+    if f.Checksum == "crc8" {
+        trailer := data[end:]
+        switch len(trailer) {
+        case len(f.Footer):
+        case len(f.Footer) + 1:
+            if trailer[0] != calculateCRC8(payload) {
+                return 0, nil, false
+            }
+            trailer = trailer[1:]
+        default:
+            return 0, nil, false
+        }
+        if !bytes.Equal(trailer, f.Footer) {
+            return 0, nil, false
+        }
+    }
+    return data[n], payload, true
+}
+
+func (f Framing) validate() error {
+    if len(f.Preamble) == 0 {
+        return fmt.Errorf("framing needs a preamble")
+    }
+    if f.Checksum != "crc8" && f.Checksum != "none" {
+        return fmt.Errorf("unknown checksum %q (want crc8 or none)", f.Checksum)
+    }
+    return nil
+}
+
+func calculateCRC8(data []byte) byte {
+    table := [256]byte{
+        0x00, 0x07, 0x0E, 0x09, 0x1C, 0x1B, 0x12, 0x15, 0x38, 0x3F, 0x36, 0x31, 0x24, 0x23, 0x2A, 0x2D,
+        0x70, 0x77, 0x7E, 0x79, 0x6C, 0x6B, 0x62, 0x65, 0x48, 0x4F, 0x46, 0x41, 0x54, 0x53, 0x5A, 0x5D,
+        0xE0, 0xE7, 0xEE, 0xE9, 0xFC, 0xFB, 0xF2, 0xF5, 0xD8, 0xDF, 0xD6, 0xD1, 0xC4, 0xC3, 0xCA, 0xCD,
+        0x90, 0x97, 0x9E, 0x99, 0x8C, 0x8B, 0x82, 0x85, 0xA8, 0xAF, 0xA6, 0xA1, 0xB4, 0xB3, 0xBA, 0xBD,
+        0xC7, 0xC0, 0xC9, 0xCE, 0xDB, 0xDC, 0xD5, 0xD2, 0xFF, 0xF8, 0xF1, 0xF6, 0xE3, 0xE4, 0xED, 0xEA,
+        0xB7, 0xB0, 0xB9, 0xBE, 0xAB, 0xAC, 0xA5, 0xA2, 0x8F, 0x88, 0x81, 0x86, 0x93, 0x94, 0x9D, 0x9A,
+        0x27, 0x20, 0x29, 0x2E, 0x3B, 0x3C, 0x35, 0x32, 0x1F, 0x18, 0x11, 0x16, 0x03, 0x04, 0x0D, 0x0A,
+        0x57, 0x50, 0x59, 0x5E, 0x4B, 0x4C, 0x45, 0x42, 0x6F, 0x68, 0x61, 0x66, 0x73, 0x74, 0x7D, 0x7A,
+        0x89, 0x8E, 0x87, 0x80, 0x95, 0x92, 0x9B, 0x9C, 0xB1, 0xB6, 0xBF, 0xB8, 0xAD, 0xAA, 0xA3, 0xA4,
+        0xF9, 0xFE, 0xF7, 0xF0, 0xE5, 0xE2, 0xEB, 0xEC, 0xC1, 0xC6, 0xCF, 0xC8, 0xDD, 0xDA, 0xD3, 0xD4,
+        0x69, 0x6E, 0x67, 0x60, 0x75, 0x72, 0x7B, 0x7C, 0x51, 0x56, 0x5F, 0x58, 0x4D, 0x4A, 0x43, 0x44,
+        0x19, 0x1E, 0x17, 0x10, 0x05, 0x02, 0x0B, 0x0C, 0x21, 0x26, 0x2F, 0x28, 0x3D, 0x3A, 0x33, 0x34,
+        0x4E, 0x49, 0x40, 0x47, 0x52, 0x55, 0x5C, 0x5B, 0x76, 0x71, 0x78, 0x7F, 0x6A, 0x6D, 0x64, 0x63,
+        0x3E, 0x39, 0x30, 0x37, 0x22, 0x25, 0x2C, 0x2B, 0x06, 0x01, 0x08, 0x0F, 0x1A, 0x1D, 0x14, 0x13,
+        0xAE, 0xA9, 0xA0, 0xA7, 0xB2, 0xB5, 0xBC, 0xBB, 0x96, 0x91, 0x98, 0x9F, 0x8A, 0x8D, 0x84, 0x83,
+        0xDE, 0xD9, 0xD0, 0xD7, 0xC2, 0xC5, 0xCC, 0xCB, 0xE6, 0xE1, 0xE8, 0xEF, 0xFA, 0xFD, 0xF4, 0xF3,
+    }
+    crc := byte(0)
+    for _, b := range data {
+        crc = table[(crc^b)&0xFF]
+    }
+    return crc
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "bytes"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "path/filepath"
+    "strconv"
+    "testing"
+)
+
+func TestFramingDecode(t *testing.T) {
+    f := MXW01_FRAMING
+    good := f.Encode(CMD_FLOW_CONTROL, []byte{FLOW_PAUSE})
+    badCRC := append([]byte{}, good...)
+    badCRC[len(badCRC)-2] ^= 0x01
+    badFooter := append([]byte{}, good...)
+    badFooter[len(badFooter)-1] = 0x00
+    footerOnly := append(append([]byte{}, good[:len(good)-2]...), 0xFF)
+
+    tests := []struct {
+        name string
+        data []byte
+        ok   bool
+    }{
+        {"good", good, true},
+        {"footer without checksum", footerOnly, true},
+        {"bad checksum", badCRC, false},
+        {"bad footer", badFooter, false},
+        {"truncated payload", good[:len(good)-3], false},
+        {"missing footer", good[:len(good)-1], false},
+        {"trailing bytes", append(append([]byte{}, good...), 0x00), false},
+        {"wrong preamble", append([]byte{0x51, 0x78}, good[2:]...), false},
+    }
+    for _, tt := range tests {
+        cmd, payload, ok := f.Decode(tt.data)
+        if ok != tt.ok {
+            t.Errorf("%s: got ok %v, want %v", tt.name, ok, tt.ok)
+            continue
+        }
+        if ok && (cmd != CMD_FLOW_CONTROL || !bytes.Equal(payload, []byte{FLOW_PAUSE})) {
+            t.Errorf("%s: got %02X % X", tt.name, cmd, payload)
+        }
+    }
+
+    // Clone firmwares without a checksum only need the length to fit
+    none := Framing{Preamble: f.Preamble, Checksum: "none", Footer: f.Footer}
+    if _, _, ok := none.Decode(none.Encode(CMD_GET_STATUS, []byte{0x00})); !ok {
+        t.Error("none: frame rejected")
+    }
+}
+
+// cmd/decode cannot import package main, so it keeps its own command names.
+func TestDecodeToolKnowsCommands(t *testing.T) {
+    file, err := parser.ParseFile(token.NewFileSet(), filepath.Join("cmd", "decode", "main.go"), nil, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    names := map[byte]string{}
+    ast.Inspect(file, func(n ast.Node) bool {
+        spec, ok := n.(*ast.ValueSpec)
+        if !ok || len(spec.Names) != 1 || spec.Names[0].Name != "commandNames" || len(spec.Values) != 1 {
+            return true
+        }
+        for _, elt := range spec.Values[0].(*ast.CompositeLit).Elts {
+            kv := elt.(*ast.KeyValueExpr)
+            id, err := strconv.ParseUint(kv.Key.(*ast.BasicLit).Value, 0, 8)
+            if err != nil {
+                t.Fatal(err)
+            }
+            name, err := strconv.Unquote(kv.Value.(*ast.BasicLit).Value)
+            if err != nil {
+                t.Fatal(err)
+            }
+            names[byte(id)] = name
+        }
+        return false
+    })
+    if len(names) == 0 {
+        t.Fatal("no commandNames in cmd/decode")
+    }
+    for id, spec := range COMMANDS {
+        if names[id] != spec.Name {
+            t.Errorf("cmd/decode names %02X %q, protocol.go %q", id, names[id], spec.Name)
+        }
+    }
+}
@@ -0,0 +1,183 @@
+//go:build daemon
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// Protocol console: a page at /admin/console for crafting commands from the
+// /protocol description, sending them through /admin/raw and watching every
+// notification the printer sends, decoded for the configured model, as it
+// arrives. Sending and the notification stream need the admin token.
+
+type consoleNotification struct {
+    rawNotification
+    Received time.Time `json:"received"`
+}
+
+func (api *HTTPAPI) registerProtocolConsoleHandlers(mux *http.ServeMux) {
+    mux.HandleFunc("/admin/console", func(w http.ResponseWriter, r *http.Request) {
+        // Public like /admin; the calls it makes are not
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(protocolConsolePage))
+    })
+
+    mux.HandleFunc("/admin/notifications", func(w http.ResponseWriter, r *http.Request) {
+        if !api.requireAdmin(w, r) {
+            return
+        }
+        flusher, ok := w.(http.Flusher)
+        if !ok {
+            http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+            return
+        }
+        notifications, cancel := api.engine.Notifications()
+        defer cancel()
+
+        w.Header().Set("Content-Type", "text/event-stream")
+        w.Header().Set("Cache-Control", "no-cache")
+        flusher.Flush()
+        for {
+            select {
+            case n := <-notifications:
+                decoded := consoleNotification{decodeNotification(api.engine.Config().profile.Framing, n), time.Now()}
+                data, _ := json.Marshal(decoded)
+                fmt.Fprintf(w, "event: notification\ndata: %s\n\n", data)
+                flusher.Flush()
+            case <-r.Context().Done():
+                return
+            }
+        }
+    })
+}
+
+const protocolConsolePage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <title>Cat Printer - Protocol console</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <style>
+    body { font-family: sans-serif; max-width: 640px; margin: 1em auto; padding: 0 1em; background: #f8f9fa; }
+    form { background: #fff; border: 1px solid #dee2e6; border-radius: 6px; padding: .75em; }
+    label { display: block; margin: .5em 0; }
+    input, select { font-family: monospace; }
+    #hint { color: #6c757d; font-size: .9em; }
+    #log { background: #212529; color: #f8f9fa; font-family: monospace; font-size: .85em; padding: .5em; height: 24em; overflow-y: auto; white-space: pre-wrap; }
+    .sent { color: #ffc107; }
+    .error { color: #dc3545; }
+  </style>
+</head>
+<body>
+  <p><input id="token" type="password" placeholder="Admin token"> <button onclick="saveToken()">Save</button> <a href="/admin">Admin</a> <span id="live"></span></p>
+  <h2>Protocol console</h2>
+  <form id="send">
+    <label>Characteristic
+      <select id="characteristic"><option value="control">control (framed)</option><option value="data">data (unframed)</option></select>
+    </label>
+    <label>Command <select id="command"></select> or <input id="customCommand" size="4" placeholder="A1"></label>
+    <div id="hint"></div>
+    <label>Payload (hex) <input id="payload" size="40" placeholder="00"></label>
+    <label>Wait (ms) <input id="wait" type="number" value="1000" min="0" max="10000"></label>
+    <button type="submit">Send</button> <button type="button" onclick="logEl.textContent = ''">Clear log</button>
+  </form>
+  <h3>Log</h3>
+  <div id="log"></div>
+  <script>
+    const tokenInput = document.getElementById('token');
+    tokenInput.value = localStorage.getItem('adminToken') || '';
+    const logEl = document.getElementById('log');
+    const commandSelect = document.getElementById('command');
+    let commands = [];
+    let stream = null;
+    function saveToken() { localStorage.setItem('adminToken', tokenInput.value); listen(); }
+    function headers() { return { 'Authorization': 'Bearer ' + tokenInput.value }; }
+    function log(text, cls) {
+      const line = document.createElement('div');
+      line.textContent = new Date().toLocaleTimeString() + ' ' + text;
+      if (cls) line.className = cls;
+      logEl.append(line);
+      logEl.scrollTop = logEl.scrollHeight;
+    }
+    function describe(n) {
+      if (!n.command) return '<- raw ' + n.raw;
+      return '<- ' + n.command + (n.name ? ' ' + n.name : '') + ' payload=' + (n.payload || '-');
+    }
+    function showHint() {
+      const cmd = commands.find(c => c.id === commandSelect.value);
+      document.getElementById('hint').textContent = cmd ? [cmd.request && 'Request: ' + cmd.request, cmd.reply && 'Reply: ' + cmd.reply].filter(Boolean).join(' / ') : '';
+    }
+    async function loadProtocol() {
+      const res = await fetch('/protocol', { cache: 'no-store' });
+      if (!res.ok) { log('Could not load /protocol: ' + await res.text(), 'error'); return; }
+      const protocol = await res.json();
+      commands = protocol.commands || [];
+      commandSelect.innerHTML = '';
+      for (const cmd of commands) {
+        const option = document.createElement('option');
+        option.value = cmd.id;
+        option.textContent = cmd.id.toUpperCase() + ' ' + cmd.name;
+        commandSelect.append(option);
+      }
+      showHint();
+      log('Model ' + protocol.model + ', ' + commands.length + ' known commands');
+    }
+    // EventSource can't send the token, so the stream is read by hand
+    async function listen() {
+      if (stream) stream.abort();
+      stream = new AbortController();
+      const live = document.getElementById('live');
+      try {
+        const res = await fetch('/admin/notifications', { headers: headers(), signal: stream.signal });
+        if (!res.ok) { live.textContent = 'Not listening: ' + await res.text(); return; }
+        live.textContent = 'Listening for notifications';
+        const reader = res.body.getReader();
+        const decoder = new TextDecoder();
+        let buffer = '';
+        for (;;) {
+          const { value, done } = await reader.read();
+          if (done) break;
+          buffer += decoder.decode(value, { stream: true });
+          let end;
+          while ((end = buffer.indexOf('\n\n')) >= 0) {
+            const event = buffer.slice(0, end);
+            buffer = buffer.slice(end + 2);
+            const data = event.split('\n').find(l => l.startsWith('data: '));
+            if (data) log(describe(JSON.parse(data.slice(6))));
+          }
+        }
+        live.textContent = 'Stream closed';
+      } catch (e) {
+        if (e.name !== 'AbortError') live.textContent = 'Stream failed: ' + e.message;
+      }
+    }
+    commandSelect.onchange = showHint;
+    document.getElementById('send').onsubmit = async (e) => {
+      e.preventDefault();
+      const characteristic = document.getElementById('characteristic').value;
+      const body = {
+        characteristic,
+        command: document.getElementById('customCommand').value.trim() || commandSelect.value,
+        payload: document.getElementById('payload').value,
+        wait_ms: Number(document.getElementById('wait').value)
+      };
+      log('-> ' + characteristic + ' ' + (characteristic === 'data' ? '' : body.command + ' ') + (body.payload || '-'), 'sent');
+      const res = await fetch('/admin/raw', { method: 'POST', headers: headers(), body: JSON.stringify(body) });
+      if (!res.ok) { log(await res.text(), 'error'); return; }
+      const result = await res.json();
+      log('   wrote ' + result.sent, 'sent');
+      // Replies already showed up on the stream while it's open
+      if (document.getElementById('live').textContent !== 'Listening for notifications') {
+        for (const n of result.notifications) log(describe(n));
+      }
+    };
+    loadProtocol();
+    listen();
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "encoding/hex"
+    "fmt"
+    "sort"
+)
+
+// The wire format as JSON, built from the definitions in protocol.go and the
+// active model profile, for other implementations and the web UI's debug
+// console to read instead of copying constants (GET /protocol on the daemon,
+// "catprinter protocol" on the CLI). Byte values are hex strings.
+
+type ProtocolDescription struct {
+    Model           string               `json:"model"`
+    Characteristics map[string]string    `json:"characteristics"`
+    PrinterWidth    int                  `json:"printer_width"`
+    RowBytes        int                  `json:"row_bytes"`
+    GrayRowBytes    int                  `json:"gray_row_bytes"`
+    MinDataBytes    int                  `json:"min_data_bytes"`
+    Framing         FramingDescription   `json:"framing"`
+    Commands        []CommandDescription `json:"commands"`
+    Values          map[string]string    `json:"values"`
+    StatusOffsets   map[string]int       `json:"status_offsets"`
+    StatusErrors    map[string]string    `json:"status_errors"`
+    Settings        map[string]string    `json:"settings,omitempty"`
+}
+
+type FramingDescription struct {
+    Preamble string `json:"preamble"`
+    // Checksum is "crc8" (over the payload) or "none"
+    Checksum string `json:"checksum"`
+    Footer   string `json:"footer"`
+    Layout   string `json:"layout"`
+}
+
+type CommandDescription struct {
+    ID      string `json:"id"`
+    Name    string `json:"name"`
+    Request string `json:"request,omitempty"`
+    Reply   string `json:"reply,omitempty"`
+}
+
+func hexByte(b byte) string {
+    return fmt.Sprintf("%02x", b)
+}
+
+// describeProtocol describes the wire format of the given model.
+func describeProtocol(profile ModelProfile) ProtocolDescription {
+    d := ProtocolDescription{
+        Model: profile.Name,
+        Characteristics: map[string]string{
+            "control": CONTROL_WRITE_UUID,
+            "notify":  NOTIFY_UUID,
+            "data":    DATA_WRITE_UUID,
+        },
+        PrinterWidth: PRINTER_WIDTH,
+        RowBytes:     PRINTER_WIDTH_BYTES,
+        GrayRowBytes: GRAY_ROW_BYTES,
+        MinDataBytes: MIN_DATA_BYTES,
+        Framing: FramingDescription{
+            Preamble: hex.EncodeToString(profile.Framing.Preamble),
+            Checksum: profile.Framing.Checksum,
+            Footer:   hex.EncodeToString(profile.Framing.Footer),
+            Layout:   "preamble, command, 00, payload length (LE16), payload, checksum, footer",
+        },
+        Values: map[string]string{
+            "default_intensity":   hexByte(DEFAULT_INTENSITY),
+            "print_request_magic": hexByte(PRINT_REQUEST_MAGIC),
+            "print_mode_1bpp":     hexByte(PRINT_MODE_1BPP),
+            "print_mode_4bpp":     hexByte(PRINT_MODE_4BPP),
+            "flow_pause":          hexByte(FLOW_PAUSE),
+            "flow_resume":         hexByte(FLOW_RESUME),
+            "status_ok":           hexByte(STATUS_OK),
+        },
+        StatusOffsets: map[string]int{
+            "state":       STATUS_STATE_OFFSET,
+            "battery":     STATUS_BATTERY_OFFSET,
+            "temperature": STATUS_TEMPERATURE_OFFSET,
+            "flag":        STATUS_FLAG_OFFSET,
+            "error":       STATUS_ERROR_OFFSET,
+            "min_length":  STATUS_MIN_LENGTH,
+        },
+        StatusErrors: map[string]string{},
+    }
+    if profile.Framing.Checksum == "none" {
+        d.Framing.Layout = "preamble, command, 00, payload length (LE16), payload, footer"
+    }
+    for id, spec := range COMMANDS {
+        d.Commands = append(d.Commands, CommandDescription{ID: hexByte(id), Name: spec.Name, Request: spec.Request, Reply: spec.Reply})
+    }
+    sort.Slice(d.Commands, func(i, j int) bool { return d.Commands[i].ID < d.Commands[j].ID })
+    for code := 1; code < 256; code++ {
+        if name := statusErrorName(byte(code)); name != "unknown" {
+            d.StatusErrors[hexByte(byte(code))] = name
+        }
+    }
+    if len(profile.SettingQueries) > 0 {
+        d.Settings = make(map[string]string, len(profile.SettingQueries))
+        for name, cmd := range profile.SettingQueries {
+            d.Settings[name] = hexByte(cmd)
+        }
+    }
+    return d
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+    "bufio"
+    "encoding/binary"
+    "fmt"
+    "image"
+    "image/color"
+    "io"
+)
+
+// PWG raster (the format IPP Everywhere clients and CUPS filters send) is a
+// "RaS2" sync word followed by pages, each a 1796 byte header and run-length
+// compressed lines. Pages are stacked into one long image, which is what a
+// roll printer wants anyway. Resolution isn't honoured as such: pages are
+// scaled to the paper width like any other image.
+
+const (
+    PWG_SYNC        = "RaS2"
+    PWG_HEADER_SIZE = 1796
+    // Guards against absurd headers eating all the memory on a Pi
+    PWG_MAX_PIXELS = 64 << 20
+)
+
+// Header fields we need, by byte offset (all big-endian uint32)
+const (
+    pwgWidth        = 372
+    pwgHeight       = 376
+    pwgBitsPerColor = 384
+    pwgBitsPerPixel = 388
+    pwgBytesPerLine = 392
+    pwgColorOrder   = 396
+    pwgColorSpace   = 400
+)
+
+// Colour spaces we accept (cups_cspace_t values)
+const (
+    pwgColorSpaceW    = 0
+    pwgColorSpaceRGB  = 1
+    pwgColorSpaceK    = 3
+    pwgColorSpaceSW   = 18
+    pwgColorSpaceSRGB = 19
+)
+
+func init() {
+    image.RegisterFormat("pwg", PWG_SYNC, decodePWG, decodePWGConfig)
+    registerCapability(Capability{Name: "pwg", Description: "PWG/CUPS raster, pages stacked into one image"})
+}
+
+type pwgPage struct {
+    width, height int
+    bitsPerColor  int
+    bitsPerPixel  int
+    bytesPerLine  int
+    colorSpace    int
+}
+
+func parsePWGHeader(h []byte) (pwgPage, error) {
+    field := func(off int) int { return int(binary.BigEndian.Uint32(h[off:])) }
+    p := pwgPage{
+        width:        field(pwgWidth),
+        height:       field(pwgHeight),
+        bitsPerColor: field(pwgBitsPerColor),
+        bitsPerPixel: field(pwgBitsPerPixel),
+        bytesPerLine: field(pwgBytesPerLine),
+        colorSpace:   field(pwgColorSpace),
+    }
+    if field(pwgColorOrder) != 0 {
+        return p, fmt.Errorf("pwg: only chunky colour order is supported")
+    }
+    if p.width <= 0 || p.height <= 0 || p.width*p.height > PWG_MAX_PIXELS {
+        return p, fmt.Errorf("pwg: bad page size %dx%d", p.width, p.height)
+    }
+    switch {
+    case (p.colorSpace == pwgColorSpaceW || p.colorSpace == pwgColorSpaceSW) && p.bitsPerPixel == 8:
+    case p.colorSpace == pwgColorSpaceK && (p.bitsPerPixel == 1 || p.bitsPerPixel == 8):
+    case (p.colorSpace == pwgColorSpaceRGB || p.colorSpace == pwgColorSpaceSRGB) && p.bitsPerPixel == 24:
+    default:
+        return p, fmt.Errorf("pwg: unsupported colour space %d at %d bits per pixel", p.colorSpace, p.bitsPerPixel)
+    }
+    if p.bytesPerLine != (p.width*p.bitsPerPixel+7)/8 {
+        return p, fmt.Errorf("pwg: bytes per line %d doesn't match width %d", p.bytesPerLine, p.width)
+    }
+    return p, nil
+}
+
+// white is the byte value of a blank pixel, used by the "clear to end of
+// line" run.
+func (p pwgPage) white() byte {
+    if p.colorSpace == pwgColorSpaceK {
+        return 0x00
+    }
+    return 0xFF
+}
+
+// readLines decodes one page's compressed lines: each group starts with a
+// repeat count, then runs of repeated or literal pixels.
+func (p pwgPage) readLines(r *bufio.Reader) ([]byte, error) {
+    unit := p.bitsPerPixel / 8
+    if unit == 0 {
+        unit = 1
+    }
+    data := make([]byte, 0, p.height*p.bytesPerLine)
+    for y := 0; y < p.height; {
+        repeat, err := r.ReadByte()
+        if err != nil {
+            return nil, err
+        }
+        line := make([]byte, 0, p.bytesPerLine)
+        for len(line) < p.bytesPerLine {
+            c, err := r.ReadByte()
+            if err != nil {
+                return nil, err
+            }
+            switch {
+            case c == 128:
+                for len(line) < p.bytesPerLine {
+                    line = append(line, p.white())
+                }
+            case c < 128:
+                pixel := make([]byte, unit)
+                if _, err := io.ReadFull(r, pixel); err != nil {
+                    return nil, err
+                }
+                for i := 0; i <= int(c); i++ {
+                    line = append(line, pixel...)
+                }
+            default:
+                literal := make([]byte, (257-int(c))*unit)
+                if _, err := io.ReadFull(r, literal); err != nil {
+                    return nil, err
+                }
+                line = append(line, literal...)
+            }
+        }
+        if len(line) > p.bytesPerLine {
+            return nil, fmt.Errorf("pwg: line %d overruns its width", y)
+        }
+        for i := 0; i <= int(repeat) && y < p.height; i++ {
+            data = append(data, line...)
+            y++
+        }
+    }
+    return data, nil
+}
+
+// gray converts one decoded pixel to a gray level (0 black, 255 white).
+func (p pwgPage) gray(data []byte, x, y int) uint8 {
+    line := data[y*p.bytesPerLine:]
+    switch {
+    case p.colorSpace == pwgColorSpaceK && p.bitsPerPixel == 1:
+        if line[x/8]&(0x80>>(x%8)) != 0 {
+            return 0
+        }
+        return 255
+    case p.colorSpace == pwgColorSpaceK:
+        return 255 - line[x]
+    case p.bitsPerPixel == 24:
+        c := color.RGBA{line[x*3], line[x*3+1], line[x*3+2], 0xFF}
+        return luminance(c)
+    }
+    return line[x]
+}
+
+// decodePWG reads every page and stacks them top to bottom. Pages narrower
+// than the widest one are padded with white on the right.
+func decodePWG(r io.Reader) (image.Image, error) {
+    br := bufio.NewReader(r)
+    sync := make([]byte, len(PWG_SYNC))
+    if _, err := io.ReadFull(br, sync); err != nil || string(sync) != PWG_SYNC {
+        return nil, fmt.Errorf("pwg: missing RaS2 sync word")
+    }
+
+    type page struct {
+        pwgPage
+        data []byte
+    }
+    var pages []page
+    width, height := 0, 0
+    header := make([]byte, PWG_HEADER_SIZE)
+    for {
+        if _, err := io.ReadFull(br, header); err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("pwg: truncated page header: %v", err)
+        }
+        p, err := parsePWGHeader(header)
+        if err != nil {
+            return nil, err
+        }
+        if height+p.height > PWG_MAX_PIXELS/p.width {
+            return nil, fmt.Errorf("pwg: document too long")
+        }
+        data, err := p.readLines(br)
+        if err != nil {
+            return nil, fmt.Errorf("pwg: page %d: %v", len(pages)+1, err)
+        }
+        pages = append(pages, page{p, data})
+        if p.width > width {
+            width = p.width
+        }
+        height += p.height
+    }
+    if len(pages) == 0 {
+        return nil, fmt.Errorf("pwg: no pages")
+    }
+
+    img := image.NewGray(image.Rect(0, 0, width, height))
+    for i := range img.Pix {
+        img.Pix[i] = 0xFF
+    }
+    top := 0
+    for _, p := range pages {
+        for y := 0; y < p.height; y++ {
+            for x := 0; x < p.width; x++ {
+                img.Pix[(top+y)*img.Stride+x] = p.gray(p.data, x, y)
+            }
+        }
+        top += p.height
+    }
+    return img, nil
+}
+
+// decodePWGConfig reports the first page only.
+func decodePWGConfig(r io.Reader) (image.Config, error) {
+    head := make([]byte, len(PWG_SYNC)+PWG_HEADER_SIZE)
+    if _, err := io.ReadFull(r, head); err != nil {
+        return image.Config{}, err
+    }
+    p, err := parsePWGHeader(head[len(PWG_SYNC):])
+    if err != nil {
+        return image.Config{}, err
+    }
+    return image.Config{ColorModel: color.GrayModel, Width: p.width, Height: p.height}, nil
+}
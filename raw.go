@@ -0,0 +1,78 @@
+package main
+
+import (
+    "encoding/hex"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// Raw command passthrough for protocol research. Control commands are framed
+// for the configured model; data writes go to AE03 untouched.
+
+const MAX_RAW_WAIT = 10 * time.Second
+
+// RawResult is what came back from a raw command: the exact bytes written and
+// every notification seen during the wait window.
+type RawResult struct {
+    Sent          []byte
+    Notifications [][]byte
+}
+
+// SendRaw writes a raw command and collects notifications for up to wait.
+// The connection is left open so several commands can be sent in a row.
+func (pd *PrinterDaemon) SendRaw(toData bool, cmdID byte, payload []byte, wait time.Duration) (*RawResult, error) {
+    if wait > MAX_RAW_WAIT {
+        wait = MAX_RAW_WAIT
+    }
+
+    pd.jobMu.Lock()
+    defer pd.jobMu.Unlock()
+
+    if err := pd.ensureConnected(); err != nil {
+        return nil, fmt.Errorf("failed to connect: %v", err)
+    }
+
+    notifications, cancel := pd.tapNotifications()
+    defer cancel()
+
+    result := &RawResult{}
+    if toData {
+        result.Sent = payload
+        if err := pd.client.WriteCharacteristic(pd.dataChar, payload, true); err != nil {
+            return nil, fmt.Errorf("failed to write data: %v", err)
+        }
+    } else {
+        result.Sent = pd.command(cmdID, payload)
+        if err := pd.client.WriteCharacteristic(pd.controlChar, result.Sent, true); err != nil {
+            return nil, fmt.Errorf("failed to write command: %v", err)
+        }
+    }
+
+    deadline := time.After(wait)
+    for {
+        select {
+        case n := <-notifications:
+            result.Notifications = append(result.Notifications, n)
+        case <-deadline:
+            return result, nil
+        }
+    }
+}
+
+// parseHexBytes accepts "a1 00", "0xA1,0x00" and "a100" style input.
+func parseHexBytes(s string) ([]byte, error) {
+    s = strings.NewReplacer("0x", "", "0X", "", ",", "", " ", "", ":", "").Replace(s)
+    b, err := hex.DecodeString(s)
+    if err != nil {
+        return nil, fmt.Errorf("invalid hex %q: %v", s, err)
+    }
+    return b, nil
+}
+
+func describeNotification(framing Framing, n []byte) string {
+    if cmd, payload, ok := framing.Decode(n); ok {
+        return fmt.Sprintf("cmd=%s payload=%s", commandName(cmd), hex.EncodeToString(payload))
+    }
+    return "raw=" + hex.EncodeToString(n)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    "crypto/rand"
+    "image/color"
+    "math/big"
+)
+
+// Receipt codes are short codes handed back when a job is accepted and
+// stamped under the printout, so people sharing a printer can tell which
+// strip is theirs. The alphabet leaves out look-alikes (0/O, 1/I/L, 2/Z, 5/S,
+// 6/G, 8/B).
+
+const (
+    RECEIPT_ALPHABET = "ACDEFHJKMNPRTUVWXY3479"
+    RECEIPT_LENGTH   = 4
+    // Glyphs are 5x7, drawn at this scale
+    RECEIPT_SCALE = 4
+)
+
+var receiptGlyphs = map[rune][]string{
+    '#': {".X.X.", ".X.X.", "XXXXX", ".X.X.", "XXXXX", ".X.X.", ".X.X."},
+    'A': {".XXX.", "X...X", "X...X", "XXXXX", "X...X", "X...X", "X...X"},
+    'C': {".XXX.", "X...X", "X....", "X....", "X....", "X...X", ".XXX."},
+    'D': {"XXXX.", "X...X", "X...X", "X...X", "X...X", "X...X", "XXXX."},
+    'E': {"XXXXX", "X....", "X....", "XXXX.", "X....", "X....", "XXXXX"},
+    'F': {"XXXXX", "X....", "X....", "XXXX.", "X....", "X....", "X...."},
+    'H': {"X...X", "X...X", "X...X", "XXXXX", "X...X", "X...X", "X...X"},
+    'J': {"..XXX", "...X.", "...X.", "...X.", "...X.", "X..X.", ".XX.."},
+    'K': {"X...X", "X..X.", "X.X..", "XX...", "X.X..", "X..X.", "X...X"},
+    'M': {"X...X", "XX.XX", "X.X.X", "X.X.X", "X...X", "X...X", "X...X"},
+    'N': {"X...X", "X...X", "XX..X", "X.X.X", "X..XX", "X...X", "X...X"},
+    'P': {"XXXX.", "X...X", "X...X", "XXXX.", "X....", "X....", "X...."},
+    'R': {"XXXX.", "X...X", "X...X", "XXXX.", "X.X..", "X..X.", "X...X"},
+    'T': {"XXXXX", "..X..", "..X..", "..X..", "..X..", "..X..", "..X.."},
+    'U': {"X...X", "X...X", "X...X", "X...X", "X...X", "X...X", ".XXX."},
+    'V': {"X...X", "X...X", "X...X", "X...X", "X...X", ".X.X.", "..X.."},
+    'W': {"X...X", "X...X", "X...X", "X.X.X", "X.X.X", "XX.XX", "X...X"},
+    'X': {"X...X", "X...X", ".X.X.", "..X..", ".X.X.", "X...X", "X...X"},
+    'Y': {"X...X", "X...X", ".X.X.", "..X..", "..X..", "..X..", "..X.."},
+    '3': {"XXXX.", "....X", "....X", ".XXX.", "....X", "....X", "XXXX."},
+    '4': {"...X.", "..XX.", ".X.X.", "X..X.", "XXXXX", "...X.", "...X."},
+    '7': {"XXXXX", "....X", "...X.", "..X..", ".X...", ".X...", ".X..."},
+    '9': {".XXX.", "X...X", "X...X", ".XXXX", "....X", "...X.", ".XX.."},
+}
+
+func newReceiptCode() string {
+    code := make([]byte, RECEIPT_LENGTH)
+    max := big.NewInt(int64(len(RECEIPT_ALPHABET)))
+    for i := range code {
+        n, _ := rand.Int(rand.Reader, max)
+        code[i] = RECEIPT_ALPHABET[n.Int64()]
+    }
+    return string(code)
+}
+
+// receiptRows renders "#CODE" centred as encoded printer rows (unpadded).
+// Rendered text is rotated 180 degrees before printing, so the footer is
+// drawn upside down too and goes in front of the job.
+func receiptRows(code string) []byte {
+    text := []rune("#" + code)
+    glyphW, glyphH := 5*RECEIPT_SCALE, 7*RECEIPT_SCALE
+    advance := glyphW + RECEIPT_SCALE*2
+    height := glyphH + 24
+    img := blankCanvas(height)
+
+    left := (PRINTER_WIDTH - len(text)*advance + RECEIPT_SCALE*2) / 2
+    top := (height - glyphH) / 2
+    for i, r := range text {
+        glyph, ok := receiptGlyphs[r]
+        if !ok {
+            continue
+        }
+        for gy, line := range glyph {
+            for gx, c := range line {
+                if c != 'X' {
+                    continue
+                }
+                for dy := 0; dy < RECEIPT_SCALE; dy++ {
+                    for dx := 0; dx < RECEIPT_SCALE; dx++ {
+                        x := left + i*advance + gx*RECEIPT_SCALE + dx
+                        y := top + gy*RECEIPT_SCALE + dy
+                        img.SetGray(PRINTER_WIDTH-1-x, height-1-y, color.Gray{0})
+                    }
+                }
+            }
+        }
+    }
+    return encodeImageRows(img)
+}
+
+// withReceipt returns a copy of prepared with the receipt code stamped on.
+func withReceipt(prepared *preparedImage, code string) *preparedImage {
+    return prependRows(prepared, prepared.encodeRows(receiptRows(code)))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+
+    "golang.org/x/image/draw"
+)
+
+// Images are scaled to fill the paper width by default. With an alignment
+// they keep their size instead (only shrinking if too wide) and are placed
+// left, centred or right, so small stickers and QR codes don't get blown up.
+// The margin is kept clear on both sides either way.
+
+const (
+    ALIGN_FIT    = ""
+    ALIGN_LEFT   = "left"
+    ALIGN_CENTER = "center"
+    ALIGN_RIGHT  = "right"
+)
+
+// MAX_MARGIN leaves at least 64 dots to print on.
+const MAX_MARGIN = (PRINTER_WIDTH - 64) / 2
+
+// MAX_SCALE is the largest whole-number enlargement: one pixel to 8x8 dots,
+// enough to take a 48px sprite across the paper.
+const MAX_SCALE = 8
+
+// Densities: full prints every dot of a line; half renders at 192 pixels
+// across and fires each pixel as two dots, a coarser draft print.
+const (
+    DENSITY_FULL = "full"
+    DENSITY_HALF = "half"
+)
+
+func validDensity(density string) bool {
+    return density == "" || density == DENSITY_FULL || density == DENSITY_HALF
+}
+
+func validAlign(align string) bool {
+    return align == ALIGN_FIT || align == ALIGN_LEFT || align == ALIGN_CENTER || align == ALIGN_RIGHT
+}
+
+func validMargin(margin int) bool {
+    return margin >= 0 && margin <= MAX_MARGIN
+}
+
+func validScale(scale int) bool {
+    return scale >= 0 && scale <= MAX_SCALE
+}
+
+// scaleUp enlarges img by a whole number, every pixel becoming a square of
+// scale x scale dots, so pixel art stays crisp instead of being smoothed by
+// the resampling in fitToWidth.
+func scaleUp(img image.Image, scale int) (image.Image, error) {
+    b := img.Bounds()
+    if b.Dx()*scale > PRINTER_WIDTH {
+        return nil, fmt.Errorf("a %dpx wide image is %d dots at scale %d, the paper is %d (largest scale: %d)", b.Dx(), b.Dx()*scale, scale, PRINTER_WIDTH, max(PRINTER_WIDTH/b.Dx(), 1))
+    }
+    out := image.NewRGBA(image.Rect(0, 0, b.Dx()*scale, b.Dy()*scale))
+    draw.NearestNeighbor.Scale(out, out.Bounds(), img, b, draw.Src, nil)
+    return out, nil
+}
+
+// fitToWidth scales an image to the printer width, keeping its aspect ratio.
+// Catmull-Rom keeps edges sharp enough for text while still smoothing photos.
+// Images that are already the right width are returned untouched.
+func fitToWidth(img image.Image) image.Image {
+    return scaleToWidth(img, PRINTER_WIDTH)
+}
+
+func scaleToWidth(img image.Image, width int) image.Image {
+    b := img.Bounds()
+    if b.Dx() == width || b.Dx() == 0 {
+        return img
+    }
+    height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+    if height < 1 {
+        height = 1
+    }
+    out := image.NewRGBA(image.Rect(0, 0, width, height))
+    draw.CatmullRom.Scale(out, out.Bounds(), img, b, draw.Src, nil)
+    return out
+}
+
+// halveWidth squashes a full-width image to half the printer width, keeping
+// its height, for the half density.
+func halveWidth(img image.Image) image.Image {
+    b := img.Bounds()
+    out := image.NewRGBA(image.Rect(0, 0, PRINTER_WIDTH/2, b.Dy()))
+    draw.ApproxBiLinear.Scale(out, out.Bounds(), img, b, draw.Src, nil)
+    return out
+}
+
+// flipImage mirrors img left to right (h) and/or top to bottom (v).
+func flipImage(img image.Image, h, v bool) *image.Gray {
+    b := img.Bounds()
+    out := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+    for y := 0; y < b.Dy(); y++ {
+        sy := y
+        if v {
+            sy = b.Dy() - 1 - y
+        }
+        for x := 0; x < b.Dx(); x++ {
+            sx := x
+            if h {
+                sx = b.Dx() - 1 - x
+            }
+            out.Pix[y*out.Stride+x] = luminance(img.At(b.Min.X+sx, b.Min.Y+sy))
+        }
+    }
+    return out
+}
+
+// layoutImage scales and places img on a full-width white strip.
+func layoutImage(img image.Image, align string, margin int) image.Image {
+    if align == ALIGN_FIT && margin == 0 {
+        return fitToWidth(img)
+    }
+    usable := PRINTER_WIDTH - 2*margin
+    if align == ALIGN_FIT || img.Bounds().Dx() > usable {
+        img = scaleToWidth(img, usable)
+    }
+    b := img.Bounds()
+    x := margin
+    switch align {
+    case ALIGN_CENTER:
+        x = (PRINTER_WIDTH - b.Dx()) / 2
+    case ALIGN_RIGHT:
+        x = PRINTER_WIDTH - margin - b.Dx()
+    }
+    out := image.NewRGBA(image.Rect(0, 0, PRINTER_WIDTH, b.Dy()))
+    draw.Draw(out, out.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+    draw.Draw(out, image.Rect(x, 0, x+b.Dx(), b.Dy()), img, b.Min, draw.Over)
+    return out
+}
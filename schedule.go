@@ -0,0 +1,98 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "strings"
+    "time"
+)
+
+// Scheduled prints: a job with print_at is prepared right away, then waits in
+// the queued state until its time comes. Times without an offset are read in
+// the job's IANA time zone (tz), falling back to time_zone from the config and
+// then the host's local time, so a daemon on a UTC server can print at 7:00
+// where the printer actually is.
+
+// Accepted print_at layouts besides RFC 3339
+var PRINT_AT_LAYOUTS = []string{
+    "2006-01-02T15:04:05",
+    "2006-01-02T15:04",
+    "2006-01-02 15:04:05",
+    "2006-01-02 15:04",
+}
+
+// How far ahead a print can be scheduled
+const MAX_SCHEDULE_AHEAD = 7 * 24 * time.Hour
+
+// checkTimeZone makes sure a time_zone setting names a zone the host knows.
+// Empty means the config's time_zone.
+func checkTimeZone(tz string) error {
+    if tz == "" {
+        return nil
+    }
+    if _, err := time.LoadLocation(tz); err != nil {
+        return fmt.Errorf("unknown time_zone %q: %v", tz, err)
+    }
+    return nil
+}
+
+// parsePrintAt reads a print_at value. A bare "15:04" means the next time the
+// clock shows that in the given zone.
+func parsePrintAt(value, tz string, cfg *Config) (time.Time, error) {
+    loc := cfg.location
+    if loc == nil {
+        loc = time.Local
+    }
+    if tz != "" {
+        var err error
+        if loc, err = time.LoadLocation(tz); err != nil {
+            return time.Time{}, fmt.Errorf("unknown time zone %q", tz)
+        }
+    }
+
+    value = strings.TrimSpace(value)
+    if at, err := time.Parse(time.RFC3339, value); err == nil {
+        return at, nil
+    }
+    for _, layout := range PRINT_AT_LAYOUTS {
+        if at, err := time.ParseInLocation(layout, value, loc); err == nil {
+            return at, nil
+        }
+    }
+    if clock, err := time.Parse("15:04", value); err == nil {
+        now := time.Now().In(loc)
+        at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+        if !at.After(now) {
+            at = at.AddDate(0, 0, 1)
+        }
+        return at, nil
+    }
+    return time.Time{}, fmt.Errorf("invalid print_at %q (use RFC 3339, \"2006-01-02 15:04\" or \"15:04\")", value)
+}
+
+// SchedulePrint holds a prepared job until at, then prints it. It returns
+// straight away; the outcome is recorded in the job tracker.
+func (pd *PrinterDaemon) SchedulePrint(jobID string, at time.Time, prepared *preparedImage, opts PrintOptions) error {
+    if wait := time.Until(at); wait > MAX_SCHEDULE_AHEAD {
+        return fmt.Errorf("print_at is more than %v ahead", MAX_SCHEDULE_AHEAD)
+    }
+    pd.jobs.SetReceipt(jobID, opts.Receipt)
+    if opts.ReplyTo != "" {
+        pd.jobs.SetReply(jobID, opts.ReplyTo, prepared)
+    }
+    pd.jobs.SetRows(jobID, prepared.numRows)
+    pd.jobs.Set(jobID, JobQueued, nil)
+    log.Printf("Job %s scheduled for %s", jobID, at.Format(time.RFC3339))
+
+    go func() {
+        select {
+        case <-time.After(time.Until(at)):
+        case <-pd.jobs.Canceled(jobID):
+            return
+        }
+        if err := pd.PrintJob(jobID, prepared, opts); err != nil {
+            log.Printf("Scheduled job %s failed: %v", jobID, err)
+        }
+    }()
+    return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+)
+
+// Separators are printed between consecutive jobs of a batch, or between
+// back-to-back jobs from the same source, so the pieces are easy to tell
+// apart and tear off. The tear line is the scissors separator printed at the
+// end of a job instead, just before the feed.
+
+const (
+    SEPARATOR_NONE     = "none"
+    SEPARATOR_FEED     = "feed"
+    SEPARATOR_DASHED   = "dashed"
+    SEPARATOR_SCISSORS = "scissors"
+)
+
+const (
+    SEPARATOR_FEED_ROWS = 40
+    DASH_ON             = 8
+    DASH_OFF            = 6
+)
+
+var scissorsGlyph = []string{
+    ".XXX................",
+    "X...X...........XX..",
+    "X...X.........XX....",
+    ".XXX.X......XX......",
+    "......XX..XX........",
+    "........XX..........",
+    "......XX..XX........",
+    ".XXX.X......XX......",
+    "X...X.........XX....",
+    "X...X...........XX..",
+    ".XXX................",
+}
+
+func validSeparator(style string) bool {
+    switch style {
+    case "", SEPARATOR_NONE, SEPARATOR_FEED, SEPARATOR_DASHED, SEPARATOR_SCISSORS:
+        return true
+    }
+    return false
+}
+
+// separatorRows renders a separator as encoded printer rows (unpadded).
+func separatorRows(style string) ([]byte, error) {
+    switch style {
+    case "", SEPARATOR_NONE:
+        return nil, nil
+    case SEPARATOR_FEED:
+        return make([]byte, SEPARATOR_FEED_ROWS*PRINTER_WIDTH_BYTES), nil
+    case SEPARATOR_DASHED:
+        img := blankCanvas(20)
+        drawDashes(img, 0, 9, 2)
+        return encodeImageRows(img), nil
+    case SEPARATOR_SCISSORS:
+        img := blankCanvas(24)
+        top := (24 - len(scissorsGlyph)) / 2
+        for y, line := range scissorsGlyph {
+            for x, c := range line {
+                if c == 'X' {
+                    img.SetGray(4+x, top+y, color.Gray{0})
+                }
+            }
+        }
+        drawDashes(img, 4+len(scissorsGlyph[0])+4, top+len(scissorsGlyph)/2, 2)
+        return encodeImageRows(img), nil
+    }
+    return nil, fmt.Errorf("unknown separator %q", style)
+}
+
+func blankCanvas(height int) *image.Gray {
+    img := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, height))
+    for i := range img.Pix {
+        img.Pix[i] = 0xFF
+    }
+    return img
+}
+
+func drawDashes(img *image.Gray, startX, y, thickness int) {
+    for x := startX; x < PRINTER_WIDTH; x++ {
+        if (x-startX)%(DASH_ON+DASH_OFF) >= DASH_ON {
+            continue
+        }
+        for t := 0; t < thickness; t++ {
+            img.SetGray(x, y+t, color.Gray{0})
+        }
+    }
+}
+
+// withSeparator returns a copy of prepared with the separator rows in front.
+func withSeparator(prepared *preparedImage, style string) (*preparedImage, error) {
+    rows, err := separatorRows(style)
+    if err != nil || len(rows) == 0 {
+        return prepared, err
+    }
+    return prependRows(prepared, prepared.encodeRows(rows)), nil
+}
+
+// withTearLine returns a copy of prepared with a scissors line under it.
+func withTearLine(prepared *preparedImage) *preparedImage {
+    rows, _ := separatorRows(SEPARATOR_SCISSORS)
+    return appendRows(prepared, prepared.encodeRows(rows))
+}
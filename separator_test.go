@@ -0,0 +1,39 @@
+package main
+
+import (
+    "image"
+    "testing"
+)
+
+// Jobs from the same source only get a separator when one is configured.
+func TestSeparatorBetweenJobs(t *testing.T) {
+    if cfg := loadTestConfig(t, `{}`); cfg.Separator != "" {
+        t.Errorf("default separator is %q, want none", cfg.Separator)
+    }
+    plain := newPreparedImage("test", image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, 8)))
+    dashed, _ := separatorRows(SEPARATOR_DASHED)
+
+    tests := []struct {
+        name       string
+        config     string
+        opts       PrintOptions
+        lastSource string
+        extra      int
+    }{
+        {"default", "", PrintOptions{Source: "a"}, "a", 0},
+        {"configured", SEPARATOR_DASHED, PrintOptions{Source: "a"}, "a", len(dashed) / PRINTER_WIDTH_BYTES},
+        {"per job", "", PrintOptions{Source: "a", Separator: SEPARATOR_DASHED}, "a", len(dashed) / PRINTER_WIDTH_BYTES},
+        {"other source", SEPARATOR_DASHED, PrintOptions{Source: "a"}, "b", 0},
+    }
+    for _, tt := range tests {
+        pd := NewPrinterDaemon("", &Config{Separator: tt.config})
+        pd.lastSource = tt.lastSource
+        got, err := pd.decorate(pd.jobs.New("test"), plain, tt.opts)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got.numRows != plain.numRows+tt.extra {
+            t.Errorf("%s: got %d rows, want %d", tt.name, got.numRows, plain.numRows+tt.extra)
+        }
+    }
+}
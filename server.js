@@ -6,6 +6,7 @@ const fs = require('fs');
 const heicConvert = require('heic-convert');
 const sharp = require('sharp');
 const floydSteinberg = require('floyd-steinberg');
+const { resolveLocale } = require('./i18n');
 
 const app = express();
 const PORT = 3000;
@@ -13,6 +14,12 @@ const PORT = 3000;
 // Replace with your printer's MAC address
 const PRINTER_MAC = '48:0F:57:12:30:9D';
 
+// print.js exits with this code when the daemon queued the job for moderation
+const PENDING_APPROVAL_EXIT_CODE = 3;
+// ...and with these when the daemon wants a login or the user's quota is used up
+const UNAUTHORIZED_EXIT_CODE = 4;
+const QUOTA_EXIT_CODE = 5;
+
 // Function to apply Floyd-Steinberg dithering to an image buffer
 async function applyDithering(imageBuffer) {
   try {
@@ -183,6 +190,56 @@ app.get('/', (req, res) => {
   res.sendFile(path.join(__dirname, 'index.html'));
 });
 
+// Abuse protection challenge comes from the daemon
+app.get('/challenge', async (req, res) => {
+  try {
+    const response = await fetch('http://localhost:8080/challenge');
+    res.status(response.status).type('application/json').send(await response.text());
+  } catch (error) {
+    console.error('Challenge request failed:', error);
+    res.status(502).send('Daemon unavailable');
+  }
+});
+
+// Who is logged in, from the token the browser sends along
+app.get('/whoami', async (req, res) => {
+  try {
+    const response = await fetch('http://localhost:8080/whoami', { headers: loginHeaders(req) });
+    res.status(response.status).type('application/json').send(await response.text());
+  } catch (error) {
+    console.error('Whoami request failed:', error);
+    res.status(502).send('Daemon unavailable');
+  }
+});
+
+// The user's login: a token from the web UI, or the daemon's SSO session
+// cookie (cookies ignore the port, so the browser sends it here too)
+function loginHeaders(req) {
+  const headers = {};
+  if (req.get('Authorization')) headers['Authorization'] = req.get('Authorization');
+  const session = (req.get('Cookie') || '').split(';').map(c => c.trim()).find(c => c.startsWith('catprinter_session='));
+  if (session) headers['Cookie'] = session;
+  return headers;
+}
+
+// Headers passed on to the daemon so it can check the abuse-protection proof
+// and the user's login
+function proofHeaders(req) {
+  const proof = req.body.proof || {};
+  const headers = { 'X-Forwarded-For': req.ip, ...loginHeaders(req) };
+  if (proof.powChallenge) headers['X-PoW-Challenge'] = String(proof.powChallenge);
+  if (proof.powNonce) headers['X-PoW-Nonce'] = String(proof.powNonce);
+  if (proof.turnstileToken) headers['X-Turnstile-Token'] = String(proof.turnstileToken);
+  return headers;
+}
+
+// Locale for printed dates and labels: the form's "locale" field, then the
+// browser's Accept-Language, then CATPRINTER_LOCALE / en-US
+function requestLocale(req) {
+  const accepted = (req.get('Accept-Language') || '').split(',')[0].split(';')[0].trim();
+  return resolveLocale(req.body.locale || accepted);
+}
+
 // Handle the print form
 app.post('/print', async (req, res) => {
   const message = req.body.message || '';
@@ -283,22 +340,44 @@ app.post('/print', async (req, res) => {
       if (jobId) inProgressJobs.add(jobId);
 
       // Send print request to daemon
-      const daemonUrl = `http://localhost:8080/print?image=debug-receipt.png`;
+      const daemonUrl = `http://localhost:8080/print?image=debug-receipt.png&source=web`;
       
       fetch(daemonUrl, {
         method: 'POST',
         headers: {
-          'Content-Type': 'application/json'
+          'Content-Type': 'application/json',
+          ...proofHeaders(req)
         }
       })
       .then(response => {
-        if (response.ok) {
+        // Receipt code to show the user, when the daemon hands them out
+        const receipt = response.headers.get('X-Receipt-Code');
+        if (receipt) res.set('X-Receipt-Code', receipt);
+        if (response.status === 202) {
+          // Gallery mode: the daemon is holding the job for an admin
+          console.log('Print job queued for moderation');
+          if (jobId) {
+            recentJobs.set(jobId, Date.now());
+            cleanupOldJobs();
+          }
+          res.status(200).send('Submitted for approval');
+        } else if (response.ok) {
           console.log('Print job completed successfully');
           if (jobId) {
             recentJobs.set(jobId, Date.now());
             cleanupOldJobs();
           }
           res.status(200).send('Printed!');
+        } else if (response.status === 422) {
+          console.error('Daemon content filter rejected the submission');
+          res.status(422).send('Rejected by content filter');
+        } else if (response.status === 403) {
+          console.error('Daemon rejected the submission');
+          res.status(403).send('Rejected by abuse protection');
+        } else if (response.status === 401) {
+          res.status(401).send('Log in to print');
+        } else if (response.status === 429) {
+          res.status(429).send('Daily quota used up');
         } else {
           console.error('Daemon print failed:', response.status);
           res.status(500).send('Print failed');
@@ -318,22 +397,37 @@ app.post('/print', async (req, res) => {
   } else {
     // Text only or text + image - use the existing print.js workflow
     if (jobId) inProgressJobs.add(jobId);
-    const printProcess = spawn('node', ['print.js', PRINTER_MAC, message]);
+    const printProcess = spawn('node', ['print.js', PRINTER_MAC, message], {
+      env: {
+        ...process.env,
+        CATPRINTER_SOURCE: 'web',
+        CATPRINTER_EXTRA_HEADERS: JSON.stringify(proofHeaders(req)),
+        CATPRINTER_LOCALE: requestLocale(req),
+        CATPRINTER_VERTICAL: req.body.vertical ? '1' : '',
+        CATPRINTER_DIRECTION: ['ltr', 'rtl'].includes(req.body.direction) ? req.body.direction : 'auto'
+      }
+    });
 
     printProcess.stdout.on('data', (data) => {
       console.log(`[print.js stdout]: ${data}`);
+      const receipt = String(data).match(/^Receipt code: (\w+)$/m);
+      if (receipt) res.set('X-Receipt-Code', receipt[1]);
     });
     printProcess.stderr.on('data', (data) => {
       console.error(`[print.js stderr]: ${data}`);
     });
 
     printProcess.on('close', (code) => {
-      if (code === 0) {
+      if (code === 0 || code === PENDING_APPROVAL_EXIT_CODE) {
         if (jobId) {
           recentJobs.set(jobId, Date.now());
           cleanupOldJobs();
         }
-        res.status(200).send('Printed!');
+        res.status(200).send(code === 0 ? 'Printed!' : 'Submitted for approval');
+      } else if (code === UNAUTHORIZED_EXIT_CODE) {
+        res.status(401).send('Log in to print');
+      } else if (code === QUOTA_EXIT_CODE) {
+        res.status(429).send('Daily quota used up');
       } else {
         res.status(500).send('Print failed');
       }
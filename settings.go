@@ -0,0 +1,154 @@
+package main
+
+import (
+    "encoding/hex"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Settings read-back: right after connecting, the daemon asks the printer for
+// its current settings and keeps the answers for /status, so calibration tools
+// can show values before and after a change. Only models whose profile names
+// a query command for a setting are asked; the MXW01 has no documented read
+// commands, so its profile has none. A custom profile can add them:
+//
+//   "profiles": {"mxw01": {"settings": {"energy": "a3", "sleep_timeout": "b2"}}}
+//
+// Each query is sent with a 0x00 payload and answered by a notification with
+// the same command ID.
+
+var SETTING_NAMES = []string{"energy", "speed", "sleep_timeout"}
+
+const SETTINGS_READ_TIMEOUT = time.Second
+
+// SettingValue is one setting as read from the printer.
+type SettingValue struct {
+    // Value is the reply payload as a little-endian number (up to 4 bytes)
+    Value int `json:"value"`
+    // Raw is the whole reply payload in hex, for replies we can't interpret
+    Raw string `json:"raw"`
+}
+
+type PrinterSettings struct {
+    Values map[string]SettingValue `json:"values"`
+    // Errors lists settings whose query got no usable reply
+    Errors map[string]string `json:"errors,omitempty"`
+    Read   time.Time         `json:"read"`
+}
+
+func validSettingName(name string) bool {
+    for _, n := range SETTING_NAMES {
+        if n == name {
+            return true
+        }
+    }
+    return false
+}
+
+// parseSettingQueries reads a profile's setting name to command hex map.
+func parseSettingQueries(profile string, queries map[string]string) (map[string]byte, error) {
+    out := make(map[string]byte, len(queries))
+    for name, cmd := range queries {
+        if !validSettingName(name) {
+            return nil, fmt.Errorf("profile %s: unknown setting %q (known: %s)", profile, name, strings.Join(SETTING_NAMES, ", "))
+        }
+        b, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(cmd), "0x"))
+        if err != nil || len(b) != 1 {
+            return nil, fmt.Errorf("profile %s: setting %s: invalid command %q", profile, name, cmd)
+        }
+        out[name] = b[0]
+    }
+    return out, nil
+}
+
+// readSettings queries every setting the profile knows about. Callers must
+// hold the connection (it runs from Connect).
+func (pd *PrinterDaemon) readSettings() {
+    queries := pd.config.profile.SettingQueries
+    if len(queries) == 0 || pd.notifyChar == nil {
+        return
+    }
+    names := make([]string, 0, len(queries))
+    for name := range queries {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    notifications, cancel := pd.tapNotifications()
+    defer cancel()
+    settings := &PrinterSettings{Values: make(map[string]SettingValue), Errors: make(map[string]string), Read: time.Now()}
+    for _, name := range names {
+        cmdID := queries[name]
+        if err := pd.client.WriteCharacteristic(pd.controlChar, pd.command(cmdID, []byte{0x00}), true); err != nil {
+            settings.Errors[name] = err.Error()
+            continue
+        }
+        payload, err := pd.awaitReply(notifications, cmdID, SETTINGS_READ_TIMEOUT)
+        if err != nil {
+            settings.Errors[name] = err.Error()
+            continue
+        }
+        value := 0
+        for i := 0; i < len(payload) && i < 4; i++ {
+            value |= int(payload[i]) << (8 * i)
+        }
+        settings.Values[name] = SettingValue{Value: value, Raw: hex.EncodeToString(payload)}
+    }
+    log.Printf("Read %d printer settings (%d failed)", len(settings.Values), len(settings.Errors))
+
+    pd.statusMu.Lock()
+    pd.settings = settings
+    pd.statusMu.Unlock()
+}
+
+// awaitReply waits for a notification carrying cmdID and returns its payload.
+func (pd *PrinterDaemon) awaitReply(notifications <-chan []byte, cmdID byte, timeout time.Duration) ([]byte, error) {
+    deadline := time.After(timeout)
+    for {
+        select {
+        case n := <-notifications:
+            if cmd, payload, ok := pd.config.profile.Framing.Decode(n); ok && cmd == cmdID {
+                return payload, nil
+            }
+        case <-deadline:
+            return nil, fmt.Errorf("no reply within %v", timeout)
+        }
+    }
+}
+
+// PrinterInfo is what /status reports.
+type PrinterInfo struct {
+    Connected bool `json:"connected"`
+    // Status is the last A1 status notification, if any arrived
+    Status *StatusInfo `json:"status,omitempty"`
+    // Settings are from the last read-back; nil if the model has no queries
+    Settings *PrinterSettings `json:"settings,omitempty"`
+    // PaperUsedMM is paper printed since the last paper-out report
+    PaperUsedMM int `json:"paper_used_mm"`
+}
+
+type StatusInfo struct {
+    State       byte      `json:"state"`
+    Battery     byte      `json:"battery"`
+    Temperature byte      `json:"temperature"`
+    OK          bool      `json:"ok"`
+    Error       string    `json:"error,omitempty"`
+    Received    time.Time `json:"received"`
+}
+
+func (pd *PrinterDaemon) info() PrinterInfo {
+    pd.statusMu.Lock()
+    defer pd.statusMu.Unlock()
+    info := PrinterInfo{Connected: pd.connected, Settings: pd.settings, PaperUsedMM: pd.paperRows / DOTS_PER_MM}
+    if pd.hasStatus {
+        s := pd.status
+        info.Status = &StatusInfo{State: s.State, Battery: s.Battery, Temperature: s.Temperature, OK: s.OK, Received: s.Received}
+        if !s.OK {
+            info.Status.Error = statusErrorName(s.ErrorCode)
+        }
+    }
+    return info
+}
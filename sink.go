@@ -0,0 +1,83 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// What to do with a job when the printer can't be reached (no Bluetooth
+// adapter, printer off or out of range):
+//
+//	fail: give up after the usual connection retries (the default)
+//	hold: keep the job waiting in "connecting" and retry until the printer
+//	      shows up, the job is canceled or its TTL runs out
+//	file: write the job as a PNG to spool_dir instead, e.g. when developing
+//	      in a container without Bluetooth
+const (
+    OFFLINE_FAIL = "fail"
+    OFFLINE_HOLD = "hold"
+    OFFLINE_FILE = "file"
+)
+
+const (
+    DEFAULT_SPOOL_DIR      = "spool"
+    OFFLINE_RETRY_INTERVAL = 30 * time.Second
+)
+
+func validOfflineMode(mode string) bool {
+    switch mode {
+    case OFFLINE_FAIL, OFFLINE_HOLD, OFFLINE_FILE:
+        return true
+    }
+    return false
+}
+
+// connectForJob connects to the printer, holding the job while the printer
+// is unavailable if configured to.
+func (pd *PrinterDaemon) connectForJob(jobID string) error {
+    for {
+        err := pd.ensureConnected()
+        if err == nil {
+            return nil
+        }
+        if pd.config.Offline != OFFLINE_HOLD {
+            return fmt.Errorf("failed to connect: %v", err)
+        }
+        log.Printf("Printer unavailable, holding job %s: %v", jobID, err)
+        select {
+        case <-time.After(OFFLINE_RETRY_INTERVAL):
+        case <-pd.jobs.Canceled(jobID):
+            return errJobCanceled
+        }
+        if pd.jobs.Expired(jobID) {
+            return errJobExpired
+        }
+    }
+}
+
+// spoolJob writes a job to the spool directory as a PNG of exactly what would
+// have been printed.
+func (pd *PrinterDaemon) spoolJob(jobID string, prepared *preparedImage) error {
+    pd.jobs.Set(jobID, JobFinishing, nil)
+    data, err := prepared.png()
+    if err != nil {
+        return fmt.Errorf("failed to render spool file: %v", err)
+    }
+    if err := os.MkdirAll(pd.config.SpoolDir, 0755); err != nil {
+        return fmt.Errorf("failed to create spool dir: %v", err)
+    }
+    name := jobID
+    if name == "" {
+        name = newJobID()
+    }
+    path := filepath.Join(pd.config.SpoolDir, time.Now().Format("20060102-150405")+"-"+name+".png")
+    if err := os.WriteFile(path, data, 0644); err != nil {
+        return fmt.Errorf("failed to write spool file: %v", err)
+    }
+    pd.jobs.SetOutput(jobID, path)
+    log.Printf("Printer unavailable, job %s written to %s", jobID, path)
+    return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "time"
+)
+
+// Slots are named, replaceable notes: "today", "shopping", "wifi". A job
+// printed with slot=today becomes the slot's current content, and the job
+// that held the slot before is marked superseded in the history, so the old
+// strip on the fridge can go. What each slot currently says is kept under
+// spool_dir/slots, as <slot>.png and <slot>.json, and can be fetched at any
+// time, e.g. to check a note while away from the printer.
+
+const SLOT_DIR = "slots"
+
+var (
+    errNoSlot      = errors.New("no such slot")
+    validSlotNames = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+)
+
+// Slot is a slot's current content.
+type Slot struct {
+    Name    string    `json:"name"`
+    JobID   string    `json:"job_id"`
+    Source  string    `json:"source,omitempty"`
+    Rows    int       `json:"rows"`
+    Updated time.Time `json:"updated"`
+}
+
+func validSlot(name string) error {
+    if name != "" && !validSlotNames.MatchString(name) {
+        return fmt.Errorf("slot must be up to 32 lowercase letters, digits, - or _")
+    }
+    return nil
+}
+
+func (pd *PrinterDaemon) slotDir() string {
+    return filepath.Join(pd.config.SpoolDir, SLOT_DIR)
+}
+
+// fillSlot makes a printed job the slot's content and supersedes the job
+// that held it before.
+func (pd *PrinterDaemon) fillSlot(name, jobID string, prepared *preparedImage) {
+    dir := pd.slotDir()
+    previous, _ := readSlot(filepath.Join(dir, name+".json"))
+    content, err := prepared.png()
+    if err == nil {
+        err = os.MkdirAll(dir, 0755)
+    }
+    if err == nil {
+        err = os.WriteFile(filepath.Join(dir, name+".png"), content, 0600)
+    }
+    if err == nil {
+        slot := Slot{Name: name, JobID: jobID, Source: prepared.source, Rows: prepared.numRows, Updated: time.Now()}
+        if job, ok := pd.jobs.Get(jobID); ok {
+            slot.Source = job.Source
+        }
+        err = writeSlot(filepath.Join(dir, name+".json"), slot)
+    }
+    if err != nil {
+        log.Printf("Failed to save slot %s: %v", name, err)
+        return
+    }
+    if previous.JobID != "" && previous.JobID != jobID {
+        pd.jobs.Supersede(previous.JobID, jobID)
+        log.Printf("Slot %s: job %s replaces %s", name, jobID, previous.JobID)
+    }
+}
+
+func readSlot(path string) (Slot, error) {
+    var s Slot
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return s, err
+    }
+    err = json.Unmarshal(data, &s)
+    return s, err
+}
+
+func writeSlot(path string, s Slot) error {
+    data, err := json.MarshalIndent(s, "", "  ")
+    if err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// Slots lists every slot's current content, by name.
+func (pd *PrinterDaemon) Slots() ([]Slot, error) {
+    files, err := filepath.Glob(filepath.Join(pd.slotDir(), "*.json"))
+    if err != nil {
+        return nil, err
+    }
+    list := []Slot{}
+    for _, file := range files {
+        s, err := readSlot(file)
+        if err != nil {
+            log.Printf("Skipping slot %s: %v", filepath.Base(file), err)
+            continue
+        }
+        list = append(list, s)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+    return list, nil
+}
+
+// SlotContent returns a slot and what it currently says, as a PNG.
+func (pd *PrinterDaemon) SlotContent(name string) (Slot, []byte, error) {
+    if name == "" || validSlot(name) != nil {
+        return Slot{}, nil, errNoSlot
+    }
+    s, err := readSlot(filepath.Join(pd.slotDir(), name+".json"))
+    if err != nil {
+        return s, nil, errNoSlot
+    }
+    content, err := os.ReadFile(filepath.Join(pd.slotDir(), name+".png"))
+    if err != nil {
+        return s, nil, fmt.Errorf("slot content missing: %v", err)
+    }
+    return s, content, nil
+}
@@ -0,0 +1,65 @@
+//go:build daemon
+
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/draw"
+    "image/png"
+    "os"
+    "strings"
+)
+
+// Snapshot cards: a camera image (a doorbell ring, a person at the gate)
+// with a short caption above it saying where and when, printed by consumers
+// with the "snapshot" format.
+
+// Blank rows between the caption and the image
+const SNAPSHOT_CAPTION_GAP = 8
+
+// composeSnapshotCard stacks the caption and the image, scaled to the paper
+// width, and writes them to a temporary PNG for the print pipeline. The
+// caller removes the file.
+func composeSnapshotCard(caption string, data []byte) (string, error) {
+    snapshot, err := decodeImage(bytes.NewReader(data), false)
+    if err != nil {
+        return "", fmt.Errorf("invalid snapshot: %v", err)
+    }
+    snapshot = fitToWidth(applyOrientation(snapshot, jpegOrientation(data)))
+
+    t, err := newTextRenderer(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT)
+    if err != nil {
+        return "", err
+    }
+    var lines []string
+    if caption = strings.TrimRight(caption, "\n"); caption != "" {
+        for _, line := range strings.Split(caption, "\n") {
+            lines = append(lines, t.wrap(line)...)
+        }
+    }
+    text := t.render(lines)
+    top := text.Bounds().Dy()
+    if top > 0 {
+        top += SNAPSHOT_CAPTION_GAP
+    }
+
+    sb := snapshot.Bounds()
+    card := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, top+sb.Dy()))
+    draw.Draw(card, card.Bounds(), image.White, image.Point{}, draw.Src)
+    draw.Draw(card, text.Bounds(), text, image.Point{}, draw.Src)
+    draw.Draw(card, image.Rect(0, top, sb.Dx(), top+sb.Dy()), snapshot, sb.Min, draw.Src)
+
+    tmp, err := os.CreateTemp("", "catprinter-snapshot-*.png")
+    if err != nil {
+        return "", fmt.Errorf("failed to create temp file: %v", err)
+    }
+    if err := png.Encode(tmp, card); err != nil {
+        tmp.Close()
+        os.Remove(tmp.Name())
+        return "", fmt.Errorf("failed to write temp file: %v", err)
+    }
+    tmp.Close()
+    return tmp.Name(), nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+// Image sources can be local paths or remote references:
+//
+//   s3://bucket/path/to/image.png           signed with the [s3] credentials
+//   dav://host/path, davs://host/path       WebDAV over http/https
+//   webdav://host/path                      alias for davs://
+//   -                                       standard input (CLI only)
+//
+// Remote objects are fetched with a plain GET; nothing is cached on disk.
+
+const (
+    SOURCE_FETCH_TIMEOUT = 30 * time.Second
+    EMPTY_PAYLOAD_SHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// STDIN_SOURCE reads the image from standard input. Only the CLI turns it
+// on; for the daemon "-" is just a file name.
+const STDIN_SOURCE = "-"
+
+var sourceClient = &http.Client{Timeout: SOURCE_FETCH_TIMEOUT}
+
+func openImageSource(cfg *Config, ref string) (io.ReadCloser, error) {
+    if ref == STDIN_SOURCE && cfg.stdin {
+        return io.NopCloser(os.Stdin), nil
+    }
+    u, err := url.Parse(ref)
+    if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+        // Plain path (a single letter "scheme" is a Windows drive)
+        return os.Open(ref)
+    }
+
+    switch u.Scheme {
+    case "s3":
+        return fetchS3Object(cfg.S3, u.Host, strings.TrimPrefix(u.Path, "/"))
+    case "dav", "davs", "webdav":
+        return fetchWebDAV(cfg.WebDAV, u)
+    case "file":
+        return os.Open(u.Path)
+    default:
+        return nil, fmt.Errorf("unsupported image source scheme %q", u.Scheme)
+    }
+}
+
+func fetchWebDAV(accounts map[string]WebDAVAccount, u *url.URL) (io.ReadCloser, error) {
+    target := *u
+    if u.Scheme == "dav" {
+        target.Scheme = "http"
+    } else {
+        target.Scheme = "https"
+    }
+
+    req, err := http.NewRequest("GET", target.String(), nil)
+    if err != nil {
+        return nil, err
+    }
+    if acct, ok := accounts[u.Host]; ok {
+        req.SetBasicAuth(acct.Username, acct.Password)
+    } else if acct, ok := accounts[u.Hostname()]; ok {
+        req.SetBasicAuth(acct.Username, acct.Password)
+    } else if u.User != nil {
+        pass, _ := u.User.Password()
+        req.SetBasicAuth(u.User.Username(), pass)
+    }
+    return doSourceRequest(req)
+}
+
+func fetchS3Object(s3 S3Config, bucket, key string) (io.ReadCloser, error) {
+    if bucket == "" || key == "" {
+        return nil, fmt.Errorf("s3 source must look like s3://bucket/key")
+    }
+    if s3.AccessKeyID == "" || s3.SecretAccessKey == "" {
+        return nil, fmt.Errorf("no S3 credentials configured")
+    }
+
+    endpoint, err := url.Parse(strings.TrimRight(s3.Endpoint, "/"))
+    if err != nil {
+        return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+    }
+    segments := []string{bucket}
+    segments = append(segments, strings.Split(key, "/")...)
+    for i, s := range segments {
+        segments[i] = s3EscapePath(s)
+    }
+    escapedPath := endpoint.EscapedPath() + "/" + strings.Join(segments, "/")
+
+    req, err := http.NewRequest("GET", endpoint.Scheme+"://"+endpoint.Host+escapedPath, nil)
+    if err != nil {
+        return nil, err
+    }
+    signS3Request(req, s3, escapedPath, time.Now().UTC())
+    return doSourceRequest(req)
+}
+
+// signS3Request adds an AWS Signature Version 4 Authorization header to a GET
+// request with an empty body.
+func signS3Request(req *http.Request, s3 S3Config, escapedPath string, now time.Time) {
+    amzDate := now.Format("20060102T150405Z")
+    day := now.Format("20060102")
+
+    req.Header.Set("x-amz-date", amzDate)
+    req.Header.Set("x-amz-content-sha256", EMPTY_PAYLOAD_SHA256)
+    headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+    values := []string{req.URL.Host, EMPTY_PAYLOAD_SHA256, amzDate}
+    if s3.SessionToken != "" {
+        req.Header.Set("x-amz-security-token", s3.SessionToken)
+        headers = append(headers, "x-amz-security-token")
+        values = append(values, s3.SessionToken)
+    }
+
+    var canonicalHeaders strings.Builder
+    for i, h := range headers {
+        canonicalHeaders.WriteString(h + ":" + values[i] + "\n")
+    }
+    signedHeaders := strings.Join(headers, ";")
+
+    canonicalRequest := strings.Join([]string{
+        "GET",
+        escapedPath,
+        "",
+        canonicalHeaders.String(),
+        signedHeaders,
+        EMPTY_PAYLOAD_SHA256,
+    }, "\n")
+
+    scope := day + "/" + s3.Region + "/s3/aws4_request"
+    requestHash := sha256.Sum256([]byte(canonicalRequest))
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+    key := hmacSHA256([]byte("AWS4"+s3.SecretAccessKey), day)
+    key = hmacSHA256(key, s3.Region)
+    key = hmacSHA256(key, "s3")
+    key = hmacSHA256(key, "aws4_request")
+    signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        s3.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    h := hmac.New(sha256.New, key)
+    h.Write([]byte(data))
+    return h.Sum(nil)
+}
+
+// s3EscapePath escapes one path segment the way SigV4 expects: everything
+// except unreserved characters is percent-encoded.
+func s3EscapePath(s string) string {
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+            c == '-' || c == '_' || c == '.' || c == '~' {
+            b.WriteByte(c)
+        } else {
+            fmt.Fprintf(&b, "%%%02X", c)
+        }
+    }
+    return b.String()
+}
+
+func doSourceRequest(req *http.Request) (io.ReadCloser, error) {
+    resp, err := sourceClient.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to fetch %s: %v", req.URL.Redacted(), err)
+    }
+    if resp.StatusCode != http.StatusOK {
+        resp.Body.Close()
+        return nil, fmt.Errorf("failed to fetch %s: %s", req.URL.Redacted(), resp.Status)
+    }
+    return resp.Body, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import "log"
+
+// Very tall prints (receipts rolls, long banners) tend to fail somewhere in
+// the middle of one huge transfer. With split_rows set they are sent as
+// several print requests of at most that many rows, back to back on the same
+// connection. Only the last segment carries the padding and the feed, so the
+// paper doesn't stop and feed between segments. The print request's row
+// count is 16 bits, so anything taller than that is always split.
+
+const (
+    MAX_PRINT_REQUEST_ROWS = 0xFFFF
+    MIN_SPLIT_ROWS         = 500
+    // Segment size when express jobs or checkpoints need segments and
+    // split_rows isn't set: how long an express job waits at most, and how
+    // much a resumed job may print twice
+    DEFAULT_SEGMENT_ROWS = 1000
+)
+
+func validSplitRows(rows int) bool {
+    return rows == 0 || (rows >= MIN_SPLIT_ROWS && rows <= MAX_PRINT_REQUEST_ROWS)
+}
+
+// splitRows is the segment size for long jobs.
+func (pd *PrinterDaemon) splitRows() int {
+    if pd.config.SplitRows == 0 && (pd.config.ExpressRows > 0 || pd.config.Checkpoints) {
+        return DEFAULT_SEGMENT_ROWS
+    }
+    return pd.config.SplitRows
+}
+
+// segments cuts prepared into consecutive pieces of at most limit rows.
+func segments(prepared *preparedImage, limit int) []*preparedImage {
+    if limit <= 0 || limit > MAX_PRINT_REQUEST_ROWS {
+        limit = MAX_PRINT_REQUEST_ROWS
+    }
+    if prepared.numRows <= limit {
+        return []*preparedImage{prepared}
+    }
+    var out []*preparedImage
+    for start := 0; start < prepared.numRows; start += limit {
+        rows := limit
+        end := (start + rows) * prepared.rowBytes()
+        if start+rows >= prepared.numRows {
+            // The last segment keeps the padding after the image
+            rows = prepared.numRows - start
+            end = len(prepared.buffer)
+        }
+        out = append(out, prepared.with(prepared.buffer[start*prepared.rowBytes():end], rows))
+    }
+    return out
+}
+
+// printSegments prints prepared, split if it is taller than split_rows.
+// Callers must hold jobMu.
+func (pd *PrinterDaemon) printSegments(jobID string, prepared *preparedImage) error {
+    parts := segments(prepared, pd.splitRows())
+    checkpoint := pd.startCheckpoint(jobID, prepared, len(parts))
+    for i, part := range parts {
+        if i > 0 {
+            pd.serveExpress()
+        }
+        if len(parts) > 1 {
+            log.Printf("Print job %s: segment %d/%d (%d rows)", jobID, i+1, len(parts), part.numRows)
+        }
+        if err := newPrintJob(pd, jobID, part).run(); err != nil {
+            checkpoint.fail(err)
+            return err
+        }
+        checkpoint.advance(i + 1)
+    }
+    checkpoint.finish()
+    return nil
+}
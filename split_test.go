@@ -0,0 +1,80 @@
+package main
+
+import (
+    "bytes"
+    "testing"
+)
+
+// testRows returns n encoded rows, each filled with its own row number, and
+// a padding row after them.
+func testRows(n int) *preparedImage {
+    buffer := make([]byte, (n+1)*PRINTER_WIDTH_BYTES)
+    for i := 0; i < n; i++ {
+        for b := 0; b < PRINTER_WIDTH_BYTES; b++ {
+            buffer[i*PRINTER_WIDTH_BYTES+b] = byte(i)
+        }
+    }
+    return &preparedImage{buffer: buffer, numRows: n}
+}
+
+func TestSegments(t *testing.T) {
+    tests := []struct {
+        rows, limit int
+        want        []int
+    }{
+        {2500, 1000, []int{1000, 1000, 500}},
+        {2000, 1000, []int{1000, 1000}},
+        {999, 1000, []int{999}},
+        {2500, 0, []int{2500}},
+        {MAX_PRINT_REQUEST_ROWS + 10, 0, []int{MAX_PRINT_REQUEST_ROWS, 10}},
+    }
+    for _, tt := range tests {
+        prepared := testRows(tt.rows)
+        parts := segments(prepared, tt.limit)
+        var got []int
+        var joined []byte
+        for _, part := range parts {
+            got = append(got, part.numRows)
+            joined = append(joined, part.buffer...)
+        }
+        if len(got) != len(tt.want) {
+            t.Errorf("%d rows by %d: got segments %v, want %v", tt.rows, tt.limit, got, tt.want)
+            continue
+        }
+        for i := range got {
+            if got[i] != tt.want[i] {
+                t.Errorf("%d rows by %d: got segments %v, want %v", tt.rows, tt.limit, got, tt.want)
+                break
+            }
+        }
+        // Nothing lost or repeated, and the padding stays at the very end
+        if !bytes.Equal(joined, prepared.buffer) {
+            t.Errorf("%d rows by %d: segments don't add up to the image", tt.rows, tt.limit)
+        }
+    }
+}
+
+// Each segment is a print of its own: status, print request, rows, flush.
+func TestPrintSegments(t *testing.T) {
+    j, client := newTestPrintJob(0)
+    j.pd.config.SplitRows = 4
+    prepared := testRows(10)
+
+    if err := j.pd.printSegments(j.jobID, prepared); err != nil {
+        t.Fatal(err)
+    }
+    client.mu.Lock()
+    defer client.mu.Unlock()
+    for _, cmd := range []byte{CMD_GET_STATUS, CMD_PRINT_REQUEST, CMD_FLUSH} {
+        if n := bytes.Count(client.commands, []byte{cmd}); n != 3 {
+            t.Errorf("%s sent %d times, want 3: % X", commandName(cmd), n, client.commands)
+        }
+    }
+    sent := 0
+    for _, n := range client.writes {
+        sent += n
+    }
+    if sent != len(prepared.buffer) {
+        t.Errorf("sent %d bytes, want %d", sent, len(prepared.buffer))
+    }
+}
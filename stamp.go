@@ -0,0 +1,101 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// Header and footer stamps: lines of text printed above and below a job so
+// a receipt or log print says where and when it came from. Both are
+// text/templates, set for the daemon ("header", "footer") or per job:
+//
+//   "footer": "{{.Time}} {{.Host}} #{{.Job}}"
+//
+// Templates see StampData. A job's own template replaces the daemon's.
+
+const MAX_STAMP_TEMPLATE = 200
+
+// StampData is what header and footer templates see.
+type StampData struct {
+    // Time is the print time as "2006-01-02 15:04"; Now is the same as a
+    // time.Time, for other layouts ({{.Now.Format "Mon 15:04"}})
+    Time   string
+    Now    time.Time
+    Host   string
+    Job    string
+    Source string
+    // Label is whatever the job was labelled with
+    Label string
+}
+
+func parseStamp(text string) (*template.Template, error) {
+    if len(text) > MAX_STAMP_TEMPLATE {
+        return nil, fmt.Errorf("header and footer templates are limited to %d characters", MAX_STAMP_TEMPLATE)
+    }
+    return template.New("stamp").Option("missingkey=zero").Parse(text)
+}
+
+func validStamp(text string) error {
+    _, err := parseStamp(text)
+    return err
+}
+
+// stampRows renders a header or footer template as encoded printer rows.
+func (pd *PrinterDaemon) stampRows(text, jobID string, opts PrintOptions) ([]byte, error) {
+    tmpl, err := parseStamp(text)
+    if err != nil {
+        return nil, err
+    }
+    now := time.Now().In(pd.config.location)
+    host, _ := os.Hostname()
+    var out bytes.Buffer
+    data := StampData{Time: now.Format("2006-01-02 15:04"), Now: now, Host: host, Job: jobID, Source: opts.Source, Label: opts.Label}
+    if err := tmpl.Execute(&out, data); err != nil {
+        return nil, fmt.Errorf("stamp template: %v", err)
+    }
+    t, err := newTextRenderer(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT)
+    if err != nil {
+        return nil, err
+    }
+    var lines []string
+    for _, line := range strings.Split(strings.TrimRight(out.String(), "\n"), "\n") {
+        lines = append(lines, t.wrap(line)...)
+    }
+    return encodeImageRows(t.render(lines)), nil
+}
+
+// withStamps returns a copy of prepared with the job's header above it and
+// footer below it, or prepared itself when there are none.
+func (pd *PrinterDaemon) withStamps(jobID string, prepared *preparedImage, opts PrintOptions) (*preparedImage, error) {
+    header, footer := opts.Header, opts.Footer
+    if header == "" {
+        header = pd.config.Header
+    }
+    if footer == "" {
+        footer = pd.config.Footer
+    }
+    if header == "" && footer == "" {
+        return prepared, nil
+    }
+    var headerRows, footerRows []byte
+    var err error
+    if header != "" {
+        if headerRows, err = pd.stampRows(header, jobID, opts); err != nil {
+            return nil, err
+        }
+    }
+    if footer != "" {
+        if footerRows, err = pd.stampRows(footer, jobID, opts); err != nil {
+            return nil, err
+        }
+    }
+    headerRows, footerRows = prepared.encodeRows(headerRows), prepared.encodeRows(footerRows)
+    rows := prepared.rows()
+    buffer := make([]byte, 0, len(headerRows)+len(rows)+len(footerRows))
+    buffer = append(append(append(buffer, headerRows...), rows...), footerRows...)
+    return prepared.with(buffer, len(buffer)/prepared.rowBytes()), nil
+}
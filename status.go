@@ -0,0 +1,58 @@
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+// PrinterStatus is the decoded payload of an A1 status notification. Offsets
+// follow PROTOCOL.md; temperature and battery are approximate raw readings.
+type PrinterStatus struct {
+    State       byte
+    Battery     byte
+    Temperature byte
+    OK          bool
+    ErrorCode   byte
+    Received    time.Time
+}
+
+func (s PrinterStatus) String() string {
+    if !s.OK {
+        return fmt.Sprintf("error %d (%s), battery %d, temp %d", s.ErrorCode, statusErrorName(s.ErrorCode), s.Battery, s.Temperature)
+    }
+    return fmt.Sprintf("state %d, battery %d, temp %d", s.State, s.Battery, s.Temperature)
+}
+
+func (s PrinterStatus) PaperOut() bool {
+    return !s.OK && (s.ErrorCode == 1 || s.ErrorCode == 9)
+}
+
+func statusErrorName(code byte) string {
+    switch code {
+    case 1, 9:
+        return "no paper"
+    case 4:
+        return "overheated"
+    case 8:
+        return "low battery"
+    default:
+        return "unknown"
+    }
+}
+
+func parseStatusPayload(payload []byte) (PrinterStatus, bool) {
+    if len(payload) < STATUS_MIN_LENGTH {
+        return PrinterStatus{}, false
+    }
+    status := PrinterStatus{
+        State:       payload[STATUS_STATE_OFFSET],
+        Battery:     payload[STATUS_BATTERY_OFFSET],
+        Temperature: payload[STATUS_TEMPERATURE_OFFSET],
+        OK:          payload[STATUS_FLAG_OFFSET] == STATUS_OK,
+        Received:    time.Now(),
+    }
+    if !status.OK && len(payload) > STATUS_ERROR_OFFSET {
+        status.ErrorCode = payload[STATUS_ERROR_OFFSET]
+    }
+    return status, true
+}
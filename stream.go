@@ -0,0 +1,132 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "log"
+    "time"
+)
+
+// Streaming text jobs print very long text (book chapters, logs) as it is
+// read instead of rendering all of it first. Every STREAM_SEGMENT_LINES
+// wrapped lines are rendered and sent as their own print request on the same
+// connection, like split.go does for tall images, so memory stays bounded
+// and the printer starts while the upload is still going. A segment is held
+// back until more text arrives, so the last one, which gets the tear line and
+// feed, is known.
+
+const (
+    // About 55cm of paper at the default line height
+    STREAM_SEGMENT_LINES = 200
+    // Longest single line read from the stream
+    MAX_STREAM_LINE = 64 << 10
+)
+
+// PrintTextStream prints the text read from r under jobID. Text already
+// printed stays printed if reading fails half way.
+func (pd *PrinterDaemon) PrintTextStream(jobID string, r io.Reader, opts PrintOptions) error {
+    pd.jobs.Set(jobID, JobQueued, nil)
+    if ttl := pd.jobTTL(opts); ttl > 0 {
+        pd.jobs.SetExpiry(jobID, time.Now().Add(ttl))
+    }
+
+    pd.lockForJob()
+    defer pd.jobMu.Unlock()
+
+    err := pd.streamText(jobID, r, opts)
+    pd.jobs.Finish(jobID, err)
+    return err
+}
+
+func (pd *PrinterDaemon) streamText(jobID string, r io.Reader, opts PrintOptions) error {
+    select {
+    case <-pd.jobs.Canceled(jobID):
+        return errJobCanceled
+    default:
+    }
+    if pd.jobs.Expired(jobID) {
+        return errJobExpired
+    }
+    text, err := newTextRenderer(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT)
+    if err != nil {
+        return err
+    }
+
+    // Streams can't be spooled: there is no whole image to write
+    pd.jobs.Set(jobID, JobConnecting, nil)
+    if err := pd.connectForJob(jobID); err != nil {
+        return err
+    }
+    defer pd.releaseConnection()
+    // The limit covers the whole stream, not each segment
+    defer pd.watchJob(jobID)()
+
+    first := true
+    printed := 0
+    send := func(lines []string, last bool) error {
+        prepared := newPreparedImage(opts.Source, text.render(lines))
+        var err error
+        if first {
+            if opts.Receipt != "" {
+                prepared = withReceipt(prepared, opts.Receipt)
+            }
+            prepared = withFeedBefore(prepared, pd.feedBeforeRows(opts))
+            if opts.Source != "" && opts.Source == pd.lastSource {
+                if prepared, err = withSeparator(prepared, pd.separatorStyle(opts)); err != nil {
+                    return err
+                }
+            }
+        }
+        if last {
+            if opts.TearLine || pd.config.TearLine {
+                prepared = withTearLine(prepared)
+            }
+            prepared = withFeed(prepared, pd.feedRows(opts))
+        }
+        if !first {
+            // Between segments, like any long job
+            pd.serveExpress()
+        }
+        first = false
+        printed += prepared.numRows
+        pd.jobs.SetRows(jobID, printed)
+        return pd.printPrepared(jobID, prepared)
+    }
+
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 4096), MAX_STREAM_LINE)
+    var lines []string
+    for scanner.Scan() {
+        lines = append(lines, text.wrap(scanner.Text())...)
+        for len(lines) > STREAM_SEGMENT_LINES {
+            select {
+            case <-pd.jobs.Canceled(jobID):
+                if pd.jobs.TimedOut(jobID) {
+                    return errJobTimedOut
+                }
+                return errJobCanceled
+            default:
+            }
+            log.Printf("Print job %s: streaming %d lines", jobID, STREAM_SEGMENT_LINES)
+            if err := send(lines[:STREAM_SEGMENT_LINES], false); err != nil {
+                return err
+            }
+            lines = append([]string{}, lines[STREAM_SEGMENT_LINES:]...)
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return fmt.Errorf("reading text failed: %v", err)
+    }
+    if len(lines) == 0 {
+        if first {
+            return fmt.Errorf("no text to print")
+        }
+        return nil
+    }
+    if err := send(lines, true); err != nil {
+        return err
+    }
+    pd.lastSource = opts.Source
+    return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "image/draw"
+    "math"
+    "strconv"
+    "time"
+)
+
+// The sun card: sunrise, sunset, day length and the moon's phase for one day
+// at the configured place ("place" in the config, or latitude and longitude
+// parameters). Times are worked out with the usual low-precision formulas,
+// good to a minute or two away from the poles, and shown in time_zone or the
+// tz parameter.
+
+const (
+    J2000        = 2451545.0
+    UNIX_EPOCH_J = 2440587.5
+    // Mean length of a lunar month in days, and a known new moon
+    // (2000-01-06 18:14 UTC) to count from
+    SYNODIC_MONTH = 29.530588853
+    NEW_MOON_J    = 2451550.26
+
+    MOON_GLYPH_RADIUS = 40
+)
+
+func init() {
+    registerCard("sun", renderSunCard)
+}
+
+// SunTimes is the sun's day at one place. With PolarDay or PolarNight set
+// the sun doesn't rise or set, and Sunrise and Sunset are zero.
+type SunTimes struct {
+    Sunrise, Noon, Sunset time.Time
+    PolarDay, PolarNight  bool
+}
+
+func julianDay(t time.Time) float64 {
+    return float64(t.Unix())/86400 + UNIX_EPOCH_J
+}
+
+func fromJulianDay(j float64) time.Time {
+    return time.Unix(int64(math.Round((j-UNIX_EPOCH_J)*86400)), 0)
+}
+
+func sinDeg(d float64) float64 { return math.Sin(d * math.Pi / 180) }
+func cosDeg(d float64) float64 { return math.Cos(d * math.Pi / 180) }
+
+// sunTimes works out the sunrise equation for the day of date, latitude
+// north and longitude east in degrees.
+func sunTimes(date time.Time, lat, lon float64) SunTimes {
+    noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, date.Location())
+    n := math.Round(julianDay(noon) - J2000 + 0.0008)
+    meanNoon := n - lon/360
+    anomaly := math.Mod(357.5291+0.98560028*meanNoon, 360)
+    center := 1.9148*sinDeg(anomaly) + 0.02*sinDeg(2*anomaly) + 0.0003*sinDeg(3*anomaly)
+    longitude := math.Mod(anomaly+center+180+102.9372, 360)
+    transit := J2000 + meanNoon + 0.0053*sinDeg(anomaly) - 0.0069*sinDeg(2*longitude)
+    sinDecl := sinDeg(longitude) * sinDeg(23.4397)
+    cosDecl := math.Cos(math.Asin(sinDecl))
+    // -0.833 degrees: refraction and the sun's radius
+    cosHour := (sinDeg(-0.833) - sinDeg(lat)*sinDecl) / (cosDeg(lat) * cosDecl)
+
+    st := SunTimes{Noon: fromJulianDay(transit).In(date.Location())}
+    switch {
+    case cosHour < -1:
+        st.PolarDay = true
+    case cosHour > 1:
+        st.PolarNight = true
+    default:
+        hour := math.Acos(cosHour) * 180 / math.Pi
+        st.Sunrise = fromJulianDay(transit - hour/360).In(date.Location())
+        st.Sunset = fromJulianDay(transit + hour/360).In(date.Location())
+    }
+    return st
+}
+
+// moonAge is how far the moon is through its cycle at t: 0 is new, 0.5 full.
+func moonAge(t time.Time) float64 {
+    age := math.Mod((julianDay(t)-NEW_MOON_J)/SYNODIC_MONTH, 1)
+    if age < 0 {
+        age++
+    }
+    return age
+}
+
+func moonPhaseName(age float64, l *Locale) string {
+    return l.T(fmt.Sprintf("moon_phase_%d", int(age*8+0.5)%8))
+}
+
+// moonIllumination is the lit fraction of the disc, 0 to 1.
+func moonIllumination(age float64) float64 {
+    return (1 - math.Cos(2*math.Pi*age)) / 2
+}
+
+// moonGlyph draws the moon, the shadow in black and the lit part left white
+// inside an outline. South of the equator it is seen the other way round.
+func moonGlyph(age float64, radius int, south bool) *image.Gray {
+    size := 2*radius + 1
+    img := image.NewGray(image.Rect(0, 0, size, size))
+    draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+    r := float64(radius)
+    terminator := math.Cos(2 * math.Pi * age)
+    for y := 0; y < size; y++ {
+        v := (float64(y) - r) / r
+        for x := 0; x < size; x++ {
+            u := (float64(x) - r) / r
+            if south {
+                u = -u
+            }
+            d := math.Hypot(u, v)
+            if d > 1 {
+                continue
+            }
+            if d > 1-2/r {
+                img.Pix[y*img.Stride+x] = 0
+                continue
+            }
+            // Waxing moons are lit from the right, waning from the left
+            half := math.Sqrt(1 - v*v)
+            lit := u > terminator*half
+            if age > 0.5 {
+                lit = u < -terminator*half
+            }
+            if !lit {
+                img.Pix[y*img.Stride+x] = 0
+            }
+        }
+    }
+    return img
+}
+
+func formatDayLength(d time.Duration, l *Locale) string {
+    d = d.Round(time.Minute)
+    return l.T("sun_day_length", "hours", fmt.Sprint(int(d.Hours())), "minutes", fmt.Sprintf("%02d", int(d.Minutes())%60))
+}
+
+// renderSunCard takes the parameters date (2006-01-02, default today), tz,
+// locale, and latitude, longitude and place, which override the configured place.
+func renderSunCard(cfg *Config, params map[string]string, now time.Time, l *Locale) (image.Image, error) {
+    var place PlaceConfig
+    if cfg.Place != nil {
+        place = *cfg.Place
+    }
+    if value := params["place"]; value != "" {
+        place.Name = value
+    }
+    for key, dst := range map[string]*float64{"latitude": &place.Latitude, "longitude": &place.Longitude} {
+        if value := params[key]; value != "" {
+            f, err := strconv.ParseFloat(value, 64)
+            if err != nil {
+                return nil, fmt.Errorf("invalid %s %q", key, value)
+            }
+            *dst = f
+        }
+    }
+    if cfg.Place == nil && (params["latitude"] == "" || params["longitude"] == "") {
+        return nil, fmt.Errorf("no place configured (set \"place\" in the config, or pass latitude and longitude)")
+    }
+    if err := place.validate(); err != nil {
+        return nil, err
+    }
+    if value := params["tz"]; value != "" {
+        loc, err := time.LoadLocation(value)
+        if err != nil {
+            return nil, fmt.Errorf("unknown time zone %q", value)
+        }
+        now = now.In(loc)
+    }
+    date := now
+    if value := params["date"]; value != "" {
+        var err error
+        if date, err = time.ParseInLocation("2006-01-02", value, now.Location()); err != nil {
+            return nil, fmt.Errorf("invalid date %q, want 2006-01-02", value)
+        }
+    }
+
+    st := sunTimes(date, place.Latitude, place.Longitude)
+    var times [][2]string
+    switch {
+    case st.PolarDay:
+        times = append(times, [2]string{l.T("sun_sunrise"), l.T("sun_polar_day")})
+    case st.PolarNight:
+        times = append(times, [2]string{l.T("sun_sunrise"), l.T("sun_polar_night")})
+    default:
+        times = append(times,
+            [2]string{l.T("sun_sunrise"), st.Sunrise.Format("15:04")},
+            [2]string{l.T("sun_sunset"), st.Sunset.Format("15:04")},
+            [2]string{l.T("sun_daylight"), formatDayLength(st.Sunset.Sub(st.Sunrise), l)})
+    }
+    times = append(times, [2]string{l.T("sun_noon"), st.Noon.Format("15:04")})
+
+    title := place.Name
+    if title == "" {
+        title = fmt.Sprintf("%.2f, %.2f", place.Latitude, place.Longitude)
+    }
+    head, err := cardText(DEFAULT_BANNER_FONT, 28, 34, title)
+    if err != nil {
+        return nil, err
+    }
+    day, err := cardText(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT, l.Date(date, "date_long"))
+    if err != nil {
+        return nil, err
+    }
+    table, err := cardColumns(120, times...)
+    if err != nil {
+        return nil, err
+    }
+    // The moon as it is at noon that day
+    noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, date.Location())
+    age := moonAge(noon)
+    moonText, err := cardText(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT,
+        moonPhaseName(age, l), l.T("moon_lit", "percent", fmt.Sprintf("%.0f", moonIllumination(age)*100)))
+    if err != nil {
+        return nil, err
+    }
+    glyph := moonGlyph(age, MOON_GLYPH_RADIUS, place.Latitude < 0)
+
+    gb := glyph.Bounds()
+    top := head.Bounds().Dy() + 4
+    tableTop := top + day.Bounds().Dy() + 4
+    moonTop := tableTop + table.Bounds().Dy() + 12
+    card := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, moonTop+gb.Dy()))
+    draw.Draw(card, card.Bounds(), image.White, image.Point{}, draw.Src)
+    draw.Draw(card, head.Bounds(), head, image.Point{}, draw.Src)
+    draw.Draw(card, day.Bounds().Add(image.Pt(0, top)), day, image.Point{}, draw.Src)
+    draw.Draw(card, table.Bounds().Add(image.Pt(0, tableTop)), table, image.Point{}, draw.Src)
+    draw.Draw(card, gb.Add(image.Pt(0, moonTop)), glyph, image.Point{}, draw.Src)
+    textTop := moonTop + (gb.Dy()-moonText.Bounds().Dy())/2
+    draw.Draw(card, image.Rect(gb.Dx()+16, textTop, PRINTER_WIDTH, textTop+moonText.Bounds().Dy()), moonText, image.Point{}, draw.Src)
+    return card, nil
+}
@@ -0,0 +1,188 @@
+//go:build !daemon
+
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "strings"
+    "time"
+)
+
+// "catprinter tail" prints the end of a file and, with -f, every line added
+// to it afterwards: a hardware log tailer. Lines go through console mode, so
+// they are wrapped to the paper and printed in small batches.
+
+const (
+    TAIL_POLL_INTERVAL = 500 * time.Millisecond
+    // How far back we look for the last -n lines
+    TAIL_BACKLOG_BYTES = 64 << 10
+)
+
+func runTail(args []string) {
+    fs := flag.NewFlagSet("tail", flag.ExitOnError)
+    follow := fs.Bool("f", false, "keep printing lines as they are appended")
+    lines := fs.Int("n", 10, "print the last n lines first")
+    rate := fs.Int("rate", 30, "print at most this many lines per minute, the rest are skipped (0 for no limit)")
+    fs.Usage = func() {
+        fmt.Println("Usage: catprinter tail [-f] [-n 10] [-rate 30] <printer-mac> <file>")
+        fs.PrintDefaults()
+    }
+    fs.Parse(args)
+
+    rest := fs.Args()
+    if len(rest) < 2 {
+        fs.Usage()
+        os.Exit(1)
+    }
+    macAddr, path := rest[0], rest[1]
+
+    cfg, err := loadConfig()
+    if err != nil {
+        log.Printf("Failed to load config: %v", err)
+        os.Exit(1)
+    }
+    f, err := os.Open(path)
+    if err != nil {
+        log.Printf("Failed to open %s: %v", path, err)
+        os.Exit(1)
+    }
+    defer f.Close()
+
+    engine := NewEngine(macAddr, cfg)
+    defer engine.Close()
+    limiter := newLineLimiter(*rate)
+    emit := func(line string) {
+        for _, l := range limiter.Allow(line) {
+            if err := engine.Append(l); err != nil {
+                log.Printf("Console: %v", err)
+            }
+        }
+    }
+
+    offset, err := lastLines(f, *lines, emit)
+    if err != nil {
+        log.Printf("Failed to read %s: %v", path, err)
+        os.Exit(1)
+    }
+    if !*follow {
+        engine.FlushConsole()
+        return
+    }
+
+    var partial []byte
+    for {
+        time.Sleep(TAIL_POLL_INTERVAL)
+        info, err := os.Stat(path)
+        if err != nil {
+            // Rotated away, wait for the new file
+            continue
+        }
+        current, err := f.Stat()
+        if err != nil || !os.SameFile(info, current) || info.Size() < offset {
+            // Rotated or truncated: start over from the top of the new file
+            if nf, err := os.Open(path); err == nil {
+                f.Close()
+                f, offset, partial = nf, 0, nil
+            }
+        }
+        if _, err := f.Seek(offset, io.SeekStart); err != nil {
+            continue
+        }
+        data, err := io.ReadAll(f)
+        if err != nil {
+            log.Printf("Failed to read %s: %v", path, err)
+            continue
+        }
+        offset += int64(len(data))
+        partial = append(partial, data...)
+        for {
+            i := bytes.IndexByte(partial, '\n')
+            if i < 0 {
+                break
+            }
+            emit(strings.TrimRight(string(partial[:i]), "\r"))
+            partial = partial[i+1:]
+        }
+    }
+}
+
+// lastLines emits the last n complete lines of f and returns the offset
+// following will continue from.
+func lastLines(f *os.File, n int, emit func(string)) (int64, error) {
+    info, err := f.Stat()
+    if err != nil {
+        return 0, err
+    }
+    size := info.Size()
+    if n <= 0 {
+        return size, nil
+    }
+    start := size - TAIL_BACKLOG_BYTES
+    if start < 0 {
+        start = 0
+    }
+    if _, err := f.Seek(start, io.SeekStart); err != nil {
+        return 0, err
+    }
+    var tail []string
+    scanner := bufio.NewScanner(io.LimitReader(f, size-start))
+    for scanner.Scan() {
+        tail = append(tail, scanner.Text())
+    }
+    if start > 0 && len(tail) > 0 {
+        // The first line was cut by the backlog window
+        tail = tail[1:]
+    }
+    if len(tail) > n {
+        tail = tail[len(tail)-n:]
+    }
+    for _, line := range tail {
+        emit(line)
+    }
+    return size, scanner.Err()
+}
+
+// lineLimiter lets through at most rate lines per minute, and reports how
+// many were skipped once lines are allowed again.
+type lineLimiter struct {
+    rate    int
+    tokens  float64
+    last    time.Time
+    skipped int
+}
+
+func newLineLimiter(rate int) *lineLimiter {
+    return &lineLimiter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// Allow returns the lines to print for an incoming line: nothing, the line,
+// or a skip notice followed by the line. The notice waits for the next
+// allowed line so a log that goes quiet doesn't end on it.
+func (l *lineLimiter) Allow(line string) []string {
+    if l.rate <= 0 {
+        return []string{line}
+    }
+    now := time.Now()
+    l.tokens += now.Sub(l.last).Minutes() * float64(l.rate)
+    if l.tokens > float64(l.rate) {
+        l.tokens = float64(l.rate)
+    }
+    l.last = now
+    if l.tokens < 1 {
+        l.skipped++
+        return nil
+    }
+    l.tokens--
+    if l.skipped > 0 {
+        notice := fmt.Sprintf("[%d lines skipped]", l.skipped)
+        l.skipped = 0
+        return []string{notice, line}
+    }
+    return []string{line}
+}
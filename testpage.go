@@ -0,0 +1,128 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "log"
+)
+
+// The test page prints a fixed set of patterns for calibrating the heat and
+// checking the head, no image needed:
+//
+//   - a solid bar: a dead heating element shows as a white streak through it
+//   - a millimetre ruler, to find which dot a streak is at
+//   - a smooth and a stepped gray ramp, to judge intensity and dithering
+//   - checkerboards and line patterns at 1, 2, 4 and 8 dots, which blur
+//     together when the head runs too hot and break up when it runs cold
+
+const (
+    TEST_BAR_ROWS     = 48
+    TEST_RAMP_ROWS    = 64
+    TEST_PATTERN_ROWS = 32
+    TEST_GAP_ROWS     = 12
+    TEST_RAMP_STEPS   = 16
+)
+
+// renderTestPage draws the test page. Labels need the text font; without
+// it the patterns print unlabelled.
+func renderTestPage(title string) image.Image {
+    text, err := newTextRenderer(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT)
+    if err != nil {
+        log.Printf("Test page without labels: %v", err)
+    }
+    var sections []image.Image
+    add := func(label string, pattern image.Image) {
+        if text != nil && label != "" {
+            sections = append(sections, text.render([]string{label}))
+        }
+        sections = append(sections, pattern, blankCanvas(TEST_GAP_ROWS))
+    }
+
+    if text != nil {
+        sections = append(sections, text.render([]string{title}), blankCanvas(TEST_GAP_ROWS))
+    }
+    add("solid (white streaks = dead dots)", testPattern(TEST_BAR_ROWS, func(x, y int) bool { return true }))
+    add("ruler (mm)", testRuler())
+    add("smooth ramp", ditherImage(testRamp(0), DITHER_FLOYD_STEINBERG, DEFAULT_THRESHOLD))
+    add("16 steps", ditherImage(testRamp(TEST_RAMP_STEPS), DITHER_BAYER, DEFAULT_THRESHOLD))
+    for _, size := range []int{1, 2, 4, 8} {
+        add(pluralDots("checkers", size), testPattern(TEST_PATTERN_ROWS, func(x, y int) bool { return (x/size+y/size)%2 == 0 }))
+    }
+    for _, size := range []int{1, 2, 4} {
+        add(pluralDots("vertical lines", size), testPattern(TEST_PATTERN_ROWS, func(x, y int) bool { return (x/size)%2 == 0 }))
+        add(pluralDots("horizontal lines", size), testPattern(TEST_PATTERN_ROWS, func(x, y int) bool { return (y/size)%2 == 0 }))
+    }
+    return stackSections(sections)
+}
+
+func pluralDots(name string, size int) string {
+    if size == 1 {
+        return name + ", 1 dot"
+    }
+    return fmt.Sprintf("%s, %d dots", name, size)
+}
+
+// testPattern draws a full-width block, black where dot says so.
+func testPattern(rows int, dot func(x, y int) bool) *image.Gray {
+    img := blankCanvas(rows)
+    for y := 0; y < rows; y++ {
+        for x := 0; x < PRINTER_WIDTH; x++ {
+            if dot(x, y) {
+                img.SetGray(x, y, color.Gray{0})
+            }
+        }
+    }
+    return img
+}
+
+// testRuler marks every millimetre, longer every 5 and 10.
+func testRuler() *image.Gray {
+    return testPattern(24, func(x, y int) bool {
+        if x%DOTS_PER_MM != 0 {
+            return false
+        }
+        mm := x / DOTS_PER_MM
+        switch {
+        case mm%10 == 0:
+            return true
+        case mm%5 == 0:
+            return y < 16
+        }
+        return y < 8
+    })
+}
+
+// testRamp runs from white on the left to black on the right, smoothly or
+// in steps.
+func testRamp(steps int) *image.Gray {
+    img := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, TEST_RAMP_ROWS))
+    for x := 0; x < PRINTER_WIDTH; x++ {
+        level := 255 - x*255/(PRINTER_WIDTH-1)
+        if steps > 1 {
+            step := x * steps / PRINTER_WIDTH
+            level = 255 - step*255/(steps-1)
+        }
+        for y := 0; y < TEST_RAMP_ROWS; y++ {
+            img.Pix[y*img.Stride+x] = uint8(level)
+        }
+    }
+    return img
+}
+
+// stackSections puts full-width sections one under the other.
+func stackSections(sections []image.Image) image.Image {
+    height := 0
+    for _, s := range sections {
+        height += s.Bounds().Dy()
+    }
+    out := blankCanvas(height)
+    y := 0
+    for _, s := range sections {
+        b := s.Bounds()
+        draw.Draw(out, image.Rect(0, y, PRINTER_WIDTH, y+b.Dy()), s, b.Min, draw.Src)
+        y += b.Dy()
+    }
+    return out
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+    "image"
+    "image/draw"
+    "strings"
+
+    "golang.org/x/image/font"
+    "golang.org/x/image/math/fixed"
+)
+
+// Plain text rendering for features that print text straight from Go
+// (console, banners...). Same look as print.js: DotMatrix at 18px on 22px
+// lines.
+
+const (
+    DEFAULT_TEXT_FONT   = "fonts/dotmatrix.ttf"
+    DEFAULT_TEXT_SIZE   = 18
+    DEFAULT_LINE_HEIGHT = 22
+)
+
+type textRenderer struct {
+    face       font.Face
+    lineHeight int
+    // For the rendered line cache (textcache.go)
+    fontPath string
+    size     float64
+}
+
+func newTextRenderer(fontPath string, size float64, lineHeight int) (*textRenderer, error) {
+    face, err := loadFontFace(fontPath, size)
+    if err != nil {
+        return nil, err
+    }
+    return &textRenderer{face: face, lineHeight: lineHeight, fontPath: fontPath, size: size}, nil
+}
+
+// wrap breaks a line into pieces that fit the paper, preferring spaces.
+func (t *textRenderer) wrap(line string) []string {
+    line = strings.TrimRight(strings.ReplaceAll(line, "\t", "    "), "\r")
+    var out []string
+    for {
+        if font.MeasureString(t.face, line).Ceil() <= PRINTER_WIDTH {
+            return append(out, line)
+        }
+        runes := []rune(line)
+        fit := 0
+        for fit < len(runes) && font.MeasureString(t.face, string(runes[:fit+1])).Ceil() <= PRINTER_WIDTH {
+            fit++
+        }
+        if fit == 0 {
+            fit = 1
+        }
+        cut := fit
+        if i := strings.LastIndex(string(runes[:fit]), " "); i > 0 {
+            cut = len([]rune(string(runes[:fit])[:i]))
+        }
+        out = append(out, string(runes[:cut]))
+        line = strings.TrimLeft(string(runes[cut:]), " ")
+    }
+}
+
+// render draws already wrapped lines top to bottom.
+func (t *textRenderer) render(lines []string) *image.Gray {
+    img := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, len(lines)*t.lineHeight))
+    draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+    for i, line := range lines {
+        if line == "" {
+            continue
+        }
+        y := i * t.lineHeight
+        draw.Draw(img, image.Rect(0, y, PRINTER_WIDTH, y+t.lineHeight), t.renderLine(line), image.Point{}, draw.Src)
+    }
+    return img
+}
+
+// renderLine draws one line, or takes it from the cache.
+func (t *textRenderer) renderLine(line string) *image.Gray {
+    key := lineKey{font: t.fontPath, size: t.size, lineHeight: t.lineHeight, text: line}
+    if img, ok := renderedLines.get(key); ok {
+        return img
+    }
+    img := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, t.lineHeight))
+    draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+    d := &font.Drawer{Dst: img, Src: image.Black, Face: t.face}
+    d.Dot = fixed.P(0, t.face.Metrics().Ascent.Ceil())
+    d.DrawString(line)
+    renderedLines.put(key, img)
+    return img
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "container/list"
+    "image"
+    "sync"
+
+    "golang.org/x/image/font/opentype"
+)
+
+// Console, receipt and log prints repeat the same short lines (prefixes,
+// timestamps down to the minute, "OK") many times a minute, and rasterizing
+// them is most of the work of printing text. So rendered lines are kept,
+// keyed by font, size and text, and reused across jobs; the least recently
+// used go once there are TEXT_CACHE_LINES. Font files are parsed once.
+
+// About 4MB of 22px lines
+const TEXT_CACHE_LINES = 512
+
+type lineKey struct {
+    font       string
+    size       float64
+    lineHeight int
+    text       string
+}
+
+type cachedLine struct {
+    key lineKey
+    img *image.Gray
+}
+
+type lineCache struct {
+    mu    sync.Mutex
+    lines map[lineKey]*list.Element
+    // Most recently used first
+    order *list.List
+}
+
+var renderedLines = &lineCache{lines: make(map[lineKey]*list.Element), order: list.New()}
+
+func (c *lineCache) get(key lineKey) (*image.Gray, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    e, ok := c.lines[key]
+    if !ok {
+        return nil, false
+    }
+    c.order.MoveToFront(e)
+    return e.Value.(*cachedLine).img, true
+}
+
+func (c *lineCache) put(key lineKey, img *image.Gray) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if _, ok := c.lines[key]; ok {
+        return
+    }
+    c.lines[key] = c.order.PushFront(&cachedLine{key: key, img: img})
+    for c.order.Len() > TEXT_CACHE_LINES {
+        oldest := c.order.Back()
+        c.order.Remove(oldest)
+        delete(c.lines, oldest.Value.(*cachedLine).key)
+    }
+}
+
+var (
+    fontsMu sync.Mutex
+    fonts   = map[string]*opentype.Font{}
+)
+
+// parsedFont returns the font at path, parsing it on first use. Parsed fonts
+// are safe to share; faces made from them are not.
+func parsedFont(path string, parse func() (*opentype.Font, error)) (*opentype.Font, error) {
+    fontsMu.Lock()
+    defer fontsMu.Unlock()
+    if f, ok := fonts[path]; ok {
+        return f, nil
+    }
+    f, err := parse()
+    if err != nil {
+        return nil, err
+    }
+    fonts[path] = f
+    return f, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "image"
+    "image/draw"
+    "io"
+    "log"
+    "math"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "golang.org/x/image/font"
+    "golang.org/x/image/math/fixed"
+)
+
+// The ticker card: one row per stock or coin with the last price, the change
+// since the previous close and a sparkline of the closes over the range.
+// Quotes come from Yahoo Finance's chart API, which needs no key and knows
+// both stocks ("AAPL") and crypto ("BTC-USD"). Any service answering in the
+// same shape can stand in for it with "url".
+//
+//   "ticker": {"symbols": ["AAPL", "MSFT", "BTC-USD"], "range": "1mo"}
+
+const (
+    DEFAULT_TICKER_URL   = "https://query1.finance.yahoo.com/v8/finance/chart/%s"
+    DEFAULT_TICKER_RANGE = "1mo"
+    TICKER_FETCH_TIMEOUT = 10 * time.Second
+
+    TICKER_ROW_HEIGHT = 30
+    // Where each column starts; the price ends at TICKER_CHANGE_X
+    TICKER_PRICE_X     = 100
+    TICKER_CHANGE_X    = 196
+    TICKER_SPARKLINE_X = 296
+    TICKER_ARROW_SIZE  = 10
+)
+
+var TICKER_RANGES = []string{"5d", "1mo", "3mo", "6mo", "1y"}
+
+// Without a browser-like agent the API answers 429 more often than not
+const TICKER_USER_AGENT = "Mozilla/5.0 (compatible; catprinter)"
+
+var tickerClient = &http.Client{Timeout: TICKER_FETCH_TIMEOUT}
+
+func init() {
+    registerCard("ticker", renderTickerCard)
+}
+
+// Quote is a symbol's daily closes over the range, oldest first. The last
+// one is the latest price while the market is open.
+type Quote struct {
+    Symbol string
+    Closes []float64
+}
+
+// Change is the last price against the close before it, as a fraction.
+func (q Quote) Change() float64 {
+    n := len(q.Closes)
+    if n < 2 || q.Closes[n-2] == 0 {
+        return 0
+    }
+    return q.Closes[n-1]/q.Closes[n-2] - 1
+}
+
+type chartResponse struct {
+    Chart struct {
+        Result []struct {
+            Indicators struct {
+                Quote []struct {
+                    Close []*float64 `json:"close"`
+                } `json:"quote"`
+            } `json:"indicators"`
+        } `json:"result"`
+        Error *struct {
+            Description string `json:"description"`
+        } `json:"error"`
+    } `json:"chart"`
+}
+
+func fetchQuote(endpoint, symbol, rng string) (Quote, error) {
+    u, err := url.Parse(fmt.Sprintf(endpoint, url.PathEscape(symbol)))
+    if err != nil {
+        return Quote{}, err
+    }
+    query := u.Query()
+    query.Set("range", rng)
+    query.Set("interval", "1d")
+    u.RawQuery = query.Encode()
+    req, err := http.NewRequest("GET", u.String(), nil)
+    if err != nil {
+        return Quote{}, err
+    }
+    req.Header.Set("User-Agent", TICKER_USER_AGENT)
+    resp, err := tickerClient.Do(req)
+    if err != nil {
+        return Quote{}, err
+    }
+    defer resp.Body.Close()
+
+    var chart chartResponse
+    if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&chart); err != nil {
+        if resp.StatusCode != http.StatusOK {
+            return Quote{}, fmt.Errorf("quote service returned %s", resp.Status)
+        }
+        return Quote{}, fmt.Errorf("invalid quote response: %v", err)
+    }
+    if chart.Chart.Error != nil {
+        return Quote{}, fmt.Errorf("%s", chart.Chart.Error.Description)
+    }
+    if resp.StatusCode != http.StatusOK {
+        return Quote{}, fmt.Errorf("quote service returned %s", resp.Status)
+    }
+    q := Quote{Symbol: symbol}
+    if len(chart.Chart.Result) > 0 && len(chart.Chart.Result[0].Indicators.Quote) > 0 {
+        // Days without trading come back as nulls
+        for _, c := range chart.Chart.Result[0].Indicators.Quote[0].Close {
+            if c != nil {
+                q.Closes = append(q.Closes, *c)
+            }
+        }
+    }
+    if len(q.Closes) == 0 {
+        return Quote{}, fmt.Errorf("no prices")
+    }
+    return q, nil
+}
+
+func formatPrice(p float64) string {
+    switch {
+    case p >= 10000:
+        return fmt.Sprintf("%.0f", p)
+    case p >= 1:
+        return fmt.Sprintf("%.2f", p)
+    default:
+        return fmt.Sprintf("%.4f", p)
+    }
+}
+
+// tickerArrow draws a solid triangle at x, y pointing up for a rise and down
+// for a fall; no change gets a dash.
+func tickerArrow(img *image.Gray, x, y int, change float64) {
+    s := TICKER_ARROW_SIZE
+    for row := 0; row < s; row++ {
+        half := row / 2
+        if change < 0 {
+            half = (s - 1 - row) / 2
+        }
+        if change == 0 {
+            if row < s/2-1 || row > s/2 {
+                continue
+            }
+            half = s / 2
+        }
+        for col := s/2 - half; col <= s/2+half && col < s; col++ {
+            img.Pix[(y+row)*img.Stride+x+col] = 0
+        }
+    }
+}
+
+// sparkline draws the closes as a line filling r, low at the bottom.
+func sparkline(img *image.Gray, r image.Rectangle, closes []float64) {
+    if len(closes) < 2 {
+        return
+    }
+    lo, hi := closes[0], closes[0]
+    for _, c := range closes {
+        lo, hi = math.Min(lo, c), math.Max(hi, c)
+    }
+    yAt := func(c float64) int {
+        if hi == lo {
+            return r.Min.Y + r.Dy()/2
+        }
+        return r.Max.Y - 1 - int(math.Round((c-lo)/(hi-lo)*float64(r.Dy()-2)))
+    }
+    prev := yAt(closes[0])
+    for x := 0; x < r.Dx(); x++ {
+        // Interpolated between the two closes either side of this column
+        pos := float64(x) / float64(r.Dx()-1) * float64(len(closes)-1)
+        i := min(int(pos), len(closes)-2)
+        y := yAt(closes[i] + (closes[i+1]-closes[i])*(pos-float64(i)))
+        // Joined to the last column, and two dots thick so it prints solid
+        top, bottom := min(prev, y), max(prev, y)
+        for yy := top; yy <= bottom+1 && yy < r.Max.Y; yy++ {
+            img.Pix[yy*img.Stride+r.Min.X+x] = 0
+        }
+        prev = y
+    }
+}
+
+// renderTickerCard takes the parameters symbols (comma separated) and range,
+// which override the configured ones. A symbol that can't be fetched shows
+// as unavailable rather than failing the card.
+func renderTickerCard(cfg *Config, params map[string]string, now time.Time, l *Locale) (image.Image, error) {
+    var ticker TickerConfig
+    if cfg.Ticker != nil {
+        ticker = *cfg.Ticker
+    }
+    if value := params["symbols"]; value != "" {
+        ticker.Symbols = nil
+        for _, symbol := range strings.Split(value, ",") {
+            if symbol = strings.TrimSpace(symbol); symbol != "" {
+                ticker.Symbols = append(ticker.Symbols, symbol)
+            }
+        }
+    }
+    if value := params["range"]; value != "" {
+        ticker.Range = value
+    }
+    if len(ticker.Symbols) == 0 {
+        return nil, fmt.Errorf("no symbols configured (set \"ticker\" in the config, or pass symbols)")
+    }
+    if err := ticker.validate(); err != nil {
+        return nil, err
+    }
+    if ticker.Range == "" {
+        ticker.Range = DEFAULT_TICKER_RANGE
+    }
+    if ticker.URL == "" {
+        ticker.URL = DEFAULT_TICKER_URL
+    }
+
+    head, err := cardText(DEFAULT_BANNER_FONT, 28, 34, l.T("ticker_title"))
+    if err != nil {
+        return nil, err
+    }
+    day, err := cardText(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE, DEFAULT_LINE_HEIGHT,
+        l.Date(now, "date_time_short")+", "+ticker.Range)
+    if err != nil {
+        return nil, err
+    }
+    face, err := loadFontFace(DEFAULT_TEXT_FONT, DEFAULT_TEXT_SIZE)
+    if err != nil {
+        return nil, err
+    }
+
+    top := head.Bounds().Dy() + 4
+    rowsTop := top + day.Bounds().Dy() + 8
+    card := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, rowsTop+len(ticker.Symbols)*TICKER_ROW_HEIGHT))
+    draw.Draw(card, card.Bounds(), image.White, image.Point{}, draw.Src)
+    draw.Draw(card, head.Bounds(), head, image.Point{}, draw.Src)
+    draw.Draw(card, day.Bounds().Add(image.Pt(0, top)), day, image.Point{}, draw.Src)
+
+    d := &font.Drawer{Dst: card, Src: image.Black, Face: face}
+    ascent := face.Metrics().Ascent.Ceil()
+    text := func(x, y int, s string) {
+        d.Dot = fixed.P(x, y+ascent)
+        d.DrawString(s)
+    }
+    for i, symbol := range ticker.Symbols {
+        y := rowsTop + i*TICKER_ROW_HEIGHT
+        text(0, y, symbol)
+        q, err := fetchQuote(ticker.URL, symbol, ticker.Range)
+        if err != nil {
+            log.Printf("Ticker: %s: %v", symbol, err)
+            text(TICKER_PRICE_X, y, l.T("ticker_unavailable"))
+            continue
+        }
+        price := formatPrice(q.Closes[len(q.Closes)-1])
+        // Prices right aligned, so the decimal points line up
+        text(TICKER_CHANGE_X-8-font.MeasureString(face, price).Ceil(), y, price)
+        change := q.Change()
+        tickerArrow(card, TICKER_CHANGE_X, y+(DEFAULT_LINE_HEIGHT-TICKER_ARROW_SIZE)/2, change)
+        text(TICKER_CHANGE_X+TICKER_ARROW_SIZE+6, y, fmt.Sprintf("%+.2f%%", change*100))
+        sparkline(card, image.Rect(TICKER_SPARKLINE_X, y, PRINTER_WIDTH, y+DEFAULT_LINE_HEIGHT), q.Closes)
+    }
+    return card, nil
+}
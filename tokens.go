@@ -0,0 +1,192 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+// API tokens are created and revoked by admins at runtime (see
+// tokens_http.go) instead of being listed in the config. Only a hash of each
+// token is kept, in the token_store file, so the token itself is shown once,
+// when it's created.
+
+const (
+    DEFAULT_TOKEN_STORE = "catprinter-tokens.json"
+    // Tokens start with this, so they're easy to spot in logs and scripts
+    API_TOKEN_PREFIX = "cpt_"
+
+    SCOPE_PRINT = "print"
+    SCOPE_ADMIN = "admin"
+    SCOPE_READ  = "read"
+
+    // ROLE_READER can look at the queue but not print or change anything
+    ROLE_READER = "reader"
+)
+
+var scopeRoles = map[string]string{
+    SCOPE_PRINT: ROLE_SUBMITTER,
+    SCOPE_ADMIN: ROLE_ADMIN,
+    SCOPE_READ:  ROLE_READER,
+}
+
+type APIToken struct {
+    ID    string `json:"id"`
+    Name  string `json:"name"`
+    Scope string `json:"scope"`
+    // DailyQuota caps prints a day for print tokens; 0 is no cap
+    DailyQuota int        `json:"daily_quota,omitempty"`
+    Created    time.Time  `json:"created"`
+    Expires    *time.Time `json:"expires,omitempty"`
+    // Hash is the hex SHA-256 of the token; only written to the store
+    Hash string `json:"hash,omitempty"`
+}
+
+func (t APIToken) expired() bool {
+    return t.Expires != nil && time.Now().After(*t.Expires)
+}
+
+// user is the token as a user, for the role checks and quotas.
+func (t APIToken) user() *UserConfig {
+    return &UserConfig{Name: "token:" + t.Name, Role: scopeRoles[t.Scope], DailyQuota: t.DailyQuota}
+}
+
+type TokenStore struct {
+    mu     sync.Mutex
+    path   string
+    tokens []APIToken
+}
+
+// openTokenStore loads the store; a missing file is an empty store.
+func openTokenStore(path string) (*TokenStore, error) {
+    store := &TokenStore{path: path}
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return store, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read token store: %v", err)
+    }
+    if err := json.Unmarshal(data, &store.tokens); err != nil {
+        return nil, fmt.Errorf("invalid token store %s: %v", path, err)
+    }
+    return store, nil
+}
+
+// save writes the store through a temporary file, so a crash can't leave
+// it half written. Callers hold mu.
+func (s *TokenStore) save() error {
+    data, err := json.MarshalIndent(s.tokens, "", "  ")
+    if err != nil {
+        return err
+    }
+    tmp := s.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return fmt.Errorf("failed to write token store: %v", err)
+    }
+    if err := os.Rename(tmp, s.path); err != nil {
+        return fmt.Errorf("failed to write token store: %v", err)
+    }
+    return nil
+}
+
+func hashToken(secret string) string {
+    sum := sha256.Sum256([]byte(secret))
+    return hex.EncodeToString(sum[:])
+}
+
+// Create adds a token and returns it with its secret, which isn't stored.
+func (s *TokenStore) Create(name, scope string, ttl time.Duration, quota int) (string, APIToken, error) {
+    if name == "" {
+        return "", APIToken{}, fmt.Errorf("token needs a name")
+    }
+    if _, ok := scopeRoles[scope]; !ok {
+        return "", APIToken{}, fmt.Errorf("unknown scope %q, want print, admin or read", scope)
+    }
+    if ttl < 0 || quota < 0 {
+        return "", APIToken{}, fmt.Errorf("expiry and quota can't be negative")
+    }
+    secret := API_TOKEN_PREFIX + randomHex(20)
+    token := APIToken{ID: newJobID(), Name: name, Scope: scope, DailyQuota: quota, Created: time.Now(), Hash: hashToken(secret)}
+    if ttl > 0 {
+        expires := token.Created.Add(ttl)
+        token.Expires = &expires
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.tokens = append(s.tokens, token)
+    if err := s.save(); err != nil {
+        s.tokens = s.tokens[:len(s.tokens)-1]
+        return "", APIToken{}, err
+    }
+    token.Hash = ""
+    return secret, token, nil
+}
+
+// Revoke deletes a token; requests using it fail from then on.
+func (s *TokenStore) Revoke(id string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for i, token := range s.tokens {
+        if token.ID == id {
+            s.tokens = append(s.tokens[:i:i], s.tokens[i+1:]...)
+            return s.save()
+        }
+    }
+    return fmt.Errorf("no such token")
+}
+
+// List returns the tokens without their hashes, expired ones included.
+func (s *TokenStore) List() []APIToken {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    list := make([]APIToken, len(s.tokens))
+    for i, token := range s.tokens {
+        token.Hash = ""
+        list[i] = token
+    }
+    return list
+}
+
+// Lookup finds the unexpired token with this secret.
+func (s *TokenStore) Lookup(secret string) *APIToken {
+    hash := []byte(hashToken(secret))
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, token := range s.tokens {
+        if subtle.ConstantTimeCompare(hash, []byte(token.Hash)) == 1 && !token.expired() {
+            return &token
+        }
+    }
+    return nil
+}
+
+// Any reports whether there is an unexpired token at all.
+func (s *TokenStore) Any() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, token := range s.tokens {
+        if !token.expired() {
+            return true
+        }
+    }
+    return false
+}
+
+// HasScope reports whether any unexpired token has the scope.
+func (s *TokenStore) HasScope(scope string) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, token := range s.tokens {
+        if token.Scope == scope && !token.expired() {
+            return true
+        }
+    }
+    return false
+}
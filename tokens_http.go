@@ -0,0 +1,87 @@
+//go:build daemon
+
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Token API (admin only):
+//
+//   GET  /admin/tokens             all tokens, without their secrets
+//   POST /admin/tokens             create one from {"name", "scope",
+//                                  "expires_in", "daily_quota"}; the reply
+//                                  holds the token, shown this once
+//   POST /admin/tokens/<id>/revoke revoke one
+
+type tokenRequest struct {
+    Name  string `json:"name"`
+    Scope string `json:"scope"`
+    // ExpiresIn is a duration like "720h"; the token never expires when empty
+    ExpiresIn  string `json:"expires_in"`
+    DailyQuota int    `json:"daily_quota"`
+}
+
+func (api *HTTPAPI) registerTokenHandlers(mux *http.ServeMux) {
+    mux.HandleFunc("/admin/tokens", func(w http.ResponseWriter, r *http.Request) {
+        if !api.requireAdmin(w, r) {
+            return
+        }
+        switch r.Method {
+        case "GET":
+            w.Header().Set("Content-Type", "application/json")
+            json.NewEncoder(w).Encode(api.tokens.List())
+        case "POST":
+            var req tokenRequest
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+                http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+                return
+            }
+            var ttl time.Duration
+            if req.ExpiresIn != "" {
+                var err error
+                if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 {
+                    http.Error(w, "Invalid expires_in", http.StatusBadRequest)
+                    return
+                }
+            }
+            secret, token, err := api.tokens.Create(req.Name, req.Scope, ttl, req.DailyQuota)
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            log.Printf("Created %s token %q (%s)", token.Scope, token.Name, token.ID)
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(http.StatusCreated)
+            json.NewEncoder(w).Encode(struct {
+                Token string `json:"token"`
+                APIToken
+            }{secret, token})
+        default:
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
+    })
+
+    mux.HandleFunc("/admin/tokens/", func(w http.ResponseWriter, r *http.Request) {
+        if !api.requireAdmin(w, r) {
+            return
+        }
+        id := strings.TrimPrefix(r.URL.Path, "/admin/tokens/")
+        if r.Method != "POST" || !strings.HasSuffix(id, "/revoke") {
+            http.Error(w, "Not found", http.StatusNotFound)
+            return
+        }
+        id = strings.TrimSuffix(id, "/revoke")
+        if err := api.tokens.Revoke(id); err != nil {
+            http.Error(w, err.Error(), http.StatusNotFound)
+            return
+        }
+        log.Printf("Revoked token %s", id)
+        w.Write([]byte("Revoked"))
+    })
+}
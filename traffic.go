@@ -0,0 +1,132 @@
+package main
+
+import (
+    "bufio"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+// Recorded BLE traffic, one JSON object per line, for protocol research. The
+// virtual printer (virtual.go) writes it; "catprinter replay" sends the app
+// side of it to a real printer again.
+
+const (
+    TRAFFIC_FROM_APP     = "app"
+    TRAFFIC_FROM_PRINTER = "printer"
+    // Replays don't sit through long pauses from the recording
+    MAX_REPLAY_GAP = 5 * time.Second
+)
+
+type TrafficRecord struct {
+    Time time.Time `json:"time"`
+    From string    `json:"from"`
+    // Char is the characteristic: ae01, ae02 or ae03
+    Char string `json:"char"`
+    Data string `json:"data"`
+    // Cmd is the decoded command ID for framed packets
+    Cmd string `json:"cmd,omitempty"`
+    // Note says what the proxy did, e.g. "rewritten"
+    Note string `json:"note,omitempty"`
+}
+
+type trafficLog struct {
+    mu      sync.Mutex
+    f       *os.File
+    framing Framing
+}
+
+func openTrafficLog(path string, framing Framing) (*trafficLog, error) {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open traffic log: %v", err)
+    }
+    return &trafficLog{f: f, framing: framing}, nil
+}
+
+// Record appends one packet. A nil log records nothing.
+func (l *trafficLog) Record(from, char string, data []byte, note string) {
+    if l == nil {
+        return
+    }
+    rec := TrafficRecord{Time: time.Now(), From: from, Char: char, Data: hex.EncodeToString(data), Note: note}
+    if char != "ae03" {
+        if cmd, _, ok := l.framing.Decode(data); ok {
+            rec.Cmd = fmt.Sprintf("%02X", cmd)
+        }
+    }
+    line, _ := json.Marshal(rec)
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.f.Write(append(line, '\n'))
+}
+
+func readTraffic(path string) ([]TrafficRecord, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    var records []TrafficRecord
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64<<10), 1<<20)
+    for n := 1; scanner.Scan(); n++ {
+        var rec TrafficRecord
+        if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+            return nil, fmt.Errorf("line %d: %v", n, err)
+        }
+        records = append(records, rec)
+    }
+    return records, scanner.Err()
+}
+
+// forward writes bytes exactly as given to the control or data
+// characteristic. Unlike SendRaw it doesn't probe a live connection first,
+// so proxied traffic goes through untouched.
+func (pd *PrinterDaemon) forward(toData bool, data []byte) error {
+    pd.jobMu.Lock()
+    defer pd.jobMu.Unlock()
+    if !pd.connected {
+        if err := pd.ensureConnected(); err != nil {
+            return fmt.Errorf("failed to connect: %v", err)
+        }
+    }
+    char := pd.controlChar
+    if toData {
+        char = pd.dataChar
+    }
+    return pd.client.WriteCharacteristic(char, data, true)
+}
+
+// Replay sends the app side of a recording to the printer, keeping the
+// original gaps between packets (divided by speed).
+func (pd *PrinterDaemon) Replay(records []TrafficRecord, speed float64) error {
+    if speed <= 0 {
+        speed = 1
+    }
+    var last time.Time
+    for i, rec := range records {
+        if rec.From != TRAFFIC_FROM_APP {
+            continue
+        }
+        data, err := hex.DecodeString(rec.Data)
+        if err != nil {
+            return fmt.Errorf("record %d: %v", i, err)
+        }
+        if !last.IsZero() {
+            gap := time.Duration(float64(rec.Time.Sub(last)) / speed)
+            if gap > MAX_REPLAY_GAP {
+                gap = MAX_REPLAY_GAP
+            }
+            time.Sleep(gap)
+        }
+        last = rec.Time
+        if err := pd.forward(rec.Char == "ae03", data); err != nil {
+            return fmt.Errorf("record %d: %v", i, err)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "fmt"
+    "sync"
+    "time"
+)
+
+// Users log in to the web UI and API with a bearer token from the config, or
+// through an OIDC provider. Admins can do everything the admin token can
+// (moderation, cancelling jobs, raw commands); submitters can only print, and
+// only daily_quota prints a day when that's set. Once any user or OIDC is
+// configured, printing needs a login.
+
+const (
+    ROLE_ADMIN     = "admin"
+    ROLE_SUBMITTER = "submitter"
+)
+
+// UserConfig is one entry of the "users" setting.
+type UserConfig struct {
+    Name  string `json:"name"`
+    Token string `json:"token"`
+    // Role is admin or submitter
+    Role string `json:"role"`
+    // DailyQuota caps a submitter's prints per day (in time_zone); 0 is no cap
+    DailyQuota int `json:"daily_quota"`
+}
+
+func validateUsers(users []UserConfig, adminToken string) error {
+    names := make(map[string]bool, len(users))
+    tokens := map[string]bool{adminToken: adminToken != ""}
+    for i, user := range users {
+        if user.Name == "" {
+            return fmt.Errorf("users[%d] has no name", i)
+        }
+        if names[user.Name] {
+            return fmt.Errorf("user %q is listed twice", user.Name)
+        }
+        names[user.Name] = true
+        if len(user.Token) < 16 {
+            return fmt.Errorf("user %q needs a token of at least 16 characters", user.Name)
+        }
+        if tokens[user.Token] {
+            return fmt.Errorf("user %q shares its token with another user", user.Name)
+        }
+        tokens[user.Token] = true
+        if user.Role != ROLE_ADMIN && user.Role != ROLE_SUBMITTER {
+            return fmt.Errorf("user %q has unknown role %q (want admin or submitter)", user.Name, user.Role)
+        }
+        if user.DailyQuota < 0 {
+            return fmt.Errorf("user %q has a negative daily_quota", user.Name)
+        }
+    }
+    return nil
+}
+
+// QuotaTracker counts prints per user for the current day. Counts are kept in
+// memory, so a restart gives everyone a fresh quota.
+type QuotaTracker struct {
+    mu       sync.Mutex
+    location *time.Location
+    day      string
+    used     map[string]int
+}
+
+func newQuotaTracker(location *time.Location) *QuotaTracker {
+    return &QuotaTracker{location: location, used: make(map[string]int)}
+}
+
+// rollover starts a new day's counts. Callers hold mu.
+func (q *QuotaTracker) rollover(now time.Time) {
+    if day := now.In(q.location).Format("2006-01-02"); day != q.day {
+        q.day = day
+        q.used = make(map[string]int)
+    }
+}
+
+// Take counts n prints against the user's quota, or fails without counting
+// any when they don't all fit.
+func (q *QuotaTracker) Take(user *UserConfig, n int) error {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.rollover(time.Now())
+    if user.Role == ROLE_SUBMITTER && user.DailyQuota > 0 && q.used[user.Name]+n > user.DailyQuota {
+        return fmt.Errorf("daily quota of %d prints used up (%d printed today)", user.DailyQuota, q.used[user.Name])
+    }
+    q.used[user.Name] += n
+    return nil
+}
+
+// Used is how many prints the user has made today.
+func (q *QuotaTracker) Used(name string) int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    q.rollover(time.Now())
+    return q.used[name]
+}
+
+// OIDCConfig lets users log in with an OpenID Connect provider (Authelia,
+// Keycloak, Google, ...) instead of a token from the config (see oidc.go).
+type OIDCConfig struct {
+    // Issuer is the provider URL that serves /.well-known/openid-configuration
+    Issuer       string `json:"issuer"`
+    ClientID     string `json:"client_id"`
+    ClientSecret string `json:"client_secret"`
+    // RedirectURL is the daemon's /login/callback as the browser reaches it
+    RedirectURL string `json:"redirect_url"`
+    // Scopes requested besides openid (default email and profile)
+    Scopes []string `json:"scopes"`
+    // Users are admins when their email is in AdminEmails or AdminGroup is in
+    // the GroupsClaim (default "groups") of their ID token
+    AdminEmails []string `json:"admin_emails"`
+    AdminGroup  string   `json:"admin_group"`
+    GroupsClaim string   `json:"groups_claim"`
+    // DailyQuota applies to everyone else, who are submitters
+    DailyQuota int `json:"daily_quota"`
+}
+
+func (c *OIDCConfig) validate() error {
+    if c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+        return fmt.Errorf("oidc needs issuer, client_id and redirect_url")
+    }
+    if c.DailyQuota < 0 {
+        return fmt.Errorf("oidc has a negative daily_quota")
+    }
+    if len(c.Scopes) == 0 {
+        c.Scopes = []string{"email", "profile"}
+    }
+    if c.GroupsClaim == "" {
+        c.GroupsClaim = "groups"
+    }
+    return nil
+}
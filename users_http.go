@@ -0,0 +1,133 @@
+//go:build daemon
+
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "net/http"
+    "strings"
+)
+
+// whoami is what GET /whoami reports, so the web UI can show who is logged in
+// and what they may do.
+type whoami struct {
+    // LoginRequired is false when no users are configured
+    LoginRequired bool `json:"login_required"`
+    // OIDC is true when users can log in at /login
+    OIDC       bool   `json:"oidc,omitempty"`
+    Name       string `json:"name,omitempty"`
+    Role       string `json:"role,omitempty"`
+    DailyQuota int    `json:"daily_quota,omitempty"`
+    UsedToday  int    `json:"used_today"`
+}
+
+// bearerToken reads "Authorization: Bearer <token>", falling back to
+// X-Admin-Token.
+func bearerToken(r *http.Request) string {
+    if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+        return strings.TrimPrefix(auth, "Bearer ")
+    }
+    return r.Header.Get("X-Admin-Token")
+}
+
+// caller is the configured user or API token the request carries, or the
+// user logged in through OIDC, or nil.
+func (api *HTTPAPI) caller(r *http.Request) *UserConfig {
+    if token := bearerToken(r); strings.HasPrefix(token, API_TOKEN_PREFIX) {
+        if stored := api.tokens.Lookup(token); stored != nil {
+            return stored.user()
+        }
+    }
+    if token := bearerToken(r); token != "" {
+        users := api.engine.Config().Users
+        for i := range users {
+            if subtle.ConstantTimeCompare([]byte(token), []byte(users[i].Token)) == 1 {
+                return &users[i]
+            }
+        }
+    }
+    if api.oidc != nil {
+        return api.oidc.User(r)
+    }
+    return nil
+}
+
+// adminEnabled is whether anyone can pass requireAdmin at all.
+func (api *HTTPAPI) adminEnabled() bool {
+    config := api.engine.Config()
+    return api.Authorize != nil || config.AdminToken != "" || config.hasAdminUser() || api.tokens.HasScope(SCOPE_ADMIN)
+}
+
+// requireUser makes sure a request that prints comes from a logged-in user
+// once users are configured. The user is nil for admins without a user entry
+// and when there are no users.
+func (api *HTTPAPI) requireUser(w http.ResponseWriter, r *http.Request) (*UserConfig, bool) {
+    if !api.engine.Config().loginRequired() {
+        return nil, true
+    }
+    if user := api.caller(r); user != nil {
+        if user.Role == ROLE_READER {
+            http.Error(w, "This token is read-only", http.StatusForbidden)
+            return nil, false
+        }
+        return user, true
+    }
+    if api.isAdmin(r) {
+        return nil, true
+    }
+    http.Error(w, "Log in to print", http.StatusUnauthorized)
+    return nil, false
+}
+
+// requireRead lets admins and read-only tokens through, for admin pages that
+// only look.
+func (api *HTTPAPI) requireRead(w http.ResponseWriter, r *http.Request) bool {
+    if user := api.caller(r); user != nil && user.Role == ROLE_READER {
+        return true
+    }
+    return api.requireAdmin(w, r)
+}
+
+// readGated is whether looking at jobs, previews and slots needs a read
+// token or an admin. Once anyone has to log in or hold a token, other
+// people's prints are no longer public.
+func (api *HTTPAPI) readGated() bool {
+    config := api.engine.Config()
+    return api.Authorize != nil || config.loginRequired() || config.AdminToken != "" || api.tokens.Any()
+}
+
+// takeQuota counts n prints for the user and writes a 429 when they're over.
+func (api *HTTPAPI) takeQuota(w http.ResponseWriter, user *UserConfig, n int) bool {
+    if user == nil {
+        return true
+    }
+    if err := api.quota.Take(user, n); err != nil {
+        http.Error(w, err.Error(), http.StatusTooManyRequests)
+        return false
+    }
+    return true
+}
+
+func (api *HTTPAPI) registerUserHandlers(mux *http.ServeMux) {
+    mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Cache-Control", "no-store")
+        info := whoami{LoginRequired: api.engine.Config().loginRequired(), OIDC: api.oidc != nil}
+        if user := api.caller(r); user != nil {
+            info.Name = user.Name
+            info.Role = user.Role
+            info.DailyQuota = user.DailyQuota
+            info.UsedToday = api.quota.Used(user.Name)
+        } else if api.isAdmin(r) {
+            info.Role = ROLE_ADMIN
+        } else if info.LoginRequired {
+            w.WriteHeader(http.StatusUnauthorized)
+        }
+        json.NewEncoder(w).Encode(info)
+    })
+
+    if api.oidc != nil {
+        api.oidc.registerHandlers(mux)
+    }
+}
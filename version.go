@@ -0,0 +1,54 @@
+package main
+
+import (
+    "runtime"
+    "runtime/debug"
+)
+
+// VERSION follows semver. Release builds stamp the commit:
+//
+//   go build -ldflags "-X main.GIT_COMMIT=$(git rev-parse --short HEAD)" ...
+//
+// Without that, the commit Go records from the checkout is used, if any.
+var (
+    VERSION    = "1.0.0"
+    GIT_COMMIT = ""
+)
+
+// VersionInfo is what /version and "catprinter version" report.
+type VersionInfo struct {
+    Version      string       `json:"version"`
+    Commit       string       `json:"commit,omitempty"`
+    Modified     bool         `json:"modified,omitempty"`
+    GoVersion    string       `json:"go_version"`
+    Capabilities []Capability `json:"capabilities"`
+    // Models are the printer profiles this install can drive, Model the
+    // one in use
+    Models []string `json:"models"`
+    Model  string   `json:"model"`
+}
+
+func versionInfo(cfg *Config) VersionInfo {
+    info := VersionInfo{
+        Version:      VERSION,
+        Commit:       GIT_COMMIT,
+        GoVersion:    runtime.Version(),
+        Capabilities: Capabilities(),
+        Models:       knownProfiles(cfg),
+        Model:        cfg.profile.Name,
+    }
+    if build, ok := debug.ReadBuildInfo(); ok && info.Commit == "" {
+        for _, s := range build.Settings {
+            switch s.Key {
+            case "vcs.revision":
+                info.Commit = s.Value
+                if len(info.Commit) > 12 {
+                    info.Commit = info.Commit[:12]
+                }
+            case "vcs.modified":
+                info.Modified = s.Value == "true"
+            }
+        }
+    }
+    return info
+}
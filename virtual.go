@@ -0,0 +1,248 @@
+//go:build daemon
+
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/go-ble/ble"
+)
+
+// Virtual printer: the daemon advertises the printer's GATT service on a
+// second Bluetooth adapter, so the official phone app connects to us. What
+// the app sends is recorded, optionally rewritten, and forwarded to the real
+// printer (or, with "sink": "file", turned into a PNG in the spool dir).
+//
+//   "virtual_printer": {"hci": 1, "record": "traffic.jsonl",
+//                       "rewrite": {"A2": "ff"}}
+//
+// Rewrites replace the payload of a control command, keyed by command ID.
+// The recording can be sent again with "catprinter replay".
+
+const (
+    VIRTUAL_SERVICE_UUID = "ae30"
+    DEFAULT_VIRTUAL_NAME = "MXW01"
+    VIRTUAL_SINK_PRINTER = "printer"
+    VIRTUAL_SINK_FILE    = "file"
+)
+
+func init() {
+    daemonServices = append(daemonServices, startVirtualPrinter)
+}
+
+type virtualPrinter struct {
+    cfg      *VirtualPrinterConfig
+    pd       *PrinterDaemon
+    framing  Framing
+    log      *trafficLog
+    rewrites map[byte][]byte
+
+    mu sync.Mutex
+    // The app's notify subscription, nil when nobody listens
+    notifier ble.Notifier
+    // File sink state: rows announced by A9 and the data received since
+    rows int
+    data []byte
+}
+
+func startVirtualPrinter(engine *Engine, config *Config) error {
+    cfg := config.VirtualPrinter
+    if cfg == nil {
+        return nil
+    }
+    if cfg.HCI == 0 {
+        // hci0 is the adapter that talks to the real printer
+        return fmt.Errorf("virtual_printer: hci must name a second adapter (1 or higher)")
+    }
+    if cfg.Sink == "" {
+        cfg.Sink = VIRTUAL_SINK_PRINTER
+    }
+    if cfg.Sink != VIRTUAL_SINK_PRINTER && cfg.Sink != VIRTUAL_SINK_FILE {
+        return fmt.Errorf("virtual_printer: unknown sink %q", cfg.Sink)
+    }
+    if cfg.Name == "" {
+        cfg.Name = DEFAULT_VIRTUAL_NAME
+    }
+
+    vp := &virtualPrinter{cfg: cfg, pd: engine.printer, framing: config.profile.Framing, rewrites: make(map[byte][]byte)}
+    for id, payload := range cfg.Rewrite {
+        cmd, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(id), "0x"), 16, 8)
+        if err != nil {
+            return fmt.Errorf("virtual_printer: invalid rewrite command %q", id)
+        }
+        b, err := parseHexBytes(payload)
+        if err != nil {
+            return fmt.Errorf("virtual_printer: rewrite %s: %v", id, err)
+        }
+        vp.rewrites[byte(cmd)] = b
+    }
+    if cfg.Record != "" {
+        l, err := openTrafficLog(cfg.Record, vp.framing)
+        if err != nil {
+            return err
+        }
+        vp.log = l
+    }
+
+    // Shared with the printer connection when both use the same adapter
+    dev, err := acquireDevice(cfg.HCI)
+    if err != nil {
+        return fmt.Errorf("virtual_printer: %v", err)
+    }
+    svc := ble.NewService(ble.MustParse(VIRTUAL_SERVICE_UUID))
+    svc.NewCharacteristic(ble.MustParse("ae01")).HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+        vp.control(req.Data())
+    }))
+    svc.NewCharacteristic(ble.MustParse("ae02")).HandleNotify(ble.NotifyHandlerFunc(vp.subscribe))
+    svc.NewCharacteristic(ble.MustParse("ae03")).HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+        vp.dataWrite(req.Data())
+    }))
+    if err := dev.AddService(svc); err != nil {
+        return fmt.Errorf("virtual_printer: %v", err)
+    }
+
+    go func() {
+        log.Printf("Virtual printer %q advertising on hci%d (sink: %s)", cfg.Name, cfg.HCI, cfg.Sink)
+        for {
+            // Advertising stops when a central connects; start again once
+            // it goes away
+            err := dev.AdvertiseNameAndServices(context.Background(), cfg.Name, svc.UUID)
+            if err != nil {
+                log.Printf("Virtual printer advertising stopped: %v", err)
+                time.Sleep(time.Second)
+            }
+        }
+    }()
+    return nil
+}
+
+// control handles a packet the app wrote to AE01.
+func (vp *virtualPrinter) control(data []byte) {
+    data = append([]byte(nil), data...)
+    cmd, payload, ok := vp.framing.Decode(data)
+    note := ""
+    if replacement, found := vp.rewrites[cmd]; ok && found {
+        data = vp.framing.Encode(cmd, replacement)
+        payload = replacement
+        note = "rewritten"
+    }
+    vp.log.Record(TRAFFIC_FROM_APP, "ae01", data, note)
+
+    if vp.cfg.Sink == VIRTUAL_SINK_FILE {
+        if ok {
+            vp.emulate(cmd, payload)
+        }
+        return
+    }
+    if err := vp.pd.forward(false, data); err != nil {
+        log.Printf("Virtual printer: forward failed: %v", err)
+    }
+}
+
+func (vp *virtualPrinter) dataWrite(data []byte) {
+    data = append([]byte(nil), data...)
+    vp.log.Record(TRAFFIC_FROM_APP, "ae03", data, "")
+    if vp.cfg.Sink == VIRTUAL_SINK_FILE {
+        vp.mu.Lock()
+        vp.data = append(vp.data, data...)
+        vp.mu.Unlock()
+        return
+    }
+    if err := vp.pd.forward(true, data); err != nil {
+        log.Printf("Virtual printer: forward failed: %v", err)
+    }
+}
+
+// subscribe runs for as long as the app listens to AE02, passing on the real
+// printer's notifications.
+func (vp *virtualPrinter) subscribe(req ble.Request, n ble.Notifier) {
+    vp.mu.Lock()
+    vp.notifier = n
+    vp.mu.Unlock()
+    defer func() {
+        vp.mu.Lock()
+        vp.notifier = nil
+        vp.mu.Unlock()
+    }()
+
+    if vp.cfg.Sink == VIRTUAL_SINK_FILE {
+        <-n.Context().Done()
+        return
+    }
+    notifications, cancel := vp.pd.tapNotifications()
+    defer cancel()
+    for {
+        select {
+        case data := <-notifications:
+            vp.notify(data, "")
+        case <-n.Context().Done():
+            return
+        }
+    }
+}
+
+func (vp *virtualPrinter) notify(data []byte, note string) {
+    vp.log.Record(TRAFFIC_FROM_PRINTER, "ae02", data, note)
+    vp.mu.Lock()
+    defer vp.mu.Unlock()
+    if vp.notifier != nil {
+        vp.notifier.Write(data)
+    }
+}
+
+// emulate answers the app like an idle printer with paper, for the file
+// sink: status, print request acks, and a PNG once the data is flushed.
+func (vp *virtualPrinter) emulate(cmd byte, payload []byte) {
+    switch cmd {
+    case CMD_GET_STATUS:
+        // Standby, battery and temperature plausible, status flag OK
+        status := make([]byte, STATUS_ERROR_OFFSET+1)
+        status[STATUS_BATTERY_OFFSET], status[STATUS_TEMPERATURE_OFFSET] = 0x50, 0x20
+        vp.notify(vp.framing.Encode(CMD_GET_STATUS, status), "emulated")
+    case CMD_PRINT_REQUEST:
+        vp.mu.Lock()
+        vp.rows, vp.data = 0, nil
+        if len(payload) >= 2 {
+            vp.rows = int(payload[0]) | int(payload[1])<<8
+        }
+        vp.mu.Unlock()
+        vp.notify(vp.framing.Encode(CMD_PRINT_REQUEST, []byte{STATUS_OK}), "emulated")
+    case CMD_FLUSH:
+        vp.mu.Lock()
+        rows, data := vp.rows, vp.data
+        vp.data = nil
+        vp.mu.Unlock()
+        if err := vp.writePNG(rows, data); err != nil {
+            log.Printf("Virtual printer: %v", err)
+        }
+        vp.notify(vp.framing.Encode(CMD_PRINT_COMPLETE, []byte{0x00}), "emulated")
+    }
+}
+
+func (vp *virtualPrinter) writePNG(rows int, data []byte) error {
+    if rows == 0 {
+        rows = len(data) / PRINTER_WIDTH_BYTES
+    }
+    preview, err := renderBufferPNG(data, rows)
+    if err != nil {
+        return fmt.Errorf("failed to render capture: %v", err)
+    }
+    dir := vp.pd.config.SpoolDir
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return fmt.Errorf("failed to create spool dir: %v", err)
+    }
+    path := filepath.Join(dir, "virtual-"+time.Now().Format("20060102-150405.000")+".png")
+    if err := os.WriteFile(path, preview, 0644); err != nil {
+        return fmt.Errorf("failed to write capture: %v", err)
+    }
+    log.Printf("Virtual printer: captured %d rows to %s", rows, path)
+    return nil
+}
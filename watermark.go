@@ -0,0 +1,89 @@
+package main
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "os"
+)
+
+// A watermark is a second image (a shop's logo, a "COPY" stamp) laid over
+// every printed image after it is fitted to the paper and before it is
+// dithered, so they dither as one picture:
+//
+//   "watermark": {"image": "logo.png", "position": "bottom-right", "opacity": 0.4, "width": 96}
+//
+// It covers images only; text, banners and poster strips print without it.
+
+const DEFAULT_WATERMARK_POSITION = "bottom-right"
+
+var watermarkPositions = map[string][2]int{
+    // Horizontal and vertical placement: 0 start, 1 middle, 2 end
+    "top-left":     {0, 0},
+    "top":          {1, 0},
+    "top-right":    {2, 0},
+    "center":       {1, 1},
+    "bottom-left":  {0, 2},
+    "bottom":       {1, 2},
+    "bottom-right": {2, 2},
+}
+
+// load checks the settings and reads the image, scaled once for all jobs.
+func (w *WatermarkConfig) load() error {
+    if w.Position == "" {
+        w.Position = DEFAULT_WATERMARK_POSITION
+    }
+    if _, ok := watermarkPositions[w.Position]; !ok {
+        return fmt.Errorf("unknown position %q", w.Position)
+    }
+    if w.Opacity < 0 || w.Opacity > 1 {
+        return fmt.Errorf("opacity must be between 0 and 1")
+    }
+    if w.Width < 0 || w.Width > PRINTER_WIDTH {
+        return fmt.Errorf("width must be between 0 and %d", PRINTER_WIDTH)
+    }
+    if !validMargin(w.Margin) {
+        return fmt.Errorf("margin must be between 0 and %d", MAX_MARGIN)
+    }
+    f, err := os.Open(w.Image)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    img, err := decodeImage(f, false)
+    if err != nil {
+        return fmt.Errorf("%s: %v", w.Image, err)
+    }
+    width := w.Width
+    if width == 0 {
+        width = min(img.Bounds().Dx(), PRINTER_WIDTH-2*w.Margin)
+    }
+    // Scaled with draw.Src, so transparency is kept
+    w.img = scaleToWidth(img, width)
+    return nil
+}
+
+// apply returns a copy of img, already laid out on the paper width, with the
+// watermark drawn over it. A watermark taller than img is cut off.
+func (w *WatermarkConfig) apply(img image.Image) image.Image {
+    if w.img == nil {
+        // Not loaded through loadConfig
+        return img
+    }
+    b := img.Bounds()
+    out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+    draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+
+    mark := w.img.Bounds()
+    place := watermarkPositions[w.Position]
+    x := w.Margin + place[0]*(b.Dx()-2*w.Margin-mark.Dx())/2
+    y := w.Margin + place[1]*(b.Dy()-2*w.Margin-mark.Dy())/2
+    var mask image.Image
+    if w.Opacity > 0 && w.Opacity < 1 {
+        mask = image.NewUniform(color.Alpha{A: uint8(w.Opacity * 255)})
+    }
+    r := image.Rect(x, y, x+mark.Dx(), y+mark.Dy())
+    draw.DrawMask(out, r, w.img, mark.Min, mask, image.Point{}, draw.Over)
+    return out
+}
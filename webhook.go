@@ -0,0 +1,81 @@
+//go:build daemon
+
+package main
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+)
+
+// Result webhooks tell an integration how its job went, so a chat bot can
+// answer the message a print came from. The bot submits the job with a
+// reply_to of its choosing (a chat and message ID, say) and the daemon posts
+// the outcome, with a preview of the print, to the webhook configured for
+// the job's source once the job is done, failed, canceled or expired:
+//
+//   "webhooks": {"telegram": "http://localhost:9000/printed"}
+//
+// The URL comes from the config only, never from the request. Jobs without a
+// reply_to, or from sources without a webhook, are not reported.
+
+const (
+    MAX_REPLY_TO    = 1024
+    WEBHOOK_TIMEOUT = 10 * time.Second
+)
+
+type JobResult struct {
+    JobID   string   `json:"job_id"`
+    Source  string   `json:"source"`
+    ReplyTo string   `json:"reply_to"`
+    State   JobState `json:"state"`
+    Error   string   `json:"error,omitempty"`
+    Receipt string   `json:"receipt,omitempty"`
+    // PreviewPNG is the print as a base64 PNG
+    PreviewPNG string `json:"preview_png,omitempty"`
+}
+
+var webhookClient = &http.Client{Timeout: WEBHOOK_TIMEOUT}
+
+func init() {
+    daemonServices = append(daemonServices, startWebhooks)
+}
+
+func startWebhooks(engine *Engine, config *Config) error {
+    if len(config.Webhooks) == 0 {
+        return nil
+    }
+    events, _ := engine.Subscribe()
+    go func() {
+        for job := range events {
+            url, ok := config.Webhooks[job.Source]
+            if !ok || job.ReplyTo == "" || !job.State.Terminal() {
+                continue
+            }
+            go postResult(engine, url, job)
+        }
+    }()
+    return nil
+}
+
+func postResult(engine *Engine, url string, job Job) {
+    result := JobResult{JobID: job.ID, Source: job.Source, ReplyTo: job.ReplyTo, State: job.State, Error: job.Error, Receipt: job.Receipt}
+    if preview, err := engine.JobPreview(job.ID); err == nil {
+        result.PreviewPNG = base64.StdEncoding.EncodeToString(preview)
+    }
+    body, _ := json.Marshal(result)
+    resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+    if err == nil {
+        resp.Body.Close()
+        if resp.StatusCode >= 300 {
+            err = fmt.Errorf("status %s", resp.Status)
+        }
+    }
+    if err != nil {
+        log.Printf("Result webhook for job %s failed: %v", job.ID, err)
+    }
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "runtime"
+    "sync/atomic"
+    "time"
+)
+
+// Rendering and transmitting are separate stages. Rendering (decoding,
+// resizing, dithering) is CPU bound and runs in the caller's goroutine, at
+// most render_workers at a time, without holding the printer. Transmitting
+// is IO bound and done by one job at a time under jobMu. So while one job
+// is printing, the next ones are already rendering, and the printer only
+// waits for the quick per-job steps (separator, feed) in between.
+//
+// A job that finishes while another one is waiting for the printer leaves
+// the connection open for it, saving a reconnect per job on a busy printer.
+
+// How long a connection kept open for a waiting job may sit unused
+const HANDOFF_IDLE_TIMEOUT = 30 * time.Second
+
+// renderWorkers is how many images may render at once.
+func (c *Config) renderWorkers() int {
+    if c.RenderWorkers > 0 {
+        return c.RenderWorkers
+    }
+    return runtime.NumCPU()
+}
+
+// renderSlots bounds concurrent rendering to render_workers.
+type renderSlots chan struct{}
+
+func newRenderSlots(n int) renderSlots {
+    return make(renderSlots, max(n, 1))
+}
+
+// run calls fn once a worker slot is free.
+func (s renderSlots) run(fn func()) {
+    s <- struct{}{}
+    defer func() { <-s }()
+    fn()
+}
+
+// transmitQueue counts jobs waiting for the printer.
+type transmitQueue struct {
+    waiting atomic.Int32
+}
+
+// lockForJob takes the printer for a job, letting the job before it know
+// that someone is waiting.
+func (pd *PrinterDaemon) lockForJob() {
+    pd.transmit.waiting.Add(1)
+    pd.jobMu.Lock()
+    pd.transmit.waiting.Add(-1)
+}
+
+// releaseConnection ends a job's use of the connection: it stays open when
+// another job is already waiting for it, and is dropped otherwise. Callers
+// must hold jobMu.
+func (pd *PrinterDaemon) releaseConnection() {
+    if pd.connected && pd.transmit.waiting.Load() > 0 {
+        // Dropped anyway if the waiting job never uses it (canceled, expired)
+        time.AfterFunc(HANDOFF_IDLE_TIMEOUT, pd.disconnectIdle)
+        return
+    }
+    pd.Disconnect()
+}
@@ -0,0 +1,403 @@
+//go:build zpl
+
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "image/draw"
+    "io"
+    "strconv"
+    "strings"
+
+    "github.com/boombuler/barcode"
+    "github.com/boombuler/barcode/code128"
+    "github.com/boombuler/barcode/code39"
+    "github.com/boombuler/barcode/qr"
+    "golang.org/x/image/font"
+    "golang.org/x/image/math/fixed"
+)
+
+// A subset of ZPL, so label software written for Zebra printers can print
+// hobby labels here. Zebra heads are 8 dots/mm like ours, so coordinates map
+// 1:1. Supported:
+//
+//   ^XA ^XZ      label start/end (several labels print one after another)
+//   ^FO ^FT      field origin
+//   ^FD ^FS      field data
+//   ^A ^CF       font height/width (rendered with the DotMatrix font)
+//   ^GB          boxes and lines
+//   ^BY          barcode module width and height
+//   ^BC ^B3 ^BQ  Code 128, Code 39 and QR codes
+//   ^LH ^LL ^PW  label home, length and width
+//   ^FX          comments
+//
+// Anything else is ignored. Only normal (N) orientation is drawn. ZPL files
+// are recognized by the leading ^XA, so they go wherever an image goes; the
+// daemon can also take raw ZPL on port 9100 (see zpl_server.go).
+
+const (
+    ZPL_DEFAULT_FONT_HEIGHT = 18
+    ZPL_DEFAULT_BAR_HEIGHT  = 50
+    // Bottom margin under the lowest field when the label has no ^LL
+    ZPL_MARGIN = 8
+)
+
+func init() {
+    image.RegisterFormat("zpl", "^XA", decodeZPL, decodeZPLConfig)
+    registerCapability(Capability{Name: "zpl", Description: "ZPL labels: text, boxes, Code 128/39 and QR barcodes", BuildTag: "zpl"})
+}
+
+type zplCommand struct {
+    name string
+    args string
+}
+
+// parseZPL splits a label into commands. ^FD runs to the next ^FS, since
+// field data may contain carets of its own.
+func parseZPL(src string) []zplCommand {
+    var cmds []zplCommand
+    for i := 0; i < len(src); {
+        if src[i] != '^' && src[i] != '~' {
+            i++
+            continue
+        }
+        if i+3 > len(src) {
+            break
+        }
+        name := strings.ToUpper(src[i+1 : i+3])
+        start := i + 3
+        if name[0] == 'A' && name != "A@" {
+            // ^A is followed by a one character font name
+            name, start = "A", i+2
+        }
+        end := start
+        if name == "FD" || name == "FX" {
+            if j := strings.Index(src[start:], "^FS"); j >= 0 {
+                end = start + j
+            } else {
+                end = len(src)
+            }
+        } else {
+            for end < len(src) && src[end] != '^' && src[end] != '~' {
+                end++
+            }
+        }
+        cmds = append(cmds, zplCommand{name: name, args: strings.TrimSpace(src[start:end])})
+        i = end
+    }
+    return cmds
+}
+
+// zplArgs returns the n-th comma separated argument as a number, or def.
+func zplArgs(args string, n, def int) int {
+    parts := strings.Split(args, ",")
+    if n >= len(parts) {
+        return def
+    }
+    v, err := strconv.Atoi(strings.TrimSpace(parts[n]))
+    if err != nil {
+        return def
+    }
+    return v
+}
+
+func zplArg(args string, n int, def string) string {
+    parts := strings.Split(args, ",")
+    if n >= len(parts) || strings.TrimSpace(parts[n]) == "" {
+        return def
+    }
+    return strings.TrimSpace(parts[n])
+}
+
+// zplLabel is the drawing state for one ^XA...^XZ block.
+type zplLabel struct {
+    img          *image.Gray
+    width        int
+    length       int
+    bottom       int
+    homeX, homeY int
+    x, y         int
+    // ^FT positions the baseline instead of the top left corner
+    baseline   bool
+    fontHeight int
+    // The pending field type: "" for text, or a barcode command
+    field     string
+    fieldArgs string
+    barModule int
+    barHeight int
+    fontFaces map[int]font.Face
+}
+
+func newZPLLabel() *zplLabel {
+    l := &zplLabel{
+        width:      PRINTER_WIDTH,
+        fontHeight: ZPL_DEFAULT_FONT_HEIGHT,
+        barModule:  2,
+        barHeight:  ZPL_DEFAULT_BAR_HEIGHT,
+        fontFaces:  make(map[int]font.Face),
+    }
+    // Drawn on a tall canvas, cropped to ^LL or the content afterwards
+    l.img = image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, 4*PRINTER_WIDTH))
+    draw.Draw(l.img, l.img.Bounds(), image.White, image.Point{}, draw.Src)
+    return l
+}
+
+func (l *zplLabel) face(height int) (font.Face, error) {
+    if f, ok := l.fontFaces[height]; ok {
+        return f, nil
+    }
+    f, err := loadFontFace(DEFAULT_TEXT_FONT, float64(height))
+    if err != nil {
+        return nil, err
+    }
+    l.fontFaces[height] = f
+    return f, nil
+}
+
+// grow makes sure the canvas reaches y, and remembers the lowest ink.
+func (l *zplLabel) grow(y int) {
+    if y > l.bottom {
+        l.bottom = y
+    }
+    if y <= l.img.Bounds().Dy() {
+        return
+    }
+    bigger := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, y*2))
+    draw.Draw(bigger, bigger.Bounds(), image.White, image.Point{}, draw.Src)
+    draw.Draw(bigger, l.img.Bounds(), l.img, image.Point{}, draw.Src)
+    l.img = bigger
+}
+
+func (l *zplLabel) fill(r image.Rectangle) {
+    r = r.Intersect(image.Rect(0, 0, l.width, 1<<20))
+    if r.Empty() {
+        return
+    }
+    l.grow(r.Max.Y)
+    draw.Draw(l.img, r, image.Black, image.Point{}, draw.Src)
+}
+
+func (l *zplLabel) run(cmds []zplCommand) error {
+    for _, c := range cmds {
+        switch c.name {
+        case "LH":
+            l.homeX, l.homeY = zplArgs(c.args, 0, 0), zplArgs(c.args, 1, 0)
+        case "PW":
+            if w := zplArgs(c.args, 0, PRINTER_WIDTH); w > 0 && w < PRINTER_WIDTH {
+                l.width = w
+            }
+        case "LL":
+            l.length = zplArgs(c.args, 0, 0)
+        case "FO", "FT":
+            l.x = l.homeX + zplArgs(c.args, 0, 0)
+            l.y = l.homeY + zplArgs(c.args, 1, 0)
+            l.baseline = c.name == "FT"
+        case "CF":
+            l.fontHeight = zplArgs(c.args, 1, l.fontHeight)
+        case "A":
+            // ^A<font><orientation>,<height>,<width>
+            l.fontHeight = zplArgs(c.args, 1, l.fontHeight)
+        case "BY":
+            l.barModule = zplArgs(c.args, 0, l.barModule)
+            l.barHeight = zplArgs(c.args, 2, l.barHeight)
+        case "BC", "B3", "BQ":
+            l.field, l.fieldArgs = c.name, c.args
+        case "GB":
+            l.box(c.args)
+        case "FD":
+            if err := l.drawField(c.args); err != nil {
+                return err
+            }
+        case "FS":
+            l.field, l.fieldArgs, l.baseline = "", "", false
+        }
+    }
+    return nil
+}
+
+// box draws ^GB width,height,thickness,color. A thickness of half the
+// smaller side or more makes a filled box.
+func (l *zplLabel) box(args string) {
+    t := zplArgs(args, 2, 1)
+    if t < 1 {
+        t = 1
+    }
+    w := zplArgs(args, 0, t)
+    h := zplArgs(args, 1, t)
+    if w < t {
+        w = t
+    }
+    if h < t {
+        h = t
+    }
+    x, y := l.x, l.y
+    l.fill(image.Rect(x, y, x+w, y+t))
+    l.fill(image.Rect(x, y+h-t, x+w, y+h))
+    l.fill(image.Rect(x, y, x+t, y+h))
+    l.fill(image.Rect(x+w-t, y, x+w, y+h))
+}
+
+func (l *zplLabel) drawField(data string) error {
+    switch l.field {
+    case "BC", "B3", "BQ":
+        return l.drawBarcode(data)
+    }
+    return l.drawText(data, l.x, l.y, l.fontHeight, l.baseline)
+}
+
+func (l *zplLabel) drawText(text string, x, y, height int, baseline bool) error {
+    face, err := l.face(height)
+    if err != nil {
+        return err
+    }
+    if !baseline {
+        y += face.Metrics().Ascent.Ceil()
+    }
+    l.grow(y + face.Metrics().Descent.Ceil())
+    d := &font.Drawer{Dst: l.img, Src: image.Black, Face: face, Dot: fixed.P(x, y)}
+    d.DrawString(text)
+    return nil
+}
+
+func (l *zplLabel) drawBarcode(data string) error {
+    var code barcode.Barcode
+    var err error
+    height := l.barHeight
+    interpretation := true
+    module := l.barModule
+    switch l.field {
+    case "BC":
+        // ^BCo,h,f: orientation, height, print interpretation line
+        height = zplArgs(l.fieldArgs, 1, height)
+        interpretation = zplArg(l.fieldArgs, 2, "Y") == "Y"
+        code, err = code128.Encode(data)
+    case "B3":
+        // ^B3o,e,h,f: orientation, check digit, height, interpretation line
+        height = zplArgs(l.fieldArgs, 2, height)
+        interpretation = zplArg(l.fieldArgs, 3, "Y") == "Y"
+        code, err = code39.Encode(data, zplArg(l.fieldArgs, 1, "N") == "Y", true)
+    case "BQ":
+        // ^BQo,model,magnification; the data starts with "<ec><mode>,"
+        module = zplArgs(l.fieldArgs, 2, 3)
+        level := qr.M
+        if i := strings.Index(data, ","); i >= 0 {
+            switch strings.ToUpper(data[:1]) {
+            case "H":
+                level = qr.H
+            case "Q":
+                level = qr.Q
+            case "L":
+                level = qr.L
+            }
+            data = data[i+1:]
+        }
+        code, err = qr.Encode(data, level, qr.Auto)
+        interpretation = false
+    }
+    if err != nil {
+        return fmt.Errorf("zpl: %s barcode: %v", l.field, err)
+    }
+
+    b := code.Bounds()
+    if l.field == "BQ" {
+        height = b.Dy() * module
+    }
+    y := l.y
+    if l.baseline {
+        y -= height
+    }
+    for mx := 0; mx < b.Dx(); mx++ {
+        for my := 0; my < b.Dy(); my++ {
+            r, _, _, _ := code.At(b.Min.X+mx, b.Min.Y+my).RGBA()
+            if r >= 0x8000 {
+                continue
+            }
+            if l.field == "BQ" {
+                l.fill(image.Rect(l.x+mx*module, y+my*module, l.x+(mx+1)*module, y+(my+1)*module))
+            } else {
+                // 1D codes are one module tall
+                l.fill(image.Rect(l.x+mx*module, y, l.x+(mx+1)*module, y+height))
+            }
+        }
+    }
+    if interpretation {
+        return l.drawText(data, l.x, y+height+2, ZPL_DEFAULT_FONT_HEIGHT, false)
+    }
+    return nil
+}
+
+// image crops the canvas to ^LL, or to the content plus a margin.
+func (l *zplLabel) image() *image.Gray {
+    length := l.length
+    if length <= 0 {
+        length = l.bottom + ZPL_MARGIN
+    }
+    l.grow(length)
+    return l.img.SubImage(image.Rect(0, 0, PRINTER_WIDTH, length)).(*image.Gray)
+}
+
+// renderZPL draws every label in src, one under the other.
+func renderZPL(src string) (image.Image, error) {
+    var labels []*image.Gray
+    height := 0
+    for _, block := range strings.Split(src, "^XZ") {
+        i := strings.Index(block, "^XA")
+        if i < 0 {
+            continue
+        }
+        l := newZPLLabel()
+        if err := l.run(parseZPL(block[i+3:])); err != nil {
+            return nil, err
+        }
+        img := l.image()
+        labels = append(labels, img)
+        height += img.Bounds().Dy()
+    }
+    if len(labels) == 0 {
+        return nil, fmt.Errorf("zpl: no ^XA...^XZ label found")
+    }
+    out := image.NewGray(image.Rect(0, 0, PRINTER_WIDTH, height))
+    top := 0
+    for _, img := range labels {
+        draw.Draw(out, image.Rect(0, top, PRINTER_WIDTH, top+img.Bounds().Dy()), img, image.Point{}, draw.Src)
+        top += img.Bounds().Dy()
+    }
+    return out, nil
+}
+
+func decodeZPL(r io.Reader) (image.Image, error) {
+    src, err := io.ReadAll(r)
+    if err != nil {
+        return nil, err
+    }
+    return renderZPL(string(src))
+}
+
+func decodeZPLConfig(r io.Reader) (image.Config, error) {
+    img, err := decodeZPL(r)
+    if err != nil {
+        return image.Config{}, err
+    }
+    b := img.Bounds()
+    return image.Config{ColorModel: img.ColorModel(), Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+// splitZPLLabels returns each complete ^XA...^XZ block of a raw stream, and
+// what is left over.
+func splitZPLLabels(buf []byte) ([][]byte, []byte) {
+    var labels [][]byte
+    for {
+        start := bytes.Index(buf, []byte("^XA"))
+        if start < 0 {
+            return labels, buf[:0]
+        }
+        end := bytes.Index(buf[start:], []byte("^XZ"))
+        if end < 0 {
+            return labels, buf[start:]
+        }
+        labels = append(labels, buf[start:start+end+3])
+        buf = buf[start+end+3:]
+    }
+}
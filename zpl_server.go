@@ -0,0 +1,73 @@
+//go:build daemon && zpl
+
+package main
+
+import (
+    "log"
+    "net"
+    "time"
+)
+
+// Raw ZPL over TCP, the way label software talks to a networked Zebra
+// ("raw" / JetDirect port 9100). Every ^XA...^XZ label received is printed as
+// its own job.
+
+// A connection that sends nothing for this long is closed
+const ZPL_IDLE_TIMEOUT = time.Minute
+
+func init() {
+    daemonServices = append(daemonServices, startZPLServer)
+}
+
+func startZPLServer(engine *Engine, config *Config) error {
+    if config.ZPLListen == "" {
+        return nil
+    }
+    ln, err := net.Listen("tcp", config.ZPLListen)
+    if err != nil {
+        return err
+    }
+    log.Printf("Accepting raw ZPL on %s", config.ZPLListen)
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                log.Printf("ZPL accept failed: %v", err)
+                continue
+            }
+            go handleZPLConn(engine, conn)
+        }
+    }()
+    return nil
+}
+
+func handleZPLConn(engine *Engine, conn net.Conn) {
+    defer conn.Close()
+    var pending []byte
+    buf := make([]byte, 4096)
+    for {
+        conn.SetReadDeadline(time.Now().Add(ZPL_IDLE_TIMEOUT))
+        n, err := conn.Read(buf)
+        pending = append(pending, buf[:n]...)
+        var labels [][]byte
+        labels, pending = splitZPLLabels(pending)
+        for _, label := range labels {
+            printZPLLabel(engine, conn.RemoteAddr().String(), label)
+        }
+        if err != nil {
+            return
+        }
+    }
+}
+
+func printZPLLabel(engine *Engine, from string, label []byte) {
+    img, err := renderZPL(string(label))
+    if err != nil {
+        log.Printf("ZPL from %s: %v", from, err)
+        return
+    }
+    opts := PrintOptions{Source: "zpl"}
+    if err := engine.Print(engine.NewJob(opts.Source), newPreparedImage("zpl", img), opts); err != nil {
+        log.Printf("ZPL from %s: print failed: %v", from, err)
+    }
+}